@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oblak/impuls/internal/api"
+	"github.com/oblak/impuls/internal/firecracker"
+	"github.com/oblak/impuls/internal/function"
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/queue"
+	"github.com/oblak/impuls/internal/scheduler"
+	"github.com/oblak/impuls/internal/secrets"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+func main() {
+	// Parse command line flags
+	port := flag.String("port", "8080", "Port to listen on")
+	dataDir := flag.String("data-dir", "/var/lib/impuls", "Directory for storing function data")
+	firecrackerBin := flag.String("firecracker", "/usr/local/bin/firecracker", "Path to firecracker binary")
+	kernelPath := flag.String("kernel", "", "Path to kernel image (defaults to data-dir/images/vmlinux)")
+	rootfsPath := flag.String("rootfs", "", "Path to rootfs image (defaults to data-dir/images/rootfs.ext4)")
+	storageType := flag.String("storage", "file", "Storage type: file or postgres")
+	dbConnStr := flag.String("db-conn", "", "Database connection string (required for postgres storage)")
+	poolSize := flag.Int("pool-size", 0, "Number of pre-warmed VMs per runtime (0 disables the warm pool)")
+	vmNetwork := flag.String("vm-network", firecracker.DefaultNetworkCIDR, "IPv4 network that VM subnets are leased from, one /30 per VM")
+	vmMemoryBudget := flag.Int("vm-memory-budget", 0, "MiB of host memory all VMs together may use (0 for no limit)")
+	vmVCPUBudget := flag.Int("vm-vcpu-budget", 0, "vCPUs all VMs together may use (0 for no limit)")
+	vmCapacityWait := flag.Duration("vm-capacity-wait", 0, "How long a VM create waits for capacity before the invocation is rejected (0 rejects at once)")
+	vmTransport := flag.String("vm-transport", firecracker.TransportTCP, "How the server reaches the agent in a VM: tcp or vsock")
+	vmSnapshots := flag.Bool("vm-snapshots", false, "Restore VMs from per-runtime snapshots instead of booting them")
+	reapInterval := flag.Duration("reap-interval", time.Minute, "How often VM processes, TAP devices, sockets and directories no VM owns are cleaned up")
+	vmMaxLifetime := flag.Duration("vm-max-lifetime", firecracker.DefaultMaxVMLifetime, "How long a VM may live before it is stopped (0 for no limit)")
+	poolSizes := flag.String("pool-sizes", "", "Per-runtime pool sizes, e.g. nodejs20=4,python312=2 (overrides --pool-size)")
+	asyncWorkers := flag.Int("async-workers", 4, "Number of async invocations run in parallel (0 disables the async worker)")
+	asyncMaxAttempts := flag.Int("async-max-attempts", models.DefaultRetryPolicy.MaxAttempts, "Attempts per async invocation before it is dead-lettered")
+	asyncBackoff := flag.Duration("async-backoff", models.DefaultRetryPolicy.InitialBackoff, "Delay before the first async retry, doubled on every further retry")
+	asyncMaxBackoff := flag.Duration("async-max-backoff", models.DefaultRetryPolicy.MaxBackoff, "Upper bound for the async retry delay")
+	asyncLease := flag.Duration("async-lease", 15*time.Minute, "How long a running async invocation is hidden from other workers (must exceed the longest function timeout)")
+	enableScheduler := flag.Bool("scheduler", true, "Fire cron and rate schedules from this server")
+	schedulerInterval := flag.Duration("scheduler-interval", time.Second, "How often the scheduler checks for due schedules")
+	maxConcurrency := flag.Int("max-concurrency", 0, "Host-wide limit on simultaneous invocations, shared by all functions (0 for no limit)")
+	historyRetention := flag.Duration("invocation-retention", models.DefaultHistoryConfig.Retention, "How long invocation records are kept (0 keeps them until --invocation-max-records is reached)")
+	historyMaxRecords := flag.Int("invocation-max-records", models.DefaultHistoryConfig.MaxPerFunction, "Invocation records kept per function (0 for no limit)")
+	historyPayloadBytes := flag.Int("invocation-payload-bytes", models.DefaultHistoryConfig.MaxPayloadBytes, "Bytes of the payload kept in each invocation record")
+	historyLogBytes := flag.Int("invocation-log-bytes", models.DefaultHistoryConfig.MaxLogBytes, "Bytes of logs kept in each invocation record, from the end")
+	secretsKeyFile := flag.String("secrets-key-file", "", "File holding the base64 encoded 32-byte master key secrets are encrypted with (defaults to $IMPULS_SECRETS_KEY; secrets are disabled without either)")
+	buildTimeout := flag.Duration("build-timeout", function.DefaultBuildTimeout, "How long npm or pip may take to install the dependencies of a code package")
+	localCgroup := flag.String("local-cgroup", function.DefaultLocalLimits.CgroupDir, "cgroup v2 directory that local invocations get a cgroup with their memory, CPU and process limits in (empty for no limits)")
+	localCPUs := flag.Float64("local-cpus", function.DefaultLocalLimits.CPUs, "CPUs each local invocation may use (0 for no limit)")
+	localPids := flag.Int("local-pids", function.DefaultLocalLimits.MaxPids, "Processes and threads each local invocation may run (0 for no limit)")
+	defaultExecutor := flag.String("executor", string(models.ExecutorFirecracker), "Backend that runs functions which pin none: firecracker, local or sandbox")
+	sandboxNetwork := flag.Bool("sandbox-network", false, "Give sandboxed functions the server's network (functions with no_network never get one)")
+	flag.Parse()
+
+	if *asyncMaxAttempts < 1 {
+		log.Fatal("--async-max-attempts must be at least 1")
+	}
+	retryPolicy := models.RetryPolicy{
+		MaxAttempts:    *asyncMaxAttempts,
+		InitialBackoff: *asyncBackoff,
+		MaxBackoff:     *asyncMaxBackoff,
+	}
+
+	historyConfig := models.HistoryConfig{
+		MaxPayloadBytes: *historyPayloadBytes,
+		MaxLogBytes:     *historyLogBytes,
+		Retention:       *historyRetention,
+		MaxPerFunction:  *historyMaxRecords,
+	}
+
+	runtimePoolSizes, err := parsePoolSizes(*poolSizes)
+	if err != nil {
+		log.Fatalf("Invalid --pool-sizes: %v", err)
+	}
+
+	// Set default paths
+	if *kernelPath == "" {
+		*kernelPath = *dataDir + "/images/vmlinux"
+	}
+	if *rootfsPath == "" {
+		*rootfsPath = *dataDir + "/images/rootfs.ext4"
+	}
+
+	// Initialize storage based on type
+	var store storage.Storage
+
+	switch *storageType {
+	case "postgres":
+		if *dbConnStr == "" {
+			log.Fatal("Database connection string is required for postgres storage. Use --db-conn flag")
+		}
+		store, err = storage.NewPostgresStorage(*dbConnStr)
+		if err != nil {
+			log.Fatalf("Failed to initialize postgres storage: %v", err)
+		}
+		log.Println("Using PostgreSQL storage")
+	case "file":
+		store, err = storage.NewFileStorage(*dataDir + "/functions")
+		if err != nil {
+			log.Fatalf("Failed to initialize file storage: %v", err)
+		}
+		log.Println("Using file storage")
+	default:
+		log.Fatalf("Invalid storage type: %s. Must be 'file' or 'postgres'", *storageType)
+	}
+
+	// Initialize Firecracker manager
+	fcConfig := firecracker.Config{
+		FirecrackerBin: *firecrackerBin,
+		KernelPath:     *kernelPath,
+		RootFSPath:     *rootfsPath,
+		DataDir:        *dataDir,
+		NetworkCIDR:    *vmNetwork,
+		GuestTransport: *vmTransport,
+		MemoryBudgetMB: *vmMemoryBudget,
+		VCPUBudget:     *vmVCPUBudget,
+		CapacityWait:   *vmCapacityWait,
+	}
+	fcManager, err := firecracker.NewManager(fcConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialize Firecracker manager: %v", err)
+	}
+
+	// Clean up after a previous run before any VM starts
+	reaper := firecracker.NewReaper(fcManager, *reapInterval, *vmMaxLifetime)
+	reaper.Start(context.Background())
+	if *vmSnapshots {
+		if err := fcManager.EnableSnapshots(context.Background(), firecracker.DefaultPoolRuntimes); err != nil {
+			log.Fatalf("Failed to enable VM snapshots: %v", err)
+		}
+		log.Printf("VM snapshots enabled for %v", firecracker.DefaultPoolRuntimes)
+	}
+
+	// Initialize function manager
+	funcManager := function.NewManager(store, fcManager)
+	funcManager.SetRetryPolicy(retryPolicy)
+	funcManager.SetHistoryConfig(historyConfig)
+	if err := funcManager.SetConcurrencyLimit(*maxConcurrency); err != nil {
+		log.Fatalf("Failed to set concurrency limit: %v", err)
+	}
+	if err := funcManager.SetBuildCache(*dataDir+"/builds", *buildTimeout); err != nil {
+		log.Fatalf("Failed to set up dependency builds: %v", err)
+	}
+	if *localCgroup != "" {
+		limits := function.LocalLimits{CgroupDir: *localCgroup, CPUs: *localCPUs, MaxPids: *localPids}
+		if err := funcManager.SetLocalLimits(limits); err != nil {
+			log.Printf("Warning: local invocations run without resource limits: %v", err)
+		} else {
+			log.Printf("Local invocations limited in cgroup %s (%g CPUs, %d processes)", *localCgroup, *localCPUs, *localPids)
+		}
+	}
+	if err := funcManager.EnableSandbox(*sandboxNetwork); err != nil {
+		if *defaultExecutor == string(models.ExecutorSandbox) {
+			log.Fatalf("Failed to enable the sandbox executor: %v", err)
+		}
+		log.Printf("Warning: sandbox executor unavailable: %v", err)
+	}
+	if err := funcManager.SetDefaultExecutor(models.ExecutorType(*defaultExecutor)); err != nil {
+		log.Fatalf("Invalid --executor: %v", err)
+	}
+	log.Printf("Functions run on the %s executor unless they pin another", *defaultExecutor)
+
+	switch {
+	case *secretsKeyFile != "":
+		key, err := secrets.LoadKey(*secretsKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load secrets master key: %v", err)
+		}
+		funcManager.SetSecretsKey(key)
+		log.Printf("Secrets enabled (master key %s)", key.ID())
+	case os.Getenv("IMPULS_SECRETS_KEY") != "":
+		key, err := secrets.ParseKey(os.Getenv("IMPULS_SECRETS_KEY"))
+		if err != nil {
+			log.Fatalf("Invalid IMPULS_SECRETS_KEY: %v", err)
+		}
+		funcManager.SetSecretsKey(key)
+		log.Printf("Secrets enabled (master key %s)", key.ID())
+	default:
+		log.Println("Secrets disabled: no master key given with --secrets-key-file or IMPULS_SECRETS_KEY")
+	}
+
+	// Prune the invocation history in the background
+	historyPruner := function.NewHistoryPruner(funcManager, time.Minute)
+	historyPruner.Start(context.Background())
+
+	// Start the warm VM pool if any runtime has a pool size
+	var vmPool *firecracker.VMPool
+	if *poolSize > 0 || len(runtimePoolSizes) > 0 {
+		vmPool = firecracker.NewVMPool(fcManager, *poolSize, runtimePoolSizes)
+		vmPool.Start(context.Background())
+		funcManager.SetVMPool(vmPool)
+		log.Printf("Warm VM pool enabled (default size %d, overrides %v)", *poolSize, runtimePoolSizes)
+	}
+
+	// Start the async invocation worker
+	var asyncWorker *queue.Worker
+	if *asyncWorkers > 0 {
+		asyncWorker = queue.NewWorker(store, funcManager, queue.Config{
+			Concurrency: *asyncWorkers,
+			Lease:       *asyncLease,
+			RetryPolicy: retryPolicy,
+		})
+		asyncWorker.Start(context.Background())
+		log.Printf("Async worker started (%d workers, %d attempts)", *asyncWorkers, retryPolicy.MaxAttempts)
+	}
+
+	// Start the scheduler. Scheduled runs are queued as async invocations,
+	// so they run wherever an async worker is running.
+	var sched *scheduler.Scheduler
+	if *enableScheduler {
+		if *asyncWorkers == 0 {
+			log.Println("Warning: scheduler enabled without async workers; scheduled runs stay queued until a server with --async-workers picks them up")
+		}
+		sched = scheduler.NewScheduler(store, funcManager, *schedulerInterval)
+		sched.Start(context.Background())
+		log.Printf("Scheduler started (checking every %s)", *schedulerInterval)
+	}
+
+	// Initialize API server
+	apiServer := api.NewServer(funcManager)
+
+	// Create HTTP server
+	server := &http.Server{
+		Addr:         ":" + *port,
+		Handler:      apiServer.Router(),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// Start server in a goroutine
+	go func() {
+		log.Printf("Impuls server starting on port %s", *port)
+		log.Printf("Data directory: %s", *dataDir)
+		log.Printf("Firecracker binary: %s", *firecrackerBin)
+		if *vmMemoryBudget > 0 || *vmVCPUBudget > 0 {
+			log.Printf("VM budget: %d MiB, %d vCPUs (0 is unlimited)", *vmMemoryBudget, *vmVCPUBudget)
+		}
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for interrupt signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down server...")
+
+	// Graceful shutdown with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Stop firing schedules, then let running async invocations finish
+	// before VMs are torn down
+	if sched != nil {
+		sched.Stop()
+	}
+	if asyncWorker != nil {
+		asyncWorker.Stop()
+	}
+	historyPruner.Stop()
+	reaper.Stop()
+
+	// Stop pooled VMs, then cleanup anything still running
+	if vmPool != nil {
+		vmPool.Stop()
+	}
+	if err := fcManager.Cleanup(); err != nil {
+		log.Printf("Error during Firecracker cleanup: %v", err)
+	}
+
+	// Close storage connection if it's PostgreSQL
+	if pgStore, ok := store.(*storage.PostgresStorage); ok {
+		if err := pgStore.Close(); err != nil {
+			log.Printf("Error closing database connection: %v", err)
+		}
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("Server forced to shutdown: %v", err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// parsePoolSizes parses a comma separated list of runtime=size pairs
+func parsePoolSizes(value string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	if value == "" {
+		return sizes, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected runtime=size, got %q", pair)
+		}
+		size, err := strconv.Atoi(parts[1])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid pool size for %s: %q", parts[0], parts[1])
+		}
+		sizes[parts[0]] = size
+	}
+
+	return sizes, nil
+}
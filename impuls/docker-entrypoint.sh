@@ -7,6 +7,7 @@ KERNEL_PATH="${IMAGES_DIR}/vmlinux"
 ROOTFS_PATH="${IMAGES_DIR}/rootfs.ext4"
 STORAGE_TYPE="${STORAGE_TYPE:-file}"
 DB_CONN="${DB_CONN:-}"
+EXECUTOR="${IMPULS_EXECUTOR:-}"
 
 # Colors for output
 RED='\033[0;31m'
@@ -136,9 +137,18 @@ else
     ARGS="$ARGS --storage file"
 fi
 
+# Without Firecracker, functions run as local processes unless an executor
+# is configured
+if [ -z "$EXECUTOR" ] && { [ "$KVM_AVAILABLE" = "false" ] || [ "$IMPULS_LOCAL_MODE" = "true" ]; }; then
+    EXECUTOR=local
+fi
+if [ -n "$EXECUTOR" ]; then
+    ARGS="$ARGS --executor ${EXECUTOR}"
+fi
+
 if [ "$KVM_AVAILABLE" = "false" ]; then
     echo -e "${YELLOW}Running in LOCAL MODE (no Firecracker)${NC}"
-    echo "Add ?local=true to invoke URLs"
+    echo "Functions run on the ${EXECUTOR} executor"
     echo ""
     ARGS="$ARGS --firecracker /dev/null"
 else
@@ -1,31 +1,69 @@
 package api
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/oblak/impuls/internal/firecracker"
 	"github.com/oblak/impuls/internal/function"
 	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/secrets"
 	"github.com/oblak/impuls/internal/storage"
 )
 
 // mockStorage implements storage.Storage interface for testing
 type mockStorage struct {
+	mu        sync.Mutex // guards functions, which dependency builds update
 	functions map[string]*models.Function
 	code      map[string][]byte
+	versions  map[string][]*models.FunctionVersion
+	aliases   map[string]map[string]*models.Alias
+	async     map[string]*models.AsyncInvocation
+	schedules map[string]*models.Schedule
+	records   map[string]*models.InvocationRecord
+	layers    map[string]map[int]*models.Layer
+	secrets   map[string]*models.Secret
 }
 
 func newMockStorage() *mockStorage {
 	return &mockStorage{
 		functions: make(map[string]*models.Function),
 		code:      make(map[string][]byte),
+		versions:  make(map[string][]*models.FunctionVersion),
+		aliases:   make(map[string]map[string]*models.Alias),
+		async:     make(map[string]*models.AsyncInvocation),
+		schedules: make(map[string]*models.Schedule),
+		records:   make(map[string]*models.InvocationRecord),
+		layers:    make(map[string]map[int]*models.Layer),
+		secrets:   make(map[string]*models.Secret),
 	}
 }
 
 func (m *mockStorage) Create(fn *models.Function) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, exists := m.functions[fn.Name]; exists {
 		return storage.ErrAlreadyExists
 	}
@@ -34,6 +72,8 @@ func (m *mockStorage) Create(fn *models.Function) error {
 }
 
 func (m *mockStorage) Get(name string) (*models.Function, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	fn, ok := m.functions[name]
 	if !ok {
 		return nil, storage.ErrNotFound
@@ -42,6 +82,8 @@ func (m *mockStorage) Get(name string) (*models.Function, error) {
 }
 
 func (m *mockStorage) GetByID(id string) (*models.Function, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, fn := range m.functions {
 		if fn.ID == id {
 			return fn, nil
@@ -51,14 +93,24 @@ func (m *mockStorage) GetByID(id string) (*models.Function, error) {
 }
 
 func (m *mockStorage) Update(fn *models.Function) error {
-	if _, exists := m.functions[fn.Name]; !exists {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, exists := m.functions[fn.Name]
+	if !exists {
 		return storage.ErrNotFound
 	}
+	if existing.NextVersion > fn.NextVersion {
+		updated := *fn
+		updated.NextVersion = existing.NextVersion
+		fn = &updated
+	}
 	m.functions[fn.Name] = fn
 	return nil
 }
 
 func (m *mockStorage) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, exists := m.functions[name]; !exists {
 		return storage.ErrNotFound
 	}
@@ -67,6 +119,8 @@ func (m *mockStorage) Delete(name string) error {
 }
 
 func (m *mockStorage) List() ([]*models.Function, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var result []*models.Function
 	for _, fn := range m.functions {
 		result = append(result, fn)
@@ -79,6 +133,10 @@ func (m *mockStorage) SaveCode(name string, code []byte) (string, error) {
 	return "/code/" + name, nil
 }
 
+func (m *mockStorage) SavePackage(name string, format models.CodeFormat, code []byte, unpack func(dir string) error) (string, error) {
+	return m.SaveCode(name, code)
+}
+
 func (m *mockStorage) GetCode(name string) ([]byte, error) {
 	code, ok := m.code[name]
 	if !ok {
@@ -87,9 +145,340 @@ func (m *mockStorage) GetCode(name string) ([]byte, error) {
 	return code, nil
 }
 
+func (m *mockStorage) CreateVersion(v *models.FunctionVersion) error {
+	for _, existing := range m.versions[v.FunctionName] {
+		if existing.Version == v.Version {
+			return storage.ErrVersionExists
+		}
+	}
+	m.versions[v.FunctionName] = append(m.versions[v.FunctionName], v)
+	return nil
+}
+
+func (m *mockStorage) SetNextVersion(name string, next int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, exists := m.functions[name]
+	if !exists {
+		return storage.ErrNotFound
+	}
+	updated := *existing
+	updated.NextVersion = max(existing.NextVersion, next)
+	m.functions[name] = &updated
+	return nil
+}
+
+func (m *mockStorage) GetVersion(name string, version int) (*models.FunctionVersion, error) {
+	for _, v := range m.versions[name] {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, storage.ErrVersionNotFound
+}
+
+func (m *mockStorage) ListVersions(name string) ([]*models.FunctionVersion, error) {
+	return m.versions[name], nil
+}
+
+func (m *mockStorage) DeleteVersion(name string, version int) error {
+	for i, v := range m.versions[name] {
+		if v.Version == version {
+			m.versions[name] = append(m.versions[name][:i], m.versions[name][i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrVersionNotFound
+}
+
+func (m *mockStorage) CreateAlias(a *models.Alias) error {
+	if _, exists := m.aliases[a.FunctionName][a.Name]; exists {
+		return storage.ErrAliasExists
+	}
+	if m.aliases[a.FunctionName] == nil {
+		m.aliases[a.FunctionName] = make(map[string]*models.Alias)
+	}
+	m.aliases[a.FunctionName][a.Name] = a
+	return nil
+}
+
+func (m *mockStorage) GetAlias(name, alias string) (*models.Alias, error) {
+	a, ok := m.aliases[name][alias]
+	if !ok {
+		return nil, storage.ErrAliasNotFound
+	}
+	return a, nil
+}
+
+func (m *mockStorage) UpdateAlias(a *models.Alias) error {
+	if _, exists := m.aliases[a.FunctionName][a.Name]; !exists {
+		return storage.ErrAliasNotFound
+	}
+	m.aliases[a.FunctionName][a.Name] = a
+	return nil
+}
+
+func (m *mockStorage) ListAliases(name string) ([]*models.Alias, error) {
+	var result []*models.Alias
+	for _, a := range m.aliases[name] {
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+func (m *mockStorage) DeleteAlias(name, alias string) error {
+	if _, exists := m.aliases[name][alias]; !exists {
+		return storage.ErrAliasNotFound
+	}
+	delete(m.aliases[name], alias)
+	return nil
+}
+
+func (m *mockStorage) EnqueueAsyncInvocation(inv *models.AsyncInvocation) error {
+	if _, exists := m.functions[inv.FunctionName]; !exists {
+		return storage.ErrNotFound
+	}
+	m.async[inv.ID] = inv
+	return nil
+}
+
+func (m *mockStorage) ClaimAsyncInvocation(now time.Time, lease time.Duration) (*models.AsyncInvocation, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) UpdateAsyncInvocation(inv *models.AsyncInvocation) error {
+	if _, exists := m.async[inv.ID]; !exists {
+		return storage.ErrAsyncInvocationNotFound
+	}
+	m.async[inv.ID] = inv
+	return nil
+}
+
+func (m *mockStorage) GetAsyncInvocation(id string) (*models.AsyncInvocation, error) {
+	inv, ok := m.async[id]
+	if !ok {
+		return nil, storage.ErrAsyncInvocationNotFound
+	}
+	return inv, nil
+}
+
+func (m *mockStorage) ListAsyncInvocations(name string, status models.AsyncStatus) ([]*models.AsyncInvocation, error) {
+	var result []*models.AsyncInvocation
+	for _, inv := range m.async {
+		if inv.FunctionName == name && inv.Status == status {
+			result = append(result, inv)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockStorage) DeleteAsyncInvocation(id string) error {
+	if _, exists := m.async[id]; !exists {
+		return storage.ErrAsyncInvocationNotFound
+	}
+	delete(m.async, id)
+	return nil
+}
+
+func (m *mockStorage) CreateSchedule(s *models.Schedule) error {
+	if _, exists := m.functions[s.FunctionName]; !exists {
+		return storage.ErrNotFound
+	}
+	m.schedules[s.ID] = s
+	return nil
+}
+
+func (m *mockStorage) GetSchedule(id string) (*models.Schedule, error) {
+	s, ok := m.schedules[id]
+	if !ok {
+		return nil, storage.ErrScheduleNotFound
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (m *mockStorage) ListSchedules(name string) ([]*models.Schedule, error) {
+	var result []*models.Schedule
+	for _, s := range m.schedules {
+		if s.FunctionName == name {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockStorage) ListDueSchedules(now time.Time) ([]*models.Schedule, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) UpdateSchedule(s *models.Schedule, expectedNext time.Time) error {
+	current, exists := m.schedules[s.ID]
+	if !exists {
+		return storage.ErrScheduleNotFound
+	}
+	if !current.NextRunAt.Equal(expectedNext) {
+		return storage.ErrScheduleChanged
+	}
+	m.schedules[s.ID] = s
+	return nil
+}
+
+func (m *mockStorage) AdvanceSchedule(s *models.Schedule, expectedNext time.Time) (bool, error) {
+	return false, nil
+}
+
+func (m *mockStorage) DeleteSchedule(id string) error {
+	if _, exists := m.schedules[id]; !exists {
+		return storage.ErrScheduleNotFound
+	}
+	delete(m.schedules, id)
+	return nil
+}
+
+func (m *mockStorage) SaveInvocationRecord(r *models.InvocationRecord) error {
+	if _, exists := m.functions[r.FunctionName]; !exists {
+		return storage.ErrNotFound
+	}
+	m.records[r.ID] = r
+	return nil
+}
+
+func (m *mockStorage) GetInvocationRecord(id string) (*models.InvocationRecord, error) {
+	r, exists := m.records[id]
+	if !exists {
+		return nil, storage.ErrInvocationRecordNotFound
+	}
+	return r, nil
+}
+
+func (m *mockStorage) ListInvocationRecords(name string, filter models.InvocationFilter) ([]*models.InvocationRecord, error) {
+	result := []*models.InvocationRecord{}
+	for _, r := range m.records {
+		if r.FunctionName == name && filter.Matches(r) {
+			result = append(result, r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.After(result[j].StartedAt)
+	})
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+func (m *mockStorage) PruneInvocationRecords(olderThan time.Time, maxPerFunction int) (int, error) {
+	return 0, nil
+}
+
+func (m *mockStorage) CreateLayer(l *models.Layer) error {
+	if _, exists := m.layers[l.Name][l.Version]; exists {
+		return storage.ErrLayerVersionExists
+	}
+	if m.layers[l.Name] == nil {
+		m.layers[l.Name] = make(map[int]*models.Layer)
+	}
+	stored := *l
+	m.layers[l.Name][l.Version] = &stored
+	return nil
+}
+
+func (m *mockStorage) GetLayer(name string, version int) (*models.Layer, error) {
+	l, exists := m.layers[name][version]
+	if !exists {
+		return nil, storage.ErrLayerNotFound
+	}
+	result := *l
+	return &result, nil
+}
+
+func (m *mockStorage) ListLayerVersions(name string) ([]*models.Layer, error) {
+	result := []*models.Layer{}
+	for _, l := range m.layers[name] {
+		listed := *l
+		listed.Content = ""
+		result = append(result, &listed)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+func (m *mockStorage) ListLayers() ([]*models.Layer, error) {
+	result := []*models.Layer{}
+	for name := range m.layers {
+		versions, _ := m.ListLayerVersions(name)
+		if len(versions) > 0 {
+			result = append(result, versions[len(versions)-1])
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (m *mockStorage) DeleteLayer(name string, version int) error {
+	if _, exists := m.layers[name][version]; !exists {
+		return storage.ErrLayerNotFound
+	}
+	delete(m.layers[name], version)
+	if len(m.layers[name]) == 0 {
+		delete(m.layers, name)
+	}
+	return nil
+}
+
+func (m *mockStorage) CreateSecret(s *models.Secret) error {
+	if _, exists := m.secrets[s.Name]; exists {
+		return storage.ErrSecretExists
+	}
+	stored := *s
+	m.secrets[s.Name] = &stored
+	return nil
+}
+
+func (m *mockStorage) GetSecret(name string) (*models.Secret, error) {
+	s, exists := m.secrets[name]
+	if !exists {
+		return nil, storage.ErrSecretNotFound
+	}
+	result := *s
+	return &result, nil
+}
+
+func (m *mockStorage) UpdateSecret(s *models.Secret) error {
+	if _, exists := m.secrets[s.Name]; !exists {
+		return storage.ErrSecretNotFound
+	}
+	stored := *s
+	m.secrets[s.Name] = &stored
+	return nil
+}
+
+func (m *mockStorage) ListSecrets() ([]*models.Secret, error) {
+	result := []*models.Secret{}
+	for _, s := range m.secrets {
+		listed := *s
+		listed.Envelope = nil
+		result = append(result, &listed)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (m *mockStorage) DeleteSecret(name string) error {
+	if _, exists := m.secrets[name]; !exists {
+		return storage.ErrSecretNotFound
+	}
+	delete(m.secrets, name)
+	return nil
+}
+
 func setupTestServer() (*Server, *mockStorage) {
 	store := newMockStorage()
 	mgr := function.NewManager(store, nil) // nil firecracker manager for tests
+	// Without Firecracker, functions run as local processes
+	if err := mgr.SetDefaultExecutor(models.ExecutorLocal); err != nil {
+		panic(err)
+	}
 	server := NewServer(mgr)
 	return server, store
 }
@@ -106,7 +495,7 @@ func TestHealthCheck(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", rr.Code)
 	}
 
-	var response map[string]string
+	var response map[string]interface{}
 	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
 		t.Fatal(err)
 	}
@@ -209,102 +598,2206 @@ func TestCreateFunctionDuplicate(t *testing.T) {
 	}
 }
 
-func TestListFunctions(t *testing.T) {
-	server, _ := setupTestServer()
+// packageEntry is a file, directory or link in a test code package
+type packageEntry struct {
+	name     string
+	body     string
+	typeflag byte // tar.TypeReg when 0
+}
 
-	// Create some functions first
-	for _, name := range []string{"fn-1", "fn-2", "fn-3"} {
-		funcReq := models.CreateFunctionRequest{
-			Name:    name,
-			Runtime: models.RuntimeNodeJS20,
-			Handler: "index.handler",
-			Code:    "exports.handler = () => {};",
+// tarGzPackage returns a base64 encoded tar.gz of the entries
+func tarGzPackage(t *testing.T, entries ...packageEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: e.typeflag}
+		switch e.typeflag {
+		case 0:
+			hdr.Typeflag = tar.TypeReg
+		case tar.TypeSymlink:
+			hdr.Linkname, hdr.Size = e.body, 0
+		case tar.TypeDir:
+			hdr.Mode, hdr.Size = 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.body))
 		}
-		body, _ := json.Marshal(funcReq)
-		req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		rr := httptest.NewRecorder()
-		server.Router().ServeHTTP(rr, req)
 	}
+	tw.Close()
+	gz.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
 
-	// List functions
-	req := httptest.NewRequest("GET", "/api/v1/functions", nil)
-	rr := httptest.NewRecorder()
-	server.Router().ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", rr.Code)
+// zipPackage returns a base64 encoded zip of the files
+func zipPackage(t *testing.T, files ...packageEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.body))
 	}
+	zw.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
 
-	var response map[string]interface{}
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
-		t.Fatal(err)
+func TestCreateFunctionPackage(t *testing.T) {
+	server, _ := setupTestServer()
+
+	tests := []struct {
+		name    string
+		runtime models.Runtime
+		handler string
+		format  models.CodeFormat
+		code    string
+		bin     string
+	}{
+		{
+			name:    "node-package",
+			runtime: models.RuntimeNodeJS20,
+			handler: "src/index.handler",
+			format:  models.CodeFormatTarGz,
+			code: tarGzPackage(t,
+				packageEntry{name: "src/", typeflag: tar.TypeDir},
+				packageEntry{name: "src/index.js", body: "const { greet } = require('../lib/greet'); exports.handler = async (event) => greet(event.name);"},
+				packageEntry{name: "lib/greet.js", body: "exports.greet = (name) => `hello ${name}`;"},
+			),
+			bin: "node",
+		},
+		{
+			name:    "python-package",
+			runtime: models.RuntimePython312,
+			handler: "app.main.handler",
+			format:  models.CodeFormatZip,
+			code: zipPackage(t,
+				packageEntry{name: "app/__init__.py"},
+				packageEntry{name: "app/main.py", body: "from app.greet import greet\n\ndef handler(event, context):\n    return greet(event['name'])\n"},
+				packageEntry{name: "app/greet.py", body: "def greet(name):\n    return 'hello ' + name\n"},
+			),
+			bin: "python3",
+		},
 	}
 
-	count := int(response["count"].(float64))
-	if count != 3 {
-		t.Errorf("Expected count 3, got %d", count)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.CreateFunctionRequest{
+				Name:       tt.name,
+				Runtime:    tt.runtime,
+				Handler:    tt.handler,
+				Code:       tt.code,
+				CodeFormat: tt.format,
+			})
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			if _, err := exec.LookPath(tt.bin); err != nil {
+				t.Skipf("%s is not installed", tt.bin)
+			}
+			req = httptest.NewRequest("POST", "/api/v1/functions/"+tt.name+"/invoke", strings.NewReader(`{"name":"world"}`))
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+
+			var response models.InvocationResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != 200 || response.Body != "hello world" {
+				t.Errorf("Expected the handler to use the package's other files, got %+v", response)
+			}
+		})
 	}
 }
 
-func TestGetFunction(t *testing.T) {
-	server, _ := setupTestServer()
-
-	// Create a function
-	funcReq := models.CreateFunctionRequest{
-		Name:    "test-function",
-		Runtime: models.RuntimeNodeJS20,
-		Handler: "index.handler",
-		Code:    "exports.handler = () => {};",
+// writePackage writes a base64 encoded package to a file, e.g. a dependency
+// a package manager installs from disk
+func writePackage(t *testing.T, path, code string) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(code)
+	if err != nil {
+		t.Fatal(err)
 	}
-	body, _ := json.Marshal(funcReq)
-	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	rr := httptest.NewRecorder()
-	server.Router().ServeHTTP(rr, req)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
 
-	// Get the function
-	req = httptest.NewRequest("GET", "/api/v1/functions/test-function", nil)
-	rr = httptest.NewRecorder()
-	server.Router().ServeHTTP(rr, req)
+// waitForBuild polls a function until its dependency build finished
+func waitForBuild(t *testing.T, server *Server, name string) *models.FunctionBuild {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		req := httptest.NewRequest("GET", "/api/v1/functions/"+name, nil)
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", rr.Code)
+		var fn models.Function
+		if err := json.NewDecoder(rr.Body).Decode(&fn); err != nil {
+			t.Fatal(err)
+		}
+		if fn.Build == nil {
+			t.Fatalf("Expected %s to have a dependency build", name)
+		}
+		if fn.Build.Status != models.BuildPending {
+			return fn.Build
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dependency build of %s did not finish", name)
+		}
+		time.Sleep(50 * time.Millisecond)
 	}
+}
 
-	var response models.Function
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+func TestFunctionDependencies(t *testing.T) {
+	// On file storage, packages run where they were unpacked, with the
+	// dependencies found on the runners' search path
+	store, err := storage.NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := function.NewManager(store, nil)
+	if err := mgr.SetDefaultExecutor(models.ExecutorLocal); err != nil {
 		t.Fatal(err)
 	}
+	server := NewServer(mgr)
+	if err := server.funcManager.SetBuildCache(t.TempDir(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	deps := t.TempDir()
+
+	// Installed from local archives, so the builds need no network
+	nodeDep := writePackage(t, filepath.Join(deps, "greet-1.0.0.tgz"), tarGzPackage(t,
+		packageEntry{name: "package/package.json", body: `{"name": "greet", "version": "1.0.0", "main": "index.js"}`},
+		packageEntry{name: "package/index.js", body: "exports.greet = (name) => `hello ${name}`;"},
+	))
+	pythonDep := writePackage(t, filepath.Join(deps, "greet-1.0-py3-none-any.whl"), zipPackage(t,
+		packageEntry{name: "greet.py", body: "def greet(name):\n    return 'hello ' + name\n"},
+		packageEntry{name: "greet-1.0.dist-info/METADATA", body: "Metadata-Version: 2.1\nName: greet\nVersion: 1.0\n"},
+		packageEntry{name: "greet-1.0.dist-info/WHEEL", body: "Wheel-Version: 1.0\nGenerator: test\nRoot-Is-Purelib: true\nTag: py3-none-any\n"},
+		packageEntry{name: "greet-1.0.dist-info/RECORD", body: ""},
+	))
+
+	tests := []struct {
+		name    string
+		runtime models.Runtime
+		handler string
+		code    string
+		bin     string
+	}{
+		{
+			name:    "node-dependencies",
+			runtime: models.RuntimeNodeJS20,
+			handler: "index.handler",
+			code: tarGzPackage(t,
+				packageEntry{name: "package.json", body: `{"name": "fn", "version": "1.0.0", "dependencies": {"greet": "file:` + nodeDep + `"}}`},
+				packageEntry{name: "index.js", body: "const { greet } = require('greet'); exports.handler = async (event) => greet(event.name);"},
+			),
+			bin: "npm",
+		},
+		{
+			name:    "python-dependencies",
+			runtime: models.RuntimePython312,
+			handler: "main.handler",
+			code: tarGzPackage(t,
+				packageEntry{name: "requirements.txt", body: pythonDep + "\n"},
+				packageEntry{name: "main.py", body: "from greet import greet\n\ndef handler(event, context):\n    return greet(event['name'])\n"},
+			),
+			bin: "python3",
+		},
+	}
 
-	if response.Name != "test-function" {
-		t.Errorf("Expected name 'test-function', got '%s'", response.Name)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.bin); err != nil {
+				t.Skipf("%s is not installed", tt.bin)
+			}
+
+			body, _ := json.Marshal(models.CreateFunctionRequest{
+				Name:       tt.name,
+				Runtime:    tt.runtime,
+				Handler:    tt.handler,
+				Code:       tt.code,
+				CodeFormat: models.CodeFormatTarGz,
+			})
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			build := waitForBuild(t, server, tt.name)
+			if build.Status != models.BuildReady || build.DependenciesHash == "" || build.FinishedAt == nil {
+				t.Fatalf("Expected a ready build, got %+v", build)
+			}
+
+			req = httptest.NewRequest("POST", "/api/v1/functions/"+tt.name+"/invoke", strings.NewReader(`{"name":"world"}`))
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+
+			var response models.InvocationResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != 200 || response.Body != "hello world" {
+				t.Errorf("Expected the handler to use its dependencies, got %+v", response)
+			}
+
+			// Versions keep the build
+			req = httptest.NewRequest("POST", "/api/v1/functions/"+tt.name+"/versions", strings.NewReader(`{}`))
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			var version models.FunctionVersion
+			json.NewDecoder(rr.Body).Decode(&version)
+			if rr.Code != http.StatusCreated || version.DependenciesHash != build.DependenciesHash {
+				t.Errorf("Expected version with dependencies %s, got %d: %+v", build.DependenciesHash, rr.Code, version)
+			}
+		})
 	}
 }
 
-func TestGetFunctionNotFound(t *testing.T) {
-	server, _ := setupTestServer()
+func TestPackageRunsFromCodeDirectory(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	dir := t.TempDir()
+	store, err := storage.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := function.NewManager(store, nil)
+	if err := mgr.SetDefaultExecutor(models.ExecutorLocal); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(mgr)
 
-	req := httptest.NewRequest("GET", "/api/v1/functions/non-existent", nil)
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:       "unpacked",
+		Runtime:    models.RuntimeNodeJS20,
+		Handler:    "index.handler",
+		Code:       zipPackage(t, packageEntry{name: "index.js", body: "exports.handler = async () => 'packaged';"}),
+		CodeFormat: models.CodeFormatZip,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
 	rr := httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
 
-	if rr.Code != http.StatusNotFound {
-		t.Errorf("Expected status 404, got %d", rr.Code)
+	// The package is unpacked once, at deploy time
+	codeDir := filepath.Join(dir, "code", "unpacked")
+	if _, err := os.Stat(filepath.Join(codeDir, "function.js")); !os.IsNotExist(err) {
+		t.Errorf("Expected no function.js for a package, got %v", err)
+	}
+	module := filepath.Join(codeDir, "package", "index.js")
+	if err := os.WriteFile(module, []byte("exports.handler = async () => 'unpacked';"), 0644); err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestUpdateFunction(t *testing.T) {
-	server, _ := setupTestServer()
+	invoke := func() interface{} {
+		req := httptest.NewRequest("POST", "/api/v1/functions/unpacked/invoke", strings.NewReader(`{}`))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		var response models.InvocationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Body
+	}
+	if got := invoke(); got != "unpacked" {
+		t.Errorf("Expected the invocation to run the unpacked package, got %v", got)
+	}
+
+	// Published versions run their own code
+	req = httptest.NewRequest("POST", "/api/v1/functions/unpacked/versions", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	req = httptest.NewRequest("POST", "/api/v1/functions/unpacked/invoke?qualifier=1", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response models.InvocationResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Body != "packaged" {
+		t.Errorf("Expected the version to run the archive it was published with, got %+v", response)
+	}
+
+	// Changing the format saves the code again: plain source in function.js
+	format := models.CodeFormatSource
+	source := "exports.handler = async () => 'source';"
+	body, _ = json.Marshal(models.UpdateFunctionRequest{Code: &source, CodeFormat: &format})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/unpacked", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(module); !os.IsNotExist(err) {
+		t.Errorf("Expected the unpacked package to be removed, got %v", err)
+	}
+	if got := invoke(); got != "source" {
+		t.Errorf("Expected the invocation to run the source, got %v", got)
+	}
+}
+
+func TestFunctionDependenciesFailed(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	server, _ := setupTestServer()
+	if err := server.funcManager.SetBuildCache(t.TempDir(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "broken-dependencies",
+		Runtime: models.RuntimePython312,
+		Handler: "main.handler",
+		Code: zipPackage(t,
+			packageEntry{name: "requirements.txt", body: "this is not a requirement!\n"},
+			packageEntry{name: "main.py", body: "def handler(event, context):\n    return 'unreachable'\n"},
+		),
+		CodeFormat: models.CodeFormatZip,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	build := waitForBuild(t, server, "broken-dependencies")
+	if build.Status != models.BuildFailed || build.Error == "" || build.Log == "" {
+		t.Fatalf("Expected a failed build with its log, got %+v", build)
+	}
+
+	for _, path := range []string{"/invoke", "/versions"} {
+		req = httptest.NewRequest("POST", "/api/v1/functions/broken-dependencies"+path, strings.NewReader(`{}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("POST %s: expected status 409, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestFunctionDependenciesWithoutBuilds(t *testing.T) {
+	server, _ := setupTestServer()
+
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "no-builds",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code: zipPackage(t,
+			packageEntry{name: "package.json", body: `{"name": "fn", "version": "1.0.0"}`},
+			packageEntry{name: "index.js", body: "exports.handler = async () => 'unreachable';"},
+		),
+		CodeFormat: models.CodeFormatZip,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var fn models.Function
+	json.NewDecoder(rr.Body).Decode(&fn)
+	if fn.Build == nil || fn.Build.Status != models.BuildFailed {
+		t.Fatalf("Expected the build to fail without a build cache, got %+v", fn.Build)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/no-builds/invoke", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDotNetCompiledOnce(t *testing.T) {
+	if _, err := exec.LookPath("dotnet"); err != nil {
+		t.Skip("dotnet is not installed")
+	}
+
+	server, _ := setupTestServer()
+	cache := t.TempDir()
+	if err := server.funcManager.SetBuildCache(cache, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	code := `using System.Text.Json;
+
+public class Handler
+{
+    public string Greet(JsonElement input, LambdaContext context)
+    {
+        return $"Hello, {input.GetProperty("name").GetString()} from {context.FunctionName}!";
+    }
+}
+`
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "dotnet-greeter",
+		Runtime: models.RuntimeDotNet8,
+		Handler: "Handler.Greet",
+		Code:    code,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	artifacts, _ := filepath.Glob(filepath.Join(cache, "dotnet-*", "Function.dll"))
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected the assembly to be built on create, got %v", artifacts)
+	}
+
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest("POST", "/api/v1/functions/dotnet-greeter/invoke", strings.NewReader(`{"name":"world"}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+
+		var response models.InvocationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 200 || response.Body != "Hello, world from dotnet-greeter!" {
+			t.Fatalf("Expected the greeting, got %+v", response)
+		}
+	}
+	if entries, _ := os.ReadDir(cache); len(entries) != 1 {
+		t.Errorf("Expected invocations to reuse the build, got %d cache entries", len(entries))
+	}
+
+	broken := `public class Handler { public string Greet() { return 42; } }`
+
+	// Compile errors fail the update and leave the function as it was
+	update, _ := json.Marshal(models.UpdateFunctionRequest{Code: &broken})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/dotnet-greeter", bytes.NewReader(update))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "CS0029") {
+		t.Fatalf("Expected status 400 with the compiler error, got %d: %s", rr.Code, rr.Body.String())
+	}
+	stored, err := server.funcManager.Get("dotnet-greeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Code != code {
+		t.Error("Expected a failed update to keep the code")
+	}
+
+	body, _ = json.Marshal(models.CreateFunctionRequest{
+		Name:    "dotnet-broken",
+		Runtime: models.RuntimeDotNet8,
+		Handler: "Handler.Greet",
+		Code:    broken,
+	})
+	req = httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Function.cs(1,") {
+		t.Errorf("Expected status 400 with the compiler error, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGoCompiledOnce(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	server, _ := setupTestServer()
+	cache := t.TempDir()
+	if err := server.funcManager.SetBuildCache(cache, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	code := `package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type Event struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+func Greet(ctx context.Context, event Event) (string, error) {
+	if event.Name == "" {
+		return "", errors.New("name is required")
+	}
+	fmt.Println("greeting", event.Name)
+	return "Hello, " + event.Name + "!", nil
+}
+`
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "go-greeter",
+		Runtime: models.RuntimeGo122,
+		Handler: "Greet",
+		Code:    code,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	artifacts, _ := filepath.Glob(filepath.Join(cache, "go-*", "bootstrap"))
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected the binary to be built on create, got %v", artifacts)
+	}
+
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest("POST", "/api/v1/functions/go-greeter/invoke", strings.NewReader(`{"name":"world"}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+
+		var response models.InvocationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != 200 || response.Body != "Hello, world!" || !strings.Contains(response.Logs, "greeting world") {
+			t.Fatalf("Expected the greeting with its log, got %+v", response)
+		}
+	}
+	if artifacts, _ := filepath.Glob(filepath.Join(cache, "go-*")); len(artifacts) != 1 {
+		t.Errorf("Expected invocations to reuse the build, got %v", artifacts)
+	}
+
+	// Errors the handler returns fail the invocation
+	req = httptest.NewRequest("POST", "/api/v1/functions/go-greeter/invoke", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response models.InvocationResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.StatusCode != 500 || !strings.Contains(response.Error, "name is required") {
+		t.Errorf("Expected the handler's error, got %+v", response)
+	}
+
+	broken := "package main\n\nfunc Greet() string { return 42 }\n"
+
+	// Compile errors fail the update and leave the function as it was
+	update, _ := json.Marshal(models.UpdateFunctionRequest{Code: &broken})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/go-greeter", bytes.NewReader(update))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "function.go:3:") {
+		t.Fatalf("Expected status 400 with the compiler error, got %d: %s", rr.Code, rr.Body.String())
+	}
+	stored, err := server.funcManager.Get("go-greeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Code != code {
+		t.Error("Expected a failed update to keep the code")
+	}
+
+	// So does a handler that is not a function of the package
+	handler := "Missing"
+	update, _ = json.Marshal(models.UpdateFunctionRequest{Handler: &handler})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/go-greeter", bytes.NewReader(update))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "undefined: Missing") {
+		t.Errorf("Expected status 400 for an undefined handler, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	body, _ = json.Marshal(models.CreateFunctionRequest{
+		Name:    "go-dotted",
+		Runtime: models.RuntimeGo122,
+		Handler: "main.Greet",
+		Code:    code,
+	})
+	req = httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "handler") {
+		t.Errorf("Expected status 400 for an invalid handler, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestProvidedRuntime(t *testing.T) {
+	server, _ := setupTestServer()
+
+	// Echoes the request it reads from stdin as the result
+	echo := "#!/bin/sh\necho \"greeting is $GREETING\" >&2\nprintf '{\"body\":%s}' \"$(cat)\"\n"
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:        "provided-echo",
+		Runtime:     models.RuntimeProvided,
+		Handler:     "bin/echo",
+		CodeFormat:  models.CodeFormatTarGz,
+		Code:        tarGzPackage(t, packageEntry{name: "bin/echo", body: echo}),
+		Environment: map[string]string{"GREETING": "hello"},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/provided-echo/invoke", strings.NewReader(`{"name":"world"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	var response struct {
+		InvocationID string `json:"invocation_id"`
+		StatusCode   int    `json:"status_code"`
+		Logs         string `json:"logs"`
+		Body         struct {
+			Event   map[string]string `json:"event"`
+			Context struct {
+				FunctionName    string `json:"function_name"`
+				FunctionVersion string `json:"function_version"`
+				InvocationID    string `json:"invocation_id"`
+				TimeoutMS       int    `json:"timeout_ms"`
+				DeadlineMS      int64  `json:"deadline_ms"`
+			} `json:"context"`
+		} `json:"body"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || response.Body.Event["name"] != "world" {
+		t.Fatalf("Expected the request to be echoed, got %+v", response)
+	}
+	if c := response.Body.Context; c.FunctionName != "provided-echo" || c.FunctionVersion != models.LatestVersion || c.InvocationID != response.InvocationID || c.TimeoutMS <= 0 || c.DeadlineMS < time.Now().UnixMilli() {
+		t.Errorf("Unexpected context %+v", c)
+	}
+	if !strings.Contains(response.Logs, "greeting is hello") {
+		t.Errorf("Expected stderr in the logs, got %q", response.Logs)
+	}
+
+	// Errors on stdout and failed binaries fail the invocation
+	for script, expected := range map[string]string{
+		"#!/bin/sh\ncat > /dev/null\necho '{\"error\":\"boom\"}'\n": "function error: boom",
+		"#!/bin/sh\nexit 3\n":          "exit status 3",
+		"#!/bin/sh\necho 'not json'\n": "invalid function result",
+	} {
+		code := tarGzPackage(t, packageEntry{name: "bin/echo", body: script})
+		update, _ := json.Marshal(models.UpdateFunctionRequest{Code: &code})
+		req = httptest.NewRequest("PUT", "/api/v1/functions/provided-echo", bytes.NewReader(update))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		req = httptest.NewRequest("POST", "/api/v1/functions/provided-echo/invoke", strings.NewReader(`{}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		var failed models.InvocationResponse
+		json.NewDecoder(rr.Body).Decode(&failed)
+		if failed.StatusCode != 500 || !strings.Contains(failed.Error, expected) {
+			t.Errorf("Expected an error containing %q, got %+v", expected, failed)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  models.CreateFunctionRequest
+	}{
+		{"plain source", models.CreateFunctionRequest{Handler: "echo", Code: echo}},
+		{"missing binary", models.CreateFunctionRequest{Handler: "bin/other", CodeFormat: models.CodeFormatZip, Code: zipPackage(t, packageEntry{name: "bin/echo", body: echo})}},
+	} {
+		tc.req.Name, tc.req.Runtime = "provided-invalid", models.RuntimeProvided
+		body, _ := json.Marshal(tc.req)
+		req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d: %s", tc.name, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestCreateFunctionPackageInvalid(t *testing.T) {
+	server, _ := setupTestServer()
+
+	index := packageEntry{name: "index.js", body: "exports.handler = async () => 1;"}
+	tests := []struct {
+		name    string
+		handler string
+		format  models.CodeFormat
+		code    string
+	}{
+		{"path traversal", "index.handler", models.CodeFormatZip, zipPackage(t, index, packageEntry{name: "../evil.js", body: "x"})},
+		{"absolute path", "index.handler", models.CodeFormatTarGz, tarGzPackage(t, index, packageEntry{name: "/etc/cron.d/evil", body: "x"})},
+		{"symlink", "index.handler", models.CodeFormatTarGz, tarGzPackage(t, index, packageEntry{name: "passwd", body: "/etc/passwd", typeflag: tar.TypeSymlink})},
+		{"missing module", "main.handler", models.CodeFormatZip, zipPackage(t, index)},
+		{"module outside package", "../index.handler", models.CodeFormatZip, zipPackage(t, index)},
+		{"not base64", "index.handler", models.CodeFormatZip, "exports.handler = () => {};"},
+		{"wrong format", "index.handler", models.CodeFormatZip, tarGzPackage(t, index)},
+		{"empty package", "index.handler", models.CodeFormatTarGz, tarGzPackage(t)},
+		{"unknown format", "index.handler", "rar", zipPackage(t, index)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.CreateFunctionRequest{
+				Name:       "bad-package",
+				Runtime:    models.RuntimeNodeJS20,
+				Handler:    tt.handler,
+				Code:       tt.code,
+				CodeFormat: tt.format,
+			})
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// A handler change is checked against the stored package
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:       "good-package",
+		Runtime:    models.RuntimeNodeJS20,
+		Handler:    "index.handler",
+		Code:       zipPackage(t, index),
+		CodeFormat: models.CodeFormatZip,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/functions/good-package", strings.NewReader(`{"handler":"other.handler"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a handler outside the package, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// publishTestLayer publishes a tar.gz layer and returns its version
+func publishTestLayer(t *testing.T, server *Server, name string, entries ...packageEntry) *models.Layer {
+	t.Helper()
+	body, _ := json.Marshal(models.PublishLayerRequest{
+		Name:       name,
+		CodeFormat: models.CodeFormatTarGz,
+		Content:    tarGzPackage(t, entries...),
+	})
+	req := httptest.NewRequest("POST", "/api/v1/layers", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var layer models.Layer
+	if err := json.NewDecoder(rr.Body).Decode(&layer); err != nil {
+		t.Fatal(err)
+	}
+	return &layer
+}
+
+func TestLayers(t *testing.T) {
+	server, _ := setupTestServer()
+
+	greetings := publishTestLayer(t, server, "greetings",
+		packageEntry{name: "lib/helpers.js", body: "exports.greet = (name) => `hello ${name}`;"},
+		packageEntry{name: "helpers.py", body: "def greet(name):\n    return 'hello ' + name\n"},
+	)
+	if greetings.Version != 1 || greetings.Content != "" || greetings.Size == 0 || len(greetings.SHA256) != 64 {
+		t.Errorf("Unexpected first layer version %+v", greetings)
+	}
+	if again := publishTestLayer(t, server, "greetings", packageEntry{name: "lib/helpers.js"}); again.Version != 2 {
+		t.Errorf("Expected version 2, got %d", again.Version)
+	}
+	publishTestLayer(t, server, "loud",
+		packageEntry{name: "helpers.py", body: "def greet(name):\n    return ('hello ' + name).upper()\n"},
+	)
+
+	tests := []struct {
+		name    string
+		runtime models.Runtime
+		handler string
+		format  models.CodeFormat
+		code    string
+		layers  []models.LayerRef
+		bin     string
+		want    string
+	}{
+		{
+			name:    "node-layer",
+			runtime: models.RuntimeNodeJS20,
+			handler: "index.handler",
+			code:    "const { greet } = require('./lib/helpers'); exports.handler = async (event) => greet(event.name);",
+			layers:  []models.LayerRef{{Name: "greetings", Version: 1}},
+			bin:     "node",
+			want:    "hello world",
+		},
+		{
+			// The function's own files replace the layer's
+			name:    "node-package-layer",
+			runtime: models.RuntimeNodeJS20,
+			handler: "index.handler",
+			format:  models.CodeFormatTarGz,
+			code: tarGzPackage(t,
+				packageEntry{name: "index.js", body: "const { greet } = require('./lib/helpers'); exports.handler = async (event) => greet(event.name);"},
+				packageEntry{name: "lib/helpers.js", body: "exports.greet = (name) => `hi ${name}`;"},
+			),
+			layers: []models.LayerRef{{Name: "greetings", Version: 1}},
+			bin:    "node",
+			want:   "hi world",
+		},
+		{
+			// Later layers are laid over earlier ones
+			name:    "python-layers",
+			runtime: models.RuntimePython312,
+			handler: "app.handler",
+			format:  models.CodeFormatZip,
+			code:    zipPackage(t, packageEntry{name: "app.py", body: "from helpers import greet\n\ndef handler(event, context):\n    return greet(event['name'])\n"}),
+			layers:  []models.LayerRef{{Name: "greetings", Version: 1}, {Name: "loud", Version: 1}},
+			bin:     "python3",
+			want:    "HELLO WORLD",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.CreateFunctionRequest{
+				Name:       tt.name,
+				Runtime:    tt.runtime,
+				Handler:    tt.handler,
+				Code:       tt.code,
+				CodeFormat: tt.format,
+				Layers:     tt.layers,
+			})
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			if _, err := exec.LookPath(tt.bin); err != nil {
+				t.Skipf("%s is not installed", tt.bin)
+			}
+			req = httptest.NewRequest("POST", "/api/v1/functions/"+tt.name+"/invoke", strings.NewReader(`{"name":"world"}`))
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+
+			var response models.InvocationResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != 200 || response.Body != tt.want {
+				t.Errorf("Expected %q, got %+v", tt.want, response)
+			}
+		})
+	}
+
+	// Listed without their content
+	req := httptest.NewRequest("GET", "/api/v1/layers", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var list struct {
+		Layers []*models.Layer `json:"layers"`
+		Count  int             `json:"count"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Count != 2 || list.Layers[0].Name != "greetings" || list.Layers[0].Version != 2 || list.Layers[0].Content != "" {
+		t.Errorf("Expected the latest version of both layers, got %+v", list)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/layers/greetings/versions/1", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var layer models.Layer
+	if err := json.NewDecoder(rr.Body).Decode(&layer); err != nil {
+		t.Fatal(err)
+	}
+	if layer.Content == "" {
+		t.Error("Expected a layer version to include its content")
+	}
+
+	// A published version keeps its layers after the function drops them
+	req = httptest.NewRequest("POST", "/api/v1/functions/node-layer/versions", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, name := range []string{"node-layer", "node-package-layer"} {
+		req = httptest.NewRequest("PATCH", "/api/v1/functions/"+name, strings.NewReader(`{"layers": []}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		var fn models.Function
+		if err := json.NewDecoder(rr.Body).Decode(&fn); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusOK || fn.Layers != nil {
+			t.Fatalf("Expected the layers to be removed, got %d: %+v", rr.Code, fn.Layers)
+		}
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/layers/greetings/versions/1", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a layer a version uses, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/layers/greetings/versions/2", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLayersInvalid(t *testing.T) {
+	server, _ := setupTestServer()
+	publishTestLayer(t, server, "greetings", packageEntry{name: "lib/helpers.js"})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"invalid name", "POST", "/api/v1/layers", `{"name": "1st", "code_format": "zip", "content": "UEs="}`, http.StatusBadRequest},
+		{"plain source", "POST", "/api/v1/layers", `{"name": "utils", "content": "exports.x = 1;"}`, http.StatusBadRequest},
+		{"invalid archive", "POST", "/api/v1/layers", `{"name": "utils", "code_format": "zip", "content": "bm90IGEgemlw"}`, http.StatusBadRequest},
+		{"unknown layer", "GET", "/api/v1/layers/unknown", "", http.StatusNotFound},
+		{"unknown version", "GET", "/api/v1/layers/greetings/versions/2", "", http.StatusNotFound},
+		{"invalid version", "DELETE", "/api/v1/layers/greetings/versions/latest", "", http.StatusBadRequest},
+		{"function with unknown layer", "POST", "/api/v1/functions",
+			`{"name": "fn", "runtime": "nodejs20", "handler": "index.handler", "code": "x", "layers": [{"name": "greetings", "version": 2}]}`, http.StatusBadRequest},
+		{"function with repeated layer", "POST", "/api/v1/functions",
+			`{"name": "fn", "runtime": "nodejs20", "handler": "index.handler", "code": "x", "layers": [{"name": "greetings", "version": 1}, {"name": "greetings", "version": 1}]}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	server, store := setupTestServer()
+	raw := make([]byte, secrets.KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key, err := secrets.ParseKey(base64.StdEncoding.EncodeToString(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.funcManager.SetSecretsKey(key)
+
+	const value = "tok-3f9a1c7e5b"
+	req := httptest.NewRequest("POST", "/api/v1/secrets", strings.NewReader(`{"name": "api-token", "description": "upstream API", "value": "`+value+`"}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), value) || strings.Contains(rr.Body.String(), "envelope") {
+		t.Errorf("Expected the response to leave out the value, got %s", rr.Body.String())
+	}
+	if strings.Contains(string(store.secrets["api-token"].Envelope.Ciphertext), value) {
+		t.Error("Expected the value to be stored encrypted")
+	}
+
+	for _, path := range []string{"/api/v1/secrets", "/api/v1/secrets/api-token"} {
+		req = httptest.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "upstream API") || strings.Contains(rr.Body.String(), value) || strings.Contains(rr.Body.String(), "envelope") {
+			t.Errorf("GET %s: expected the secret without its value, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+
+	code := `exports.handler = async (event) => {
+  console.log('token is ' + process.env.API_TOKEN);
+  if (event.fail) throw new Error('rejected ' + process.env.API_TOKEN);
+  return process.env.API_TOKEN.length;
+};`
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "secret-user",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code:    code,
+		Secrets: map[string]string{"API_TOKEN": "api-token"},
+	})
+	req = httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/secret-user", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var fn models.Function
+	if err := json.NewDecoder(rr.Body).Decode(&fn); err != nil {
+		t.Fatal(err)
+	}
+	if fn.Secrets["API_TOKEN"] != "api-token" {
+		t.Errorf("Expected the function to reference api-token, got %v", fn.Secrets)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/secrets/api-token", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a secret a function uses, got %d", rr.Code)
+	}
+
+	if _, err := exec.LookPath("node"); err == nil {
+		req = httptest.NewRequest("POST", "/api/v1/functions/secret-user/invoke", strings.NewReader(`{}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		var response models.InvocationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Body != float64(len(value)) {
+			t.Errorf("Expected the secret in the environment, got %+v", response)
+		}
+		if strings.Contains(response.Logs, value) || !strings.Contains(response.Logs, "token is [REDACTED]") {
+			t.Errorf("Expected the value to be redacted from the logs, got %q", response.Logs)
+		}
+
+		req = httptest.NewRequest("POST", "/api/v1/functions/secret-user/invoke", strings.NewReader(`{"fail": true}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		response = models.InvocationResponse{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(rr.Body.String(), value) || !strings.Contains(response.Error, "rejected [REDACTED]") {
+			t.Errorf("Expected the value to be redacted from the error, got %+v", response)
+		}
+		for _, record := range store.records {
+			if strings.Contains(record.Logs, value) || strings.Contains(record.Error, value) {
+				t.Errorf("Expected the invocation history to be redacted, got %+v", record)
+			}
+		}
+
+		// Functions get a new value on their next invocation
+		req = httptest.NewRequest("PUT", "/api/v1/secrets/api-token", strings.NewReader(`{"value": "rotated"}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "rotated") {
+			t.Fatalf("Expected status 200 without the value, got %d: %s", rr.Code, rr.Body.String())
+		}
+		req = httptest.NewRequest("POST", "/api/v1/functions/secret-user/invoke", strings.NewReader(`{}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		response = models.InvocationResponse{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Body != float64(len("rotated")) {
+			t.Errorf("Expected the rotated secret, got %+v", response)
+		}
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/v1/functions/secret-user", strings.NewReader(`{"secrets": {}}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	req = httptest.NewRequest("DELETE", "/api/v1/secrets/api-token", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSecretsInvalid(t *testing.T) {
+	server, _ := setupTestServer()
+
+	// Without a master key no value can be stored
+	req := httptest.NewRequest("POST", "/api/v1/secrets", strings.NewReader(`{"name": "api-token", "value": "x"}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected status 503 without Retry-After, got %d (%q)", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	tests := []struct {
+		name    string
+		secrets map[string]string
+		env     map[string]string
+	}{
+		{name: "missing secret", secrets: map[string]string{"API_TOKEN": "missing"}},
+		{name: "invalid variable", secrets: map[string]string{"API-TOKEN": "api-token"}},
+		{name: "also in environment", secrets: map[string]string{"API_TOKEN": "api-token"}, env: map[string]string{"API_TOKEN": "plain"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.CreateFunctionRequest{
+				Name:        "secret-user",
+				Runtime:     models.RuntimeNodeJS20,
+				Handler:     "index.handler",
+				Code:        "exports.handler = async () => 1;",
+				Environment: tt.env,
+				Secrets:     tt.secrets,
+			})
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "secrets") {
+				t.Errorf("Expected status 400 on secrets, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/secrets/missing", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestLocalIsolation(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	server, _ := setupTestServer()
+	t.Setenv("IMPULS_SERVER_ONLY", "hidden")
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:        "isolated",
+		Runtime:     models.RuntimeNodeJS20,
+		Handler:     "index.handler",
+		Code:        "exports.handler = async (event) => { if (event.hog) { const a = []; for (;;) a.push(Buffer.alloc(16 << 20, 1)); } return Object.keys(process.env); };",
+		MemoryMB:    128,
+		Environment: map[string]string{"GREETING": "hello"},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	invoke := func(payload string) models.InvocationResponse {
+		req := httptest.NewRequest("POST", "/api/v1/functions/isolated/invoke", strings.NewReader(payload))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		var response models.InvocationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	// The process only sees the function's variables
+	response := invoke(`{}`)
+	keys := make(map[string]bool)
+	for _, key := range response.Body.([]interface{}) {
+		keys[key.(string)] = true
+	}
+	if !keys["GREETING"] || !keys["PATH"] || keys["IMPULS_SERVER_ONLY"] {
+		t.Errorf("Expected GREETING and PATH but not the server's environment, got %v", response.Body)
+	}
+
+	// Limits need a cgroup v2 hierarchy that delegates the controllers
+	dir := fmt.Sprintf("/sys/fs/cgroup/impuls-test-%d", os.Getpid())
+	if err := server.funcManager.SetLocalLimits(function.LocalLimits{CgroupDir: dir, CPUs: 1, MaxPids: 64}); err != nil {
+		t.Skipf("local limits unavailable: %v", err)
+	}
+	defer os.Remove(dir)
+
+	response = invoke(`{"hog": true}`)
+	if response.ErrorType != models.ErrorTypeOutOfMemory || !strings.Contains(response.Error, "out of memory") {
+		t.Errorf("Expected the function to run out of memory, got %+v", response)
+	}
+	if response = invoke(`{}`); response.Error != "" {
+		t.Errorf("Expected the next invocation to succeed, got %+v", response)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "inv-*")); len(leftovers) > 0 {
+		t.Errorf("Expected the invocation cgroups to be removed, got %v", leftovers)
+	}
+}
+
+func TestSandboxExecutor(t *testing.T) {
+	server, _ := setupTestServer()
+
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:     "sandboxed",
+		Runtime:  models.RuntimeNodeJS20,
+		Handler:  "index.handler",
+		Code:     "exports.handler = async () => { const fs = require('fs'); let readOnly = false; try { fs.writeFileSync('/usr/impuls-sandbox', 'x'); } catch (e) { readOnly = e.code === 'EROFS'; } return { pid: process.pid, readOnly, shadow: fs.existsSync('/etc/shadow'), user: fs.readFileSync('/etc/passwd', 'utf8').split(':')[0] }; };",
+		Executor: models.ExecutorSandbox,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/v1/functions/sandboxed", strings.NewReader(`{"executor": "docker"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown executor, got %d", rr.Code)
+	}
+
+	// A pinned executor the server has not enabled is unavailable
+	req = httptest.NewRequest("POST", "/api/v1/functions/sandboxed/invoke", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without the sandbox, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	if err := server.funcManager.EnableSandbox(false); err != nil {
+		t.Skipf("sandbox unavailable: %v", err)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/functions/sandboxed/invoke", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response models.InvocationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := response.Body.(map[string]interface{})
+	if result["pid"] != float64(1) || result["readOnly"] != true {
+		t.Errorf("Expected the handler to run as pid 1 on a read-only /usr, got %+v", response)
+	}
+	if result["shadow"] != false || result["user"] != "sandbox" {
+		t.Errorf("Expected a minimal /etc without the host's credentials, got %+v", response)
+	}
+}
+
+// fakeExecutor runs invocations without a runtime. It logs the event's
+// action and returns the event, unless the action is "fail" or "stream".
+type fakeExecutor struct {
+	mu       sync.Mutex
+	prepared int
+	invoked  int
+	tornDown int
+}
+
+func (e *fakeExecutor) Prepare(ctx context.Context, inv *function.Invocation) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.prepared++
+	return nil
+}
+
+func (e *fakeExecutor) Invoke(ctx context.Context, inv *function.Invocation) (*models.InvocationResponse, error) {
+	e.mu.Lock()
+	e.invoked++
+	e.mu.Unlock()
+
+	event, _ := inv.Payload().(map[string]interface{})
+	fmt.Fprintf(inv.Logs(), "%s ran %v\n", inv.Function().Name, event["action"])
+	switch event["action"] {
+	case "fail":
+		return nil, errors.New("fake failure")
+	case "stream":
+		io.WriteString(inv.Stream(), "one,")
+		io.WriteString(inv.Stream(), "two")
+		return &models.InvocationResponse{StatusCode: 200}, nil
+	}
+	return &models.InvocationResponse{StatusCode: 200, Body: event}, nil
+}
+
+func (e *fakeExecutor) Teardown(inv *function.Invocation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tornDown++
+}
+
+// calls returns how often each phase ran
+func (e *fakeExecutor) calls() [3]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return [3]int{e.prepared, e.invoked, e.tornDown}
+}
+
+func TestFakeExecutor(t *testing.T) {
+	server, _ := setupTestServer()
+	fake := &fakeExecutor{}
+	if err := server.funcManager.RegisterExecutor("fake", fake); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.funcManager.SetDefaultExecutor("missing"); err == nil {
+		t.Error("Expected an unregistered default executor to be rejected")
+	}
+	if err := server.funcManager.SetDefaultExecutor("fake"); err != nil {
+		t.Fatal(err)
+	}
+	createTestFunction(t, server, "faked")
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/faked/invoke", strings.NewReader(`{"action": "echo"}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response models.InvocationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := response.Body.(map[string]interface{})
+	if rr.Code != http.StatusOK || result["action"] != "echo" || response.Logs != "faked ran echo\n" {
+		t.Errorf("Expected the event back with the executor's logs, got %d %+v", rr.Code, response)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/faked/invocations/"+response.InvocationID, nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var record models.InvocationRecord
+	if err := json.NewDecoder(rr.Body).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != models.InvocationSucceeded || record.Logs != "faked ran echo\n" {
+		t.Errorf("Expected a succeeded record with the logs, got %+v", record)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/faked/invoke", strings.NewReader(`{"action": "fail"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	response = models.InvocationResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusInternalServerError || response.Error != "fake failure" || response.Logs != "faked ran fail\n" {
+		t.Errorf("Expected status 500 with the executor's error, got %d %+v", rr.Code, response)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/faked/invoke?mode=stream", strings.NewReader(`{"action": "stream"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "one,two" {
+		t.Errorf("Expected the streamed chunks, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	if calls := fake.calls(); calls != [3]int{3, 3, 3} {
+		t.Errorf("Expected every invocation to be prepared, invoked and torn down once, got %v", calls)
+	}
+}
+
+func TestLocalQueryIgnored(t *testing.T) {
+	server, _ := setupTestServer()
+	fake := &fakeExecutor{}
+	if err := server.funcManager.RegisterExecutor(models.ExecutorFirecracker, fake); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.funcManager.SetDefaultExecutor(models.ExecutorFirecracker); err != nil {
+		t.Fatal(err)
+	}
+	createTestFunction(t, server, "in-vm")
+
+	// local=true is deprecated: the function still runs on its executor
+	for _, query := range []string{"?local=true", "?mode=stream&local=true"} {
+		req := httptest.NewRequest("POST", "/api/v1/functions/in-vm/invoke"+query, strings.NewReader(`{"action": "echo"}`))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+	}
+	if calls := fake.calls(); calls != [3]int{2, 2, 2} {
+		t.Errorf("Expected both invocations to run on the default executor, got %v", calls)
+	}
+}
+
+func TestPinnedExecutor(t *testing.T) {
+	server, _ := setupTestServer()
+	fake := &fakeExecutor{}
+	if err := server.funcManager.RegisterExecutor("fake", fake); err != nil {
+		t.Fatal(err)
+	}
+
+	// Functions can pin a registered executor, but not an unknown one
+	for executor, want := range map[string]int{"fake": http.StatusCreated, "docker": http.StatusBadRequest} {
+		body, _ := json.Marshal(models.CreateFunctionRequest{
+			Name:     "pinned-" + executor,
+			Runtime:  models.RuntimeNodeJS20,
+			Handler:  "index.handler",
+			Code:     "exports.handler = () => {};",
+			Executor: models.ExecutorType(executor),
+		})
+		req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("Expected status %d for executor %s, got %d: %s", want, executor, rr.Code, rr.Body.String())
+		}
+	}
+	createTestFunction(t, server, "repinned")
+	req := httptest.NewRequest("PATCH", "/api/v1/functions/repinned", strings.NewReader(`{"executor": "fake"}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The server's default is the local executor; only the fake counts
+	for _, name := range []string{"pinned-fake", "repinned"} {
+		before := fake.calls()
+		req = httptest.NewRequest("POST", "/api/v1/functions/"+name+"/invoke", strings.NewReader(`{"action": "echo"}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected %s to run on the fake, got %d: %s", name, rr.Code, rr.Body.String())
+		}
+		if calls := fake.calls(); calls != [3]int{before[0] + 1, before[1] + 1, before[2] + 1} {
+			t.Errorf("Expected %s to be prepared, invoked and torn down once, went from %v to %v", name, before, calls)
+		}
+
+		before = fake.calls()
+		req = httptest.NewRequest("POST", "/api/v1/functions/"+name+"/invoke", strings.NewReader(`{"action": "fail"}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500 for a failed invocation of %s, got %d", name, rr.Code)
+		}
+		if calls := fake.calls(); calls != [3]int{before[0] + 1, before[1] + 1, before[2] + 1} {
+			t.Errorf("Expected a failed invocation of %s to be torn down too, went from %v to %v", name, before, calls)
+		}
+	}
+
+	// A function pinned to an executor the server no longer registers
+	// cannot run on it
+	other, store := setupTestServer()
+	fn, err := server.funcManager.Get("pinned-fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Create(fn)
+	store.SaveCode(fn.Name, []byte(fn.Code))
+	req = httptest.NewRequest("POST", "/api/v1/functions/pinned-fake/invoke", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	other.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 for an unregistered executor, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// fakeFirecracker accepts every API call and, once the vsock device is
+// configured, answers invocations the way the guest agent would. With
+// FAKE_FIRECRACKER_LOG set, it appends the API calls and the files of each
+// invocation to that file, one JSON document per line.
+const fakeFirecracker = `#!/usr/bin/env python3
+import http.server, json, os, socketserver, sys, threading
+
+def record(entry):
+    if "FAKE_FIRECRACKER_LOG" in os.environ:
+        with open(os.environ["FAKE_FIRECRACKER_LOG"], "a") as f:
+            f.write(json.dumps(entry) + "\n")
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def body(self):
+        return json.loads(self.rfile.read(int(self.headers["Content-Length"])))
+
+    def log_message(self, *args):
+        pass
+
+class API(Handler):
+    def do_PUT(self):
+        body = self.body()
+        record({"path": self.path, "body": body})
+        if self.path == "/vsock":
+            serve(body["uds_path"], Guest)
+        self.send_response(204)
+        self.end_headers()
+
+class Guest(Handler):
+    def handle(self):
+        if self.rfile.readline() != b"CONNECT 8080\n":
+            return
+        self.wfile.write(b"OK 1\n")
+        super().handle()
+
+    def do_POST(self):
+        body = self.body()
+        record({"path": self.path, "files": sorted(body.get("files") or {})})
+        result = json.dumps({"statusCode": 200, "body": body["event"]}).encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(result)))
+        self.end_headers()
+        self.wfile.write(result)
+
+def serve(path, handler):
+    server = socketserver.ThreadingUnixStreamServer(path, handler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+
+serve(sys.argv[sys.argv.index("--api-sock") + 1], API)
+threading.Event().wait()
+`
+
+func TestDedicatedVM(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	dir := t.TempDir()
+	config := firecracker.Config{
+		FirecrackerBin: filepath.Join(dir, "firecracker"),
+		KernelPath:     filepath.Join(dir, "vmlinux"),
+		RootFSPath:     filepath.Join(dir, "rootfs.ext4"),
+		DataDir:        dir,
+	}
+	if err := os.WriteFile(config.FirecrackerBin, []byte(fakeFirecracker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.RootFSPath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fcManager, err := firecracker.NewManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(function.NewManager(newMockStorage(), fcManager))
+
+	// Without a pool or a network, the invocation boots a VM of its own;
+	// it has to keep running once Prepare has taken it
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:      "dedicated",
+		Runtime:   models.RuntimeNodeJS20,
+		Handler:   "index.handler",
+		Code:      "exports.handler = (event) => event;",
+		NoNetwork: true,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/dedicated/invoke", strings.NewReader(`{"ping": "pong"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response models.InvocationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := response.Body.(map[string]interface{})
+	if rr.Code != http.StatusOK || result["ping"] != "pong" {
+		t.Errorf("Expected the guest to answer, got %d %+v", rr.Code, response)
+	}
+
+	// Teardown stops the VM
+	if vms := fcManager.ListVMs(); len(vms) != 0 {
+		t.Errorf("Expected no VMs after the invocation, got %d", len(vms))
+	}
+}
+
+func TestDependenciesDrive(t *testing.T) {
+	for _, bin := range []string{"python3", "npm", "mkfs.ext4"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+	dir := t.TempDir()
+	config := firecracker.Config{
+		FirecrackerBin: filepath.Join(dir, "firecracker"),
+		KernelPath:     filepath.Join(dir, "vmlinux"),
+		RootFSPath:     filepath.Join(dir, "rootfs.ext4"),
+		DataDir:        dir,
+	}
+	if err := os.WriteFile(config.FirecrackerBin, []byte(fakeFirecracker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.RootFSPath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "calls.log")
+	t.Setenv("FAKE_FIRECRACKER_LOG", log)
+	fcManager, err := firecracker.NewManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := function.NewManager(newMockStorage(), fcManager)
+	if err := mgr.SetBuildCache(t.TempDir(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(mgr)
+
+	dep := writePackage(t, filepath.Join(t.TempDir(), "greet-1.0.0.tgz"), tarGzPackage(t,
+		packageEntry{name: "package/package.json", body: `{"name": "greet", "version": "1.0.0", "main": "index.js"}`},
+		packageEntry{name: "package/index.js", body: "exports.greet = (name) => `hello ${name}`;"},
+	))
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "with-deps",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code: tarGzPackage(t,
+			packageEntry{name: "package.json", body: `{"name": "fn", "version": "1.0.0", "dependencies": {"greet": "file:` + dep + `"}}`},
+			packageEntry{name: "index.js", body: "const { greet } = require('greet'); exports.handler = async (event) => greet(event.name);"},
+		),
+		CodeFormat: models.CodeFormatTarGz,
+		NoNetwork:  true,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	build := waitForBuild(t, server, "with-deps")
+	if build.Status != models.BuildReady {
+		t.Fatalf("Expected a ready build, got %+v", build)
+	}
+
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest("POST", "/api/v1/functions/with-deps/invoke", strings.NewReader(`{"name": "world"}`))
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// The dependencies are a drive image built once for their hash; the
+	// invocations carry only the package's own files
+	image := filepath.Join(dir, "layers", "deps-"+build.DependenciesHash+".ext4")
+	if _, err := os.Stat(image); err != nil {
+		t.Fatalf("Expected the dependencies image %s: %v", image, err)
+	}
+	if _, err := exec.LookPath("debugfs"); err == nil {
+		out, err := exec.Command("debugfs", "-R", "cat /node_modules/greet/index.js", image).Output()
+		if err != nil || !strings.Contains(string(out), "exports.greet") {
+			t.Errorf("Expected the installed dependencies in the image, got %q, %v", out, err)
+		}
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drives, invocations := 0, 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var call struct {
+			Path  string                 `json:"path"`
+			Body  map[string]interface{} `json:"body"`
+			Files []string               `json:"files"`
+		}
+		if err := json.Unmarshal([]byte(line), &call); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case call.Path == "/invoke":
+			invocations++
+			if !slices.Equal(call.Files, []string{"index.js", "package.json"}) {
+				t.Errorf("Expected only the package's files in the invocation, got %v", call.Files)
+			}
+		case strings.HasPrefix(call.Path, "/drives/") && call.Body["path_on_host"] == image:
+			drives++
+			if call.Body["is_read_only"] != true {
+				t.Errorf("Expected the dependencies drive to be read-only, got %+v", call.Body)
+			}
+		}
+	}
+	if invocations != 2 || drives != 2 {
+		t.Errorf("Expected both VMs to get the dependencies drive, got %d drives for %d invocations", drives, invocations)
+	}
+}
+
+func TestFunctionURL(t *testing.T) {
+	server, _ := setupTestServer()
+
+	code := `exports.handler = async (event) => {
+  switch (event.path) {
+    case '/plain':
+      return 'hello ' + (event.query.name || 'world');
+    case '/binary':
+      return {statusCode: 200, headers: {'content-type': 'image/png'}, isBase64Encoded: true, body: Buffer.from([0x89, 0x50, 0x00, 0xff]).toString('base64')};
+    case '/fail':
+      throw new Error('internal detail');
+    default:
+      return {
+        statusCode: 201,
+        headers: {'x-custom': 'yes', 'set-cookie': ['a=1', 'b=2'], 'access-control-allow-origin': 'https://evil.example'},
+        body: event,
+      };
+  }
+};`
+	body, _ := json.Marshal(models.CreateFunctionRequest{
+		Name:    "web",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code:    code,
+		CORS: &models.CORSConfig{
+			AllowOrigins:  []string{"https://app.example.com"},
+			AllowMethods:  []string{"GET", "POST"},
+			AllowHeaders:  []string{"Content-Type"},
+			ExposeHeaders: []string{"X-Custom"},
+			MaxAgeSec:     600,
+		},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Preflight requests are answered without invoking the function
+	req = httptest.NewRequest("OPTIONS", "/fn/web/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := rr.Header().Get(header); got != want {
+			t.Errorf("Expected %s %q, got %q", header, want, got)
+		}
+	}
+
+	req = httptest.NewRequest("OPTIONS", "/fn/web/items", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected a preflight without CORS headers for another origin, got %d %v", rr.Code, rr.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/fn/missing/", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+
+	// Callers cannot take the function out of its VM
+	fake := &fakeExecutor{}
+	if err := server.funcManager.RegisterExecutor(models.ExecutorFirecracker, fake); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.funcManager.SetDefaultExecutor(models.ExecutorFirecracker); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest("POST", "/fn/web/", strings.NewReader(`{}`))
+	req.Header.Set("X-Impuls-Local", "true")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if calls := fake.calls(); calls[1] != 1 {
+		t.Errorf("Expected the server's default executor to run the function, got %v calls", calls)
+	}
+
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	if err := server.funcManager.SetDefaultExecutor(models.ExecutorLocal); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("POST", "/fn/web/items/42?tag=a&tag=b", strings.NewReader(`{"n": 1}`))
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("X-Custom") != "yes" || len(rr.Header().Values("Set-Cookie")) != 2 {
+		t.Errorf("Expected the function's headers, got %v", rr.Header())
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || rr.Header().Get("Access-Control-Expose-Headers") != "X-Custom" {
+		t.Errorf("Expected the CORS policy to replace the function's CORS headers, got %v", rr.Header())
+	}
+	if rr.Header().Get("Content-Type") != "application/json" || rr.Header().Get("X-Impuls-Invocation-Id") == "" {
+		t.Errorf("Expected a JSON body and the invocation ID, got %v", rr.Header())
+	}
+	var event struct {
+		Method          string            `json:"method"`
+		Path            string            `json:"path"`
+		Query           map[string]string `json:"query"`
+		Headers         map[string]string `json:"headers"`
+		Body            string            `json:"body"`
+		IsBase64Encoded bool              `json:"isBase64Encoded"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Method != "POST" || event.Path != "/items/42" || event.Query["tag"] != "a,b" || event.Headers["content-type"] != "application/json" || event.Body != `{"n": 1}` || event.IsBase64Encoded {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	// Bodies that are not text are passed base64-encoded
+	req = httptest.NewRequest("PUT", "/fn/web/upload", bytes.NewReader([]byte{0xff, 0xfe, 0x00}))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	event.Body, event.IsBase64Encoded = "", false
+	if err := json.NewDecoder(rr.Body).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	if !event.IsBase64Encoded || event.Body != base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0x00}) {
+		t.Errorf("Expected a base64 body, got %+v", event)
+	}
+
+	req = httptest.NewRequest("GET", "/fn/web/plain?name=impuls", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello impuls" || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected a text response, got %d %q (%s)", rr.Code, rr.Body.String(), rr.Header().Get("Content-Type"))
+	}
+
+	req = httptest.NewRequest("GET", "/fn/web/binary", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if !bytes.Equal(rr.Body.Bytes(), []byte{0x89, 0x50, 0x00, 0xff}) || rr.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected a binary response, got %q (%s)", rr.Body.Bytes(), rr.Header().Get("Content-Type"))
+	}
+
+	req = httptest.NewRequest("GET", "/fn/web/fail", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadGateway || strings.Contains(rr.Body.String(), "internal detail") {
+		t.Errorf("Expected status 502 without the function's error, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Functions are addressed by version or alias after a colon
+	req = httptest.NewRequest("GET", "/fn/web:missing/plain", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown alias, got %d", rr.Code)
+	}
+}
+
+func TestFunctionURLCORSUpdate(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "web")
+
+	req := httptest.NewRequest("PATCH", "/api/v1/functions/web", strings.NewReader(`{"cors": {"allow_origins": ["*"], "allow_credentials": true}}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "cors") {
+		t.Errorf("Expected status 400 on cors, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/v1/functions/web", strings.NewReader(`{"cors": {"allow_origins": ["*"]}}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var fn models.Function
+	if err := json.NewDecoder(rr.Body).Decode(&fn); err != nil {
+		t.Fatal(err)
+	}
+	if fn.CORS == nil || fn.CORS.AllowOrigins[0] != "*" {
+		t.Fatalf("Expected the CORS policy to be set, got %+v", fn.CORS)
+	}
+
+	req = httptest.NewRequest("OPTIONS", "/fn/web", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" || rr.Header().Get("Access-Control-Allow-Methods") != "DELETE" {
+		t.Errorf("Expected any origin and the requested method to be allowed, got %v", rr.Header())
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/v1/functions/web", strings.NewReader(`{"cors": {"allow_origins": []}}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	fn = models.Function{}
+	if err := json.NewDecoder(rr.Body).Decode(&fn); err != nil {
+		t.Fatal(err)
+	}
+	if fn.CORS != nil {
+		t.Errorf("Expected the CORS policy to be removed, got %+v", fn.CORS)
+	}
+}
+
+func TestListFunctions(t *testing.T) {
+	server, _ := setupTestServer()
+
+	// Create some functions first
+	for _, name := range []string{"fn-1", "fn-2", "fn-3"} {
+		funcReq := models.CreateFunctionRequest{
+			Name:    name,
+			Runtime: models.RuntimeNodeJS20,
+			Handler: "index.handler",
+			Code:    "exports.handler = () => {};",
+		}
+		body, _ := json.Marshal(funcReq)
+		req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+	}
+
+	// List functions
+	req := httptest.NewRequest("GET", "/api/v1/functions", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	count := int(response["count"].(float64))
+	if count != 3 {
+		t.Errorf("Expected count 3, got %d", count)
+	}
+}
+
+func TestGetFunction(t *testing.T) {
+	server, _ := setupTestServer()
+
+	// Create a function
+	funcReq := models.CreateFunctionRequest{
+		Name:    "test-function",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code:    "exports.handler = () => {};",
+	}
+	body, _ := json.Marshal(funcReq)
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	// Get the function
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	var response models.Function
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Name != "test-function" {
+		t.Errorf("Expected name 'test-function', got '%s'", response.Name)
+	}
+}
+
+func TestGetFunctionNotFound(t *testing.T) {
+	server, _ := setupTestServer()
+
+	req := httptest.NewRequest("GET", "/api/v1/functions/non-existent", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestUpdateFunction(t *testing.T) {
+	server, _ := setupTestServer()
+
+	// Create a function
+	funcReq := models.CreateFunctionRequest{
+		Name:       "test-function",
+		Runtime:    models.RuntimeNodeJS20,
+		Handler:    "index.handler",
+		Code:       "exports.handler = () => {};",
+		MemoryMB:   128,
+		TimeoutSec: 30,
+	}
+	body, _ := json.Marshal(funcReq)
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	// Update the function
+	memoryMB := 256
+	timeoutSec := 60
+	noNetwork := true
+	updateReq := models.UpdateFunctionRequest{
+		MemoryMB:   &memoryMB,
+		TimeoutSec: &timeoutSec,
+		NoNetwork:  &noNetwork,
+	}
+	body, _ = json.Marshal(updateReq)
+	req = httptest.NewRequest("PUT", "/api/v1/functions/test-function", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response models.Function
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.MemoryMB != 256 {
+		t.Errorf("Expected MemoryMB 256, got %d", response.MemoryMB)
+	}
+
+	if response.TimeoutSec != 60 {
+		t.Errorf("Expected TimeoutSec 60, got %d", response.TimeoutSec)
+	}
+
+	if !response.NoNetwork {
+		t.Error("Expected NoNetwork to be set")
+	}
+}
+
+func TestUpdateFunctionRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		update string
+	}{
+		{name: "concurrency", update: `"max_concurrency": 1, "reserved_concurrency": 2`},
+		{name: "missing layer", update: `"layers": [{"name": "missing", "version": 1}]`},
+		{name: "missing secret", update: `"secrets": {"TOKEN": "missing"}`},
+		{name: "unknown executor", update: `"executor": "docker"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, store := setupTestServer()
+			createTestFunction(t, server, "test-function")
+
+			// A rejected update changes neither the code nor any other field
+			body := `{"code": "exports.handler = () => 2;", "memory_mb": 256, ` + tt.update + `}`
+			req := httptest.NewRequest("PUT", "/api/v1/functions/test-function", strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code < 400 || rr.Code >= 500 {
+				t.Fatalf("Expected the update to be rejected, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			fn, err := store.Get("test-function")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fn.Code != "exports.handler = () => {};" || fn.MemoryMB != 128 {
+				t.Errorf("Expected the function to be unchanged, got code %q and %d MB", fn.Code, fn.MemoryMB)
+			}
+			if code, _ := store.GetCode("test-function"); string(code) != "exports.handler = () => {};" {
+				t.Errorf("Expected the stored code to be unchanged, got %q", code)
+			}
+		})
+	}
+}
+
+func TestDeleteFunction(t *testing.T) {
+	server, _ := setupTestServer()
 
 	// Create a function
 	funcReq := models.CreateFunctionRequest{
-		Name:       "test-function",
-		Runtime:    models.RuntimeNodeJS20,
-		Handler:    "index.handler",
-		Code:       "exports.handler = () => {};",
-		MemoryMB:   128,
-		TimeoutSec: 30,
+		Name:    "test-function",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code:    "exports.handler = () => {};",
+	}
+	body, _ := json.Marshal(funcReq)
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	// Delete the function
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	// Verify it's deleted
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after deletion, got %d", rr.Code)
+	}
+}
+
+func TestDeleteFunctionNotFound(t *testing.T) {
+	server, _ := setupTestServer()
+
+	req := httptest.NewRequest("DELETE", "/api/v1/functions/non-existent", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestListVMs(t *testing.T) {
+	server, _ := setupTestServer()
+
+	req := httptest.NewRequest("GET", "/api/v1/vms", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	// VM routes are registered but return empty list
+	// This is currently a placeholder
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+}
+
+// createTestFunction creates a Node.js function through the API
+func createTestFunction(t *testing.T, server *Server, name string) {
+	t.Helper()
+
+	funcReq := models.CreateFunctionRequest{
+		Name:    name,
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code:    "exports.handler = () => {};",
 	}
 	body, _ := json.Marshal(funcReq)
 	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
@@ -312,94 +2805,1051 @@ func TestUpdateFunction(t *testing.T) {
 	rr := httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
 
-	// Update the function
-	memoryMB := 256
-	timeoutSec := 60
-	updateReq := models.UpdateFunctionRequest{
-		MemoryMB:   &memoryMB,
-		TimeoutSec: &timeoutSec,
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Failed to create function: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPublishVersion(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var version models.FunctionVersion
+	if err := json.NewDecoder(rr.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != 1 {
+		t.Errorf("Expected version 1, got %d", version.Version)
+	}
+
+	// Publishing unchanged code returns the same version
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != 1 {
+		t.Errorf("Expected unchanged publish to return version 1, got %d", version.Version)
+	}
+
+	// Changing the code publishes a new version
+	code := "exports.handler = () => 2;"
+	body, _ := json.Marshal(models.UpdateFunctionRequest{Code: &code})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/test-function", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != 2 {
+		t.Errorf("Expected version 2, got %d", version.Version)
+	}
+	if version.Code != code {
+		t.Errorf("Expected version code %q, got %q", code, version.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/versions", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if count := int(response["count"].(float64)); count != 2 {
+		t.Errorf("Expected 2 versions, got %d", count)
+	}
+
+	// The number of a deleted version is never handed out again
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function/versions/2", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	code = "exports.handler = () => 3;"
+	body, _ = json.Marshal(models.UpdateFunctionRequest{Code: &code})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/test-function", bytes.NewReader(body))
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != 3 {
+		t.Errorf("Expected version 3 after deleting version 2, got %d", version.Version)
+	}
+}
+
+func TestPublishVersionFunctionNotFound(t *testing.T) {
+	server, _ := setupTestServer()
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/non-existent/versions", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	// Alias to a version that does not exist
+	body, _ := json.Marshal(models.CreateAliasRequest{Name: "prod", Version: 5})
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/aliases", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown version, got %d", rr.Code)
+	}
+
+	body, _ = json.Marshal(models.CreateAliasRequest{Name: "prod", Version: 1})
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/aliases", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Duplicate alias
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/aliases", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for duplicate alias, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/aliases/prod", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var alias models.Alias
+	if err := json.NewDecoder(rr.Body).Decode(&alias); err != nil {
+		t.Fatal(err)
+	}
+	if alias.Version != 1 {
+		t.Errorf("Expected alias to point at version 1, got %d", alias.Version)
+	}
+
+	// A version referenced by an alias cannot be deleted
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function/versions/1", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 deleting aliased version, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function/aliases/prod", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function/versions/1", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 deleting unreferenced version, got %d", rr.Code)
+	}
+}
+
+func TestAliasTrafficSplit(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	code := "exports.handler = () => 2;"
+	body, _ := json.Marshal(models.UpdateFunctionRequest{Code: &code})
+	req = httptest.NewRequest("PUT", "/api/v1/functions/test-function", bytes.NewReader(body))
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/versions", nil)
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	// Splitting to an unpublished version fails
+	body, _ = json.Marshal(models.CreateAliasRequest{
+		Name:          "prod",
+		Version:       1,
+		RoutingConfig: &models.AliasRoutingConfig{AdditionalVersion: 3, Weight: 0.1},
+	})
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/aliases", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown additional version, got %d", rr.Code)
+	}
+
+	body, _ = json.Marshal(models.CreateAliasRequest{
+		Name:          "prod",
+		Version:       1,
+		RoutingConfig: &models.AliasRoutingConfig{AdditionalVersion: 2, Weight: 0.1},
+	})
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/aliases", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The canary version is referenced by the alias
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function/versions/2", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 deleting canary version, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/metrics?alias=prod", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for metrics, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Promoting the canary removes the split
+	version := 2
+	body, _ = json.Marshal(models.UpdateAliasRequest{Version: &version})
+	req = httptest.NewRequest("PATCH", "/api/v1/functions/test-function/aliases/prod", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var alias models.Alias
+	if err := json.NewDecoder(rr.Body).Decode(&alias); err != nil {
+		t.Fatal(err)
+	}
+	if alias.Version != 2 || alias.RoutingConfig != nil {
+		t.Errorf("Expected alias promoted to version 2 without routing, got %+v", alias)
+	}
+}
+
+func TestInvokeUnknownQualifier(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/test-function/invoke?qualifier=prod", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown alias, got %d", rr.Code)
+	}
+}
+
+func TestInvokeStream(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not installed")
+	}
+	server, _ := setupTestServer()
+
+	code := `exports.handler = async (event, context) => {
+  context.write('first\n');
+  if (event.fail) throw new Error('broken');
+  await new Promise(resolve => setTimeout(resolve, event.delay || 0));
+  context.write(Buffer.from([0x00, 0xff]));
+  context.write({n: 1});
+  return 'last';
+};
+exports.quiet = async (event) => {
+  if (event.fail) throw new Error('broken');
+  return {done: true};
+};`
+	for _, fn := range []struct{ name, handler string }{{"streamer", "index.handler"}, {"quiet", "index.quiet"}} {
+		body, _ := json.Marshal(models.CreateFunctionRequest{
+			Name:    fn.name,
+			Runtime: models.RuntimeNodeJS20,
+			Handler: fn.handler,
+			Code:    code,
+		})
+		req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	// Chunks reach the client while the function is still running
+	start := time.Now()
+	resp, err := http.Post(ts.URL+"/api/v1/functions/streamer/invoke?mode=stream", "application/json", strings.NewReader(`{"delay": 1500}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	first, err := reader.ReadString('\n')
+	if err != nil || first != "first\n" {
+		t.Fatalf("Expected the first chunk, got %q (%v)", first, err)
+	}
+	if elapsed := time.Since(start); elapsed > 1400*time.Millisecond {
+		t.Errorf("Expected the first chunk before the function finished, got it after %s", elapsed)
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x00\xff{\"n\":1}last"; string(rest) != want {
+		t.Errorf("Expected the remaining chunks %q, got %q", want, rest)
+	}
+	if resp.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("Expected an octet stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	if resp.Trailer.Get("X-Impuls-Invocation-Id") == "" || resp.Trailer.Get("X-Impuls-Version") != "$LATEST" || resp.Trailer.Get("X-Impuls-Error") != "" {
+		t.Errorf("Expected the invocation in the trailers, got %v", resp.Trailer)
+	}
+
+	// A failure after the first chunk is reported in the trailers
+	req := httptest.NewRequest("POST", "/api/v1/functions/streamer/invoke?mode=stream", strings.NewReader(`{"fail": true}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "first\n" || !strings.Contains(rr.Result().Trailer.Get("X-Impuls-Error"), "broken") {
+		t.Errorf("Expected the first chunk and the error in the trailers, got %d %q %v", rr.Code, rr.Body.String(), rr.Result().Trailer)
+	}
+
+	// Before the first chunk it is reported like a synchronous invocation
+	req = httptest.NewRequest("POST", "/api/v1/functions/quiet/invoke?mode=stream", strings.NewReader(`{"fail": true}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response models.InvocationResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(response.Error, "broken") {
+		t.Errorf("Expected status 500 with the error, got %d %+v", rr.Code, response)
+	}
+
+	// Functions that only return a result stream it as one chunk
+	req = httptest.NewRequest("POST", "/api/v1/functions/quiet/invoke?mode=stream", strings.NewReader(`{}`))
+	req.Header.Set("Accept", "text/event-stream")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Type") != "text/event-stream" || !strings.HasPrefix(rr.Body.String(), "event: chunk\ndata: {\"done\":true}\n\nevent: done\ndata: {") {
+		t.Errorf("Expected a chunk event and a done event, got %q", rr.Body.String())
+	}
+
+	// Without streaming, the chunks are collected
+	req = httptest.NewRequest("POST", "/api/v1/functions/streamer/invoke", strings.NewReader(`{}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	response = models.InvocationResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Body != "last" {
+		t.Errorf("Expected the returned body, got %+v", response)
+	}
+}
+
+func TestInvokeStreamGenerators(t *testing.T) {
+	tests := []struct {
+		name    string
+		bin     string
+		runtime models.Runtime
+		code    string
+	}{
+		{
+			name:    "nodejs",
+			bin:     "node",
+			runtime: models.RuntimeNodeJS20,
+			code: `exports.handler = async function* (event) {
+  yield 'data: 1\n';
+  yield 'data: 2';
+};`,
+		},
+		{
+			name:    "python",
+			bin:     "python3",
+			runtime: models.RuntimePython312,
+			code: `def handler(event, context):
+    context.write(b'data: 1\n')
+    yield 'data: 2'
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.bin); err != nil {
+				t.Skipf("%s not installed", tt.bin)
+			}
+			server, _ := setupTestServer()
+			body, _ := json.Marshal(models.CreateFunctionRequest{
+				Name:    "generator",
+				Runtime: tt.runtime,
+				Handler: "index.handler",
+				Code:    tt.code,
+			})
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			// Every chunk is one event; lines of a chunk are data lines
+			req = httptest.NewRequest("POST", "/api/v1/functions/generator/invoke?mode=stream", strings.NewReader(`{}`))
+			req.Header.Set("Accept", "text/event-stream")
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			want := "event: chunk\ndata: data: 1\ndata: \n\nevent: chunk\ndata: data: 2\n\nevent: done\n"
+			if !strings.HasPrefix(rr.Body.String(), want) {
+				t.Errorf("Expected events %q, got %q", want, rr.Body.String())
+			}
+
+			req = httptest.NewRequest("POST", "/api/v1/functions/generator/invoke", strings.NewReader(`{}`))
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			var response models.InvocationResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Body != "data: 1\ndata: 2" {
+				t.Errorf("Expected the chunks as the body, got %+v", response)
+			}
+		})
+	}
+}
+
+func TestInvokeAsync(t *testing.T) {
+	server, store := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	req := httptest.NewRequest("POST", "/api/v1/functions/test-function/invoke?mode=async", bytes.NewReader([]byte(`{"key":"value"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
 	}
-	body, _ = json.Marshal(updateReq)
-	req = httptest.NewRequest("PUT", "/api/v1/functions/test-function", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := response["invocation_id"].(string)
+	if id == "" {
+		t.Fatalf("Expected an invocation ID, got %v", response)
+	}
+
+	inv, ok := store.async[id]
+	if !ok {
+		t.Fatalf("Expected invocation %s to be queued", id)
+	}
+	if inv.Status != models.AsyncStatusQueued || string(inv.Payload) != `{"key":"value"}` {
+		t.Errorf("Unexpected queued invocation: %+v", inv)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/async-invocations/"+id, nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	// Only dead-lettered invocations can be replayed
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/dead-letters/"+id+"/replay", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 replaying a queued invocation, got %d", rr.Code)
+	}
+
+	inv.Status = models.AsyncStatusDeadLetter
+	inv.Attempts = 3
+	inv.LastError = "boom"
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/dead-letters", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if count := int(response["count"].(float64)); count != 1 {
+		t.Errorf("Expected 1 dead letter, got %d", count)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/test-function/dead-letters/"+id+"/replay", nil)
 	rr = httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202 replaying, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if inv := store.async[id]; inv.Status != models.AsyncStatusQueued || inv.Attempts != 0 || inv.LastError != "" {
+		t.Errorf("Expected replayed invocation to be queued afresh, got %+v", inv)
+	}
+}
+
+func TestInvokeAsyncErrors(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{name: "unknown function", url: "/api/v1/functions/missing/invoke?mode=async", status: http.StatusNotFound},
+		{name: "unknown qualifier", url: "/api/v1/functions/test-function/invoke?mode=async&qualifier=prod", status: http.StatusNotFound},
+		{name: "invalid mode", url: "/api/v1/functions/test-function/invoke?mode=later", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, nil)
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/functions/test-function/async-invocations/missing", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown invocation, got %d", rr.Code)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	body, _ := json.Marshal(models.CreateScheduleRequest{
+		Expression: "rate(5 minutes)",
+		Payload:    json.RawMessage(`{"job":"cleanup"}`),
+		JitterSec:  10,
+	})
+	req := httptest.NewRequest("POST", "/api/v1/functions/test-function/schedules", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var schedule models.Schedule
+	if err := json.NewDecoder(rr.Body).Decode(&schedule); err != nil {
+		t.Fatal(err)
+	}
+	if !schedule.Enabled {
+		t.Error("Expected new schedule to be enabled")
+	}
+	if until := time.Until(schedule.NextRunAt); until <= 4*time.Minute || until > 5*time.Minute {
+		t.Errorf("Expected next run in about 5 minutes, got %v", until)
+	}
 
+	expression := "0 9 * * MON-FRI"
+	body, _ = json.Marshal(models.UpdateScheduleRequest{Expression: &expression})
+	req = httptest.NewRequest("PATCH", "/api/v1/functions/test-function/schedules/"+schedule.ID, bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&schedule); err != nil {
+		t.Fatal(err)
+	}
+	if next := schedule.NextRunAt.UTC(); next.Hour() != 9 || next.Minute() != 0 || next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+		t.Errorf("Expected next run at 09:00 on a weekday, got %v", next)
 	}
 
-	var response models.Function
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/schedules", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var response map[string]interface{}
 	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
 		t.Fatal(err)
 	}
+	if count := int(response["count"].(float64)); count != 1 {
+		t.Errorf("Expected 1 schedule, got %d", count)
+	}
 
-	if response.MemoryMB != 256 {
-		t.Errorf("Expected MemoryMB 256, got %d", response.MemoryMB)
+	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function/schedules/"+schedule.ID, nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
 	}
 
-	if response.TimeoutSec != 60 {
-		t.Errorf("Expected TimeoutSec 60, got %d", response.TimeoutSec)
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/schedules/"+schedule.ID, nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after delete, got %d", rr.Code)
 	}
 }
 
-func TestDeleteFunction(t *testing.T) {
+func TestCreateScheduleInvalid(t *testing.T) {
 	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	tests := []struct {
+		name   string
+		req    models.CreateScheduleRequest
+		status int
+	}{
+		{name: "missing expression", req: models.CreateScheduleRequest{}, status: http.StatusBadRequest},
+		{name: "bad cron", req: models.CreateScheduleRequest{Expression: "61 * * * *"}, status: http.StatusBadRequest},
+		{name: "bad rate", req: models.CreateScheduleRequest{Expression: "rate(0 minutes)"}, status: http.StatusBadRequest},
+		{name: "bad timezone", req: models.CreateScheduleRequest{Expression: "@daily", Timezone: "Mars/Olympus"}, status: http.StatusBadRequest},
+		{name: "negative jitter", req: models.CreateScheduleRequest{Expression: "@daily", JitterSec: -1}, status: http.StatusBadRequest},
+		{name: "unknown alias", req: models.CreateScheduleRequest{Expression: "@daily", Qualifier: "prod"}, status: http.StatusNotFound},
+	}
 
-	// Create a function
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.req)
+			req := httptest.NewRequest("POST", "/api/v1/functions/test-function/schedules", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestInvocationHistory(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	server, _ := setupTestServer()
 	funcReq := models.CreateFunctionRequest{
 		Name:    "test-function",
 		Runtime: models.RuntimeNodeJS20,
 		Handler: "index.handler",
-		Code:    "exports.handler = () => {};",
+		Code:    "exports.handler = async (event) => { console.log('got', event.n); if (event.n > 1) throw new Error('too big'); return event.n; };",
 	}
 	body, _ := json.Marshal(funcReq)
 	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	var ids []string
+	for _, payload := range []string{`{"n":1}`, `{"n":2}`} {
+		req := httptest.NewRequest("POST", "/api/v1/functions/test-function/invoke", bytes.NewReader([]byte(payload)))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+
+		var response models.InvocationResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.InvocationID == "" || rr.Header().Get("X-Impuls-Invocation-Id") != response.InvocationID {
+			t.Fatalf("Expected the invocation ID in the body and header, got %+v", response)
+		}
+		if !strings.Contains(response.Logs, "got") {
+			t.Errorf("Expected the function's output in the logs, got %q", response.Logs)
+		}
+		ids = append(ids, response.InvocationID)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/invocations/"+ids[1], nil)
 	rr := httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var record models.InvocationRecord
+	if err := json.NewDecoder(rr.Body).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != models.InvocationFailed || !strings.Contains(record.Error, "too big") {
+		t.Errorf("Expected a failed record, got %+v", record)
+	}
+	if record.Payload != `{"n":2}` || !strings.Contains(record.Logs, "got 2") || record.Version != models.LatestVersion {
+		t.Errorf("Expected payload, logs and version to be recorded, got %+v", record)
+	}
 
-	// Delete the function
-	req = httptest.NewRequest("DELETE", "/api/v1/functions/test-function", nil)
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/invocations?status=failed", nil)
 	rr = httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
+	var response map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if count := int(response["count"].(float64)); count != 1 {
+		t.Errorf("Expected 1 failed invocation, got %d", count)
+	}
+}
 
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", rr.Code)
+func TestInvocationHistoryErrors(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{name: "unknown function", url: "/api/v1/functions/missing/invocations", status: http.StatusNotFound},
+		{name: "unknown invocation", url: "/api/v1/functions/test-function/invocations/missing", status: http.StatusNotFound},
+		{name: "invalid status", url: "/api/v1/functions/test-function/invocations?status=broken", status: http.StatusBadRequest},
+		{name: "invalid before", url: "/api/v1/functions/test-function/invocations?before=yesterday", status: http.StatusBadRequest},
+		{name: "invalid limit", url: "/api/v1/functions/test-function/invocations?limit=0", status: http.StatusBadRequest},
+		{name: "valid filters", url: "/api/v1/functions/test-function/invocations?status=succeeded&limit=10&before=2025-01-15T10:00:00Z", status: http.StatusOK},
 	}
 
-	// Verify it's deleted
-	req = httptest.NewRequest("GET", "/api/v1/functions/test-function", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+		})
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	server, _ := setupTestServer()
+	funcReq := models.CreateFunctionRequest{
+		Name:    "test-function",
+		Runtime: models.RuntimeNodeJS20,
+		Handler: "index.handler",
+		Code:    "exports.handler = async (event) => { console.log('first'); console.error('second'); return 'ok'; };",
+	}
+	body, _ := json.Marshal(funcReq)
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/functions/test-function/logs?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	// The opening comment confirms the subscription is in place
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	// The invocation runs while the lines are read, which only waits for
+	// the lines themselves
+	invoked := make(chan *http.Response, 1)
+	invokeErr := make(chan error, 1)
+	go func() {
+		invokeResp, err := http.Post(ts.URL+"/api/v1/functions/test-function/invoke", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			invokeErr <- err
+			return
+		}
+		invoked <- invokeResp
+	}()
+
+	var lines []models.LogLine
+	for len(lines) < 2 {
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Log stream ended early: %v", err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(text), "data: ")
+		if !ok {
+			continue
+		}
+		var line models.LogLine
+		if err := json.Unmarshal([]byte(data), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	var response models.InvocationResponse
+	select {
+	case invokeResp := <-invoked:
+		json.NewDecoder(invokeResp.Body).Decode(&response)
+		invokeResp.Body.Close()
+		if _, err := strconv.ParseInt(invokeResp.Header.Get("X-Impuls-Duration"), 10, 64); err != nil {
+			t.Errorf("Expected the duration in milliseconds, got %q", invokeResp.Header.Get("X-Impuls-Duration"))
+		}
+	case err := <-invokeErr:
+		t.Fatal(err)
+	}
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Stream > lines[j].Stream })
+	if lines[0].Stream != "stdout" || !strings.Contains(lines[0].Line, "first") {
+		t.Errorf("Expected the stdout line, got %+v", lines[0])
+	}
+	if lines[1].Stream != "stderr" || !strings.Contains(lines[1].Line, "second") {
+		t.Errorf("Expected the stderr line, got %+v", lines[1])
+	}
+	if lines[0].InvocationID != response.InvocationID {
+		t.Errorf("Expected lines tagged with invocation %s, got %s", response.InvocationID, lines[0].InvocationID)
+	}
+
+	// Without follow the logs of finished invocations are returned
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/logs", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var recent map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&recent); err != nil {
+		t.Fatal(err)
+	}
+	if count := int(recent["count"].(float64)); count != 1 {
+		t.Errorf("Expected the logs of 1 invocation, got %d", count)
+	}
+}
+
+func TestLogsErrors(t *testing.T) {
+	server, _ := setupTestServer()
+	createTestFunction(t, server, "test-function")
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{name: "unknown function", url: "/api/v1/functions/missing/logs", status: http.StatusNotFound},
+		{name: "follow unknown function", url: "/api/v1/functions/missing/logs?follow=true", status: http.StatusNotFound},
+		{name: "invalid limit", url: "/api/v1/functions/test-function/logs?limit=abc", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+		})
+	}
+}
+
+func TestConcurrencyThrottling(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	server, _ := setupTestServer()
+	funcReq := models.CreateFunctionRequest{
+		Name:           "test-function",
+		Runtime:        models.RuntimeNodeJS20,
+		Handler:        "index.handler",
+		Code:           "exports.handler = async () => { await new Promise(r => setTimeout(r, 1000)); return 'done'; };",
+		MaxConcurrency: 1,
+	}
+	body, _ := json.Marshal(funcReq)
+	req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	invoke := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/functions/test-function/invoke", nil)
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- invoke() }()
+
+	// Wait for the first call to hold the only slot
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := server.funcManager.Concurrency("test-function")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Running == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("First invocation never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rr = invoke()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	if rr := <-first; rr.Code != http.StatusOK {
+		t.Errorf("Expected the first call to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/functions/test-function/concurrency", nil)
 	rr = httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
+	var stats models.FunctionConcurrency
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Throttles != 1 || stats.Running != 0 || stats.MaxConcurrency != 1 {
+		t.Errorf("Expected 1 throttle and nothing running, got %+v", stats)
+	}
 
-	if rr.Code != http.StatusNotFound {
-		t.Errorf("Expected status 404 after deletion, got %d", rr.Code)
+	req = httptest.NewRequest("GET", "/api/v1/concurrency", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	var host models.HostConcurrency
+	if err := json.NewDecoder(rr.Body).Decode(&host); err != nil {
+		t.Fatal(err)
+	}
+	if host.Throttles != 1 {
+		t.Errorf("Expected 1 throttle host-wide, got %+v", host)
 	}
 }
 
-func TestDeleteFunctionNotFound(t *testing.T) {
+func TestReservedConcurrency(t *testing.T) {
 	server, _ := setupTestServer()
+	if err := server.funcManager.SetConcurrencyLimit(10); err != nil {
+		t.Fatal(err)
+	}
 
-	req := httptest.NewRequest("DELETE", "/api/v1/functions/non-existent", nil)
+	create := func(name string, reserved int) int {
+		funcReq := models.CreateFunctionRequest{
+			Name:                name,
+			Runtime:             models.RuntimeNodeJS20,
+			Handler:             "index.handler",
+			Code:                "exports.handler = async () => 'ok';",
+			ReservedConcurrency: reserved,
+		}
+		body, _ := json.Marshal(funcReq)
+		req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := create("critical", 8); code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", code)
+	}
+	if code := create("greedy", 3); code != http.StatusBadRequest {
+		t.Errorf("Expected reservations over the host limit to be rejected, got %d", code)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "reservation fits", body: `{"reserved_concurrency": 10}`, status: http.StatusOK},
+		{name: "reservation over host limit", body: `{"reserved_concurrency": 11}`, status: http.StatusBadRequest},
+		{name: "reservation over function cap", body: `{"max_concurrency": 4}`, status: http.StatusBadRequest},
+		{name: "negative cap", body: `{"max_concurrency": -1}`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/api/v1/functions/critical", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/concurrency", nil)
 	rr := httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusNotFound {
-		t.Errorf("Expected status 404, got %d", rr.Code)
+	var host models.HostConcurrency
+	if err := json.NewDecoder(rr.Body).Decode(&host); err != nil {
+		t.Fatal(err)
+	}
+	if host.Limit != 10 || host.Reserved != 10 {
+		t.Errorf("Expected the whole host reserved, got %+v", host)
 	}
 }
 
-func TestListVMs(t *testing.T) {
+func TestVMRoutes(t *testing.T) {
 	server, _ := setupTestServer()
 
-	req := httptest.NewRequest("GET", "/api/v1/vms", nil)
+	// Without a Firecracker manager there are no VMs
+	req := httptest.NewRequest("GET", "/api/v1/vms?function=hello", nil)
 	rr := httptest.NewRecorder()
 	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var list struct {
+		VMs   []interface{} `json:"vms"`
+		Count int           `json:"count"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if list.VMs == nil || list.Count != 0 {
+		t.Errorf("Expected an empty VM list, got %+v", list)
+	}
 
-	// VM routes are registered but return empty list
-	// This is currently a placeholder
+	for _, method := range []string{"GET", "DELETE"} {
+		req = httptest.NewRequest(method, "/api/v1/vms/unknown-vm", nil)
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", method, rr.Code)
+		}
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/functions/hello/vms/drain", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", rr.Code)
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var drain map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&drain)
+	if drain["function"] != "hello" || drain["stopped"] != float64(0) || drain["draining"] != float64(0) {
+		t.Errorf("Unexpected drain response: %v", drain)
 	}
 }
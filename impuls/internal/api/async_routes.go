@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// registerAsyncRoutes registers asynchronous invocation and dead-letter routes
+func (s *Server) registerAsyncRoutes(api *mux.Router) {
+	api.HandleFunc("/functions/{name}/async-invocations/{id}", s.getAsyncInvocation).Methods("GET")
+
+	api.HandleFunc("/functions/{name}/dead-letters", s.listDeadLetters).Methods("GET")
+	api.HandleFunc("/functions/{name}/dead-letters/{id}/replay", s.replayDeadLetter).Methods("POST")
+	api.HandleFunc("/functions/{name}/dead-letters/{id}", s.deleteDeadLetter).Methods("DELETE")
+}
+
+// invokeAsync queues an invocation and responds with 202 Accepted
+func (s *Server) invokeAsync(w http.ResponseWriter, r *http.Request, name, qualifier string, payload interface{}) {
+	var raw json.RawMessage
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		raw = data
+	}
+
+	inv, err := s.funcManager.InvokeAsync(name, qualifier, raw)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/functions/"+name+"/async-invocations/"+inv.ID)
+	respondJSON(w, http.StatusAccepted, map[string]interface{}{
+		"invocation_id": inv.ID,
+		"status":        inv.Status,
+	})
+}
+
+// getAsyncInvocation returns the status and result of an async invocation
+func (s *Server) getAsyncInvocation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	inv, err := s.funcManager.GetAsyncInvocation(vars["name"], vars["id"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, inv)
+}
+
+// listDeadLetters lists invocations that exhausted their retries
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	invocations, err := s.funcManager.ListDeadLetters(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letters": invocations,
+		"count":        len(invocations),
+	})
+}
+
+// replayDeadLetter queues a dead-lettered invocation again
+func (s *Server) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	inv, err := s.funcManager.ReplayDeadLetter(vars["name"], vars["id"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, inv)
+}
+
+// deleteDeadLetter discards a dead-lettered invocation
+func (s *Server) deleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := s.funcManager.DeleteDeadLetter(vars["name"], vars["id"]); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "Dead letter deleted successfully",
+		"id":      vars["id"],
+	})
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// registerConcurrencyRoutes registers concurrency usage routes
+func (s *Server) registerConcurrencyRoutes(api *mux.Router) {
+	api.HandleFunc("/concurrency", s.getHostConcurrency).Methods("GET")
+	api.HandleFunc("/functions/{name}/concurrency", s.getFunctionConcurrency).Methods("GET")
+}
+
+// getHostConcurrency returns the host-wide concurrency limit and usage
+func (s *Server) getHostConcurrency(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, s.funcManager.HostConcurrency())
+}
+
+// getFunctionConcurrency returns a function's concurrency limits, running
+// invocations and throttle count
+func (s *Server) getFunctionConcurrency(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	stats, err := s.funcManager.Concurrency(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+const (
+	defaultInvocationListLimit = 50
+	maxInvocationListLimit     = 1000
+)
+
+// registerInvocationRoutes registers invocation history routes
+func (s *Server) registerInvocationRoutes(api *mux.Router) {
+	api.HandleFunc("/functions/{name}/invocations", s.listInvocations).Methods("GET")
+	api.HandleFunc("/functions/{name}/invocations/{id}", s.getInvocation).Methods("GET")
+}
+
+// listInvocations lists recorded invocations, newest first. The status,
+// before and limit query parameters narrow the list; passing the started_at
+// of the last record as before fetches the next page.
+func (s *Server) listInvocations(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	query := r.URL.Query()
+
+	filter := models.InvocationFilter{Limit: defaultInvocationListLimit}
+
+	switch status := models.InvocationStatus(query.Get("status")); status {
+	case "", models.InvocationSucceeded, models.InvocationFailed:
+		filter.Status = status
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid status: "+string(status)+". Must be 'succeeded' or 'failed'")
+		return
+	}
+
+	if before := query.Get("before"); before != "" {
+		t, err := time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid before: must be an RFC 3339 timestamp")
+			return
+		}
+		filter.Before = t
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxInvocationListLimit {
+			respondError(w, http.StatusBadRequest, "Invalid limit: must be between 1 and "+strconv.Itoa(maxInvocationListLimit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	invocations, err := s.funcManager.ListInvocations(name, filter)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"invocations": invocations,
+		"count":       len(invocations),
+	})
+}
+
+// getInvocation returns a recorded invocation with its payload and logs
+func (s *Server) getInvocation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	record, err := s.funcManager.GetInvocation(vars["name"], vars["id"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, record)
+}
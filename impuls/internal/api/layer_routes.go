@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+// registerLayerRoutes registers shared layer routes
+func (s *Server) registerLayerRoutes(api *mux.Router) {
+	api.HandleFunc("/layers", s.publishLayer).Methods("POST")
+	api.HandleFunc("/layers", s.listLayers).Methods("GET")
+	api.HandleFunc("/layers/{name}", s.listLayerVersions).Methods("GET")
+	api.HandleFunc("/layers/{name}/versions/{version}", s.getLayer).Methods("GET")
+	api.HandleFunc("/layers/{name}/versions/{version}", s.deleteLayer).Methods("DELETE")
+}
+
+// publishLayer publishes the next version of a layer
+func (s *Server) publishLayer(w http.ResponseWriter, r *http.Request) {
+	var req models.PublishLayerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	layer, err := s.funcManager.PublishLayer(&req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, layer)
+}
+
+// listLayers lists the latest version of every layer
+func (s *Server) listLayers(w http.ResponseWriter, r *http.Request) {
+	layers, err := s.funcManager.ListLayers()
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"layers": layers,
+		"count":  len(layers),
+	})
+}
+
+// listLayerVersions lists the versions of a layer
+func (s *Server) listLayerVersions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	versions, err := s.funcManager.ListLayerVersions(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// getLayer returns a layer version with its content
+func (s *Server) getLayer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, ok := models.ParseVersion(vars["version"])
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid version: "+vars["version"])
+		return
+	}
+
+	layer, err := s.funcManager.GetLayer(vars["name"], version)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, layer)
+}
+
+// deleteLayer deletes a layer version no function uses
+func (s *Server) deleteLayer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, ok := models.ParseVersion(vars["version"])
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid version: "+vars["version"])
+		return
+	}
+
+	if err := s.funcManager.DeleteLayer(vars["name"], version); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Layer version deleted successfully",
+		"name":    vars["name"],
+		"version": version,
+	})
+}
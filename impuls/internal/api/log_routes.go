@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+const (
+	defaultRecentLogsLimit = 10
+
+	// logHeartbeatInterval keeps idle log streams from being closed by
+	// proxies
+	logHeartbeatInterval = 15 * time.Second
+)
+
+// registerLogRoutes registers log retrieval and streaming routes
+func (s *Server) registerLogRoutes(api *mux.Router) {
+	api.HandleFunc("/functions/{name}/logs", s.getLogs).Methods("GET")
+}
+
+// getLogs returns the logs of recent invocations, or with follow=true
+// streams the output of running invocations as Server-Sent Events
+func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	query := r.URL.Query()
+
+	if query.Get("follow") == "true" {
+		s.followLogs(w, r, name, query.Get("invocation_id"))
+		return
+	}
+
+	limit := defaultRecentLogsLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxInvocationListLimit {
+			respondError(w, http.StatusBadRequest, "Invalid limit: must be between 1 and "+strconv.Itoa(maxInvocationListLimit))
+			return
+		}
+		limit = n
+	}
+
+	records, err := s.funcManager.ListInvocations(name, models.InvocationFilter{Limit: limit})
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	logs := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		logs = append(logs, map[string]interface{}{
+			"invocation_id":  record.ID,
+			"started_at":     record.StartedAt,
+			"status":         record.Status,
+			"logs":           record.Logs,
+			"logs_truncated": record.LogsTruncated,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"function": name,
+		"logs":     logs,
+		"count":    len(logs),
+	})
+}
+
+// followLogs streams log lines as "log" events until the client goes away.
+// If invocationID is set, only that invocation's lines are sent.
+func (s *Server) followLogs(w http.ResponseWriter, r *http.Request, name, invocationID string) {
+	sub, err := s.funcManager.SubscribeLogs(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to clear write deadline for log stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Tell the client the subscription is in place
+	fmt.Fprintf(w, ": following logs of %s\n\n", name)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(logHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if invocationID != "" && line.InvocationID != invocationID {
+				continue
+			}
+			data, err := json.Marshal(line)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+// registerScheduleRoutes registers scheduled trigger routes
+func (s *Server) registerScheduleRoutes(api *mux.Router) {
+	api.HandleFunc("/functions/{name}/schedules", s.createSchedule).Methods("POST")
+	api.HandleFunc("/functions/{name}/schedules", s.listSchedules).Methods("GET")
+	api.HandleFunc("/functions/{name}/schedules/{id}", s.getSchedule).Methods("GET")
+	api.HandleFunc("/functions/{name}/schedules/{id}", s.updateSchedule).Methods("PUT", "PATCH")
+	api.HandleFunc("/functions/{name}/schedules/{id}", s.deleteSchedule).Methods("DELETE")
+}
+
+// createSchedule creates a cron or rate schedule for a function
+func (s *Server) createSchedule(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req models.CreateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	schedule, err := s.funcManager.CreateSchedule(name, &req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, schedule)
+}
+
+// listSchedules lists the schedules of a function
+func (s *Server) listSchedules(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	schedules, err := s.funcManager.ListSchedules(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"schedules": schedules,
+		"count":     len(schedules),
+	})
+}
+
+// getSchedule returns a single schedule
+func (s *Server) getSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	schedule, err := s.funcManager.GetSchedule(vars["name"], vars["id"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, schedule)
+}
+
+// updateSchedule updates a schedule
+func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req models.UpdateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	schedule, err := s.funcManager.UpdateSchedule(vars["name"], vars["id"], &req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, schedule)
+}
+
+// deleteSchedule deletes a schedule
+func (s *Server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := s.funcManager.DeleteSchedule(vars["name"], vars["id"]); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "Schedule deleted successfully",
+		"id":      vars["id"],
+	})
+}
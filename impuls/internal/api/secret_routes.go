@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+// registerSecretRoutes registers secret routes. Secret values can be set
+// but never read back.
+func (s *Server) registerSecretRoutes(api *mux.Router) {
+	api.HandleFunc("/secrets", s.createSecret).Methods("POST")
+	api.HandleFunc("/secrets", s.listSecrets).Methods("GET")
+	api.HandleFunc("/secrets/{name}", s.getSecret).Methods("GET")
+	api.HandleFunc("/secrets/{name}", s.updateSecret).Methods("PUT", "PATCH")
+	api.HandleFunc("/secrets/{name}", s.deleteSecret).Methods("DELETE")
+}
+
+// createSecret creates a secret
+func (s *Server) createSecret(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	secret, err := s.funcManager.CreateSecret(&req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, secret)
+}
+
+// listSecrets lists all secrets
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request) {
+	secrets, err := s.funcManager.ListSecrets()
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"secrets": secrets,
+		"count":   len(secrets),
+	})
+}
+
+// getSecret returns a secret without its value
+func (s *Server) getSecret(w http.ResponseWriter, r *http.Request) {
+	secret, err := s.funcManager.GetSecret(mux.Vars(r)["name"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, secret)
+}
+
+// updateSecret replaces a secret's value or description
+func (s *Server) updateSecret(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	secret, err := s.funcManager.UpdateSecret(mux.Vars(r)["name"], &req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, secret)
+}
+
+// deleteSecret deletes a secret no function uses
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := s.funcManager.DeleteSecret(name); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Secret deleted successfully",
+		"name":    name,
+	})
+}
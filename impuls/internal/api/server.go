@@ -3,7 +3,10 @@ package api
 import (
 	"encoding/json"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/oblak/impuls/internal/function"
@@ -48,9 +51,36 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/functions/{name}", s.deleteFunction).Methods("DELETE")
 	api.HandleFunc("/functions/{name}/invoke", s.invokeFunction).Methods("POST")
 
+	// Version and alias routes
+	s.registerVersionRoutes(api)
+
+	// Shared layer routes
+	s.registerLayerRoutes(api)
+
+	// Secret routes
+	s.registerSecretRoutes(api)
+
+	// Asynchronous invocation and dead-letter routes
+	s.registerAsyncRoutes(api)
+
+	// Scheduled trigger routes
+	s.registerScheduleRoutes(api)
+
+	// Invocation history routes
+	s.registerInvocationRoutes(api)
+
+	// Log routes
+	s.registerLogRoutes(api)
+
+	// Concurrency limit routes
+	s.registerConcurrencyRoutes(api)
+
 	// VM routes (for debugging/admin)
 	s.registerVMRoutes(api)
 
+	// Function URLs, which serve HTTP requests with functions
+	s.registerURLRoutes(s.router)
+
 	// Add middleware
 	s.router.Use(loggingMiddleware)
 	s.router.Use(contentTypeMiddleware)
@@ -58,10 +88,14 @@ func (s *Server) setupRoutes() {
 
 // healthCheck handles health check requests
 func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]string{
+	response := map[string]interface{}{
 		"status":  "healthy",
 		"service": "impuls",
-	})
+	}
+	if usage := s.funcManager.Capacity(); usage != nil {
+		response["capacity"] = usage
+	}
+	respondJSON(w, http.StatusOK, response)
 }
 
 // createFunction handles function creation
@@ -126,7 +160,7 @@ func (s *Server) updateFunction(w http.ResponseWriter, r *http.Request) {
 
 	fn, err := s.funcManager.Update(name, &req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondManagerError(w, err)
 		return
 	}
 
@@ -162,25 +196,38 @@ func (s *Server) invokeFunction(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Check for local execution mode (for development/testing without Firecracker)
-	useLocal := r.URL.Query().Get("local") == "true"
+	// The function runs on the executor it pins or the server's default.
+	// local=true, which used to run it as a local process, is deprecated
+	// and ignored.
 
-	var response *models.InvocationResponse
-	var err error
+	// Version number or alias to invoke (defaults to $LATEST)
+	qualifier := r.URL.Query().Get("qualifier")
 
-	if useLocal {
-		response, err = s.funcManager.InvokeLocal(r.Context(), name, payload)
-	} else {
-		response, err = s.funcManager.Invoke(r.Context(), name, payload)
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "sync":
+	case "async":
+		s.invokeAsync(w, r, name, qualifier, payload)
+		return
+	case "stream":
+		s.invokeStream(w, r, name, qualifier, payload)
+		return
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid mode: "+mode+". Must be 'sync', 'async' or 'stream'")
+		return
 	}
 
+	response, err := s.funcManager.Invoke(r.Context(), name, qualifier, payload)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondManagerError(w, err)
 		return
 	}
 
 	// Return the invocation response
-	w.Header().Set("X-Impuls-Duration", string(rune(response.Duration)))
+	w.Header().Set("X-Impuls-Duration", strconv.FormatInt(response.Duration, 10))
+	w.Header().Set("X-Impuls-Version", response.Version)
+	if response.InvocationID != "" {
+		w.Header().Set("X-Impuls-Invocation-Id", response.InvocationID)
+	}
 	respondJSON(w, response.StatusCode, response)
 }
 
@@ -201,6 +248,36 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	})
 }
 
+// respondManagerError maps errors returned by the function manager to
+// HTTP status codes
+func respondManagerError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *models.ValidationError:
+		respondError(w, http.StatusBadRequest, err.Error())
+	case *models.NotFoundError:
+		respondError(w, http.StatusNotFound, err.Error())
+	case *models.ConflictError:
+		respondError(w, http.StatusConflict, err.Error())
+	case *models.ThrottledError:
+		setRetryAfter(w, e.RetryAfter)
+		respondError(w, http.StatusTooManyRequests, err.Error())
+	case *models.UnavailableError:
+		setRetryAfter(w, e.RetryAfter)
+		respondError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// setRetryAfter tells the client how many seconds to wait before retrying,
+// if the error is expected to clear
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
 // loggingMiddleware logs all requests
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
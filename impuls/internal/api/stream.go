@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamTrailers are sent after a chunked response, once the invocation
+// has finished
+var streamTrailers = []string{"X-Impuls-Invocation-Id", "X-Impuls-Version", "X-Impuls-Duration", "X-Impuls-Error"}
+
+// invokeStream invokes a function and forwards the chunks its handler
+// writes as they arrive: as "chunk" events if the client accepts server-sent
+// events, otherwise as a chunked response. Until the first chunk, the
+// invocation is answered like a synchronous one.
+func (s *Server) invokeStream(w http.ResponseWriter, r *http.Request, name, qualifier string, payload interface{}) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to clear write deadline for response stream: %v", err)
+	}
+
+	started := false
+	send := func(chunk []byte) error {
+		if !started {
+			started = true
+			if sse {
+				w.Header().Set("Content-Type", "text/event-stream")
+			} else {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Trailer", strings.Join(streamTrailers, ", "))
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			// Keep proxies from buffering the stream
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+		}
+		var err error
+		if sse {
+			err = writeEvent(w, "chunk", chunk)
+		} else {
+			_, err = w.Write(chunk)
+		}
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	response, err := s.funcManager.InvokeStream(r.Context(), name, qualifier, payload, send)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	if !started {
+		w.Header().Set("X-Impuls-Version", response.Version)
+		if response.InvocationID != "" {
+			w.Header().Set("X-Impuls-Invocation-Id", response.InvocationID)
+		}
+		respondJSON(w, response.StatusCode, response)
+		return
+	}
+
+	// The outcome follows the chunks: a last event, or the trailers
+	if sse {
+		event := "done"
+		if response.Error != "" {
+			event = "error"
+		}
+		data, _ := json.Marshal(response)
+		writeEvent(w, event, data)
+		rc.Flush()
+		return
+	}
+	w.Header().Set("X-Impuls-Invocation-Id", response.InvocationID)
+	w.Header().Set("X-Impuls-Version", response.Version)
+	w.Header().Set("X-Impuls-Duration", strconv.FormatInt(response.Duration, 10))
+	w.Header().Set("X-Impuls-Error", response.Error)
+}
+
+// writeEvent writes a server-sent event, one data line per line of data
+func writeEvent(w http.ResponseWriter, event string, data []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString("event: " + event + "\n")
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
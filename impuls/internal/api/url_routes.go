@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+// maxURLBodyBytes is the largest request body a function URL accepts
+const maxURLBodyBytes = 6 << 20
+
+// skippedResponseHeaders are the headers a function cannot set on its
+// response, because the server manages them
+var skippedResponseHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+}
+
+// urlEvent is the event a function receives for a request to its URL
+type urlEvent struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	RawQuery string            `json:"rawQuery"`
+	Query    map[string]string `json:"query"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"`
+	// IsBase64Encoded is set for bodies that are not valid UTF-8
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+	SourceIP        string `json:"sourceIp"`
+}
+
+// registerURLRoutes registers function URLs, which serve HTTP requests
+// with functions. They live outside the API prefix, so a function can
+// serve any path below its URL.
+func (s *Server) registerURLRoutes(router *mux.Router) {
+	router.HandleFunc("/fn/{function}", s.serveFunctionURL)
+	router.HandleFunc("/fn/{function}/{path:.*}", s.serveFunctionURL)
+}
+
+// serveFunctionURL invokes a function with an HTTP request and maps its
+// result onto the response. The function is addressed as name or
+// name:qualifier.
+func (s *Server) serveFunctionURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, qualifier, _ := strings.Cut(vars["function"], ":")
+
+	fn, err := s.funcManager.Get(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+	if fn.CORS != nil && isPreflight(r) {
+		writePreflight(w, r, fn.CORS)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxURLBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxURLBodyBytes))
+			return
+		}
+		respondError(w, http.StatusBadRequest, "Failed to read request body: "+err.Error())
+		return
+	}
+	event := newURLEvent(r, "/"+vars["path"], body)
+
+	// Callers may be anyone, so the function always runs on its own
+	// executor; the query belongs to the function
+	response, err := s.funcManager.Invoke(r.Context(), name, qualifier, event)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	w.Header().Del("Content-Type")
+	w.Header().Set("X-Impuls-Version", response.Version)
+	if response.InvocationID != "" {
+		w.Header().Set("X-Impuls-Invocation-Id", response.InvocationID)
+	}
+	// The function's error stays in its logs, callers may be anyone
+	if response.Error != "" {
+		respondError(w, http.StatusBadGateway, "Function invocation failed")
+		return
+	}
+
+	status, content, err := mapURLResponse(w.Header(), response.Body)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, "Invalid function response: "+err.Error())
+		return
+	}
+	if fn.CORS != nil {
+		setCORSHeaders(w, r, fn.CORS)
+	}
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+// newURLEvent describes a request to a function's URL. Repeated query
+// parameters and headers are joined with commas; header names are lower
+// case.
+func newURLEvent(r *http.Request, path string, body []byte) *urlEvent {
+	event := &urlEvent{
+		Method:   r.Method,
+		Path:     path,
+		RawQuery: r.URL.RawQuery,
+		Query:    make(map[string]string),
+		Headers:  make(map[string]string),
+		SourceIP: r.RemoteAddr,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		event.SourceIP = host
+	}
+	for key, values := range r.URL.Query() {
+		event.Query[key] = strings.Join(values, ",")
+	}
+	for key, values := range r.Header {
+		event.Headers[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+	if r.Host != "" {
+		event.Headers["host"] = r.Host
+	}
+
+	if utf8.Valid(body) {
+		event.Body = string(body)
+	} else {
+		event.Body = base64.StdEncoding.EncodeToString(body)
+		event.IsBase64Encoded = true
+	}
+	return event
+}
+
+// mapURLResponse turns a function's result into an HTTP response. A result
+// with a numeric statusCode sets the status, headers and body; any other
+// result is the body of a 200 response, text if it is a string and JSON
+// otherwise.
+func mapURLResponse(header http.Header, result interface{}) (int, []byte, error) {
+	fields, _ := result.(map[string]interface{})
+	statusCode, ok := fields["statusCode"].(float64)
+	if !ok {
+		return http.StatusOK, encodeURLBody(header, result), nil
+	}
+
+	status := int(statusCode)
+	if float64(status) != statusCode || status < 100 || status > 599 {
+		return 0, nil, fmt.Errorf("invalid statusCode %v", statusCode)
+	}
+	if headers, ok := fields["headers"].(map[string]interface{}); ok {
+		for key, value := range headers {
+			if skippedResponseHeaders[http.CanonicalHeaderKey(key)] {
+				continue
+			}
+			switch v := value.(type) {
+			case []interface{}:
+				for _, item := range v {
+					header.Add(key, fmt.Sprint(item))
+				}
+			case nil:
+			default:
+				header.Set(key, fmt.Sprint(v))
+			}
+		}
+	} else if fields["headers"] != nil {
+		return 0, nil, errors.New("headers must be an object")
+	}
+
+	body := fields["body"]
+	if encoded, _ := fields["isBase64Encoded"].(bool); encoded {
+		s, ok := body.(string)
+		if !ok {
+			return 0, nil, errors.New("a base64-encoded body must be a string")
+		}
+		content, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+		return status, content, nil
+	}
+	if body == nil {
+		return status, nil, nil
+	}
+	return status, encodeURLBody(header, body), nil
+}
+
+// encodeURLBody encodes a body as text if it is a string and as JSON
+// otherwise, defaulting the content type to match
+func encodeURLBody(header http.Header, body interface{}) []byte {
+	if s, ok := body.(string); ok {
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		return []byte(s)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+// isPreflight reports whether a request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writePreflight answers a preflight request from the function's CORS
+// policy without invoking it. Requests the policy does not allow get no
+// CORS headers, which makes the browser reject them.
+func writePreflight(w http.ResponseWriter, r *http.Request, cors *models.CORSConfig) {
+	w.Header().Del("Content-Type")
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if cors.AllowsOrigin(origin) && cors.AllowsMethod(method) {
+		setAllowOrigin(w, origin, cors)
+		if len(cors.AllowMethods) > 0 {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(cors.AllowMethods, ", "))
+		} else {
+			w.Header().Set("Access-Control-Allow-Methods", method)
+		}
+		if len(cors.AllowHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.AllowHeaders, ", "))
+		}
+		if cors.MaxAgeSec > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAgeSec))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCORSHeaders adds the CORS headers of a response to an allowed origin,
+// replacing any the function set
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, cors *models.CORSConfig) {
+	for _, key := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+		w.Header().Del(key)
+	}
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" || !cors.AllowsOrigin(origin) {
+		return
+	}
+	setAllowOrigin(w, origin, cors)
+	if len(cors.ExposeHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.ExposeHeaders, ", "))
+	}
+}
+
+// setAllowOrigin allows an origin: any origin if the policy allows all of
+// them, otherwise the origin itself
+func setAllowOrigin(w http.ResponseWriter, origin string, cors *models.CORSConfig) {
+	allowed := origin
+	for _, o := range cors.AllowOrigins {
+		if o == "*" {
+			allowed = "*"
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	if cors.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
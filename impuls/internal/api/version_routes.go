@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/oblak/impuls/internal/models"
+)
+
+// registerVersionRoutes registers function version and alias routes
+func (s *Server) registerVersionRoutes(api *mux.Router) {
+	api.HandleFunc("/functions/{name}/versions", s.publishVersion).Methods("POST")
+	api.HandleFunc("/functions/{name}/versions", s.listVersions).Methods("GET")
+	api.HandleFunc("/functions/{name}/versions/{version}", s.getVersion).Methods("GET")
+	api.HandleFunc("/functions/{name}/versions/{version}", s.deleteVersion).Methods("DELETE")
+
+	api.HandleFunc("/functions/{name}/aliases", s.createAlias).Methods("POST")
+	api.HandleFunc("/functions/{name}/aliases", s.listAliases).Methods("GET")
+	api.HandleFunc("/functions/{name}/aliases/{alias}", s.getAlias).Methods("GET")
+	api.HandleFunc("/functions/{name}/aliases/{alias}", s.updateAlias).Methods("PUT", "PATCH")
+	api.HandleFunc("/functions/{name}/aliases/{alias}", s.deleteAlias).Methods("DELETE")
+
+	api.HandleFunc("/functions/{name}/metrics", s.getRevisionMetrics).Methods("GET")
+}
+
+// publishVersion publishes the function's current code and config
+func (s *Server) publishVersion(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req models.PublishVersionRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+
+	version, err := s.funcManager.PublishVersion(name, &req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, version)
+}
+
+// listVersions lists the published versions of a function
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	versions, err := s.funcManager.ListVersions(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// getVersion returns a single published version
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, ok := models.ParseVersion(vars["version"])
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid version: "+vars["version"])
+		return
+	}
+
+	v, err := s.funcManager.GetVersion(vars["name"], version)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, v)
+}
+
+// deleteVersion deletes a published version
+func (s *Server) deleteVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, ok := models.ParseVersion(vars["version"])
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Invalid version: "+vars["version"])
+		return
+	}
+
+	if err := s.funcManager.DeleteVersion(vars["name"], version); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Version deleted successfully",
+		"name":    vars["name"],
+		"version": version,
+	})
+}
+
+// createAlias creates an alias for a published version
+func (s *Server) createAlias(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req models.CreateAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	alias, err := s.funcManager.CreateAlias(name, &req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, alias)
+}
+
+// listAliases lists the aliases of a function
+func (s *Server) listAliases(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	aliases, err := s.funcManager.ListAliases(name)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"aliases": aliases,
+		"count":   len(aliases),
+	})
+}
+
+// getAlias returns a single alias
+func (s *Server) getAlias(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	alias, err := s.funcManager.GetAlias(vars["name"], vars["alias"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, alias)
+}
+
+// updateAlias repoints an alias
+func (s *Server) updateAlias(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req models.UpdateAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	alias, err := s.funcManager.UpdateAlias(vars["name"], vars["alias"], &req)
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, alias)
+}
+
+// deleteAlias deletes an alias
+func (s *Server) deleteAlias(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := s.funcManager.DeleteAlias(vars["name"], vars["alias"]); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "Alias deleted successfully",
+		"name":    vars["alias"],
+	})
+}
+
+// getRevisionMetrics returns per-revision invocation counts, optionally
+// limited to the revisions an alias routes to
+func (s *Server) getRevisionMetrics(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	revisions, err := s.funcManager.RevisionMetrics(name, r.URL.Query().Get("alias"))
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"function":  name,
+		"revisions": revisions,
+	})
+}
@@ -14,31 +14,55 @@ func (s *Server) registerVMRoutes(api *mux.Router) {
 	api.HandleFunc("/vms", s.listVMs).Methods("GET")
 	api.HandleFunc("/vms/{id}", s.getVM).Methods("GET")
 	api.HandleFunc("/vms/{id}", s.stopVM).Methods("DELETE")
+	api.HandleFunc("/functions/{name}/vms/drain", s.drainVMs).Methods("POST")
 }
 
-// listVMs lists all running VMs
+// listVMs lists the VMs on this host, optionally only those of one function
 func (s *Server) listVMs(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement when VM pool is integrated
+	vms := s.funcManager.ListVMs(r.URL.Query().Get("function"))
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"vms":   []interface{}{},
-		"count": 0,
+		"vms":      vms,
+		"count":    len(vms),
+		"capacity": s.funcManager.Capacity(),
 	})
 }
 
 // getVM gets details of a specific VM
 func (s *Server) getVM(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	_ = vars["id"]
 
-	// TODO: Implement when VM pool is integrated
-	respondError(w, http.StatusNotFound, "VM not found")
+	vm, err := s.funcManager.GetVM(vars["id"])
+	if err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, vm)
 }
 
 // stopVM stops a specific VM
 func (s *Server) stopVM(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	_ = vars["id"]
 
-	// TODO: Implement when VM pool is integrated
-	respondError(w, http.StatusNotFound, "VM not found")
+	if err := s.funcManager.StopVM(vars["id"]); err != nil {
+		respondManagerError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "VM stopped",
+		"id":      vars["id"],
+	})
+}
+
+// drainVMs retires all VMs that served a function
+func (s *Server) drainVMs(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	stopped, draining := s.funcManager.DrainVMs(name)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"function": name,
+		"stopped":  stopped,
+		"draining": draining,
+	})
 }
@@ -0,0 +1,17 @@
+package firecracker
+
+import "syscall"
+
+// bindToDevice returns a dialer control function that makes connections
+// leave through the given network device, whatever the routing table says
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), device)
+		}); err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
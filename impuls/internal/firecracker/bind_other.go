@@ -0,0 +1,15 @@
+//go:build !linux
+
+package firecracker
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice is only supported on Linux, which Firecracker requires anyway
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("cannot bind to %s: only supported on Linux", device)
+	}
+}
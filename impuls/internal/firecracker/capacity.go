@@ -0,0 +1,170 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Utilization reports the host resources committed to VMs. Pooled VMs count
+// like any other running VM.
+type Utilization struct {
+	MemoryMB       int   `json:"memory_mb"`
+	MemoryBudgetMB int   `json:"memory_budget_mb"` // 0 if unlimited
+	VCPUs          int   `json:"vcpus"`
+	VCPUBudget     int   `json:"vcpu_budget"` // 0 if unlimited
+	VMs            int   `json:"vms"`
+	Waiting        int   `json:"waiting"`  // Create requests queued for capacity
+	Rejected       int64 `json:"rejected"` // Since the server started
+}
+
+// CapacityError is returned when a VM does not fit in the host budget
+type CapacityError struct {
+	MemoryMB int
+	VCPUs    int
+	Usage    Utilization
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("insufficient host capacity for a VM with %d MiB and %d vCPUs (%d/%d MiB, %d/%d vCPUs committed)",
+		e.MemoryMB, e.VCPUs, e.Usage.MemoryMB, e.Usage.MemoryBudgetMB, e.Usage.VCPUs, e.Usage.VCPUBudget)
+}
+
+// capacity tracks the memory and vCPUs committed to VMs against the host
+// budget. A budget of zero is unlimited.
+type capacity struct {
+	memoryBudget int
+	vcpuBudget   int
+	wait         time.Duration // how long a create may queue, 0 rejects at once
+
+	mu       sync.Mutex
+	memory   int
+	vcpus    int
+	vms      int
+	waiting  int
+	rejected int64
+	freed    chan struct{} // closed and replaced whenever resources are released
+}
+
+func newCapacity(memoryBudget, vcpuBudget int, wait time.Duration) *capacity {
+	return &capacity{
+		memoryBudget: memoryBudget,
+		vcpuBudget:   vcpuBudget,
+		wait:         wait,
+		freed:        make(chan struct{}),
+	}
+}
+
+// fits reports whether a VM fits next to the committed ones. The caller
+// holds mu.
+func (c *capacity) fits(memoryMB, vcpus int) bool {
+	if c.memoryBudget > 0 && c.memory+memoryMB > c.memoryBudget {
+		return false
+	}
+	if c.vcpuBudget > 0 && c.vcpus+vcpus > c.vcpuBudget {
+		return false
+	}
+	return true
+}
+
+// reserve commits resources for a VM. If they are not available and wait
+// is set, it waits up to c.wait for other VMs to release theirs. Waiters
+// are not served in order.
+func (c *capacity) reserve(ctx context.Context, memoryMB, vcpus int, wait bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A VM larger than the whole budget would wait forever
+	tooLarge := (c.memoryBudget > 0 && memoryMB > c.memoryBudget) || (c.vcpuBudget > 0 && vcpus > c.vcpuBudget)
+	if tooLarge || !wait || c.wait <= 0 {
+		if !tooLarge && c.fits(memoryMB, vcpus) {
+			c.commit(memoryMB, vcpus)
+			return nil
+		}
+		return c.reject(memoryMB, vcpus)
+	}
+
+	timer := time.NewTimer(c.wait)
+	defer timer.Stop()
+
+	for !c.fits(memoryMB, vcpus) {
+		freed := c.freed
+		c.waiting++
+		c.mu.Unlock()
+
+		var err error
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-timer.C:
+			err = errCapacityTimeout
+		}
+
+		c.mu.Lock()
+		c.waiting--
+		if err == errCapacityTimeout {
+			return c.reject(memoryMB, vcpus)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	c.commit(memoryMB, vcpus)
+	return nil
+}
+
+// errCapacityTimeout ends a wait for capacity
+var errCapacityTimeout = errors.New("timed out waiting for capacity")
+
+// commit adds a VM's resources. The caller holds mu.
+func (c *capacity) commit(memoryMB, vcpus int) {
+	c.memory += memoryMB
+	c.vcpus += vcpus
+	c.vms++
+}
+
+// reject counts a refused VM. The caller holds mu.
+func (c *capacity) reject(memoryMB, vcpus int) error {
+	c.rejected++
+	return &CapacityError{MemoryMB: memoryMB, VCPUs: vcpus, Usage: c.utilizationLocked()}
+}
+
+// release returns a VM's resources and wakes the waiting creates
+func (c *capacity) release(memoryMB, vcpus int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.memory -= memoryMB
+	c.vcpus -= vcpus
+	c.vms--
+	close(c.freed)
+	c.freed = make(chan struct{})
+}
+
+// utilization returns the committed resources
+func (c *capacity) utilization() Utilization {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.utilizationLocked()
+}
+
+func (c *capacity) utilizationLocked() Utilization {
+	return Utilization{
+		MemoryMB:       c.memory,
+		MemoryBudgetMB: c.memoryBudget,
+		VCPUs:          c.vcpus,
+		VCPUBudget:     c.vcpuBudget,
+		VMs:            c.vms,
+		Waiting:        c.waiting,
+		Rejected:       c.rejected,
+	}
+}
+
+// Utilization returns the host resources committed to VMs
+func (m *Manager) Utilization() Utilization {
+	return m.capacity.utilization()
+}
@@ -0,0 +1,107 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCapacityRejectsOverBudget(t *testing.T) {
+	c := newCapacity(512, 4, 0)
+
+	for i := 0; i < 4; i++ {
+		if err := c.reserve(context.Background(), 128, 1, true); err != nil {
+			t.Fatalf("Expected VM %d to fit: %v", i, err)
+		}
+	}
+
+	var capacityErr *CapacityError
+	if err := c.reserve(context.Background(), 128, 1, true); !errors.As(err, &capacityErr) {
+		t.Fatalf("Expected a CapacityError, got %v", err)
+	}
+	if capacityErr.Usage.MemoryMB != 512 || capacityErr.Usage.VMs != 4 {
+		t.Errorf("Expected the error to report 512 MiB in 4 VMs, got %+v", capacityErr.Usage)
+	}
+
+	c.release(128, 1)
+	if err := c.reserve(context.Background(), 128, 1, true); err != nil {
+		t.Errorf("Expected released memory to be reusable: %v", err)
+	}
+
+	usage := c.utilization()
+	if usage.MemoryMB != 512 || usage.VCPUs != 4 || usage.Rejected != 1 {
+		t.Errorf("Unexpected utilization: %+v", usage)
+	}
+}
+
+func TestCapacityVCPUBudget(t *testing.T) {
+	c := newCapacity(0, 2, 0)
+
+	if err := c.reserve(context.Background(), 4096, 2, true); err != nil {
+		t.Fatalf("Expected memory to be unlimited: %v", err)
+	}
+	if err := c.reserve(context.Background(), 128, 1, true); err == nil {
+		t.Error("Expected the vCPU budget to be enforced")
+	}
+}
+
+func TestCapacityWaitsForRelease(t *testing.T) {
+	c := newCapacity(256, 0, 5*time.Second)
+	if err := c.reserve(context.Background(), 256, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- c.reserve(context.Background(), 128, 1, true) }()
+
+	// Wait for the second create to queue
+	deadline := time.Now().Add(5 * time.Second)
+	for c.utilization().Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Create never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.release(256, 1)
+	if err := <-done; err != nil {
+		t.Fatalf("Expected the queued create to succeed: %v", err)
+	}
+	if usage := c.utilization(); usage.MemoryMB != 128 || usage.Waiting != 0 {
+		t.Errorf("Unexpected utilization: %+v", usage)
+	}
+}
+
+func TestCapacityWaitLimits(t *testing.T) {
+	c := newCapacity(256, 0, 50*time.Millisecond)
+	if err := c.reserve(context.Background(), 256, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var capacityErr *CapacityError
+	if err := c.reserve(context.Background(), 128, 1, true); !errors.As(err, &capacityErr) {
+		t.Errorf("Expected a CapacityError after the wait, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.reserve(ctx, 128, 1, true); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+
+	// Callers that may not wait are rejected at once
+	start := time.Now()
+	if err := c.reserve(context.Background(), 128, 1, false); !errors.As(err, &capacityErr) {
+		t.Errorf("Expected a CapacityError, got %v", err)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Error("Expected no wait")
+	}
+
+	// A VM larger than the budget never fits
+	c.release(256, 1)
+	if err := c.reserve(context.Background(), 512, 1, true); !errors.As(err, &capacityErr) {
+		t.Errorf("Expected a CapacityError for an oversized VM, got %v", err)
+	}
+}
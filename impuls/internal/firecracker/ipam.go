@@ -0,0 +1,216 @@
+package firecracker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DefaultNetworkCIDR is the network VM subnets are leased from by default
+const DefaultNetworkCIDR = "172.16.0.0/16"
+
+// ErrAddressesExhausted is returned when every subnet of the network is
+// leased
+var ErrAddressesExhausted = errors.New("no free VM subnets left")
+
+// Lease is a /30 subnet and MAC address held by one VM. The host end of the
+// TAP device takes the first address of the subnet, the guest the second.
+type Lease struct {
+	Owner   string
+	HostIP  net.IP
+	GuestIP net.IP
+	MAC     string
+	index   uint32
+}
+
+// Netmask returns the netmask of the leased subnet
+func (l *Lease) Netmask() string {
+	return "255.255.255.252"
+}
+
+// IPAM leases unique /30 subnets and MAC addresses to VMs from a network.
+// The MAC is derived from the guest address, so it is unique whenever the
+// subnet is.
+type IPAM struct {
+	network uint32 // first address of the network
+	subnets uint32 // number of /30 subnets in the network
+
+	mu     sync.Mutex
+	leases map[uint32]*Lease // subnet index -> lease
+	owners map[string]*Lease
+	next   uint32 // where the search for a free subnet starts
+}
+
+// NewIPAM creates an allocator for an IPv4 network such as 172.16.0.0/16
+func NewIPAM(cidr string) (*IPAM, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VM network %q: %w", cidr, err)
+	}
+	ip := network.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid VM network %q: only IPv4 is supported", cidr)
+	}
+	ones, _ := network.Mask.Size()
+	if ones > 30 {
+		return nil, fmt.Errorf("invalid VM network %q: too small for a /30 subnet", cidr)
+	}
+
+	return &IPAM{
+		network: binary.BigEndian.Uint32(ip),
+		subnets: 1 << (30 - ones),
+		leases:  make(map[uint32]*Lease),
+		owners:  make(map[string]*Lease),
+	}, nil
+}
+
+// Allocate leases a free subnet to owner. An owner that already holds a
+// lease gets the same one back. Subnets are handed out round-robin, so a
+// released subnet is not reused until the others have been.
+func (a *IPAM) Allocate(owner string) (*Lease, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if lease, ok := a.owners[owner]; ok {
+		return lease, nil
+	}
+
+	for i := uint32(0); i < a.subnets; i++ {
+		index := (a.next + i) % a.subnets
+		if _, taken := a.leases[index]; taken {
+			continue
+		}
+		a.next = (index + 1) % a.subnets
+		return a.lease(owner, index), nil
+	}
+	return nil, ErrAddressesExhausted
+}
+
+// Claim leases the subnet containing ip to owner. It is used to take back
+// the subnets of TAP devices that already exist.
+func (a *IPAM) Claim(owner string, ip net.IP) (*Lease, error) {
+	index, ok := a.subnetOf(ip)
+	if !ok {
+		return nil, fmt.Errorf("address %s is outside the VM network", ip)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if lease, taken := a.leases[index]; taken {
+		if lease.Owner == owner {
+			return lease, nil
+		}
+		return nil, fmt.Errorf("subnet of %s is already leased to %s", ip, lease.Owner)
+	}
+	if _, ok := a.owners[owner]; ok {
+		return nil, fmt.Errorf("%s already holds a lease", owner)
+	}
+	return a.lease(owner, index), nil
+}
+
+// Reserve leases the last subnet of the network to owner, taking it over
+// from whoever holds it. It is meant for a subnet that many TAP devices
+// carry at once, such as the one baked into VM snapshots, and keeps the
+// round-robin allocation away from it.
+func (a *IPAM) Reserve(owner string) *Lease {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	index := a.subnets - 1
+	if lease, taken := a.leases[index]; taken {
+		if lease.Owner == owner {
+			return lease
+		}
+		delete(a.owners, lease.Owner)
+	}
+	if lease, ok := a.owners[owner]; ok {
+		delete(a.leases, lease.index)
+	}
+	return a.lease(owner, index)
+}
+
+// Release returns the owner's subnet. Releasing an owner without a lease
+// does nothing.
+func (a *IPAM) Release(owner string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if lease, ok := a.owners[owner]; ok {
+		delete(a.leases, lease.index)
+		delete(a.owners, owner)
+	}
+}
+
+// Contains reports whether ip is in the VM network
+func (a *IPAM) Contains(ip net.IP) bool {
+	_, ok := a.subnetOf(ip)
+	return ok
+}
+
+// Leased returns the number of leased subnets and the size of the network
+func (a *IPAM) Leased() (leased, total int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.leases), int(a.subnets)
+}
+
+// subnetOf returns the index of the subnet containing ip
+func (a *IPAM) subnetOf(ip net.IP) (uint32, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint32(ip4) - a.network
+	index := offset / 4
+	return index, index < a.subnets
+}
+
+// lease records a lease of a subnet. The caller holds mu.
+func (a *IPAM) lease(owner string, index uint32) *Lease {
+	base := a.network + index*4
+	lease := &Lease{
+		Owner:   owner,
+		HostIP:  uint32ToIP(base + 1),
+		GuestIP: uint32ToIP(base + 2),
+		index:   index,
+	}
+	guest := lease.GuestIP.To4()
+	lease.MAC = fmt.Sprintf("AA:FC:%02X:%02X:%02X:%02X", guest[0], guest[1], guest[2], guest[3])
+
+	a.leases[index] = lease
+	a.owners[owner] = lease
+	return lease
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// parseTapAddresses reads the IPv4 addresses of TAP devices from the output
+// of `ip -o -4 addr show`
+func parseTapAddresses(output string) map[string]net.IP {
+	addresses := make(map[string]net.IP)
+	for _, line := range strings.Split(output, "\n") {
+		// 7: tap-1a2b3c4d    inet 172.16.0.1/30 scope global tap-1a2b3c4d ...
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "inet" {
+			continue
+		}
+		name := strings.TrimSuffix(fields[1], ":")
+		if !strings.HasPrefix(name, tapPrefix) {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(fields[3])
+		if err != nil {
+			continue
+		}
+		addresses[name] = ip
+	}
+	return addresses
+}
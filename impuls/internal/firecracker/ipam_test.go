@@ -0,0 +1,180 @@
+package firecracker
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestIPAMAllocate(t *testing.T) {
+	ipam, err := NewIPAM("10.200.0.0/28")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seenIPs := make(map[string]bool)
+	seenMACs := make(map[string]bool)
+	for _, owner := range []string{"vm-a", "vm-b", "vm-c", "vm-d"} {
+		lease, err := ipam.Allocate(owner)
+		if err != nil {
+			t.Fatalf("Failed to allocate for %s: %v", owner, err)
+		}
+		if seenIPs[lease.GuestIP.String()] || seenMACs[lease.MAC] {
+			t.Fatalf("Duplicate lease handed out: %+v", lease)
+		}
+		seenIPs[lease.GuestIP.String()] = true
+		seenMACs[lease.MAC] = true
+	}
+
+	// A /28 holds exactly four /30 subnets
+	if _, err := ipam.Allocate("vm-e"); !errors.Is(err, ErrAddressesExhausted) {
+		t.Fatalf("Expected ErrAddressesExhausted, got %v", err)
+	}
+
+	first, _ := ipam.Allocate("vm-a")
+	if first.HostIP.String() != "10.200.0.1" || first.GuestIP.String() != "10.200.0.2" {
+		t.Errorf("Unexpected first lease: %s/%s", first.HostIP, first.GuestIP)
+	}
+	if first.MAC != "AA:FC:0A:C8:00:02" {
+		t.Errorf("Expected the MAC to encode the guest address, got %s", first.MAC)
+	}
+
+	ipam.Release("vm-b")
+	ipam.Release("vm-b") // releasing twice is harmless
+	lease, err := ipam.Allocate("vm-e")
+	if err != nil {
+		t.Fatalf("Expected the released subnet to be reused: %v", err)
+	}
+	if lease.GuestIP.String() != "10.200.0.6" {
+		t.Errorf("Expected vm-b's subnet, got %s", lease.GuestIP)
+	}
+
+	if leased, total := ipam.Leased(); leased != 4 || total != 4 {
+		t.Errorf("Expected 4 of 4 subnets leased, got %d of %d", leased, total)
+	}
+}
+
+func TestIPAMRoundRobin(t *testing.T) {
+	ipam, err := NewIPAM("10.200.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := ipam.Allocate("vm-a")
+	ipam.Release("vm-a")
+	second, _ := ipam.Allocate("vm-b")
+	if second.GuestIP.Equal(first.GuestIP) {
+		t.Error("Expected a just released subnet not to be handed out again right away")
+	}
+
+	// The same owner keeps its lease
+	again, _ := ipam.Allocate("vm-b")
+	if again != second {
+		t.Error("Expected an owner to get its existing lease back")
+	}
+}
+
+func TestIPAMClaim(t *testing.T) {
+	ipam, err := NewIPAM("172.16.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := ipam.Claim("tap-1a2b3c4d", net.ParseIP("172.16.0.1"))
+	if err != nil {
+		t.Fatalf("Failed to claim: %v", err)
+	}
+	if claimed.GuestIP.String() != "172.16.0.2" {
+		t.Errorf("Expected the claimed subnet's guest address, got %s", claimed.GuestIP)
+	}
+
+	// The recovered subnet is skipped by new allocations
+	lease, err := ipam.Allocate("vm-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.GuestIP.Equal(claimed.GuestIP) {
+		t.Error("Expected a claimed subnet not to be allocated again")
+	}
+
+	if _, err := ipam.Claim("tap-other", net.ParseIP("172.16.0.2")); err == nil {
+		t.Error("Expected a claim on a leased subnet to fail")
+	}
+	if _, err := ipam.Claim("tap-outside", net.ParseIP("10.0.0.1")); err == nil {
+		t.Error("Expected a claim outside the network to fail")
+	}
+	if ipam.Contains(net.ParseIP("172.17.0.1")) {
+		t.Error("Expected 172.17.0.1 to be outside the network")
+	}
+}
+
+func TestNewIPAMInvalid(t *testing.T) {
+	for _, cidr := range []string{"", "not-a-network", "fd00::/64", "10.0.0.0/31"} {
+		if _, err := NewIPAM(cidr); err == nil {
+			t.Errorf("Expected %q to be rejected", cidr)
+		}
+	}
+}
+
+func TestParseTapAddresses(t *testing.T) {
+	output := `1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 192.168.1.10/24 brd 192.168.1.255 scope global eth0\       valid_lft forever preferred_lft forever
+7: tap-1a2b3c4d    inet 172.16.0.1/30 scope global tap-1a2b3c4d\       valid_lft forever preferred_lft forever
+9: tap-5e6f7a8b    inet 172.16.0.5/30 scope global tap-5e6f7a8b\       valid_lft forever preferred_lft forever
+`
+	addresses := parseTapAddresses(output)
+	if len(addresses) != 2 {
+		t.Fatalf("Expected 2 TAP devices, got %v", addresses)
+	}
+	if ip := addresses["tap-5e6f7a8b"]; ip == nil || ip.String() != "172.16.0.5" {
+		t.Errorf("Unexpected address for tap-5e6f7a8b: %v", ip)
+	}
+}
+
+func TestKernelIPArg(t *testing.T) {
+	ipam, _ := NewIPAM(DefaultNetworkCIDR)
+	lease, _ := ipam.Allocate("vm-a")
+	if arg := kernelIPArg(lease); arg != "ip=172.16.0.2::172.16.0.1:255.255.255.252::eth0:off" {
+		t.Errorf("Unexpected kernel argument: %s", arg)
+	}
+}
+
+func TestIPAMReserve(t *testing.T) {
+	ipam, err := NewIPAM("10.200.0.0/28")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A TAP device recovered from an earlier run holds the last subnet
+	if _, err := ipam.Claim("tap-1a2b3c4d", net.ParseIP("10.200.0.13")); err != nil {
+		t.Fatal(err)
+	}
+
+	lease := ipam.Reserve("template")
+	if lease.GuestIP.String() != "10.200.0.14" {
+		t.Fatalf("Expected the last subnet, got %s", lease.GuestIP)
+	}
+	if again := ipam.Reserve("template"); again != lease {
+		t.Error("Expected reserving twice to return the same lease")
+	}
+
+	// The reserved subnet is never handed out
+	for _, owner := range []string{"vm-a", "vm-b", "vm-c"} {
+		other, err := ipam.Allocate(owner)
+		if err != nil {
+			t.Fatalf("Failed to allocate for %s: %v", owner, err)
+		}
+		if other.GuestIP.Equal(lease.GuestIP) {
+			t.Fatalf("Reserved subnet leased to %s", owner)
+		}
+	}
+	if _, err := ipam.Allocate("vm-d"); !errors.Is(err, ErrAddressesExhausted) {
+		t.Fatalf("Expected ErrAddressesExhausted, got %v", err)
+	}
+
+	// The TAP device no longer owns it
+	ipam.Release("tap-1a2b3c4d")
+	if leased, _ := ipam.Leased(); leased != 4 {
+		t.Errorf("Expected 4 leased subnets, got %d", leased)
+	}
+}
@@ -0,0 +1,97 @@
+package firecracker
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Layer images are sized to their files plus room for the filesystem's own
+// structures
+const (
+	layerImageBlock   = 4096
+	layerImageSlackMB = 8
+)
+
+// LayerImage returns the path of a read-only ext4 image holding a layer's
+// files, building it on first use. Images are kept in DataDir/layers/ by
+// key, which identifies the layer's content; unpack writes the files into
+// the directory the image is made from.
+func (m *Manager) LayerImage(key string, unpack func(dir string) error) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\.`) {
+		return "", fmt.Errorf("invalid layer key %q", key)
+	}
+
+	m.layersMu.Lock()
+	defer m.layersMu.Unlock()
+
+	dir := filepath.Join(m.config.DataDir, "layers")
+	image := filepath.Join(dir, key+".ext4")
+	if _, err := os.Stat(image); err == nil {
+		return image, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create layers directory: %w", err)
+	}
+
+	// No other build runs while the lock is held, so working directories
+	// left here were interrupted by a restart
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".build-*"))
+	for _, path := range leftovers {
+		os.RemoveAll(path)
+	}
+
+	work, err := os.MkdirTemp(dir, ".build-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create layer build directory: %w", err)
+	}
+	defer os.RemoveAll(work)
+
+	files := filepath.Join(work, "files")
+	if err := os.Mkdir(files, 0755); err != nil {
+		return "", err
+	}
+	if err := unpack(files); err != nil {
+		return "", fmt.Errorf("failed to unpack layer: %w", err)
+	}
+
+	size, err := layerSize(files)
+	if err != nil {
+		return "", fmt.Errorf("failed to size layer image: %w", err)
+	}
+	sizeMB := size*5/4>>20 + layerImageSlackMB
+
+	built := filepath.Join(work, "layer.ext4")
+	cmd := exec.Command("mkfs.ext4", "-q", "-F", "-O", "^has_journal", "-d", files, built, fmt.Sprintf("%dM", sizeMB))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build layer image: %w (output: %s)", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(built, image); err != nil {
+		return "", fmt.Errorf("failed to store layer image: %w", err)
+	}
+	return image, nil
+}
+
+// layerSize estimates the bytes a tree takes on an ext4 filesystem: its
+// files rounded up to whole blocks and a block for each entry
+func layerSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		size += layerImageBlock
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += (info.Size() + layerImageBlock - 1) / layerImageBlock * layerImageBlock
+		}
+		return nil
+	})
+	return size, err
+}
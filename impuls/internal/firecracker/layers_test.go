@@ -0,0 +1,61 @@
+package firecracker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLayerImage(t *testing.T) {
+	if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+		t.Skip("mkfs.ext4 not installed")
+	}
+	m := newTestManager(t)
+
+	unpacked := 0
+	unpack := func(dir string) error {
+		unpacked++
+		if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, "lib", "helpers.js"), []byte("exports.greet = () => 'hello';\n"), 0644)
+	}
+
+	image, err := m.LayerImage("0123abcd", unpack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image != filepath.Join(m.config.DataDir, "layers", "0123abcd.ext4") {
+		t.Errorf("Unexpected image path %s", image)
+	}
+
+	if _, err := exec.LookPath("debugfs"); err == nil {
+		out, err := exec.Command("debugfs", "-R", "cat /lib/helpers.js", image).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), "exports.greet") {
+			t.Errorf("Expected the layer's files in the image, got %q", out)
+		}
+	}
+
+	// Built once per key
+	again, err := m.LayerImage("0123abcd", unpack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != image || unpacked != 1 {
+		t.Errorf("Expected the cached image to be reused, unpacked %d times", unpacked)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(image))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the image to remain, got %d entries", len(entries))
+	}
+
+	if _, err := m.LayerImage("../escape", unpack); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package firecracker
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -24,6 +26,15 @@ type Config struct {
 	KernelPath     string
 	RootFSPath     string
 	DataDir        string
+	NetworkCIDR    string // VM subnets are leased from here, DefaultNetworkCIDR if empty
+	GuestTransport string // How the host reaches the agent: TransportTCP (default) or TransportVsock
+
+	// Host budget for all VMs together, 0 for no limit
+	MemoryBudgetMB int
+	VCPUBudget     int
+	// CapacityWait is how long a create request waits for capacity before
+	// it is rejected, 0 to reject at once
+	CapacityWait time.Duration
 }
 
 // VMConfig holds configuration for a single VM
@@ -36,6 +47,8 @@ type VMConfig struct {
 	Handler      string
 	Runtime      string
 	Environment  map[string]string
+	NoNetwork    bool     // No TAP device; the agent is reached over vsock
+	Layers       []string // Layer images attached as read-only drives, in order
 }
 
 // VM represents a running Firecracker VM
@@ -45,8 +58,15 @@ type VM struct {
 	SocketPath   string
 	Process      *exec.Cmd
 	IPAddress    string
+	VsockPath    string // Host side of the vsock device, if the VM has one
 	State        VMState
 	CreatedAt    time.Time
+	LastUsedAt   time.Time
+	device       string // TAP device requests to the guest are bound to, set if its address is shared
+	transport    GuestTransport
+	pooled       bool // Waiting in the pool
+	draining     bool // Stopped instead of going back to the pool
+	deadline     time.Time // Of the invocation the guest is running, zero between invocations
 	mu           sync.Mutex
 }
 
@@ -66,6 +86,10 @@ type Manager struct {
 	vms        map[string]*VM
 	mu         sync.RWMutex
 	httpClient *http.Client
+	capacity   *capacity
+	ipam       *IPAM
+	snapshots  *snapshotter // nil unless snapshots are enabled
+	layersMu   sync.Mutex   // serializes building layer images
 }
 
 // NewManager creates a new Firecracker manager
@@ -88,21 +112,46 @@ func NewManager(config Config) (*Manager, error) {
 		}
 	}
 
+	switch config.GuestTransport {
+	case "":
+		config.GuestTransport = TransportTCP
+	case TransportTCP, TransportVsock:
+	default:
+		return nil, fmt.Errorf("invalid guest transport %q: must be %s or %s", config.GuestTransport, TransportTCP, TransportVsock)
+	}
+
+	if config.NetworkCIDR == "" {
+		config.NetworkCIDR = DefaultNetworkCIDR
+	}
+	ipam, err := NewIPAM(config.NetworkCIDR)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create HTTP client with Unix socket transport
-	return &Manager{
+	m := &Manager{
 		config: config,
 		vms:    make(map[string]*VM),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-	}, nil
+		capacity: newCapacity(config.MemoryBudgetMB, config.VCPUBudget, config.CapacityWait),
+		ipam:     ipam,
+	}
+	m.recoverLeases()
+
+	return m, nil
 }
 
-// CreateVM creates and starts a new Firecracker VM
+// CreateVM creates and starts a new Firecracker VM. If the host budget is
+// used up it waits up to Config.CapacityWait for other VMs to stop.
 func (m *Manager) CreateVM(ctx context.Context, config VMConfig) (*VM, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.createVM(ctx, config, true)
+}
 
+// createVM reserves the VM's resources and boots it. Only waits for
+// capacity if wait is set.
+func (m *Manager) createVM(ctx context.Context, config VMConfig, wait bool) (*VM, error) {
 	if config.ID == "" {
 		config.ID = uuid.New().String()
 	}
@@ -115,6 +164,34 @@ func (m *Manager) CreateVM(ctx context.Context, config VMConfig) (*VM, error) {
 		config.VCPUs = 1
 	}
 
+	if err := m.capacity.reserve(ctx, config.MemoryMB, config.VCPUs, wait); err != nil {
+		return nil, err
+	}
+
+	if snapshot := m.snapshots.get(config); snapshot != nil {
+		vm, err := m.restoreVM(ctx, config, snapshot)
+		if err == nil {
+			m.snapshots.restored(snapshot)
+			return vm, nil
+		}
+		fmt.Printf("Warning: failed to restore VM %s from the %s snapshot, booting it instead: %v\n", config.ID, config.Runtime, err)
+		m.snapshots.restoreFailed(snapshot)
+	}
+
+	vm, err := m.bootVM(ctx, config, nil)
+	if err != nil {
+		m.capacity.release(config.MemoryMB, config.VCPUs)
+		return nil, err
+	}
+	return vm, nil
+}
+
+// bootVM starts a VM whose resources have been reserved. The VM gets its
+// own subnet unless lease is set.
+func (m *Manager) bootVM(ctx context.Context, config VMConfig, lease *Lease) (*VM, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// Create VM instance
 	vm := &VM{
 		ID:         config.ID,
@@ -122,13 +199,63 @@ func (m *Manager) CreateVM(ctx context.Context, config VMConfig) (*VM, error) {
 		SocketPath: filepath.Join(m.config.DataDir, "sockets", config.ID+".sock"),
 		State:      VMStateCreating,
 		CreatedAt:  time.Now(),
+		transport:  tcpTransport{},
+	}
+
+	// Snapshot templates, which share an address, never get a vsock
+	// device: it would be restored into every VM with the same socket path
+	if lease == nil && (config.NoNetwork || m.config.GuestTransport == TransportVsock) {
+		vm.VsockPath = filepath.Join(m.config.DataDir, "sockets", config.ID+".vsock")
+		vm.transport = vsockTransport{}
+	}
+
+	logFile, err := m.startFirecracker(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave nothing behind, the reaper would only find it on its next pass.
+	// A lease that was passed in belongs to the caller.
+	fail := func(err error) (*VM, error) {
+		vm.Process.Process.Kill()
+		vm.Process.Wait()
+		logFile.Close()
+		os.Remove(vm.SocketPath)
+		if vm.VsockPath != "" {
+			os.Remove(vm.VsockPath)
+		}
+		exec.Command("ip", "link", "del", tapName(vm.ID)).Run()
+		if lease == nil {
+			m.ipam.Release(vm.ID)
+		}
+		os.RemoveAll(filepath.Join(m.config.DataDir, "vms", vm.ID))
+		return nil, err
+	}
+
+	// Configure the VM
+	if err := m.configureVM(vm, lease); err != nil {
+		return fail(fmt.Errorf("failed to configure VM: %w", err))
 	}
 
+	// Start the VM
+	if err := m.startVM(vm); err != nil {
+		return fail(fmt.Errorf("failed to start VM: %w", err))
+	}
+
+	vm.State = VMStateRunning
+	m.vms[vm.ID] = vm
+
+	return vm, nil
+}
+
+// startFirecracker starts the Firecracker process of a VM and waits for its
+// API socket
+func (m *Manager) startFirecracker(ctx context.Context, vm *VM) (*os.File, error) {
 	// Remove existing socket if any
 	os.Remove(vm.SocketPath)
 
 	// Create log file
-	logPath := filepath.Join(m.config.DataDir, "logs", config.ID+".log")
+	logPath := filepath.Join(m.config.DataDir, "logs", vm.ID+".log")
 	logFile, err := os.Create(logPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create log file: %w", err)
@@ -158,24 +285,7 @@ func (m *Manager) CreateVM(ctx context.Context, config VMConfig) (*VM, error) {
 		return nil, fmt.Errorf("failed to wait for socket: %w", err)
 	}
 
-	// Configure the VM
-	if err := m.configureVM(vm); err != nil {
-		cmd.Process.Kill()
-		logFile.Close()
-		return nil, fmt.Errorf("failed to configure VM: %w", err)
-	}
-
-	// Start the VM
-	if err := m.startVM(vm); err != nil {
-		cmd.Process.Kill()
-		logFile.Close()
-		return nil, fmt.Errorf("failed to start VM: %w", err)
-	}
-
-	vm.State = VMStateRunning
-	m.vms[vm.ID] = vm
-
-	return vm, nil
+	return logFile, nil
 }
 
 // waitForSocket waits for the Unix socket to become available
@@ -192,12 +302,26 @@ func (m *Manager) waitForSocket(socketPath string, timeout time.Duration) error
 	return fmt.Errorf("timeout waiting for socket %s", socketPath)
 }
 
-// configureVM configures the VM via Firecracker API
-func (m *Manager) configureVM(vm *VM) error {
+// configureVM configures the VM via Firecracker API. A nil lease leases
+// the VM a subnet of its own, unless it has no network.
+func (m *Manager) configureVM(vm *VM, lease *Lease) error {
+	// Lease the VM's subnet first; the kernel configures the guest side
+	// from the boot arguments
+	if lease == nil && !vm.Config.NoNetwork {
+		var err error
+		if lease, err = m.ipam.Allocate(vm.ID); err != nil {
+			return fmt.Errorf("failed to allocate network: %w", err)
+		}
+	}
+
 	// Set boot source
+	bootArgs := "console=ttyS0 reboot=k panic=1 pci=off"
+	if lease != nil {
+		bootArgs += " " + kernelIPArg(lease)
+	}
 	bootSource := map[string]interface{}{
 		"kernel_image_path": m.config.KernelPath,
-		"boot_args":         "console=ttyS0 reboot=k panic=1 pci=off",
+		"boot_args":         bootArgs,
 	}
 	if err := m.apiCall(vm.SocketPath, "PUT", "/boot-source", bootSource); err != nil {
 		return fmt.Errorf("failed to set boot source: %w", err)
@@ -220,6 +344,20 @@ func (m *Manager) configureVM(vm *VM) error {
 		return fmt.Errorf("failed to set root drive: %w", err)
 	}
 
+	// Attach layers as read-only drives; the guest mounts them in order
+	for i, image := range vm.Config.Layers {
+		driveID := fmt.Sprintf("layer%d", i)
+		layerDrive := map[string]interface{}{
+			"drive_id":       driveID,
+			"path_on_host":   image,
+			"is_root_device": false,
+			"is_read_only":   true,
+		}
+		if err := m.apiCall(vm.SocketPath, "PUT", "/drives/"+driveID, layerDrive); err != nil {
+			return fmt.Errorf("failed to attach layer drive: %w", err)
+		}
+	}
+
 	// Set machine config
 	machineConfig := map[string]interface{}{
 		"vcpu_count":  vm.Config.VCPUs,
@@ -230,8 +368,16 @@ func (m *Manager) configureVM(vm *VM) error {
 	}
 
 	// Configure network
-	if err := m.configureNetwork(vm); err != nil {
-		return fmt.Errorf("failed to configure network: %w", err)
+	if lease != nil {
+		if err := m.configureNetwork(vm, lease); err != nil {
+			return fmt.Errorf("failed to configure network: %w", err)
+		}
+	}
+
+	if vm.VsockPath != "" {
+		if err := m.configureVsock(vm); err != nil {
+			return fmt.Errorf("failed to configure vsock: %w", err)
+		}
 	}
 
 	return nil
@@ -246,24 +392,54 @@ func (m *Manager) createOverlayRootFS(vm *VM) (string, error) {
 		return "", err
 	}
 
-	// Create a sparse copy of the base rootfs (copy-on-write using cp --reflink if available)
-	cmd := exec.Command("cp", "--reflink=auto", "--sparse=always", m.config.RootFSPath, overlayPath)
+	if err := copyDisk(m.config.RootFSPath, overlayPath); err != nil {
+		return "", err
+	}
+
+	return overlayPath, nil
+}
+
+// copyDisk copies a disk image, sharing blocks with the source where the
+// filesystem allows it
+func copyDisk(src, dst string) error {
+	// Create a sparse copy (copy-on-write using cp --reflink if available)
+	cmd := exec.Command("cp", "--reflink=auto", "--sparse=always", src, dst)
 	if err := cmd.Run(); err != nil {
 		// Fallback: create a qcow2 overlay or just copy
-		cmd = exec.Command("cp", m.config.RootFSPath, overlayPath)
+		cmd = exec.Command("cp", src, dst)
 		if err := cmd.Run(); err != nil {
-			return "", fmt.Errorf("failed to create rootfs overlay: %w", err)
+			return fmt.Errorf("failed to copy disk %s: %w", src, err)
 		}
 	}
-
-	return overlayPath, nil
+	return nil
 }
 
 // configureNetwork sets up networking for the VM
-func (m *Manager) configureNetwork(vm *VM) error {
+func (m *Manager) configureNetwork(vm *VM, lease *Lease) error {
 	// Create TAP device for this VM
-	tapName := fmt.Sprintf("tap-%s", vm.ID[:8])
-	
+	tapName := tapName(vm.ID)
+	if err := createTap(tapName, lease); err != nil {
+		return err
+	}
+
+	// Store guest IP for later use
+	vm.IPAddress = lease.GuestIP.String()
+
+	// Configure network interface in Firecracker
+	networkIface := map[string]interface{}{
+		"iface_id":     "eth0",
+		"guest_mac":    lease.MAC,
+		"host_dev_name": tapName,
+	}
+	if err := m.apiCall(vm.SocketPath, "PUT", "/network-interfaces/eth0", networkIface); err != nil {
+		return fmt.Errorf("failed to configure network interface: %w", err)
+	}
+
+	return nil
+}
+
+// createTap creates a TAP device carrying the host address of lease
+func createTap(tapName string, lease *Lease) error {
 	// Create TAP device
 	if err := exec.Command("ip", "tuntap", "add", tapName, "mode", "tap").Run(); err != nil {
 		// TAP might already exist, try to continue
@@ -276,56 +452,47 @@ func (m *Manager) configureNetwork(vm *VM) error {
 	}
 
 	// Assign IP to TAP device (host side)
-	hostIP := m.getHostIP(vm.ID)
+	hostIP := lease.HostIP.String()
 	if err := exec.Command("ip", "addr", "add", hostIP+"/30", "dev", tapName).Run(); err != nil {
 		// IP might already be assigned
 		fmt.Printf("Warning: failed to assign IP to TAP device: %v\n", err)
 	}
 
-	// Store guest IP for later use
-	vm.IPAddress = m.getGuestIP(vm.ID)
+	return nil
+}
 
-	// Configure network interface in Firecracker
-	networkIface := map[string]interface{}{
-		"iface_id":     "eth0",
-		"guest_mac":    m.generateMAC(vm.ID),
-		"host_dev_name": tapName,
-	}
-	if err := m.apiCall(vm.SocketPath, "PUT", "/network-interfaces/eth0", networkIface); err != nil {
-		return fmt.Errorf("failed to configure network interface: %w", err)
-	}
+// tapPrefix starts the name of every TAP device created for a VM
+const tapPrefix = "tap-"
 
-	return nil
+// tapName returns the TAP device name of a VM. Interface names are limited
+// to 15 characters, so only the start of the ID is used.
+func tapName(vmID string) string {
+	return tapPrefix + vmID[:8]
 }
 
-// getHostIP generates a host IP for the TAP device
-func (m *Manager) getHostIP(vmID string) string {
-	// Use a simple hash to generate unique IPs
-	// In production, use a proper IP allocation mechanism
-	hash := 0
-	for _, c := range vmID {
-		hash = (hash + int(c)) % 250
-	}
-	return fmt.Sprintf("172.16.%d.1", hash+1)
+// kernelIPArg returns the kernel argument that configures the guest's eth0
+func kernelIPArg(lease *Lease) string {
+	// ip=<client>:<server>:<gateway>:<netmask>:<hostname>:<device>:<autoconf>
+	return fmt.Sprintf("ip=%s::%s:%s::eth0:off", lease.GuestIP, lease.HostIP, lease.Netmask())
 }
 
-// getGuestIP generates a guest IP for the VM
-func (m *Manager) getGuestIP(vmID string) string {
-	hash := 0
-	for _, c := range vmID {
-		hash = (hash + int(c)) % 250
+// recoverLeases takes back the subnets of TAP devices left by an earlier
+// run, since their VMs may still be running
+func (m *Manager) recoverLeases() {
+	output, err := exec.Command("ip", "-o", "-4", "addr", "show").Output()
+	if err != nil {
+		fmt.Printf("Warning: failed to list existing TAP devices: %v\n", err)
+		return
 	}
-	return fmt.Sprintf("172.16.%d.2", hash+1)
-}
 
-// generateMAC generates a unique MAC address for the VM
-func (m *Manager) generateMAC(vmID string) string {
-	// Generate MAC from VM ID
-	hash := 0
-	for _, c := range vmID {
-		hash = (hash + int(c)) % 256
+	for tap, ip := range parseTapAddresses(string(output)) {
+		if !m.ipam.Contains(ip) {
+			continue
+		}
+		if _, err := m.ipam.Claim(tap, ip); err != nil {
+			fmt.Printf("Warning: failed to recover subnet of %s: %v\n", tap, err)
+		}
 	}
-	return fmt.Sprintf("AA:FC:00:00:00:%02X", hash)
 }
 
 // startVM starts the configured VM
@@ -346,6 +513,7 @@ func (m *Manager) StopVM(vmID string) error {
 		return fmt.Errorf("VM %s not found", vmID)
 	}
 
+	delete(m.vms, vmID)
 	return m.stopVM(vm)
 }
 
@@ -353,6 +521,11 @@ func (m *Manager) stopVM(vm *VM) error {
 	vm.mu.Lock()
 	defer vm.mu.Unlock()
 
+	if vm.State == VMStateStopped {
+		return nil
+	}
+	defer m.capacity.release(vm.Config.MemoryMB, vm.Config.VCPUs)
+
 	if vm.Process != nil && vm.Process.Process != nil {
 		vm.Process.Process.Kill()
 		vm.Process.Wait()
@@ -360,10 +533,13 @@ func (m *Manager) stopVM(vm *VM) error {
 
 	// Cleanup
 	os.Remove(vm.SocketPath)
+	if vm.VsockPath != "" {
+		os.Remove(vm.VsockPath)
+	}
 	
-	// Remove TAP device
-	tapName := fmt.Sprintf("tap-%s", vm.ID[:8])
-	exec.Command("ip", "link", "del", tapName).Run()
+	// Remove TAP device and release its subnet
+	exec.Command("ip", "link", "del", tapName(vm.ID)).Run()
+	m.ipam.Release(vm.ID)
 
 	// Remove VM directory
 	vmDir := filepath.Join(m.config.DataDir, "vms", vm.ID)
@@ -413,21 +589,47 @@ func (m *Manager) Cleanup() error {
 	return lastErr
 }
 
-// ExecuteFunction executes a function in a VM and returns the result
-func (m *Manager) ExecuteFunction(ctx context.Context, vm *VM, payload []byte) ([]byte, error) {
+// ndjsonContentType is the content type of streamed guest responses
+const ndjsonContentType = "application/x-ndjson"
+
+// defaultExecuteTimeout bounds invocations whose context has no deadline
+var defaultExecuteTimeout = 30 * time.Second
+
+// ExecuteFunction executes a function in a VM and returns the result.
+// Runtimes that support it stream the function's output while it runs; each
+// line is passed to onLog and each chunk of a streamed response to onChunk,
+// both of which may be nil.
+func (m *Manager) ExecuteFunction(ctx context.Context, vm *VM, payload []byte, onLog func(stream, line string), onChunk func(data []byte)) ([]byte, error) {
 	// The VM runs a small HTTP server that receives function invocations
 	// We send the payload to this server and wait for the response
 	
-	client := &http.Client{
-		Timeout: time.Duration(30) * time.Second,
+	// The deadline of ctx, the function's timeout, bounds the whole
+	// invocation, however long its response streams
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultExecuteTimeout)
+		defer cancel()
 	}
 
-	url := fmt.Sprintf("http://%s:8080/invoke", vm.IPAddress)
+	// The reaper leaves the VM alone until then
+	deadline, _ := ctx.Deadline()
+	vm.mu.Lock()
+	vm.deadline = deadline
+	vm.mu.Unlock()
+	defer func() {
+		vm.mu.Lock()
+		vm.deadline = time.Time{}
+		vm.mu.Unlock()
+	}()
+	client := guestClient(vm, 0)
+
+	url := guestURL + "/invoke"
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", ndjsonContentType+", application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -435,7 +637,50 @@ func (m *Manager) ExecuteFunction(ctx context.Context, vm *VM, payload []byte) (
 	}
 	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), ndjsonContentType) {
+		return io.ReadAll(resp.Body)
+	}
+	return readGuestFrames(resp.Body, onLog, onChunk)
+}
+
+// guestFrame is one line of a streamed guest response
+type guestFrame struct {
+	Type   string          `json:"type"` // "log", "chunk" or "result"
+	Stream string          `json:"stream,omitempty"`
+	Line   string          `json:"line,omitempty"`
+	Data   []byte          `json:"data,omitempty"` // base64 in JSON
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// readGuestFrames passes log frames to onLog and chunk frames to onChunk
+// until the result frame arrives and returns its result
+func readGuestFrames(r io.Reader, onLog func(stream, line string), onChunk func(data []byte)) ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var frame guestFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("invalid frame from guest: %w", err)
+		}
+		switch frame.Type {
+		case "log":
+			if onLog != nil {
+				onLog(frame.Stream, frame.Line)
+			}
+		case "chunk":
+			if onChunk != nil {
+				onChunk(frame.Data)
+			}
+		case "result":
+			return frame.Result, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read function output: %w", err)
+	}
+	return nil, fmt.Errorf("guest closed the stream without a result")
 }
 
 // apiCall makes an API call to the Firecracker socket
@@ -0,0 +1,68 @@
+package firecracker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// failingFirecracker accepts every API call but the machine config, which
+// comes after the VM has its subnet and overlay disk
+const failingFirecracker = `#!/usr/bin/env python3
+import http.server, socketserver, sys
+
+class API(http.server.BaseHTTPRequestHandler):
+    def do_PUT(self):
+        self.rfile.read(int(self.headers["Content-Length"]))
+        self.send_response(400 if self.path == "/machine-config" else 204)
+        self.end_headers()
+
+    def log_message(self, *args):
+        pass
+
+socketserver.UnixStreamServer(sys.argv[sys.argv.index("--api-sock") + 1], API).serve_forever()
+`
+
+func TestCreateVMCleansUpAfterFailedBoot(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	dir := t.TempDir()
+	config := Config{
+		FirecrackerBin: filepath.Join(dir, "firecracker"),
+		KernelPath:     filepath.Join(dir, "vmlinux"),
+		RootFSPath:     filepath.Join(dir, "rootfs.ext4"),
+		DataDir:        dir,
+	}
+	if err := os.WriteFile(config.FirecrackerBin, []byte(failingFirecracker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.RootFSPath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.CreateVM(context.Background(), VMConfig{ID: "failed-boot", Runtime: "nodejs20"}); err == nil {
+		t.Fatal("Expected the boot to fail")
+	}
+
+	if leased, _ := m.ipam.Leased(); leased != 0 {
+		t.Errorf("Expected the VM's subnet to be released, %d still leased", leased)
+	}
+	for _, path := range []string{
+		filepath.Join(dir, "vms", "failed-boot"),
+		filepath.Join(dir, "sockets", "failed-boot.sock"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	if usage := m.Utilization(); usage.MemoryMB != 0 {
+		t.Errorf("Expected the VM's memory to be released, got %+v", usage)
+	}
+}
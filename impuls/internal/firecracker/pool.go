@@ -2,40 +2,107 @@ package firecracker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
-// VMPool manages a pool of pre-warmed VMs for faster cold starts
+const (
+	// PoolVMMemoryMB is the memory size of pre-warmed VMs. Functions that ask
+	// for a different size bypass the pool and get a dedicated VM.
+	PoolVMMemoryMB = 128
+
+	// defaultPoolIdleTimeout is how long a VM loaded with a function's code
+	// is kept around waiting for another invocation of that function.
+	defaultPoolIdleTimeout = 5 * time.Minute
+)
+
+// DefaultPoolRuntimes lists the runtimes that get a warm pool
+var DefaultPoolRuntimes = []string{"nodejs20", "nodejs18", "python312", "python311", "dotnet8", "dotnet7", "go122", "provided"}
+
+// VMPool manages a pool of pre-warmed VMs for faster cold starts.
+//
+// Clean VMs are kept per runtime. Once a VM has served a function it is
+// only handed out again for that same function on the same runtime, so
+// environment variables and module state never leak between functions, and
+// a function whose runtime changed never gets a VM booted with the old one.
 type VMPool struct {
-	manager  *Manager
-	pools    map[string]chan *VM // runtime -> pool of warm VMs
-	poolSize int
-	mu       sync.RWMutex
-	stopChan chan struct{}
+	manager     *Manager
+	pools       map[string]chan *VM  // runtime -> pool of clean warm VMs
+	warm        map[warmKey]chan *VM // VMs already loaded with a function's code
+	sizes       map[string]int       // runtime -> target pool size
+	creating    map[string]int       // runtime -> VMs currently booting
+	idleTimeout time.Duration
+	ctx         context.Context
+	mu          sync.RWMutex
+	stopped     bool
+	stopChan    chan struct{}
 }
 
-// NewVMPool creates a new VM pool
-func NewVMPool(manager *Manager, poolSize int) *VMPool {
+// NewVMPool creates a new VM pool. Every runtime in DefaultPoolRuntimes
+// gets defaultSize warm VMs unless sizes overrides it.
+func NewVMPool(manager *Manager, defaultSize int, sizes map[string]int) *VMPool {
 	pool := &VMPool{
-		manager:  manager,
-		pools:    make(map[string]chan *VM),
-		poolSize: poolSize,
-		stopChan: make(chan struct{}),
+		manager:     manager,
+		pools:       make(map[string]chan *VM),
+		warm:        make(map[warmKey]chan *VM),
+		sizes:       make(map[string]int),
+		creating:    make(map[string]int),
+		idleTimeout: defaultPoolIdleTimeout,
+		ctx:         context.Background(),
+		stopChan:    make(chan struct{}),
 	}
 
 	// Initialize pools for supported runtimes
-	runtimes := []string{"nodejs20", "nodejs18", "python312", "python311", "dotnet8", "dotnet7"}
-	for _, runtime := range runtimes {
-		pool.pools[runtime] = make(chan *VM, poolSize)
+	for _, runtime := range DefaultPoolRuntimes {
+		pool.sizes[runtime] = defaultSize
+	}
+	for runtime, size := range sizes {
+		pool.sizes[runtime] = size
+	}
+	for runtime, size := range pool.sizes {
+		pool.pools[runtime] = make(chan *VM, poolCapacity(size))
 	}
 
 	return pool
 }
 
+// warmKey identifies the VMs that served a function on a runtime
+type warmKey struct {
+	function string
+	runtime  string
+}
+
+// poolCapacity keeps at least one slot so VMs can be returned to runtimes
+// that are not pre-warmed
+func poolCapacity(size int) int {
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+// Size returns the target number of warm VMs for a runtime
+func (p *VMPool) Size(runtime string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.sizes[runtime]
+}
+
+// Accepts reports whether a function with the given memory size can be
+// served from the pool
+func (p *VMPool) Accepts(memoryMB int) bool {
+	return memoryMB == PoolVMMemoryMB
+}
+
 // Start starts the pool manager which keeps pools warm
 func (p *VMPool) Start(ctx context.Context) {
+	p.mu.Lock()
+	p.ctx = ctx
+	p.mu.Unlock()
+
+	p.warmPools(ctx)
 	go p.warmPoolsLoop(ctx)
 }
 
@@ -51,6 +118,7 @@ func (p *VMPool) warmPoolsLoop(ctx context.Context) {
 		case <-p.stopChan:
 			return
 		case <-ticker.C:
+			p.evictIdle()
 			p.warmPools(ctx)
 		}
 	}
@@ -58,21 +126,37 @@ func (p *VMPool) warmPoolsLoop(ctx context.Context) {
 
 // warmPools ensures each pool has the minimum number of VMs
 func (p *VMPool) warmPools(ctx context.Context) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	for runtime, pool := range p.pools {
-		current := len(pool)
-		needed := p.poolSize - current
+		needed := p.sizes[runtime] - len(pool) - p.creating[runtime]
 
 		for i := 0; i < needed; i++ {
+			p.creating[runtime]++
 			go func(rt string, pl chan *VM) {
-				vm, err := p.createWarmVM(ctx, rt)
+				// Warming never queues for capacity, so it does not
+				// compete with invocations
+				vm, err := p.createWarmVM(ctx, rt, false)
+
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				p.creating[rt]--
+
 				if err != nil {
-					fmt.Printf("Failed to create warm VM for %s: %v\n", rt, err)
+					var capacityErr *CapacityError
+					if !errors.As(err, &capacityErr) {
+						fmt.Printf("Failed to create warm VM for %s: %v\n", rt, err)
+					}
 					return
 				}
 
+				if p.stopped {
+					p.manager.StopVM(vm.ID)
+					return
+				}
+
+				vm.setPooled()
 				select {
 				case pl <- vm:
 				default:
@@ -84,52 +168,159 @@ func (p *VMPool) warmPools(ctx context.Context) {
 	}
 }
 
-// createWarmVM creates a VM ready to receive function code
-func (p *VMPool) createWarmVM(ctx context.Context, runtime string) (*VM, error) {
+// evictIdle stops function VMs that have not been used for idleTimeout and
+// drops VMs stopped or drained while they waited, so the pools refill
+func (p *VMPool) evictIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+
+	for key, pool := range p.warm {
+		p.sweep(pool, func(vm *VM) bool {
+			vm.mu.Lock()
+			idle := time.Since(vm.LastUsedAt)
+			vm.mu.Unlock()
+			return idle <= p.idleTimeout
+		})
+		if len(pool) == 0 {
+			delete(p.warm, key)
+		}
+	}
+
+	for _, pool := range p.pools {
+		p.sweep(pool, func(vm *VM) bool { return true })
+	}
+}
+
+// sweep stops the VMs waiting in pool that are retired or that keep
+// rejects. GetVM takes VMs without the lock, so the pool may run empty while
+// it is swept; sweeping then ends early instead of waiting for a VM.
+func (p *VMPool) sweep(pool chan *VM, keep func(vm *VM) bool) {
+	for i := len(pool); i > 0; i-- {
+		var vm *VM
+		select {
+		case vm = <-pool:
+		default:
+			return
+		}
+		if vm.isRetired() || !keep(vm) {
+			p.manager.StopVM(vm.ID)
+			continue
+		}
+		select {
+		case pool <- vm:
+		default:
+			p.manager.StopVM(vm.ID)
+		}
+	}
+}
+
+// createWarmVM creates a VM ready to receive function code. If wait is
+// set it queues for host capacity like any other VM.
+func (p *VMPool) createWarmVM(ctx context.Context, runtime string, wait bool) (*VM, error) {
 	config := VMConfig{
 		Runtime:  runtime,
-		MemoryMB: 128,
+		MemoryMB: PoolVMMemoryMB,
 		VCPUs:    1,
 	}
 
-	return p.manager.CreateVM(ctx, config)
+	return p.manager.createVM(ctx, config, wait)
 }
 
-// GetVM gets a warm VM from the pool or creates a new one
-func (p *VMPool) GetVM(ctx context.Context, runtime string) (*VM, error) {
+// GetVM gets a VM for a function invocation. A VM that already served the
+// function is preferred, then a clean warm VM for the runtime, and finally
+// a freshly booted one.
+func (p *VMPool) GetVM(ctx context.Context, runtime, functionName string) (*VM, error) {
 	p.mu.RLock()
 	pool, exists := p.pools[runtime]
+	warm := p.warm[warmKey{function: functionName, runtime: runtime}]
+	poolCtx := p.ctx
+	stopped := p.stopped
 	p.mu.RUnlock()
 
 	if !exists {
 		return nil, fmt.Errorf("unsupported runtime: %s", runtime)
 	}
+	if stopped {
+		return nil, errPoolStopped
+	}
 
-	// Try to get from pool first
-	select {
-	case vm := <-pool:
-		return vm, nil
-	default:
-		// Pool empty, create new VM
-		return p.createWarmVM(ctx, runtime)
+	if warm != nil {
+		if vm, err := takeIdle(warm, functionName); vm != nil || err != nil {
+			return vm, err
+		}
+	}
+	if vm, err := takeIdle(pool, functionName); vm != nil || err != nil {
+		return vm, err
+	}
+
+	// Pool empty, create new VM. The pool context is used so the VM
+	// outlives the request and can be returned to the pool afterwards.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vm, err := p.createWarmVM(poolCtx, runtime, true)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.mu.Lock()
+	vm.Config.FunctionName = functionName
+	vm.mu.Unlock()
+
+	return vm, nil
+}
+
+// errPoolStopped is returned for VMs requested from a stopped pool
+var errPoolStopped = errors.New("VM pool is stopped")
+
+// takeIdle claims a waiting VM for a function, skipping VMs stopped or
+// drained while they waited. It returns nil if no VM is waiting.
+func takeIdle(pool chan *VM, functionName string) (*VM, error) {
+	for {
+		select {
+		case vm, ok := <-pool:
+			if !ok {
+				return nil, errPoolStopped
+			}
+			if vm.takeFromPool(functionName) {
+				return vm, nil
+			}
+		default:
+			return nil, nil
+		}
 	}
 }
 
-// ReturnVM returns a VM to the pool
+// ReturnVM returns a VM to the pool of the function it served. Drained VMs
+// are stopped instead.
 func (p *VMPool) ReturnVM(vm *VM, reusable bool) {
-	if !reusable {
+	if !reusable || vm.Config.FunctionName == "" || vm.isRetired() {
 		p.manager.StopVM(vm.ID)
 		return
 	}
 
-	p.mu.RLock()
-	pool, exists := p.pools[vm.Config.Runtime]
-	p.mu.RUnlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	if !exists {
+	size, exists := p.sizes[vm.Config.Runtime]
+	if !exists || p.stopped {
 		p.manager.StopVM(vm.ID)
 		return
 	}
+	key := warmKey{function: vm.Config.FunctionName, runtime: vm.Config.Runtime}
+	pool, ok := p.warm[key]
+	if !ok {
+		pool = make(chan *VM, poolCapacity(size))
+		p.warm[key] = pool
+	}
+
+	vm.mu.Lock()
+	vm.LastUsedAt = time.Now()
+	vm.pooled = true
+	vm.mu.Unlock()
 
 	select {
 	case pool <- vm:
@@ -147,7 +338,15 @@ func (p *VMPool) Stop() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	p.stopped = true
+	pools := make([]chan *VM, 0, len(p.pools)+len(p.warm))
 	for _, pool := range p.pools {
+		pools = append(pools, pool)
+	}
+	for _, pool := range p.warm {
+		pools = append(pools, pool)
+	}
+	for _, pool := range pools {
 		close(pool)
 		for vm := range pool {
 			p.manager.StopVM(vm.ID)
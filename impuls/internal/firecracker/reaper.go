@@ -0,0 +1,310 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxVMLifetime is how long a VM may live before the reaper stops it
+const DefaultMaxVMLifetime = time.Hour
+
+// ReapReport lists what one reaper pass cleaned up
+type ReapReport struct {
+	Processes  []int    // PIDs of killed firecracker processes
+	TapDevices []string // Removed TAP devices
+	Sockets    []string // Removed API and vsock sockets
+	VMDirs     []string // Removed VM directories
+	Expired    []string // IDs of VMs stopped for outliving the maximum lifetime
+}
+
+// Empty reports whether nothing was cleaned up
+func (r ReapReport) Empty() bool {
+	return len(r.Processes)+len(r.TapDevices)+len(r.Sockets)+len(r.VMDirs)+len(r.Expired) == 0
+}
+
+func (r ReapReport) String() string {
+	var parts []string
+	if n := len(r.Processes); n > 0 {
+		parts = append(parts, fmt.Sprintf("killed %d firecracker processes %v", n, r.Processes))
+	}
+	if n := len(r.TapDevices); n > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d TAP devices %v", n, r.TapDevices))
+	}
+	if n := len(r.Sockets); n > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d sockets", n))
+	}
+	if n := len(r.VMDirs); n > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d VM directories", n))
+	}
+	if n := len(r.Expired); n > 0 {
+		parts = append(parts, fmt.Sprintf("stopped %d expired VMs %v", n, r.Expired))
+	}
+	if len(parts) == 0 {
+		return "nothing to clean up"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Reaper removes VM resources the manager does not track, such as those
+// left behind when the server crashed before Cleanup ran: firecracker
+// processes, TAP devices, sockets and VM directories. It also stops VMs
+// that outlive the maximum lifetime, so hung guests cannot leak.
+type Reaper struct {
+	manager     *Manager
+	interval    time.Duration
+	maxLifetime time.Duration // 0 for no limit
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+
+	// Host access, replaced in tests
+	procDir   string
+	listTaps  func() ([]string, error)
+	deleteTap func(name string) error
+	kill      func(pid int) error
+}
+
+// NewReaper creates a reaper that runs every interval
+func NewReaper(manager *Manager, interval, maxLifetime time.Duration) *Reaper {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &Reaper{
+		manager:     manager,
+		interval:    interval,
+		maxLifetime: maxLifetime,
+		stopChan:    make(chan struct{}),
+		procDir:     "/proc",
+		listTaps:    listTapDevices,
+		deleteTap: func(name string) error {
+			return exec.Command("ip", "link", "del", name).Run()
+		},
+		kill: func(pid int) error {
+			return syscall.Kill(pid, syscall.SIGKILL)
+		},
+	}
+}
+
+// Start reaps once and then keeps reaping in the background
+func (r *Reaper) Start(ctx context.Context) {
+	r.reap()
+
+	r.wg.Add(1)
+	go r.run(ctx)
+}
+
+// Stop stops the reaper
+func (r *Reaper) Stop() {
+	close(r.stopChan)
+	r.wg.Wait()
+}
+
+// run reaps every interval until stopped
+func (r *Reaper) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+			r.reap()
+		}
+	}
+}
+
+// reap runs one pass and logs what it cleaned up
+func (r *Reaper) reap() {
+	if report := r.Reap(); !report.Empty() {
+		fmt.Printf("Reaped VM resources: %s\n", report)
+	}
+}
+
+// Reap stops expired VMs and removes everything that belongs to no tracked
+// VM. VMs cannot be created or stopped meanwhile, so a VM that is booting
+// is never mistaken for an orphan.
+func (r *Reaper) Reap() ReapReport {
+	m := r.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var report ReapReport
+	r.expire(&report)
+
+	tracked := make(map[string]bool, len(m.vms))
+	trackedTaps := make(map[string]bool, len(m.vms))
+	for id := range m.vms {
+		tracked[id] = true
+		trackedTaps[tapName(id)] = true
+	}
+
+	socketsDir := filepath.Join(m.config.DataDir, "sockets")
+	for pid, id := range findFirecrackerProcesses(r.procDir, socketsDir) {
+		if tracked[id] {
+			continue
+		}
+		if err := r.kill(pid); err != nil {
+			fmt.Printf("Warning: failed to kill firecracker process %d: %v\n", pid, err)
+			continue
+		}
+		report.Processes = append(report.Processes, pid)
+	}
+
+	taps, err := r.listTaps()
+	if err != nil {
+		fmt.Printf("Warning: failed to list TAP devices: %v\n", err)
+	}
+	for _, tap := range taps {
+		if trackedTaps[tap] {
+			continue
+		}
+		if err := r.deleteTap(tap); err != nil {
+			fmt.Printf("Warning: failed to remove TAP device %s: %v\n", tap, err)
+			continue
+		}
+		// Recovered at startup under the device's name
+		m.ipam.Release(tap)
+		report.TapDevices = append(report.TapDevices, tap)
+	}
+
+	report.Sockets = removeUntracked(socketsDir, tracked)
+	report.VMDirs = removeUntracked(filepath.Join(m.config.DataDir, "vms"), tracked)
+
+	return report
+}
+
+// expire stops VMs older than the maximum lifetime. A VM serving an
+// invocation is drained instead so the invocation can finish; if it is
+// still serving at a later pass and no invocation is running within its
+// deadline, the guest is taken to be hung and stopped. The caller holds
+// m.mu.
+func (r *Reaper) expire(report *ReapReport) {
+	if r.maxLifetime <= 0 {
+		return
+	}
+
+	m := r.manager
+	for id, vm := range m.vms {
+		vm.mu.Lock()
+		expired := time.Since(vm.CreatedAt) > r.maxLifetime
+		serving := !vm.pooled && vm.Config.FunctionName != ""
+		running := time.Now().Before(vm.deadline)
+		stop := expired && (!serving || vm.draining && !running)
+		if expired && !stop {
+			vm.draining = true
+		}
+		vm.mu.Unlock()
+
+		if stop {
+			delete(m.vms, id)
+			m.stopVM(vm)
+			report.Expired = append(report.Expired, id)
+		}
+	}
+}
+
+// removeUntracked removes the entries of dir whose name does not start with
+// the ID of a tracked VM, e.g. "<id>.sock", "<id>.vsock" or "<id>"
+func removeUntracked(dir string, tracked map[string]bool) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		id, _, _ := strings.Cut(entry.Name(), ".")
+		if tracked[id] {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			fmt.Printf("Warning: failed to remove %s: %v\n", path, err)
+			continue
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed
+}
+
+// findFirecrackerProcesses maps the PIDs of firecracker processes whose API
+// socket is in socketsDir to their VM IDs
+func findFirecrackerProcesses(procDir, socketsDir string) map[int]string {
+	processes := make(map[int]string)
+
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return processes
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if id, ok := firecrackerVMID(cmdline, socketsDir); ok {
+			processes[pid] = id
+		}
+	}
+	return processes
+}
+
+// firecrackerVMID returns the VM ID of a firecracker process started by the
+// manager, read from its NUL separated command line
+func firecrackerVMID(cmdline []byte, socketsDir string) (string, bool) {
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	for i := 1; i < len(args)-1; i++ {
+		if args[i] != "--api-sock" {
+			continue
+		}
+		socket := args[i+1]
+		if filepath.Dir(filepath.Clean(socket)) != filepath.Clean(socketsDir) {
+			return "", false
+		}
+		return strings.TrimSuffix(filepath.Base(socket), ".sock"), true
+	}
+	return "", false
+}
+
+// listTapDevices returns the names of the host's VM TAP devices
+func listTapDevices() ([]string, error) {
+	output, err := exec.Command("ip", "-o", "link", "show").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseTapNames(string(output)), nil
+}
+
+// parseTapNames reads the names of VM TAP devices from the output of
+// `ip -o link show`
+func parseTapNames(output string) []string {
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		// 7: tap-1a2b3c4d: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 ...
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
+		if strings.HasPrefix(name, tapPrefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
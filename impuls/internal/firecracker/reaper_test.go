@@ -0,0 +1,223 @@
+package firecracker
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestReaper returns a reaper for m that sees the given processes and TAP
+// devices instead of the host's
+func newTestReaper(t *testing.T, m *Manager, maxLifetime time.Duration, cmdlines map[string][]string, taps []string) (*Reaper, *[]int, *[]string) {
+	t.Helper()
+
+	procDir := t.TempDir()
+	for pid, args := range cmdlines {
+		dir := filepath.Join(procDir, pid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmdline := strings.Join(args, "\x00") + "\x00"
+		if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var killed []int
+	var deleted []string
+	r := NewReaper(m, time.Minute, maxLifetime)
+	r.procDir = procDir
+	r.listTaps = func() ([]string, error) {
+		var remaining []string
+		for _, tap := range taps {
+			if !slices.Contains(deleted, tap) {
+				remaining = append(remaining, tap)
+			}
+		}
+		return remaining, nil
+	}
+	r.deleteTap = func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+	r.kill = func(pid int) error {
+		killed = append(killed, pid)
+		return os.RemoveAll(filepath.Join(procDir, strconv.Itoa(pid)))
+	}
+	return r, &killed, &deleted
+}
+
+func TestFirecrackerVMID(t *testing.T) {
+	socketsDir := "/var/lib/impuls/sockets"
+
+	tests := []struct {
+		cmdline string
+		id      string
+		ok      bool
+	}{
+		{"/usr/local/bin/firecracker\x00--api-sock\x00/var/lib/impuls/sockets/vm-1234.sock\x00", "vm-1234", true},
+		{"firecracker\x00--api-sock\x00/var/lib/impuls/sockets/../sockets/vm-5678.sock\x00", "vm-5678", true},
+		{"firecracker\x00--api-sock\x00/tmp/other/vm-1234.sock\x00", "", false},
+		{"firecracker\x00--api-sock\x00", "", false},
+		{"bash\x00-c\x00sleep 10\x00", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		id, ok := firecrackerVMID([]byte(tt.cmdline), socketsDir)
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("firecrackerVMID(%q) = %q, %v; want %q, %v", tt.cmdline, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestParseTapNames(t *testing.T) {
+	output := `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000\    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+2: eth0@if12: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP mode DEFAULT group default qlen 1000\    link/ether 02:42:ac:11:00:02 brd ff:ff:ff:ff:ff:ff
+7: tap-1a2b3c4d: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP mode DEFAULT group default qlen 1000\    link/ether 6a:1f:0e:22:31:7b brd ff:ff:ff:ff:ff:ff
+8: tap-5e6f7a8b: <NO-CARRIER,BROADCAST,MULTICAST,UP> mtu 1500 qdisc fq_codel state DOWN mode DEFAULT group default qlen 1000\    link/ether 4e:0a:91:5c:02:d8 brd ff:ff:ff:ff:ff:ff
+`
+	names := parseTapNames(output)
+	want := []string{"tap-1a2b3c4d", "tap-5e6f7a8b"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+}
+
+func TestReapOrphans(t *testing.T) {
+	m := newTestManager(t)
+	tracked := addTestVM(m, "vm-tracked-1", "hello")
+
+	socketsDir := filepath.Join(m.config.DataDir, "sockets")
+	vmsDir := filepath.Join(m.config.DataDir, "vms")
+	for _, dir := range []string{
+		socketsDir,
+		filepath.Join(vmsDir, tracked.ID),
+		filepath.Join(vmsDir, "vm-orphan-1"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{
+		tracked.ID + ".sock",
+		tracked.ID + ".vsock",
+		"vm-orphan-1.sock",
+		"vm-orphan-1.vsock",
+		"vm-orphan-1.vsock_52",
+	} {
+		if err := os.WriteFile(filepath.Join(socketsDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The orphan's lease was recovered from its TAP device at startup
+	orphanTap := tapName("vm-orphan-1")
+	if _, err := m.ipam.Claim(orphanTap, net.ParseIP("10.200.0.5")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, killed, deleted := newTestReaper(t, m, 0, map[string][]string{
+		"100":  {"firecracker", "--api-sock", filepath.Join(socketsDir, tracked.ID+".sock")},
+		"200":  {"firecracker", "--api-sock", filepath.Join(socketsDir, "vm-orphan-1.sock")},
+		"300":  {"firecracker", "--api-sock", "/elsewhere/vm-orphan-2.sock"},
+		"self": {"firecracker", "--api-sock", filepath.Join(socketsDir, "vm-orphan-3.sock")},
+	}, []string{tapName(tracked.ID), orphanTap})
+
+	report := r.Reap()
+
+	if !reflect.DeepEqual(*killed, []int{200}) || !reflect.DeepEqual(report.Processes, []int{200}) {
+		t.Errorf("Expected only process 200 to be killed, got %v", *killed)
+	}
+	if !reflect.DeepEqual(*deleted, []string{orphanTap}) || !reflect.DeepEqual(report.TapDevices, []string{orphanTap}) {
+		t.Errorf("Expected only %s to be removed, got %v", orphanTap, *deleted)
+	}
+	if leased, _ := m.ipam.Leased(); leased != 0 {
+		t.Errorf("Expected the orphan's lease to be released, %d still leased", leased)
+	}
+
+	sort.Strings(report.Sockets)
+	wantSockets := []string{"vm-orphan-1.sock", "vm-orphan-1.vsock", "vm-orphan-1.vsock_52"}
+	if !reflect.DeepEqual(report.Sockets, wantSockets) {
+		t.Errorf("Expected sockets %v to be removed, got %v", wantSockets, report.Sockets)
+	}
+	if !reflect.DeepEqual(report.VMDirs, []string{"vm-orphan-1"}) {
+		t.Errorf("Expected the orphan's directory to be removed, got %v", report.VMDirs)
+	}
+
+	entries, _ := os.ReadDir(socketsDir)
+	if len(entries) != 2 {
+		t.Errorf("Expected the tracked VM's sockets to remain, got %d entries", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(vmsDir, tracked.ID)); err != nil {
+		t.Errorf("Expected the tracked VM's directory to remain: %v", err)
+	}
+	if _, exists := m.vms[tracked.ID]; !exists {
+		t.Error("Expected the tracked VM to remain")
+	}
+
+	// Nothing is left for the next pass
+	if again := r.Reap(); !again.Empty() {
+		t.Errorf("Expected nothing to clean up, got %s", again)
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	m := newTestManager(t)
+
+	fresh := addTestVM(m, "vm-fresh-1", "hello")
+	idle := addTestVM(m, "vm-idle-1", "hello")
+	idle.pooled = true
+	serving := addTestVM(m, "vm-serving-1", "hello")
+	running := addTestVM(m, "vm-running-1", "hello")
+	running.deadline = time.Now().Add(time.Hour)
+	for _, vm := range []*VM{idle, serving, running} {
+		vm.CreatedAt = time.Now().Add(-2 * time.Hour)
+	}
+
+	r, _, _ := newTestReaper(t, m, time.Hour, nil, nil)
+
+	// Idle VMs are stopped at once, serving ones are drained first
+	report := r.Reap()
+	if !reflect.DeepEqual(report.Expired, []string{idle.ID}) {
+		t.Errorf("Expected only %s to expire, got %v", idle.ID, report.Expired)
+	}
+	if idle.State != VMStateStopped {
+		t.Error("Expected the idle VM to be stopped")
+	}
+	if !serving.draining || serving.State == VMStateStopped {
+		t.Error("Expected the serving VM to be drained but left running")
+	}
+	if !running.draining || running.State == VMStateStopped {
+		t.Error("Expected the running VM to be drained but left running")
+	}
+
+	// Still serving a pass later: the guest is hung
+	report = r.Reap()
+	if !reflect.DeepEqual(report.Expired, []string{serving.ID}) {
+		t.Errorf("Expected %s to expire, got %v", serving.ID, report.Expired)
+	}
+	if serving.State != VMStateStopped {
+		t.Error("Expected the hung VM to be stopped")
+	}
+
+	// An invocation within its deadline is not hung, however many passes
+	// it takes
+	if report = r.Reap(); len(report.Expired) != 0 || running.State == VMStateStopped {
+		t.Errorf("Expected the running VM to be left to finish, got %v", report.Expired)
+	}
+	running.deadline = time.Now().Add(-time.Second)
+	if report = r.Reap(); !reflect.DeepEqual(report.Expired, []string{running.ID}) {
+		t.Errorf("Expected %s to expire past its deadline, got %v", running.ID, report.Expired)
+	}
+
+	if len(m.vms) != 1 || fresh.State != VMStateRunning || fresh.draining {
+		t.Error("Expected the fresh VM to be left alone")
+	}
+}
@@ -0,0 +1,585 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// snapshotOwner holds the subnet shared by snapshot templates and every
+	// VM restored from them
+	snapshotOwner = "snapshot-template"
+
+	// snapshotVCPUs is the vCPU count of snapshot templates. Their memory is
+	// PoolVMMemoryMB, so snapshots serve the same VMs the pool does.
+	snapshotVCPUs = 1
+
+	// snapshotAgentTimeout is how long a template may take to boot and start
+	// its agent
+	snapshotAgentTimeout = 30 * time.Second
+
+	// snapshotReseedTimeout is how long a restored guest may take to reseed
+	// its entropy, including restarts of its runtime
+	snapshotReseedTimeout = 10 * time.Second
+
+	// reseedEntropyBytes is how much fresh entropy a restored guest gets
+	reseedEntropyBytes = 64
+
+	// snapshotCheckInterval is how often the images are checked for changes
+	snapshotCheckInterval = time.Minute
+
+	// snapshotRetryDelay is how long a runtime boots its VMs after its
+	// snapshot could not be built or kept failing to restore
+	snapshotRetryDelay = 10 * time.Minute
+
+	// maxRestoreFailures is how many restores in a row may fail before a
+	// snapshot is given up
+	maxRestoreFailures = 3
+
+	snapshotMetaFile   = "snapshot.json"
+	snapshotStateFile  = "vmstate"
+	snapshotMemoryFile = "memory"
+	snapshotDiskFile   = "rootfs.ext4"
+)
+
+// Snapshot is a paused template VM of a runtime whose agent is ready to
+// serve. Key ties it to the firecracker binary, kernel and rootfs it was
+// taken with.
+type Snapshot struct {
+	Runtime   string    `json:"runtime"`
+	Key       string    `json:"key"`
+	MemoryMB  int       `json:"memory_mb"`
+	VCPUs     int       `json:"vcpus"`
+	GuestIP   string    `json:"guest_ip"`
+	CreatedAt time.Time `json:"created_at"`
+	dir       string
+}
+
+// path returns the path of one of the snapshot's files
+func (s *Snapshot) path(file string) string {
+	return filepath.Join(s.dir, file)
+}
+
+// snapshotter builds, keeps and invalidates the snapshot of each runtime.
+// Snapshots are stored in DataDir/snapshots/<runtime>/<key>.
+type snapshotter struct {
+	manager  *Manager
+	dir      string
+	runtimes []string
+	lease    *Lease // the guest address baked into every snapshot
+
+	mu        sync.Mutex
+	snapshots map[string]*Snapshot // runtime -> snapshot to restore from
+	failures  map[string]int       // runtime -> restores failed in a row
+	retryAt   map[string]time.Time // runtime -> when its snapshot is built again
+}
+
+func newSnapshotter(m *Manager, runtimes []string) (*snapshotter, error) {
+	dir := filepath.Join(m.config.DataDir, "snapshots")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	return &snapshotter{
+		manager:   m,
+		dir:       dir,
+		runtimes:  runtimes,
+		lease:     m.ipam.Reserve(snapshotOwner),
+		snapshots: make(map[string]*Snapshot),
+		failures:  make(map[string]int),
+		retryAt:   make(map[string]time.Time),
+	}, nil
+}
+
+// EnableSnapshots makes VMs of the given runtimes restore from a snapshot
+// instead of booting. Snapshots left by an earlier run are used right away;
+// missing and outdated ones are built in the background until ctx is done,
+// and VMs boot normally until they are ready. Call it before creating VMs.
+func (m *Manager) EnableSnapshots(ctx context.Context, runtimes []string) error {
+	s, err := newSnapshotter(m, runtimes)
+	if err != nil {
+		return err
+	}
+	s.load()
+	m.snapshots = s
+
+	go s.run(ctx)
+	return nil
+}
+
+// key fingerprints everything a runtime's snapshot depends on. Images are
+// compared by size and modification time, as hashing their content would
+// mean reading the whole rootfs.
+func (s *snapshotter) key(runtime string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %d %d %s\n", runtime, PoolVMMemoryMB, snapshotVCPUs, s.lease.GuestIP)
+
+	config := s.manager.config
+	for _, path := range []string{config.FirecrackerBin, config.KernelPath, config.RootFSPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// load picks up the snapshots of an earlier run that still match the
+// images and removes the others
+func (s *snapshotter) load() {
+	for _, runtime := range s.runtimes {
+		key, err := s.key(runtime)
+		if err != nil {
+			continue
+		}
+
+		snapshot, err := readSnapshot(filepath.Join(s.dir, runtime, key))
+		if err != nil {
+			s.prune(runtime, "")
+			continue
+		}
+		s.snapshots[runtime] = snapshot
+		s.prune(runtime, key)
+	}
+}
+
+// readSnapshot reads a complete snapshot from dir
+func readSnapshot(dir string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotMetaFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata in %s: %w", dir, err)
+	}
+	snapshot.dir = dir
+	return &snapshot, nil
+}
+
+// prune removes the snapshot files of a runtime except those of key keep
+func (s *snapshotter) prune(runtime, keep string) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, runtime))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Name() != keep {
+			os.RemoveAll(filepath.Join(s.dir, runtime, entry.Name()))
+		}
+	}
+}
+
+// get returns the snapshot to restore a VM from, or nil if the VM has to
+// boot. It may be called on a nil snapshotter.
+func (s *snapshotter) get(config VMConfig) *Snapshot {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	snapshot := s.snapshots[config.Runtime]
+	s.mu.Unlock()
+
+	// The guest's memory, vCPUs, network and drives are part of the snapshot
+	if snapshot == nil || snapshot.MemoryMB != config.MemoryMB || snapshot.VCPUs != config.VCPUs || config.NoNetwork || len(config.Layers) > 0 {
+		return nil
+	}
+
+	// Images replaced since the snapshot was taken make it useless. The
+	// next check removes it and builds a new one.
+	if key, err := s.key(config.Runtime); err != nil || key != snapshot.Key {
+		s.drop(snapshot)
+		return nil
+	}
+	return snapshot
+}
+
+// drop stops restoring from a snapshot
+func (s *snapshotter) drop(snapshot *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropLocked(snapshot)
+}
+
+func (s *snapshotter) dropLocked(snapshot *Snapshot) {
+	if s.snapshots[snapshot.Runtime] == snapshot {
+		delete(s.snapshots, snapshot.Runtime)
+	}
+}
+
+// restored resets the failure count of a snapshot that worked
+func (s *snapshotter) restored(snapshot *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, snapshot.Runtime)
+}
+
+// restoreFailed counts a failed restore. After maxRestoreFailures in a row
+// the runtime boots its VMs until the snapshot is built again.
+func (s *snapshotter) restoreFailed(snapshot *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[snapshot.Runtime]++
+	if s.failures[snapshot.Runtime] < maxRestoreFailures {
+		return
+	}
+
+	delete(s.failures, snapshot.Runtime)
+	s.dropLocked(snapshot)
+	s.retryAt[snapshot.Runtime] = time.Now().Add(snapshotRetryDelay)
+	fmt.Printf("Warning: %s snapshot failed to restore %d times in a row, rebuilding it in %v\n",
+		snapshot.Runtime, maxRestoreFailures, snapshotRetryDelay)
+}
+
+// run keeps the snapshots in line with the images until ctx is done
+func (s *snapshotter) run(ctx context.Context) {
+	ticker := time.NewTicker(snapshotCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, runtime := range s.runtimes {
+			if ctx.Err() != nil {
+				return
+			}
+			s.ensure(ctx, runtime)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ensure replaces a runtime's snapshot if it is missing or outdated
+func (s *snapshotter) ensure(ctx context.Context, runtime string) {
+	key, err := s.key(runtime)
+	if err != nil {
+		fmt.Printf("Warning: cannot snapshot %s: %v\n", runtime, err)
+		return
+	}
+
+	s.mu.Lock()
+	current := s.snapshots[runtime]
+	retryAt := s.retryAt[runtime]
+	s.mu.Unlock()
+
+	if current != nil && current.Key == key {
+		return
+	}
+	if current != nil {
+		s.drop(current)
+	}
+
+	// VMs restored from an old snapshot keep running: their memory file
+	// stays mapped and their disk is a copy
+	s.prune(runtime, "")
+	if time.Now().Before(retryAt) {
+		return
+	}
+
+	start := time.Now()
+	snapshot, err := s.build(ctx, runtime, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.retryAt[runtime] = time.Now().Add(snapshotRetryDelay)
+		fmt.Printf("Warning: failed to snapshot %s, retrying in %v: %v\n", runtime, snapshotRetryDelay, err)
+		return
+	}
+	delete(s.retryAt, runtime)
+	s.snapshots[runtime] = snapshot
+	fmt.Printf("Snapshot of %s ready after %v\n", runtime, time.Since(start).Round(time.Millisecond))
+}
+
+// build boots a template VM for a runtime, waits for its agent and saves
+// its memory and disk
+func (s *snapshotter) build(ctx context.Context, runtime, key string) (snapshot *Snapshot, err error) {
+	m := s.manager
+
+	snapshot = &Snapshot{
+		Runtime:  runtime,
+		Key:      key,
+		MemoryMB: PoolVMMemoryMB,
+		VCPUs:    snapshotVCPUs,
+		GuestIP:  s.lease.GuestIP.String(),
+		dir:      filepath.Join(s.dir, runtime, key),
+	}
+	if err := os.MkdirAll(snapshot.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", snapshot.dir, err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(snapshot.dir)
+		}
+	}()
+
+	// Like pool refills, templates only use capacity that is free
+	config := VMConfig{
+		ID:       uuid.New().String(),
+		Runtime:  runtime,
+		MemoryMB: snapshot.MemoryMB,
+		VCPUs:    snapshot.VCPUs,
+	}
+	if err := m.capacity.reserve(ctx, config.MemoryMB, config.VCPUs, false); err != nil {
+		return nil, err
+	}
+	vm, err := m.bootVM(ctx, config, s.lease)
+	if err != nil {
+		m.capacity.release(config.MemoryMB, config.VCPUs)
+		return nil, err
+	}
+	defer m.StopVM(vm.ID)
+	vm.device = tapName(vm.ID)
+
+	if err := waitForAgent(ctx, vm, snapshotAgentTimeout); err != nil {
+		return nil, err
+	}
+
+	// Pause the guest so memory and disk are saved in the same state.
+	// Restores open the drive recorded in the snapshot before they move to
+	// a copy of their own, so it has to be the snapshot's disk.
+	if err := m.apiCall(vm.SocketPath, "PATCH", "/vm", map[string]interface{}{"state": "Paused"}); err != nil {
+		return nil, fmt.Errorf("failed to pause VM: %w", err)
+	}
+	overlayPath := filepath.Join(m.config.DataDir, "vms", vm.ID, "rootfs.ext4")
+	if err := copyDisk(overlayPath, snapshot.path(snapshotDiskFile)); err != nil {
+		return nil, err
+	}
+	if err := m.setRootDrive(vm, snapshot.path(snapshotDiskFile)); err != nil {
+		return nil, err
+	}
+
+	create := map[string]interface{}{
+		"snapshot_type": "Full",
+		"snapshot_path": snapshot.path(snapshotStateFile),
+		"mem_file_path": snapshot.path(snapshotMemoryFile),
+	}
+	if err := m.apiCall(vm.SocketPath, "PUT", "/snapshot/create", create); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	// The metadata marks the snapshot complete, so it is written last
+	snapshot.CreatedAt = time.Now()
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(snapshot.path(snapshotMetaFile), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot metadata: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// restoreVM starts a VM from a snapshot instead of booting it. The guest
+// resumes with the address baked into the snapshot, so every restored VM
+// gets a TAP device of its own carrying the shared subnet, and requests to
+// the guest are bound to that device. Every clone also resumes with the
+// template's RNG state, so the guest is reseeded before it is handed out.
+func (m *Manager) restoreVM(ctx context.Context, config VMConfig, snapshot *Snapshot) (*VM, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vm := &VM{
+		ID:         config.ID,
+		Config:     config,
+		SocketPath: filepath.Join(m.config.DataDir, "sockets", config.ID+".sock"),
+		State:      VMStateCreating,
+		CreatedAt:  time.Now(),
+		device:     tapName(config.ID),
+		transport:  tcpTransport{},
+	}
+	vmDir := filepath.Join(m.config.DataDir, "vms", vm.ID)
+
+	logFile, err := m.startFirecracker(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave nothing behind, the VM is booted with the same ID next
+	fail := func(err error) (*VM, error) {
+		vm.Process.Process.Kill()
+		vm.Process.Wait()
+		logFile.Close()
+		os.Remove(vm.SocketPath)
+		exec.Command("ip", "link", "del", vm.device).Run()
+		os.RemoveAll(vmDir)
+		return nil, err
+	}
+
+	if err := createTap(vm.device, m.snapshots.lease); err != nil {
+		return fail(err)
+	}
+	if err := os.MkdirAll(vmDir, 0755); err != nil {
+		return fail(err)
+	}
+	diskPath := filepath.Join(vmDir, "rootfs.ext4")
+	if err := copyDisk(snapshot.path(snapshotDiskFile), diskPath); err != nil {
+		return fail(err)
+	}
+
+	// Load the snapshot paused, move the guest to its own disk and TAP
+	// device, then let it run
+	load := map[string]interface{}{
+		"snapshot_path": snapshot.path(snapshotStateFile),
+		"mem_backend": map[string]interface{}{
+			"backend_type": "File",
+			"backend_path": snapshot.path(snapshotMemoryFile),
+		},
+		"resume_vm": false,
+		"network_overrides": []map[string]interface{}{
+			{"iface_id": "eth0", "host_dev_name": vm.device},
+		},
+	}
+	if err := m.apiCall(vm.SocketPath, "PUT", "/snapshot/load", load); err != nil {
+		return fail(fmt.Errorf("failed to load snapshot: %w", err))
+	}
+	if err := m.setRootDrive(vm, diskPath); err != nil {
+		return fail(err)
+	}
+	if err := m.apiCall(vm.SocketPath, "PATCH", "/vm", map[string]interface{}{"state": "Resumed"}); err != nil {
+		return fail(fmt.Errorf("failed to resume VM: %w", err))
+	}
+
+	vm.IPAddress = snapshot.GuestIP
+	if err := reseedGuest(ctx, vm); err != nil {
+		return fail(fmt.Errorf("failed to reseed guest: %w", err))
+	}
+	vm.State = VMStateRunning
+	m.vms[vm.ID] = vm
+
+	return vm, nil
+}
+
+// setRootDrive points a started VM's root drive at another file
+func (m *Manager) setRootDrive(vm *VM, path string) error {
+	drive := map[string]interface{}{
+		"drive_id":     "rootfs",
+		"path_on_host": path,
+	}
+	if err := m.apiCall(vm.SocketPath, "PATCH", "/drives/rootfs", drive); err != nil {
+		return fmt.Errorf("failed to set root drive: %w", err)
+	}
+	return nil
+}
+
+// reseedGuest hands a restored guest fresh entropy. The agent mixes it into
+// the kernel pool and reseeds its runtime's RNG. A runtime that cannot
+// reseed in place asks for a restart and exits, and is asked again once its
+// bootstrap started it anew. A guest that does not confirm the reseed must
+// not serve invocations, as it would share its random numbers with every
+// other clone of the snapshot.
+func reseedGuest(ctx context.Context, vm *VM) error {
+	ctx, cancel := context.WithTimeout(ctx, snapshotReseedTimeout)
+	defer cancel()
+
+	client := guestClient(vm, time.Second)
+	for {
+		restart, err := sendReseed(ctx, client)
+		if err == nil && !restart {
+			return nil
+		}
+		if errors.Is(err, errReseedRejected) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return fmt.Errorf("agent did not confirm reseed: %w", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// errReseedRejected ends a reseed whose agent answered with an error, such
+// as an agent without a reseed endpoint
+var errReseedRejected = errors.New("agent rejected reseed")
+
+// sendReseed sends one reseed request with fresh entropy and reports whether
+// the agent restarts to apply it
+func sendReseed(ctx context.Context, client *http.Client) (bool, error) {
+	entropy := make([]byte, reseedEntropyBytes)
+	if _, err := rand.Read(entropy); err != nil {
+		return false, err
+	}
+	body, err := json.Marshal(map[string]interface{}{"entropy": entropy})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", guestURL+"/reseed", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%w: status %d", errReseedRejected, resp.StatusCode)
+	}
+
+	var result struct {
+		Restart bool `json:"restart"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("invalid reseed response: %w", err)
+	}
+	return result.Restart, nil
+}
+
+// waitForAgent waits until the agent in a VM answers its health check
+func waitForAgent(ctx context.Context, vm *VM, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := guestClient(vm, time.Second)
+	url := guestURL + "/health"
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("agent did not become ready: %w", ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
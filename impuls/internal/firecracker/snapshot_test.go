@@ -0,0 +1,233 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestSnapshotter sets up fake images and a snapshotter for them
+func newTestSnapshotter(t *testing.T) *snapshotter {
+	t.Helper()
+	dir := t.TempDir()
+
+	config := Config{
+		FirecrackerBin: filepath.Join(dir, "firecracker"),
+		KernelPath:     filepath.Join(dir, "vmlinux"),
+		RootFSPath:     filepath.Join(dir, "rootfs.ext4"),
+		DataDir:        dir,
+	}
+	for _, path := range []string{config.FirecrackerBin, config.KernelPath, config.RootFSPath} {
+		if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ipam, err := NewIPAM("10.200.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSnapshotter(&Manager{config: config, ipam: ipam}, []string{"nodejs20", "python312"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// writeTestSnapshot stores a complete snapshot the way build leaves it
+func writeTestSnapshot(t *testing.T, s *snapshotter, runtime, key string) {
+	t.Helper()
+	dir := filepath.Join(s.dir, runtime, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(&Snapshot{
+		Runtime:  runtime,
+		Key:      key,
+		MemoryMB: PoolVMMemoryMB,
+		VCPUs:    snapshotVCPUs,
+		GuestIP:  s.lease.GuestIP.String(),
+	})
+	if err := os.WriteFile(filepath.Join(dir, snapshotMetaFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotKey(t *testing.T) {
+	s := newTestSnapshotter(t)
+
+	key, err := s.key("nodejs20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.key("nodejs20"); again != key {
+		t.Error("Expected the key to be stable")
+	}
+	if other, _ := s.key("python312"); other == key {
+		t.Error("Expected runtimes to have different keys")
+	}
+
+	// Replacing the rootfs invalidates the snapshot
+	if err := os.WriteFile(s.manager.config.RootFSPath, []byte("new image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.key("nodejs20"); changed == key {
+		t.Error("Expected a new key after the rootfs changed")
+	}
+
+	os.Remove(s.manager.config.KernelPath)
+	if _, err := s.key("nodejs20"); err == nil {
+		t.Error("Expected an error without a kernel")
+	}
+}
+
+func TestSnapshotLoad(t *testing.T) {
+	s := newTestSnapshotter(t)
+
+	key, _ := s.key("nodejs20")
+	writeTestSnapshot(t, s, "nodejs20", key)
+	writeTestSnapshot(t, s, "nodejs20", "0123456789abcdef") // taken with older images
+
+	// Interrupted while building: no metadata
+	if err := os.MkdirAll(filepath.Join(s.dir, "python312", "fedcba9876543210"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s.load()
+
+	snapshot := s.get(VMConfig{Runtime: "nodejs20", MemoryMB: PoolVMMemoryMB, VCPUs: 1})
+	if snapshot == nil || snapshot.Key != key {
+		t.Fatalf("Expected the current nodejs20 snapshot, got %+v", snapshot)
+	}
+	if snapshot.path(snapshotMemoryFile) != filepath.Join(s.dir, "nodejs20", key, "memory") {
+		t.Errorf("Unexpected memory file path %s", snapshot.path(snapshotMemoryFile))
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "nodejs20", "0123456789abcdef")); !os.IsNotExist(err) {
+		t.Error("Expected the outdated snapshot to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "python312", "fedcba9876543210")); !os.IsNotExist(err) {
+		t.Error("Expected the incomplete snapshot to be removed")
+	}
+
+	if s.get(VMConfig{Runtime: "python312", MemoryMB: PoolVMMemoryMB, VCPUs: 1}) != nil {
+		t.Error("Expected no python312 snapshot")
+	}
+	if s.get(VMConfig{Runtime: "nodejs20", MemoryMB: 256, VCPUs: 1}) != nil {
+		t.Error("Expected VMs of another size to boot")
+	}
+
+	// A changed image stops restores at once
+	if err := os.WriteFile(s.manager.config.KernelPath, []byte("new kernel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s.get(VMConfig{Runtime: "nodejs20", MemoryMB: PoolVMMemoryMB, VCPUs: 1}) != nil {
+		t.Error("Expected the snapshot to be invalidated by the new kernel")
+	}
+	if _, ok := s.snapshots["nodejs20"]; ok {
+		t.Error("Expected the invalidated snapshot to be dropped")
+	}
+}
+
+func TestSnapshotRestoreFailures(t *testing.T) {
+	s := newTestSnapshotter(t)
+
+	key, _ := s.key("nodejs20")
+	writeTestSnapshot(t, s, "nodejs20", key)
+	s.load()
+
+	config := VMConfig{Runtime: "nodejs20", MemoryMB: PoolVMMemoryMB, VCPUs: 1}
+	snapshot := s.get(config)
+
+	// Only failures in a row count
+	for i := 0; i < maxRestoreFailures-1; i++ {
+		s.restoreFailed(snapshot)
+	}
+	s.restored(snapshot)
+	for i := 0; i < maxRestoreFailures-1; i++ {
+		s.restoreFailed(snapshot)
+	}
+	if s.get(config) == nil {
+		t.Fatal("Expected the snapshot to still be used")
+	}
+
+	s.restoreFailed(snapshot)
+	if s.get(config) != nil {
+		t.Error("Expected the snapshot to be given up")
+	}
+	if s.retryAt["nodejs20"].IsZero() {
+		t.Error("Expected a rebuild to be scheduled")
+	}
+}
+
+// TestReseedGuest reseeds a fake agent that, like the Node.js runtime,
+// restarts once to apply the entropy
+func TestReseedGuest(t *testing.T) {
+	var mu sync.Mutex
+	var entropy [][]byte
+	restarted := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/reseed" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Entropy []byte `json:"entropy"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		mu.Lock()
+		defer mu.Unlock()
+		entropy = append(entropy, req.Entropy)
+		json.NewEncoder(w).Encode(map[string]bool{"restart": !restarted})
+		restarted = true
+	})
+	vm := &VM{
+		ID:        "restored-vm",
+		VsockPath: startFakeGuest(t, handler, "OK 1073741824\n"),
+		transport: vsockTransport{},
+	}
+
+	if err := reseedGuest(context.Background(), vm); err != nil {
+		t.Fatalf("Failed to reseed: %v", err)
+	}
+
+	if len(entropy) != 2 {
+		t.Fatalf("Expected the agent to be asked again after its restart, got %d requests", len(entropy))
+	}
+	for _, e := range entropy {
+		if len(e) != reseedEntropyBytes {
+			t.Errorf("Expected %d bytes of entropy, got %d", reseedEntropyBytes, len(e))
+		}
+	}
+	if bytes.Equal(entropy[0], entropy[1]) {
+		t.Error("Expected every reseed to send fresh entropy")
+	}
+}
+
+func TestReseedGuestRejected(t *testing.T) {
+	// An agent without a reseed endpoint
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	vm := &VM{
+		ID:        "restored-vm",
+		VsockPath: startFakeGuest(t, handler, "OK 1073741824\n"),
+		transport: vsockTransport{},
+	}
+
+	if err := reseedGuest(context.Background(), vm); err == nil {
+		t.Fatal("Expected a guest that does not reseed to fail its restore")
+	}
+}
+
+func TestNilSnapshotter(t *testing.T) {
+	var s *snapshotter
+	if s.get(VMConfig{Runtime: "nodejs20", MemoryMB: PoolVMMemoryMB, VCPUs: 1}) != nil {
+		t.Error("Expected VMs to boot without snapshots")
+	}
+}
@@ -0,0 +1,113 @@
+package firecracker
+
+import (
+	"time"
+)
+
+// VM statuses reported to operators
+const (
+	VMStatusStarting = "starting" // Booting for the pool, or a snapshot template
+	VMStatusPooled   = "pooled"   // Waiting in the pool
+	VMStatusServing  = "serving"  // Handed out for an invocation
+)
+
+// VMInfo describes a VM for operators
+type VMInfo struct {
+	ID         string    `json:"id"`
+	Function   string    `json:"function,omitempty"` // Empty for clean pool VMs
+	Runtime    string    `json:"runtime"`
+	State      VMState   `json:"state"`
+	Status     string    `json:"status"`
+	Draining   bool      `json:"draining,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"` // Empty for VMs reached over vsock only
+	MemoryMB   int       `json:"memory_mb"`
+	VCPUs      int       `json:"vcpus"`
+	CreatedAt  time.Time `json:"created_at"`
+	AgeSec     int64     `json:"age_sec"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// Info returns a snapshot of the VM's state
+func (vm *VM) Info() VMInfo {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	info := VMInfo{
+		ID:         vm.ID,
+		Function:   vm.Config.FunctionName,
+		Runtime:    vm.Config.Runtime,
+		State:      vm.State,
+		Draining:   vm.draining,
+		IPAddress:  vm.IPAddress,
+		MemoryMB:   vm.Config.MemoryMB,
+		VCPUs:      vm.Config.VCPUs,
+		CreatedAt:  vm.CreatedAt,
+		AgeSec:     int64(time.Since(vm.CreatedAt) / time.Second),
+		LastUsedAt: vm.LastUsedAt,
+	}
+	switch {
+	case vm.pooled:
+		info.Status = VMStatusPooled
+	case vm.Config.FunctionName != "":
+		info.Status = VMStatusServing
+	default:
+		info.Status = VMStatusStarting
+	}
+	return info
+}
+
+// takeFromPool claims a VM received from a pool channel. It fails for VMs
+// that were stopped or drained while they waited.
+func (vm *VM) takeFromPool(functionName string) bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.State == VMStateStopped || vm.draining {
+		return false
+	}
+	vm.pooled = false
+	vm.Config.FunctionName = functionName
+	return true
+}
+
+// setPooled marks a VM as waiting in the pool
+func (vm *VM) setPooled() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	vm.pooled = true
+}
+
+// isRetired reports whether a pooled VM must not be handed out again
+func (vm *VM) isRetired() bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	return vm.State == VMStateStopped || vm.draining
+}
+
+// DrainFunction retires every VM that served a function. Idle pooled VMs
+// are stopped at once; VMs still serving an invocation are stopped when
+// they are returned instead of going back to the pool.
+func (m *Manager) DrainFunction(functionName string) (stopped, draining int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, vm := range m.vms {
+		vm.mu.Lock()
+		if vm.Config.FunctionName != functionName {
+			vm.mu.Unlock()
+			continue
+		}
+		vm.draining = true
+		idle := vm.pooled
+		vm.mu.Unlock()
+
+		if idle {
+			delete(m.vms, id)
+			m.stopVM(vm)
+			stopped++
+		} else {
+			draining++
+		}
+	}
+	return stopped, draining
+}
@@ -0,0 +1,180 @@
+package firecracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a manager whose VMs are added by the test and have
+// no firecracker process
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	ipam, err := NewIPAM("10.200.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{
+		config:   Config{DataDir: t.TempDir()},
+		vms:      make(map[string]*VM),
+		capacity: newCapacity(0, 0, 0),
+		ipam:     ipam,
+	}
+}
+
+// addTestVM registers a fake running VM
+func addTestVM(m *Manager, id, functionName string) *VM {
+	vm := &VM{
+		ID:        id,
+		Config:    VMConfig{ID: id, FunctionName: functionName, Runtime: "nodejs20", MemoryMB: PoolVMMemoryMB, VCPUs: 1},
+		State:     VMStateRunning,
+		CreatedAt: time.Now().Add(-time.Minute),
+	}
+	m.capacity.commit(vm.Config.MemoryMB, vm.Config.VCPUs)
+	m.vms[id] = vm
+	return vm
+}
+
+func TestVMInfo(t *testing.T) {
+	m := newTestManager(t)
+
+	starting := addTestVM(m, "vm-starting-1", "")
+	if info := starting.Info(); info.Status != VMStatusStarting || info.AgeSec < 60 {
+		t.Errorf("Unexpected info for a booting VM: %+v", info)
+	}
+
+	serving := addTestVM(m, "vm-serving-1", "hello")
+	serving.IPAddress = "172.16.0.2"
+	info := serving.Info()
+	if info.Status != VMStatusServing || info.Function != "hello" || info.IPAddress != "172.16.0.2" || info.MemoryMB != PoolVMMemoryMB {
+		t.Errorf("Unexpected info for a serving VM: %+v", info)
+	}
+
+	serving.setPooled()
+	if info := serving.Info(); info.Status != VMStatusPooled {
+		t.Errorf("Expected a pooled VM, got %s", info.Status)
+	}
+}
+
+func TestDrainFunction(t *testing.T) {
+	m := newTestManager(t)
+	pool := NewVMPool(m, 0, nil)
+
+	idle := addTestVM(m, "vm-idle-1", "hello")
+	pool.ReturnVM(idle, true)
+	busy := addTestVM(m, "vm-busy-1", "hello")
+	other := addTestVM(m, "vm-other-1", "world")
+	pool.ReturnVM(other, true)
+
+	stopped, draining := m.DrainFunction("hello")
+	if stopped != 1 || draining != 1 {
+		t.Fatalf("Expected 1 stopped and 1 draining VM, got %d and %d", stopped, draining)
+	}
+	if idle.State != VMStateStopped {
+		t.Error("Expected the idle VM to be stopped")
+	}
+	if busy.State != VMStateRunning || !busy.Info().Draining {
+		t.Error("Expected the busy VM to keep running until it is returned")
+	}
+
+	// The stopped VM is never handed out again
+	vm, err := takeIdle(pool.warm[warmKey{function: "hello", runtime: "nodejs20"}], "hello")
+	if err != nil || vm != nil {
+		t.Errorf("Expected no idle VM for hello, got %v, %v", vm, err)
+	}
+
+	// The busy VM is stopped instead of pooled when it is done
+	pool.ReturnVM(busy, true)
+	if busy.State != VMStateStopped {
+		t.Error("Expected the drained VM to be stopped when returned")
+	}
+	if _, err := m.GetVM("vm-busy-1"); err == nil {
+		t.Error("Expected the drained VM to be removed")
+	}
+
+	// Other functions are left alone
+	if vm, _ := takeIdle(pool.warm[warmKey{function: "world", runtime: "nodejs20"}], "world"); vm != other {
+		t.Error("Expected the other function's VM to stay pooled")
+	}
+	if m.Utilization().VMs != 1 {
+		t.Errorf("Expected 1 VM left, got %d", m.Utilization().VMs)
+	}
+}
+
+func TestEvictStoppedPoolVMs(t *testing.T) {
+	m := newTestManager(t)
+	pool := NewVMPool(m, 0, nil)
+
+	vm := addTestVM(m, "vm-clean-1", "")
+	vm.setPooled()
+	pool.pools["nodejs20"] <- vm
+
+	// An operator stops the VM while it waits
+	if err := m.StopVM(vm.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	pool.evictIdle()
+	if len(pool.pools["nodejs20"]) != 0 {
+		t.Error("Expected the stopped VM to leave the pool so it can be refilled")
+	}
+}
+
+func TestEvictIdleFunctionVMs(t *testing.T) {
+	m := newTestManager(t)
+	pool := NewVMPool(m, 2, nil)
+	pool.idleTimeout = time.Minute
+
+	recent := addTestVM(m, "vm-recent-1", "hello")
+	pool.ReturnVM(recent, true)
+	idle := addTestVM(m, "vm-idleold-1", "hello")
+	pool.ReturnVM(idle, true)
+	idle.mu.Lock()
+	idle.LastUsedAt = time.Now().Add(-time.Hour)
+	idle.mu.Unlock()
+
+	// An invocation holds the recent VM while the pool is swept
+	vm, _ := takeIdle(pool.warm[warmKey{function: "hello", runtime: "nodejs20"}], "hello")
+	if vm != recent {
+		t.Fatalf("Expected the recently used VM, got %v", vm)
+	}
+
+	pool.evictIdle()
+	if idle.State != VMStateStopped {
+		t.Error("Expected the idle VM to be stopped")
+	}
+	if _, ok := pool.warm[warmKey{function: "hello", runtime: "nodejs20"}]; ok {
+		t.Error("Expected the emptied pool to be dropped")
+	}
+	if recent.State == VMStateStopped {
+		t.Error("Expected the VM in use to be left alone")
+	}
+}
+
+func TestWarmVMsKeepTheirRuntime(t *testing.T) {
+	m := newTestManager(t)
+	pool := NewVMPool(m, 1, nil)
+
+	node := addTestVM(m, "vm-nodejs-1", "hello")
+	pool.ReturnVM(node, true)
+	python := addTestVM(m, "vm-python-1", "")
+	python.Config.Runtime = "python312"
+	python.setPooled()
+	pool.pools["python312"] <- python
+
+	// After hello moved to python, the VM booted with node is not reused
+	vm, err := pool.GetVM(context.Background(), "python312", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm != python {
+		t.Errorf("Expected a clean python VM, got %s", vm.ID)
+	}
+	vm, err = pool.GetVM(context.Background(), "nodejs20", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm != node {
+		t.Errorf("Expected the warm node VM, got %s", vm.ID)
+	}
+}
@@ -0,0 +1,138 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Guest transports
+const (
+	TransportTCP   = "tcp"
+	TransportVsock = "vsock"
+)
+
+const (
+	// guestAgentPort is where the agent in every VM listens, on TCP and on
+	// vsock
+	guestAgentPort = 8080
+
+	// guestCID is the guest's vsock context ID. Firecracker backs each
+	// vsock device with a Unix socket of its own, so all VMs can share it.
+	guestCID = 3
+
+	// guestURL is the base URL of every agent. The transport decides which
+	// VM a request goes to.
+	guestURL = "http://guest"
+)
+
+// GuestTransport opens connections from the host to the agent in a VM.
+// Invocation requests, responses and log frames are HTTP over these
+// connections whichever transport carries them.
+type GuestTransport interface {
+	Dial(ctx context.Context, vm *VM) (net.Conn, error)
+}
+
+// tcpTransport reaches the agent through the VM's TAP device
+type tcpTransport struct{}
+
+func (tcpTransport) Dial(ctx context.Context, vm *VM) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if vm.device != "" {
+		// Several TAP devices carry the guest's subnet, so the route alone
+		// does not pick the right one
+		dialer.Control = bindToDevice(vm.device)
+	}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(vm.IPAddress, strconv.Itoa(guestAgentPort)))
+}
+
+// vsockTransport reaches the agent through the VM's virtio-vsock device,
+// which Firecracker exposes on the host as a Unix socket. It needs no
+// network in the guest.
+type vsockTransport struct{}
+
+func (vsockTransport) Dial(ctx context.Context, vm *VM) (net.Conn, error) {
+	if vm.VsockPath == "" {
+		return nil, fmt.Errorf("VM %s has no vsock device", vm.ID)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", vm.VsockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to vsock of VM %s: %w", vm.ID, err)
+	}
+	if err := vsockConnect(ctx, conn, guestAgentPort); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// vsockConnect asks Firecracker to forward conn to a port in the guest
+func vsockConnect(ctx context.Context, conn net.Conn, port int) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		return fmt.Errorf("vsock handshake failed: %w", err)
+	}
+
+	// The reply is "OK <host port>". Read it a byte at a time, anything
+	// after it comes from the guest.
+	var reply []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return fmt.Errorf("vsock handshake failed: %w", err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if len(reply) >= 64 {
+			return fmt.Errorf("vsock handshake failed: reply too long")
+		}
+		reply = append(reply, b[0])
+	}
+	if !strings.HasPrefix(string(reply), "OK ") {
+		return fmt.Errorf("vsock handshake failed: %q", reply)
+	}
+	return nil
+}
+
+// guestClient returns an HTTP client for the agent in a VM
+func guestClient(vm *VM, timeout time.Duration) *http.Client {
+	transport := vm.transport
+	if transport == nil {
+		transport = tcpTransport{}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return transport.Dial(ctx, vm)
+			},
+			DisableKeepAlives: true,
+		},
+		Timeout: timeout,
+	}
+}
+
+// configureVsock adds the vsock device the host reaches the agent through
+func (m *Manager) configureVsock(vm *VM) error {
+	os.Remove(vm.VsockPath)
+
+	vsock := map[string]interface{}{
+		"guest_cid": guestCID,
+		"uds_path":  vm.VsockPath,
+	}
+	return m.apiCall(vm.SocketPath, "PUT", "/vsock", vsock)
+}
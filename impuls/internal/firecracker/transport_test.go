@@ -0,0 +1,217 @@
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connListener hands connections accepted elsewhere to an http.Server
+type connListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "guest", Net: "unix"}
+}
+
+// startFakeGuest serves handler the way an agent behind Firecracker's vsock
+// device would: the host connects to a Unix socket and asks for a guest
+// port first. reply is what the fake Firecracker answers to a connect to
+// port 8080. It returns the socket path.
+func startFakeGuest(t *testing.T, handler http.Handler, reply string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "vm.vsock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest := &connListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	server := &http.Server{Handler: handler}
+	go server.Serve(guest)
+	t.Cleanup(func() {
+		listener.Close()
+		server.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				line, err := bufio.NewReader(io.LimitReader(conn, 32)).ReadString('\n')
+				if err != nil || line != fmt.Sprintf("CONNECT %d\n", guestAgentPort) {
+					conn.Close()
+					return
+				}
+				if _, err := io.WriteString(conn, reply); err != nil || !strings.HasPrefix(reply, "OK ") {
+					conn.Close()
+					return
+				}
+				guest.conns <- conn
+			}()
+		}
+	}()
+
+	return path
+}
+
+func TestVsockExecuteFunction(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/invoke" {
+			http.NotFound(w, r)
+			return
+		}
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		w.Header().Set("Content-Type", ndjsonContentType)
+		fmt.Fprintln(w, `{"type":"log","stream":"stdout","line":"hello from the guest"}`)
+		fmt.Fprintln(w, `{"type":"chunk","data":"Y2h1bmsgMQ=="}`)
+		fmt.Fprintln(w, `{"type":"chunk","data":"AP8="}`)
+		fmt.Fprintf(w, `{"type":"result","result":{"statusCode":200,"body":%q}}`+"\n", payload["event"])
+	})
+
+	vm := &VM{
+		ID:        "vsock-vm",
+		VsockPath: startFakeGuest(t, handler, "OK 1073741824\n"),
+		transport: vsockTransport{},
+	}
+
+	var logs []string
+	var chunks [][]byte
+	m := &Manager{}
+	result, err := m.ExecuteFunction(context.Background(), vm, []byte(`{"event":"ping"}`), func(stream, line string) {
+		logs = append(logs, stream+": "+line)
+	}, func(data []byte) {
+		chunks = append(chunks, data)
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute over vsock: %v", err)
+	}
+
+	if len(logs) != 1 || logs[0] != "stdout: hello from the guest" {
+		t.Errorf("Unexpected logs: %v", logs)
+	}
+	if len(chunks) != 2 || string(chunks[0]) != "chunk 1" || string(chunks[1]) != "\x00\xff" {
+		t.Errorf("Unexpected chunks: %q", chunks)
+	}
+	var response struct {
+		StatusCode int    `json:"statusCode"`
+		Body       string `json:"body"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		t.Fatalf("Invalid result %s: %v", result, err)
+	}
+	if response.StatusCode != 200 || response.Body != "ping" {
+		t.Errorf("Unexpected result: %s", result)
+	}
+
+	// Every invocation opens a new connection
+	if _, err := m.ExecuteFunction(context.Background(), vm, []byte(`{"event":"again"}`), nil, nil); err != nil {
+		t.Fatalf("Failed to execute a second time: %v", err)
+	}
+}
+
+func TestExecuteFunctionDeadline(t *testing.T) {
+	defer func(timeout time.Duration) { defaultExecuteTimeout = timeout }(defaultExecuteTimeout)
+	defaultExecuteTimeout = 200 * time.Millisecond
+
+	// The guest streams for longer than the default timeout
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		for i := 0; i < 5; i++ {
+			fmt.Fprintln(w, `{"type":"chunk","data":"AA=="}`)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprintln(w, `{"type":"result","result":{"statusCode":200}}`)
+	})
+	vm := &VM{
+		ID:        "vsock-vm",
+		VsockPath: startFakeGuest(t, handler, "OK 1073741824\n"),
+		transport: vsockTransport{},
+	}
+
+	// The function's timeout applies instead of the default
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	chunks := 0
+	var recorded time.Time
+	if _, err := (&Manager{}).ExecuteFunction(ctx, vm, []byte(`{}`), nil, func(data []byte) {
+		if chunks++; chunks == 1 {
+			vm.mu.Lock()
+			recorded = vm.deadline
+			vm.mu.Unlock()
+		}
+	}); err != nil {
+		t.Fatalf("Expected the stream to outlast the default timeout, got %v", err)
+	}
+	if chunks != 5 {
+		t.Errorf("Expected 5 chunks, got %d", chunks)
+	}
+
+	// The reaper sees the deadline while the invocation runs
+	if deadline, _ := ctx.Deadline(); !recorded.Equal(deadline) || !vm.deadline.IsZero() {
+		t.Errorf("Expected the VM to carry the deadline %v only while running, got %v and %v", deadline, recorded, vm.deadline)
+	}
+
+	// Without a deadline the default applies
+	if _, err := (&Manager{}).ExecuteFunction(context.Background(), vm, []byte(`{}`), nil, nil); err == nil {
+		t.Error("Expected the default timeout to end the stream")
+	}
+}
+
+func TestVsockHandshakeRejected(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Request reached the guest")
+	})
+
+	// Firecracker closes the connection if nothing listens on the port
+	vm := &VM{
+		ID:        "vsock-vm",
+		VsockPath: startFakeGuest(t, handler, ""),
+		transport: vsockTransport{},
+	}
+	if _, err := (&Manager{}).ExecuteFunction(context.Background(), vm, []byte(`{}`), nil, nil); err == nil {
+		t.Error("Expected the invocation to fail")
+	}
+
+	// A VM without a vsock device cannot be reached over vsock
+	if _, err := (vsockTransport{}).Dial(context.Background(), &VM{ID: "tcp-vm"}); err == nil {
+		t.Error("Expected an error without a vsock device")
+	}
+}
@@ -0,0 +1,123 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// SetRetryPolicy sets the retry policy given to new asynchronous invocations
+func (m *Manager) SetRetryPolicy(policy models.RetryPolicy) {
+	m.retryPolicy = policy
+}
+
+// InvokeAsync queues an invocation to be run in the background by the queue
+// worker and returns it immediately
+func (m *Manager) InvokeAsync(name, qualifier string, payload json.RawMessage) (*models.AsyncInvocation, error) {
+	return m.EnqueueInvocation(name, qualifier, payload, time.Now())
+}
+
+// EnqueueInvocation queues an invocation that becomes due at the given time
+func (m *Manager) EnqueueInvocation(name, qualifier string, payload json.RawMessage, at time.Time) (*models.AsyncInvocation, error) {
+	// Resolve up front so unknown functions and qualifiers fail the request
+	// instead of ending up in the dead-letter list
+	if _, err := m.resolve(name, qualifier); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	inv := &models.AsyncInvocation{
+		ID:            uuid.New().String(),
+		FunctionName:  name,
+		Qualifier:     qualifier,
+		Payload:       payload,
+		Status:        models.AsyncStatusQueued,
+		MaxAttempts:   m.retryPolicy.MaxAttempts,
+		NextAttemptAt: at,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := m.storage.EnqueueAsyncInvocation(inv); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, &models.NotFoundError{Resource: "function", Name: name}
+		}
+		return nil, fmt.Errorf("failed to enqueue invocation: %w", err)
+	}
+
+	return inv, nil
+}
+
+// GetAsyncInvocation returns the state of an asynchronous invocation
+func (m *Manager) GetAsyncInvocation(name, id string) (*models.AsyncInvocation, error) {
+	inv, err := m.storage.GetAsyncInvocation(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrAsyncInvocationNotFound) {
+			return nil, &models.NotFoundError{Resource: "invocation", Name: id}
+		}
+		return nil, err
+	}
+	if inv.FunctionName != name {
+		return nil, &models.NotFoundError{Resource: "invocation", Name: id}
+	}
+	return inv, nil
+}
+
+// ListDeadLetters returns the invocations of a function that exhausted
+// their retries
+func (m *Manager) ListDeadLetters(name string) ([]*models.AsyncInvocation, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+	return m.storage.ListAsyncInvocations(name, models.AsyncStatusDeadLetter)
+}
+
+// getDeadLetter returns a dead-lettered invocation of a function
+func (m *Manager) getDeadLetter(name, id string) (*models.AsyncInvocation, error) {
+	inv, err := m.GetAsyncInvocation(name, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != models.AsyncStatusDeadLetter {
+		return nil, &models.ConflictError{Message: fmt.Sprintf("invocation %s is %s, not dead-lettered", id, inv.Status)}
+	}
+	return inv, nil
+}
+
+// ReplayDeadLetter queues a dead-lettered invocation again with a fresh set
+// of attempts
+func (m *Manager) ReplayDeadLetter(name, id string) (*models.AsyncInvocation, error) {
+	inv, err := m.getDeadLetter(name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	inv.Status = models.AsyncStatusQueued
+	inv.Attempts = 0
+	inv.MaxAttempts = m.retryPolicy.MaxAttempts
+	inv.LastError = ""
+	inv.Result = nil
+	inv.NextAttemptAt = now
+	inv.UpdatedAt = now
+	inv.CompletedAt = nil
+
+	if err := m.storage.UpdateAsyncInvocation(inv); err != nil {
+		return nil, fmt.Errorf("failed to replay invocation: %w", err)
+	}
+
+	return inv, nil
+}
+
+// DeleteDeadLetter discards a dead-lettered invocation
+func (m *Manager) DeleteDeadLetter(name, id string) error {
+	if _, err := m.getDeadLetter(name, id); err != nil {
+		return err
+	}
+	return m.storage.DeleteAsyncInvocation(id)
+}
@@ -0,0 +1,481 @@
+package function
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+const (
+	// DefaultBuildTimeout is how long a dependency build may run
+	DefaultBuildTimeout = 5 * time.Minute
+
+	// buildRetryAfter is how long callers are asked to wait for a pending
+	// dependency build
+	buildRetryAfter = 10 * time.Second
+
+	// maxBuildLog is how much of the end of a build's output is kept
+	maxBuildLog = 64 << 10
+)
+
+// dependencyFiles are the files at the root of a package that declare its
+// dependencies, per runtime language. The first one triggers a build.
+var dependencyFiles = map[string][]string{
+	"nodejs": {"package.json", "package-lock.json"},
+	"python": {"requirements.txt"},
+}
+
+// builder installs package dependencies into a cache directory. Each build
+// is an artifact named by the hash of the dependency files, holding a tree
+// that is laid over the package: node_modules for Node.js, the installed
+// packages for Python. .NET and Go functions are compiled into the same
+// cache, the artifact holding the built assembly or binary.
+type builder struct {
+	dir     string
+	timeout time.Duration
+
+	mu      sync.Mutex
+	running map[string]*buildRun // by dependencies hash
+}
+
+// buildRun is a build in progress that later requests for the same
+// dependencies wait for
+type buildRun struct {
+	done  chan struct{}
+	build *models.FunctionBuild
+}
+
+// buildJob is a build planned for a function
+type buildJob struct {
+	hash     string
+	language string
+	files    map[string][]byte // the dependency files
+	target   *invocationTarget // the code to compile, for .NET and Go
+}
+
+// SetBuildCache enables dependency builds and compiling .NET and Go
+// functions when they are created or updated. Artifacts are kept in dir and
+// a build fails if it takes longer than timeout.
+func (m *Manager) SetBuildCache(dir string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultBuildTimeout
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create build cache: %w", err)
+	}
+
+	// Builds interrupted by a restart leave their working directories
+	leftovers, _ := filepath.Glob(filepath.Join(dir, ".build-*"))
+	for _, path := range leftovers {
+		os.RemoveAll(path)
+	}
+
+	m.builds = &builder{dir: dir, timeout: timeout, running: make(map[string]*buildRun)}
+	return nil
+}
+
+// planBuild sets the build of a function whose code changed. Functions
+// without dependency files have none; dependencies built before are ready
+// at once. Otherwise the build is pending and the returned job must be run
+// once the function is stored.
+func (m *Manager) planBuild(fn *models.Function, code []byte) (*buildJob, error) {
+	fn.Build = nil
+	if !fn.CodeFormat.IsArchive() {
+		return nil, nil
+	}
+
+	language := models.GetRuntimeLanguage(fn.Runtime)
+	names := dependencyFiles[language]
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	files := make(map[string][]byte)
+	err := walkPackage(fn.CodeFormat, code, func(name string, mode os.FileMode, r io.Reader) error {
+		for _, dependencyFile := range names {
+			if name == dependencyFile {
+				data, err := io.ReadAll(r)
+				files[name] = data
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &models.ValidationError{Field: "code", Message: err.Error()}
+	}
+	if _, ok := files[names[0]]; !ok {
+		return nil, nil
+	}
+
+	now := time.Now()
+	job := &buildJob{hash: dependenciesHash(fn.Runtime, names, files), language: language, files: files}
+	fn.Build = &models.FunctionBuild{Status: models.BuildPending, DependenciesHash: job.hash, StartedAt: now}
+
+	switch {
+	case m.builds == nil:
+		fn.Build.Status = models.BuildFailed
+		fn.Build.Error = "dependency builds are not enabled on this server"
+		fn.Build.FinishedAt = &now
+	case m.builds.has(job.hash):
+		fn.Build.Status = models.BuildReady
+		fn.Build.Log = "dependencies are cached"
+		fn.Build.FinishedAt = &now
+	default:
+		return job, nil
+	}
+	return nil, nil
+}
+
+// runBuild runs a planned build and records its outcome, unless the
+// function was deleted or its code changed meanwhile
+func (m *Manager) runBuild(name string, job *buildJob) {
+	build := m.builds.build(job)
+
+	fn, err := m.storage.Get(name)
+	if err != nil {
+		return
+	}
+	if fn.Build == nil || fn.Build.Status != models.BuildPending || fn.Build.DependenciesHash != job.hash {
+		return
+	}
+
+	// The stored function is shared with readers, so the outcome is
+	// recorded on a copy
+	build.StartedAt = fn.Build.StartedAt
+	updated := *fn
+	updated.Build = build
+	if err := m.storage.Update(&updated); err != nil {
+		fmt.Printf("Warning: failed to record the dependency build of function %s: %v\n", name, err)
+	}
+}
+
+// dependencies returns the directory of a function's installed
+// dependencies, empty if it has none. Functions whose build is pending or
+// failed cannot be invoked.
+func (m *Manager) dependencies(fn *models.Function) (string, error) {
+	build := fn.Build
+	if build == nil {
+		return "", nil
+	}
+
+	switch build.Status {
+	case models.BuildFailed:
+		return "", &models.ConflictError{Message: fmt.Sprintf("dependency build of function %s failed: %s", fn.Name, build.Error)}
+	case models.BuildPending:
+		// Builds are started right after the function is stored
+		if m.builds != nil && (m.builds.isRunning(build.DependenciesHash) || time.Since(build.StartedAt) < m.builds.timeout) {
+			return "", &models.UnavailableError{
+				Message:    fmt.Sprintf("dependencies of function %s are still being installed", fn.Name),
+				RetryAfter: buildRetryAfter,
+			}
+		}
+	}
+
+	// A pending build that is not running was interrupted by a restart,
+	// but another function may have built the same dependencies since
+	if m.builds == nil || !m.builds.has(build.DependenciesHash) {
+		return "", &models.ConflictError{Message: fmt.Sprintf("dependencies of function %s are not installed, update its code to rebuild them", fn.Name)}
+	}
+	return m.builds.artifact(build.DependenciesHash), nil
+}
+
+// dependenciesHash identifies a set of dependency files. The runtime is
+// part of it since installed packages may depend on the interpreter version.
+func dependenciesHash(runtime models.Runtime, names []string, files map[string][]byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", runtime)
+	for _, name := range names {
+		if data, ok := files[name]; ok {
+			fmt.Fprintf(h, "%s %d\n", name, len(data))
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// artifact returns the directory of a build artifact
+func (b *builder) artifact(hash string) string {
+	return filepath.Join(b.dir, hash)
+}
+
+// has reports whether the dependencies with the given hash were built
+func (b *builder) has(hash string) bool {
+	info, err := os.Stat(b.artifact(hash))
+	return err == nil && info.IsDir()
+}
+
+// isRunning reports whether the dependencies with the given hash are being
+// built
+func (b *builder) isRunning(hash string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.running[hash]
+	return ok
+}
+
+// build runs a job, or waits for the running build of the same
+// dependencies, and returns a copy of its outcome
+func (b *builder) build(job *buildJob) *models.FunctionBuild {
+	b.mu.Lock()
+	run, ok := b.running[job.hash]
+	if !ok {
+		run = &buildRun{done: make(chan struct{})}
+		b.running[job.hash] = run
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		run.build = b.install(job)
+
+		b.mu.Lock()
+		delete(b.running, job.hash)
+		b.mu.Unlock()
+		close(run.done)
+	}
+
+	<-run.done
+	build := *run.build
+	return &build
+}
+
+// install runs the package manager for a job in a scratch directory and
+// moves the result into the cache
+func (b *builder) install(job *buildJob) *models.FunctionBuild {
+	build := &models.FunctionBuild{Status: models.BuildFailed, DependenciesHash: job.hash, StartedAt: time.Now()}
+	defer func() {
+		now := time.Now()
+		build.FinishedAt = &now
+	}()
+
+	output := &tailBuffer{limit: maxBuildLog}
+	err := b.run(job, output)
+	build.Log = output.String()
+	if err != nil {
+		build.Error = err.Error()
+		return build
+	}
+	build.Status = models.BuildReady
+	return build
+}
+
+// run installs a job's dependencies, or compiles its code, and stores the
+// artifact
+func (b *builder) run(job *buildJob, output io.Writer) error {
+	// In the cache directory, so the artifact can be renamed into place
+	workDir, err := os.MkdirTemp(b.dir, ".build-*")
+	if err != nil {
+		return fmt.Errorf("failed to create build directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	srcDir := filepath.Join(workDir, "src")
+	outDir := filepath.Join(workDir, "out")
+	for _, dir := range []string{srcDir, outDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create build directory: %w", err)
+		}
+	}
+	for name, data := range job.files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	buildDir := srcDir
+	if job.target != nil {
+		sourceFile := "Function.cs"
+		if job.language == "go" {
+			sourceFile = "function.go"
+		}
+		codeDir, err := writeCode(srcDir, job.target, sourceFile)
+		if err != nil {
+			return err
+		}
+		if job.language == "go" {
+			// The bootstrap is compiled into the function's main package
+			if err := writeGoBootstrap(codeDir, job.target.fn.Handler); err != nil {
+				return err
+			}
+			buildDir = codeDir
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch job.language {
+	case "nodejs":
+		args := []string{"install", "--no-package-lock"}
+		if _, ok := job.files["package-lock.json"]; ok {
+			args = []string{"ci"}
+		}
+		args = append(args, "--omit=dev", "--ignore-scripts", "--no-audit", "--no-fund")
+		cmd = exec.CommandContext(ctx, "npm", args...)
+	case "python":
+		cmd = exec.CommandContext(ctx, "python3", "-m", "pip", "install",
+			"--target", outDir, "--requirement", "requirements.txt",
+			"--no-cache-dir", "--disable-pip-version-check", "--no-input")
+	case "dotnet":
+		cmd = exec.CommandContext(ctx, "dotnet", "build", "--configuration", "Release",
+			"--output", outDir, "--nologo", "--disable-build-servers")
+	case "go":
+		cmd = exec.CommandContext(ctx, "go", "build", "-trimpath", "-ldflags=-s -w",
+			"-o", filepath.Join(outDir, goBinary), ".")
+	default:
+		return fmt.Errorf("dependency builds are not supported for %s", job.language)
+	}
+
+	cmd.Dir = buildDir
+	cmd.Env = buildEnv(workDir)
+	if job.language == "go" {
+		cmd.Env = append(cmd.Env, goEnv(b.dir)...)
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("build timed out after %s", b.timeout)
+		}
+		return fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	}
+
+	if job.language == "nodejs" {
+		// npm installs next to package.json; a package without
+		// dependencies gets none
+		modules := filepath.Join(srcDir, "node_modules")
+		if err := os.MkdirAll(modules, 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(modules, filepath.Join(outDir, "node_modules")); err != nil {
+			return fmt.Errorf("failed to collect node_modules: %w", err)
+		}
+	}
+
+	if err := os.Rename(outDir, b.artifact(job.hash)); err != nil && !b.has(job.hash) {
+		return fmt.Errorf("failed to store build: %w", err)
+	}
+	return nil
+}
+
+// buildEnv is the environment of a package manager: the host's PATH and
+// proxy settings, with home and caches inside the build directory
+func buildEnv(workDir string) []string {
+	env := []string{
+		"HOME=" + workDir,
+		"TMPDIR=" + workDir,
+		"npm_config_cache=" + filepath.Join(workDir, ".npm"),
+		"npm_config_update_notifier=false",
+		"DOTNET_CLI_TELEMETRY_OPTOUT=1",
+		"DOTNET_NOLOGO=1",
+		"DOTNET_SKIP_FIRST_TIME_EXPERIENCE=1",
+	}
+	for _, key := range []string{"PATH", "DOTNET_ROOT", "GOROOT", "GOPROXY", "GOSUMDB", "GOPRIVATE", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"} {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return env
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	if t.truncated {
+		return "[earlier output truncated]\n" + string(t.buf)
+	}
+	return string(t.buf)
+}
+
+// linkDependencies makes an artifact's entries visible in a local runner's
+// code directory, without replacing the package's own files. Directories a
+// layer already put there, such as node_modules, are merged into.
+func linkDependencies(deps, codeDir string) error {
+	entries, err := os.ReadDir(deps)
+	if err != nil {
+		return fmt.Errorf("failed to read dependencies: %w", err)
+	}
+	for _, entry := range entries {
+		link := filepath.Join(codeDir, entry.Name())
+		if info, err := os.Lstat(link); err == nil {
+			if info.IsDir() && entry.IsDir() {
+				if err := linkDependencies(filepath.Join(deps, entry.Name()), link); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := os.Symlink(filepath.Join(deps, entry.Name()), link); err != nil {
+			return fmt.Errorf("failed to link dependencies: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyDependencies copies an artifact's files into dir, where they are laid
+// under the package in guests. Links, such as the scripts in
+// node_modules/.bin, are left out.
+func copyDependencies(deps, dir string) error {
+	return filepath.WalkDir(deps, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(deps, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		perm := os.FileMode(0644)
+		if info.Mode()&0111 != 0 {
+			perm = 0755
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+}
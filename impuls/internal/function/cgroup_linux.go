@@ -0,0 +1,199 @@
+package function
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// cgroup2SuperMagic is the file system type of a cgroup v2 hierarchy
+	cgroup2SuperMagic = 0x63677270
+
+	// cpuPeriodUS is the period CPU quotas are given for
+	cpuPeriodUS = 100000
+)
+
+// cgroupControllers are the controllers local invocations are limited with
+var cgroupControllers = []string{"memory", "cpu", "pids"}
+
+// cgroupParent is the cgroup that local invocations get their cgroups in
+type cgroupParent struct {
+	dir    string
+	limits LocalLimits
+}
+
+// newCgroupParent creates the parent cgroup and enables the controllers for
+// its children. Cgroups left behind by a previous run are removed.
+func newCgroupParent(limits LocalLimits) (*cgroupParent, error) {
+	dir := filepath.Clean(limits.CgroupDir)
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Dir(dir), &fs); err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", filepath.Dir(dir), err)
+	}
+	if fs.Type != cgroup2SuperMagic {
+		return nil, fmt.Errorf("%s is not on a cgroup v2 hierarchy", dir)
+	}
+
+	// The controllers must be enabled in the parent of dir to be available
+	// in dir, and in dir to be available to the invocations
+	if err := enableControllers(filepath.Dir(dir)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	if err := enableControllers(dir); err != nil {
+		return nil, err
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "inv-*"))
+	for _, path := range leftovers {
+		(&cgroup{dir: path}).remove()
+	}
+	return &cgroupParent{dir: dir, limits: limits}, nil
+}
+
+// enableControllers enables the invocations' controllers for the children
+// of the cgroup at path
+func enableControllers(path string) error {
+	available, err := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("failed to read controllers of %s: %w", path, err)
+	}
+	var enable []string
+	for _, controller := range cgroupControllers {
+		if !containsField(available, controller) {
+			return fmt.Errorf("controller %s is not available in %s", controller, path)
+		}
+		enable = append(enable, "+"+controller)
+	}
+	if err := writeCgroupFile(path, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return fmt.Errorf("failed to enable controllers in %s: %w", path, err)
+	}
+	return nil
+}
+
+// create creates the cgroup of an invocation, limited to memoryMB of memory
+// without swap. The kernel kills all of its processes together once they
+// run out of memory.
+func (p *cgroupParent) create(id string, memoryMB int) (*cgroup, error) {
+	dir := filepath.Join(p.dir, "inv-"+id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg := &cgroup{dir: dir}
+
+	settings := [][2]string{
+		{"memory.max", strconv.FormatInt(int64(memoryMB)<<20, 10)},
+		{"memory.oom.group", "1"},
+	}
+	if p.limits.CPUs > 0 {
+		quota := int(p.limits.CPUs * cpuPeriodUS)
+		settings = append(settings, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriodUS)})
+	}
+	if p.limits.MaxPids > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.Itoa(p.limits.MaxPids)})
+	}
+	for _, setting := range settings {
+		if err := writeCgroupFile(dir, setting[0], setting[1]); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", setting[0], err)
+		}
+	}
+	// Kernels without swap accounting have no swap limit to set
+	if err := writeCgroupFile(dir, "memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		cg.remove()
+		return nil, fmt.Errorf("failed to set memory.swap.max: %w", err)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		cg.remove()
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	cg.fd = f
+	return cg, nil
+}
+
+// cgroup is the transient cgroup of one local invocation
+type cgroup struct {
+	dir string
+	fd  *os.File // the directory, which processes are started in
+}
+
+// attach starts the command's process in the cgroup, so that it is limited
+// from its first instruction on
+func (c *cgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.fd.Fd())
+}
+
+// oomKilled reports whether the kernel killed processes of the cgroup
+// because it ran out of memory
+func (c *cgroup) oomKilled() bool {
+	data, err := os.ReadFile(filepath.Join(c.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "oom_kill" {
+			n, _ := strconv.Atoi(value)
+			return n > 0
+		}
+	}
+	return false
+}
+
+// remove kills what is left of the invocation's processes, such as children
+// the handler did not wait for, and removes the cgroup
+func (c *cgroup) remove() {
+	if c.fd != nil {
+		c.fd.Close()
+	}
+	if err := writeCgroupFile(c.dir, "cgroup.kill", "1"); err != nil {
+		// Kernels before 5.14 have no cgroup.kill
+		procs, _ := os.ReadFile(filepath.Join(c.dir, "cgroup.procs"))
+		for _, field := range strings.Fields(string(procs)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	}
+
+	// The cgroup can only be removed once its processes have exited
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(c.dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// writeCgroupFile writes a value to one of a cgroup's interface files
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0)
+}
+
+// containsField reports whether a space separated list contains s
+func containsField(list []byte, s string) bool {
+	for _, field := range strings.Fields(string(list)) {
+		if field == s {
+			return true
+		}
+	}
+	return false
+}
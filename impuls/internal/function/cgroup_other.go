@@ -0,0 +1,29 @@
+//go:build !linux
+
+package function
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// cgroupParent is the cgroup that local invocations get their cgroups in
+type cgroupParent struct{}
+
+// newCgroupParent fails: cgroups only exist on Linux
+func newCgroupParent(limits LocalLimits) (*cgroupParent, error) {
+	return nil, errors.New("local limits are only supported on Linux")
+}
+
+func (p *cgroupParent) create(id string, memoryMB int) (*cgroup, error) {
+	return nil, errors.New("local limits are only supported on Linux")
+}
+
+// cgroup is the transient cgroup of one local invocation
+type cgroup struct{}
+
+func (c *cgroup) attach(cmd *exec.Cmd) {}
+
+func (c *cgroup) oomKilled() bool { return false }
+
+func (c *cgroup) remove() {}
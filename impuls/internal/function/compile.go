@@ -0,0 +1,122 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// goErrorPattern matches the errors the Go compiler reports for a file
+var goErrorPattern = regexp.MustCompile(`^(\./)?[^\s:]+\.go:\d+(:\d+)?: `)
+
+// isCompiled reports whether functions of a runtime are compiled before
+// they run: .NET into an assembly, Go into a binary
+func isCompiled(runtime models.Runtime) bool {
+	switch models.GetRuntimeLanguage(runtime) {
+	case "dotnet", "go":
+		return true
+	default:
+		return false
+	}
+}
+
+// compileJob returns the build that compiles a target, nil for runtimes that
+// are not compiled
+func compileJob(target *invocationTarget) *buildJob {
+	switch models.GetRuntimeLanguage(target.fn.Runtime) {
+	case "dotnet":
+		return dotnetJob(target)
+	case "go":
+		return goJob(target)
+	default:
+		return nil
+	}
+}
+
+// checkCompiles compiles the code a .NET or Go function is created or
+// updated with, so compile errors are reported then rather than on its
+// first invocation. Without a build cache, functions are compiled when they
+// are invoked.
+func (m *Manager) checkCompiles(fn *models.Function, code []byte) error {
+	if m.builds == nil || !isCompiled(fn.Runtime) {
+		return nil
+	}
+
+	layers, err := m.loadLayers(fn)
+	if err != nil {
+		return err
+	}
+	job := compileJob(&invocationTarget{fn: fn, code: code, layers: layers})
+	if m.builds.has(job.hash) {
+		return nil
+	}
+	if build := m.builds.build(job); build.Status != models.BuildReady {
+		return &models.ValidationError{Field: "code", Message: "compilation failed: " + compileErrors(build)}
+	}
+	return nil
+}
+
+// compiled returns the directory of a target's build, compiling it on first
+// use. Without a build cache the target is compiled into a scratch
+// directory that cleanup removes.
+func (m *Manager) compiled(target *invocationTarget) (dir string, cleanup func(), err error) {
+	job := compileJob(target)
+	if job == nil {
+		return "", nil, fmt.Errorf("runtime %s is not compiled", target.fn.Runtime)
+	}
+	builds, cleanup := m.builds, func() {}
+	if builds == nil {
+		scratch, err := os.MkdirTemp("", "impuls-build-*")
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to create build directory: %w", err)
+		}
+		builds = &builder{dir: scratch, timeout: DefaultBuildTimeout, running: make(map[string]*buildRun)}
+		cleanup = func() { os.RemoveAll(scratch) }
+	}
+
+	if !builds.has(job.hash) {
+		if build := builds.build(job); build.Status != models.BuildReady {
+			cleanup()
+			return "", nil, errors.New("failed to build function: " + compileErrors(build))
+		}
+	}
+	return builds.artifact(job.hash), cleanup, nil
+}
+
+// compileErrors summarizes a failed compile by the compiler's errors, with
+// paths relative to the function's code
+func compileErrors(build *models.FunctionBuild) string {
+	var errs []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(build.Log, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.Contains(line, ": error "):
+			// MSBuild appends the project and prefixes the build directory
+			if i := strings.LastIndex(line, " ["); i > 0 && strings.HasSuffix(line, "]") {
+				line = line[:i]
+			}
+			if i := strings.Index(line, "/src/"); i >= 0 {
+				line = line[i+len("/src/"):]
+			}
+		case goErrorPattern.MatchString(line):
+			line = strings.TrimPrefix(line, "./")
+		case strings.HasPrefix(line, "go: ") && !strings.HasPrefix(line, "go: downloading "):
+			// Module errors, such as a dependency that cannot be found
+		default:
+			continue
+		}
+		if !seen[line] {
+			seen[line] = true
+			errs = append(errs, line)
+		}
+	}
+	if len(errs) == 0 {
+		return build.Error
+	}
+	return strings.Join(errs, "; ")
+}
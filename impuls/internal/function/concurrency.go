@@ -0,0 +1,261 @@
+package function
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/firecracker"
+	"github.com/oblak/impuls/internal/models"
+)
+
+const (
+	// throttleRetryAfter is how long throttled callers are asked to wait
+	throttleRetryAfter = time.Second
+
+	// capacityRetryAfter is how long callers are asked to wait when the
+	// host has no room for another VM
+	capacityRetryAfter = 5 * time.Second
+)
+
+// concurrencyLimiter admits invocations within the per-function and
+// host-wide limits. Reservations are carved out of the host limit: a
+// function runs in its own reservation first and only then competes with
+// the others for what is left. Usage is tracked per server.
+type concurrencyLimiter struct {
+	mu             sync.Mutex
+	limit          int                          // host-wide, 0 for no limit
+	reserved       map[string]int               // function -> reservation
+	usage          map[string]*concurrencyUsage // function -> running invocations
+	unreserved     int                          // running outside of reservations
+	throttles      map[string]int64
+	totalThrottles int64
+}
+
+// concurrencyUsage counts a function's running invocations by the slots
+// they hold
+type concurrencyUsage struct {
+	reserved   int
+	unreserved int
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{
+		reserved:  make(map[string]int),
+		usage:     make(map[string]*concurrencyUsage),
+		throttles: make(map[string]int64),
+	}
+}
+
+// configure sets the host limit and the reservations of all functions
+func (l *concurrencyLimiter) configure(limit int, functions []*models.Function) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.reserved = make(map[string]int)
+	for _, fn := range functions {
+		l.setReservationLocked(fn.Name, fn.ReservedConcurrency)
+	}
+}
+
+// hostLimit returns the host-wide limit
+func (l *concurrencyLimiter) hostLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// setReservation records a function's reservation
+func (l *concurrencyLimiter) setReservation(function string, reserved int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setReservationLocked(function, reserved)
+}
+
+func (l *concurrencyLimiter) setReservationLocked(function string, reserved int) {
+	if reserved > 0 {
+		l.reserved[function] = reserved
+	} else {
+		delete(l.reserved, function)
+	}
+}
+
+// remove drops the reservation and throttle count of a deleted function.
+// Invocations still running release their slots as usual.
+func (l *concurrencyLimiter) remove(function string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.reserved, function)
+	delete(l.throttles, function)
+}
+
+// totalReserved returns the sum of all reservations. The caller holds mu.
+func (l *concurrencyLimiter) totalReserved() int {
+	total := 0
+	for _, reserved := range l.reserved {
+		total += reserved
+	}
+	return total
+}
+
+// acquire admits an invocation of fn or returns a ThrottledError. The
+// returned function releases the slot and may be called more than once.
+func (l *concurrencyLimiter) acquire(fn *models.Function) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The function was just read from storage, so its reservation may be
+	// newer than ours if another server changed it
+	l.setReservationLocked(fn.Name, fn.ReservedConcurrency)
+
+	usage := l.usage[fn.Name]
+	if usage == nil {
+		usage = &concurrencyUsage{}
+		l.usage[fn.Name] = usage
+	}
+
+	if fn.MaxConcurrency > 0 && usage.reserved+usage.unreserved >= fn.MaxConcurrency {
+		return nil, l.throttle(fn.Name, "function")
+	}
+
+	inReservation := usage.reserved < fn.ReservedConcurrency
+	if !inReservation && l.limit > 0 && l.unreserved >= l.limit-l.totalReserved() {
+		return nil, l.throttle(fn.Name, "host")
+	}
+
+	if inReservation {
+		usage.reserved++
+	} else {
+		usage.unreserved++
+		l.unreserved++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.release(fn.Name, inReservation)
+		})
+	}, nil
+}
+
+// release frees a slot taken by acquire
+func (l *concurrencyLimiter) release(function string, inReservation bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	usage := l.usage[function]
+	if inReservation {
+		usage.reserved--
+	} else {
+		usage.unreserved--
+		l.unreserved--
+	}
+	if usage.reserved == 0 && usage.unreserved == 0 {
+		delete(l.usage, function)
+	}
+}
+
+// throttle counts a rejected invocation. The caller holds mu.
+func (l *concurrencyLimiter) throttle(function, limit string) error {
+	l.throttles[function]++
+	l.totalThrottles++
+	return &models.ThrottledError{Function: function, Limit: limit, RetryAfter: throttleRetryAfter}
+}
+
+// function returns the usage of a function
+func (l *concurrencyLimiter) function(fn *models.Function) *models.FunctionConcurrency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := &models.FunctionConcurrency{
+		Function:            fn.Name,
+		MaxConcurrency:      fn.MaxConcurrency,
+		ReservedConcurrency: fn.ReservedConcurrency,
+		Throttles:           l.throttles[fn.Name],
+	}
+	if usage := l.usage[fn.Name]; usage != nil {
+		stats.Running = usage.reserved + usage.unreserved
+	}
+	return stats
+}
+
+// host returns the host-wide usage
+func (l *concurrencyLimiter) host() models.HostConcurrency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := models.HostConcurrency{
+		Limit:             l.limit,
+		Reserved:          l.totalReserved(),
+		UnreservedRunning: l.unreserved,
+		Throttles:         l.totalThrottles,
+	}
+	for _, usage := range l.usage {
+		stats.Running += usage.reserved + usage.unreserved
+	}
+	return stats
+}
+
+// SetConcurrencyLimit sets the host-wide limit on simultaneous invocations,
+// 0 for no limit, and loads the functions' reservations
+func (m *Manager) SetConcurrencyLimit(limit int) error {
+	functions, err := m.storage.List()
+	if err != nil {
+		return fmt.Errorf("failed to load reservations: %w", err)
+	}
+	m.limiter.configure(limit, functions)
+	return nil
+}
+
+// checkReservation verifies that a function's reservation fits in the host
+// limit next to the reservations of all other functions
+func (m *Manager) checkReservation(name string, reserved int) error {
+	limit := m.limiter.hostLimit()
+	if reserved == 0 || limit == 0 {
+		return nil
+	}
+
+	functions, err := m.storage.List()
+	if err != nil {
+		return fmt.Errorf("failed to check reservations: %w", err)
+	}
+
+	total := reserved
+	for _, fn := range functions {
+		if fn.Name != name {
+			total += fn.ReservedConcurrency
+		}
+	}
+	if total > limit {
+		return &models.ValidationError{
+			Field:   "reserved_concurrency",
+			Message: fmt.Sprintf("reservations would total %d, more than the host limit of %d", total, limit),
+		}
+	}
+	return nil
+}
+
+// Concurrency returns a function's concurrency settings and usage
+func (m *Manager) Concurrency(name string) (*models.FunctionConcurrency, error) {
+	fn, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.limiter.function(fn), nil
+}
+
+// HostConcurrency returns the host-wide concurrency limit and usage
+func (m *Manager) HostConcurrency() models.HostConcurrency {
+	return m.limiter.host()
+}
+
+// Capacity returns the host resources committed to VMs, or nil without a
+// Firecracker manager
+func (m *Manager) Capacity() *firecracker.Utilization {
+	if m.fcManager == nil {
+		return nil
+	}
+	usage := m.fcManager.Utilization()
+	return &usage
+}
@@ -0,0 +1,131 @@
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// dotnetProject is the project local .NET functions are compiled with.
+// System.Text.Json is part of the shared framework, so nothing is restored.
+const dotnetProject = `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <OutputType>Exe</OutputType>
+    <TargetFramework>net8.0</TargetFramework>
+    <Nullable>enable</Nullable>
+    <ImplicitUsings>enable</ImplicitUsings>
+    <AssemblyName>Function</AssemblyName>
+  </PropertyGroup>
+</Project>
+`
+
+// dotnetRunner is compiled into the function's assembly. It takes the
+// handler and the invocation as arguments, so one build serves every call:
+// class, method, function name, memory (MB), timeout (s), event file and
+// result file.
+const dotnetRunner = `
+using System;
+using System.IO;
+using System.Text.Json;
+using System.Threading.Tasks;
+
+public class LambdaContext
+{
+    public string FunctionName { get; set; } = "";
+    public string FunctionVersion { get; set; } = "1";
+    public int MemoryLimitInMB { get; set; }
+    private DateTime _startTime = DateTime.UtcNow;
+    internal int _timeoutSec;
+
+    public int GetRemainingTimeInMillis()
+    {
+        var elapsed = (DateTime.UtcNow - _startTime).TotalSeconds;
+        return Math.Max(0, (int)((_timeoutSec - elapsed) * 1000));
+    }
+}
+
+public static class Runner
+{
+    public static async Task Main(string[] args)
+    {
+        var resultFile = args[6];
+        try
+        {
+            var eventData = JsonSerializer.Deserialize<JsonElement>(File.ReadAllText(args[5]));
+            var context = new LambdaContext
+            {
+                FunctionName = args[2],
+                MemoryLimitInMB = int.Parse(args[3]),
+                _timeoutSec = int.Parse(args[4]),
+            };
+
+            var handlerType = typeof(Runner).Assembly.GetType(args[0]);
+            if (handlerType == null)
+            {
+                File.WriteAllText(resultFile, JsonSerializer.Serialize(new { statusCode = 500, error = $"Class '{args[0]}' not found" }));
+                return;
+            }
+            var instance = Activator.CreateInstance(handlerType);
+            var method = handlerType.GetMethod(args[1]);
+
+            if (method == null)
+            {
+                File.WriteAllText(resultFile, JsonSerializer.Serialize(new { statusCode = 500, error = "Method not found" }));
+                return;
+            }
+
+            object? result;
+            var parameters = method.GetParameters();
+
+            if (parameters.Length == 0)
+                result = method.Invoke(instance, null);
+            else if (parameters.Length == 1)
+                result = method.Invoke(instance, new object?[] { eventData });
+            else
+                result = method.Invoke(instance, new object?[] { eventData, context });
+
+            if (result is Task task)
+            {
+                await task;
+                var resultProperty = task.GetType().GetProperty("Result");
+                result = resultProperty?.GetValue(task);
+            }
+
+            File.WriteAllText(resultFile, JsonSerializer.Serialize(new { statusCode = 200, body = result }));
+        }
+        catch (Exception ex)
+        {
+            File.WriteAllText(resultFile, JsonSerializer.Serialize(new {
+                statusCode = 500,
+                error = ex.InnerException?.Message ?? ex.Message,
+                stack = ex.StackTrace
+            }));
+        }
+    }
+}
+`
+
+// dotnetJob returns the build that compiles a .NET target. Builds are keyed
+// by the runtime, the code and its layers, and the project and runner they
+// are compiled with; the handler is passed to the runner.
+func dotnetJob(target *invocationTarget) *buildJob {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", target.fn.Runtime, target.fn.CodeFormat)
+	h.Write([]byte(dotnetProject))
+	h.Write([]byte(dotnetRunner))
+	fmt.Fprintf(h, "code %d\n", len(target.code))
+	h.Write(target.code)
+	for _, layer := range target.layers {
+		fmt.Fprintf(h, "layer %s\n", layer.SHA256)
+	}
+
+	return &buildJob{
+		hash:     "dotnet-" + hex.EncodeToString(h.Sum(nil)),
+		language: "dotnet",
+		files: map[string][]byte{
+			"Function.csproj": []byte(dotnetProject),
+			"Runner.cs":       []byte(dotnetRunner),
+		},
+		target: target,
+	}
+}
@@ -4,18 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/oblak/impuls/internal/models"
 )
 
 // executeNodeJSLocal executes a Node.js function locally (without Firecracker)
 // This is useful for development and testing
-func executeNodeJSLocal(ctx context.Context, fn *models.Function, code []byte, payload interface{}) (interface{}, error) {
+func executeNodeJSLocal(ctx context.Context, inv *Invocation) (interface{}, error) {
+	fn := inv.target.fn
+
 	// Create a temporary directory for the function
 	tmpDir, err := os.MkdirTemp("", "impuls-function-*")
 	if err != nil {
@@ -24,30 +25,31 @@ func executeNodeJSLocal(ctx context.Context, fn *models.Function, code []byte, p
 	defer os.RemoveAll(tmpDir)
 
 	// Write the function code
-	functionFile := filepath.Join(tmpDir, "function.js")
-	if err := os.WriteFile(functionFile, code, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write function code: %w", err)
+	codeDir, err := localCode(tmpDir, inv.target, "function.js")
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse handler (format: "filename.handlerFunction")
-	handlerParts := strings.SplitN(fn.Handler, ".", 2)
-	if len(handlerParts) != 2 {
-		return nil, fmt.Errorf("invalid handler format: %s (expected 'module.function')", fn.Handler)
+	// Parse handler (format: "module.handlerFunction")
+	module, handlerFunction, err := handlerModule(fn, "function")
+	if err != nil {
+		return nil, err
 	}
-	handlerFunction := handlerParts[1]
+	modulePath := filepath.Join(codeDir, module)
 
 	// Serialize the payload
-	payloadJSON, err := json.Marshal(payload)
+	payloadJSON, err := json.Marshal(inv.payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
 	// Create the runner script
 	runnerScript := fmt.Sprintf(`
+const fs = require('fs');
 const path = require('path');
 
 // Load the function
-const fn = require('./function.js');
+const fn = require('%s');
 
 // Get the handler
 const handler = fn['%s'];
@@ -66,6 +68,16 @@ const context = {
     memoryLimitInMB: %d,
     getRemainingTimeInMillis: () => %d * 1000,
     callbackWaitsForEmptyEventLoop: false,
+    // Writes a chunk of the response, sent to streaming callers right away
+    write: (chunk) => {
+        const data = Buffer.isBuffer(chunk) || chunk instanceof Uint8Array
+            ? Buffer.from(chunk)
+            : Buffer.from(typeof chunk === 'string' ? chunk : JSON.stringify(chunk));
+        if (data.length > %d) {
+            throw new Error('chunk exceeds %d bytes');
+        }
+        fs.writeSync(3, JSON.stringify({ data: data.toString('base64') }) + '\n');
+    },
 };
 
 // Execute the handler
@@ -84,9 +96,17 @@ async function run() {
                 });
             });
         }
-        console.log(JSON.stringify({ statusCode: 200, body: result }));
+        // Generators write what they yield
+        const kind = Object.prototype.toString.call(result);
+        if (kind === '[object AsyncGenerator]' || kind === '[object Generator]') {
+            for await (const chunk of result) {
+                context.write(chunk);
+            }
+            result = undefined;
+        }
+        fs.writeFileSync('%s', JSON.stringify({ statusCode: 200, body: result }));
     } catch (err) {
-        console.log(JSON.stringify({ 
+        fs.writeFileSync('%s', JSON.stringify({ 
             statusCode: 500, 
             error: err.message,
             stack: err.stack 
@@ -95,7 +115,7 @@ async function run() {
 }
 
 run();
-`, handlerFunction, handlerFunction, string(payloadJSON), fn.Name, fn.MemoryMB, fn.TimeoutSec)
+`, modulePath, handlerFunction, handlerFunction, string(payloadJSON), fn.Name, fn.MemoryMB, fn.TimeoutSec, maxChunkSize, maxChunkSize, runnerResultFile, runnerResultFile)
 
 	runnerFile := filepath.Join(tmpDir, "runner.js")
 	if err := os.WriteFile(runnerFile, []byte(runnerScript), 0644); err != nil {
@@ -109,30 +129,63 @@ run();
 	cmd := exec.CommandContext(timeoutCtx, "node", "runner.js")
 	cmd.Dir = tmpDir
 
-	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range fn.Environment {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	// Only the function's variables and secrets. Modules the package does
+	// not have are looked up in the installed dependencies.
+	cmd.Env = localEnv(inv.target, tmpDir)
+	if inv.target.deps != "" {
+		cmd.Env = append(cmd.Env, "NODE_PATH="+filepath.Join(inv.target.deps, "node_modules"))
 	}
 
-	// Run the command and capture output
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		if timeoutCtx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("function execution timed out after %d seconds", fn.TimeoutSec)
+	return runRunner(timeoutCtx, cmd, inv, inv.stream)
+}
+
+// runnerResultFile is the file in the runner's directory that local runners
+// write the handler's result to. Keeping it out of stdout leaves the
+// output to the function's logs.
+const runnerResultFile = "result.json"
+
+// runRunner runs a local runner process for an invocation, streaming its
+// stdout and stderr into the invocation's output and the chunks it writes
+// into stream, and returns the result the runner wrote. Runners without a
+// stream get no chunk pipe.
+func runRunner(timeoutCtx context.Context, cmd *exec.Cmd, inv *Invocation, stream *responseStream) (interface{}, error) {
+	output := inv.output
+	cmd.Stdout = output.Stdout()
+	cmd.Stderr = output.Stderr()
+	// Don't wait for children of the function that still hold the pipes
+	cmd.WaitDelay = time.Second
+
+	var chunks *chunkPipe
+	if stream != nil {
+		var err error
+		if chunks, err = openChunkPipe(cmd, stream); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("function execution failed: %s (output: %s)", err, string(output))
 	}
 
-	// Parse the output
+	err := runLocal(timeoutCtx, cmd, inv)
+	if chunks != nil {
+		chunks.close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(cmd.Dir, runnerResultFile))
+	if err != nil {
+		return nil, fmt.Errorf("function exited without returning a result")
+	}
+
 	var result map[string]interface{}
-	if err := json.Unmarshal(output, &result); err != nil {
-		// Return raw output if not JSON
-		return string(output), nil
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid function result: %w", err)
 	}
 
 	// Check for error
 	if errMsg, ok := result["error"].(string); ok {
+		if stack, ok := result["stack"].(string); ok && stack != "" {
+			io.WriteString(output.Stderr(), strings.TrimSuffix(stack, "\n")+"\n")
+		}
 		return nil, fmt.Errorf("function error: %s", errMsg)
 	}
 
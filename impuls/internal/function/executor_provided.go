@@ -0,0 +1,142 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// providedRequest is the JSON document a provided runtime's binary reads
+// from stdin, once per invocation
+type providedRequest struct {
+	Event   interface{}     `json:"event"`
+	Context providedContext `json:"context"`
+}
+
+// providedContext describes the invocation to the binary
+type providedContext struct {
+	FunctionName    string `json:"function_name"`
+	FunctionVersion string `json:"function_version"`
+	InvocationID    string `json:"invocation_id"`
+	MemoryMB        int    `json:"memory_mb"`
+	TimeoutMS       int    `json:"timeout_ms"`
+	DeadlineMS      int64  `json:"deadline_ms"` // Unix time in milliseconds
+}
+
+// providedResponse is the JSON document the binary writes to stdout: the
+// result as body, or an error with an optional stack
+type providedResponse struct {
+	Body  interface{} `json:"body"`
+	Error string      `json:"error"`
+	Stack string      `json:"stack"`
+}
+
+// executeProvidedLocal runs a provided function's binary locally (without
+// Firecracker). The handler is the binary's path in the package.
+func executeProvidedLocal(ctx context.Context, inv *Invocation) (interface{}, error) {
+	// Create a temporary directory for the function
+	tmpDir, err := os.MkdirTemp("", "impuls-provided-function-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	codeDir, err := localCode(tmpDir, inv.target, "")
+	if err != nil {
+		return nil, err
+	}
+	binary := filepath.Join(codeDir, filepath.FromSlash(inv.target.fn.Handler))
+	if err := os.Chmod(binary, 0755); err != nil {
+		return nil, fmt.Errorf("failed to make handler executable: %w", err)
+	}
+
+	return runBinary(ctx, inv, binary, codeDir, tmpDir)
+}
+
+// executeGoLocal runs a Go function locally (without Firecracker). The
+// function is compiled once per code and handler; invocations run the
+// built binary.
+func (m *Manager) executeGoLocal(ctx context.Context, inv *Invocation) (interface{}, error) {
+	binaryDir, cleanup, err := m.compiled(inv.target)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// Create a temporary directory for the invocation
+	tmpDir, err := os.MkdirTemp("", "impuls-go-function-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	return runBinary(ctx, inv, filepath.Join(binaryDir, goBinary), tmpDir, tmpDir)
+}
+
+// runBinary runs a binary that speaks the provided runtime's contract in
+// dir: the request on stdin, the response on stdout and logs on stderr.
+// tmpDir is the invocation's scratch directory.
+func runBinary(ctx context.Context, inv *Invocation, binary, dir, tmpDir string) (interface{}, error) {
+	target, output := inv.target, inv.output
+	fn := target.fn
+
+	// Create command with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(fn.TimeoutSec)*time.Second)
+	defer cancel()
+	deadline, _ := timeoutCtx.Deadline()
+
+	request, err := json.Marshal(providedRequest{
+		Event: inv.payload,
+		Context: providedContext{
+			FunctionName:    fn.Name,
+			FunctionVersion: target.version,
+			InvocationID:    output.invocationID,
+			MemoryMB:        fn.MemoryMB,
+			TimeoutMS:       fn.TimeoutSec * 1000,
+			DeadlineMS:      deadline.UnixMilli(),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	cmd := exec.CommandContext(timeoutCtx, binary)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(request)
+
+	// Only the function's variables and secrets
+	cmd.Env = localEnv(target, tmpDir)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = output.Stderr()
+	// Don't wait for children of the function that still hold the pipes
+	cmd.WaitDelay = time.Second
+
+	if err := runLocal(timeoutCtx, cmd, inv); err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return nil, fmt.Errorf("function exited without returning a result")
+	}
+	var response providedResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("invalid function result: %w", err)
+	}
+
+	if response.Error != "" {
+		if response.Stack != "" {
+			io.WriteString(output.Stderr(), strings.TrimSuffix(response.Stack, "\n")+"\n")
+		}
+		return nil, fmt.Errorf("function error: %s", response.Error)
+	}
+	return response.Body, nil
+}
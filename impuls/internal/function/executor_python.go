@@ -9,13 +9,13 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/oblak/impuls/internal/models"
 )
 
 // executePythonLocal executes a Python function locally (without Firecracker)
 // This is useful for development and testing
-func executePythonLocal(ctx context.Context, fn *models.Function, code []byte, payload interface{}) (interface{}, error) {
+func executePythonLocal(ctx context.Context, inv *Invocation) (interface{}, error) {
+	fn := inv.target.fn
+
 	// Create a temporary directory for the function
 	tmpDir, err := os.MkdirTemp("", "impuls-python-function-*")
 	if err != nil {
@@ -24,35 +24,51 @@ func executePythonLocal(ctx context.Context, fn *models.Function, code []byte, p
 	defer os.RemoveAll(tmpDir)
 
 	// Write the function code
-	functionFile := filepath.Join(tmpDir, "function.py")
-	if err := os.WriteFile(functionFile, code, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write function code: %w", err)
+	codeDir, err := localCode(tmpDir, inv.target, "function.py")
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse handler (format: "filename.handler_function")
-	handlerParts := strings.SplitN(fn.Handler, ".", 2)
-	if len(handlerParts) != 2 {
-		return nil, fmt.Errorf("invalid handler format: %s (expected 'module.function')", fn.Handler)
+	// Parse handler (format: "module.handler_function")
+	module, handlerFunction, err := handlerModule(fn, "function")
+	if err != nil {
+		return nil, err
+	}
+	moduleName := strings.ReplaceAll(module, "/", ".")
+
+	// Modules are imported from the code, then the installed dependencies
+	searchPath := []string{codeDir}
+	if inv.target.deps != "" {
+		searchPath = append(searchPath, inv.target.deps)
+	}
+	searchPathJSON, err := json.Marshal(searchPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal search path: %w", err)
 	}
-	handlerFunction := handlerParts[1]
 
 	// Serialize the payload
-	payloadJSON, err := json.Marshal(payload)
+	payloadJSON, err := json.Marshal(inv.payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
 	// Create the runner script
 	runnerScript := fmt.Sprintf(`
+import base64
+import inspect
+import os
 import sys
 import json
 import traceback
 import time
+import importlib
 
-sys.path.insert(0, '%s')
+# Bytecode is not written next to the code, which may be shared
+sys.dont_write_bytecode = True
+sys.path[0:0] = %s
 
 # Import the function module
-import function
+function = importlib.import_module('%s')
 
 # Get the handler
 handler = getattr(function, '%s', None)
@@ -75,6 +91,20 @@ class Context:
         elapsed = time.time() - self._start_time
         return max(0, int((self._timeout - elapsed) * 1000))
 
+    # Writes a chunk of the response, sent to streaming callers right away
+    def write(self, chunk):
+        if isinstance(chunk, (bytes, bytearray)):
+            data = bytes(chunk)
+        elif isinstance(chunk, str):
+            data = chunk.encode('utf-8')
+        else:
+            data = json.dumps(chunk).encode('utf-8')
+        if len(data) > %d:
+            raise ValueError('chunk exceeds %d bytes')
+        _chunks.write((json.dumps({'data': base64.b64encode(data).decode('ascii')}) + '\n').encode('ascii'))
+        _chunks.flush()
+
+_chunks = os.fdopen(3, 'wb')
 context = Context()
 
 # Execute the handler
@@ -86,14 +116,29 @@ try:
     else:
         result = handler(event, context)
     
-    print(json.dumps({'statusCode': 200, 'body': result}))
+    # Generators write what they yield
+    if inspect.isgenerator(result):
+        for chunk in result:
+            context.write(chunk)
+        result = None
+    elif inspect.isasyncgen(result):
+        async def drain(chunks):
+            async for chunk in chunks:
+                context.write(chunk)
+        asyncio.run(drain(result))
+        result = None
+    
+    response = json.dumps({'statusCode': 200, 'body': result})
 except Exception as e:
-    print(json.dumps({
+    response = json.dumps({
         'statusCode': 500,
         'error': str(e),
         'stack': traceback.format_exc()
-    }))
-`, tmpDir, handlerFunction, handlerFunction, string(payloadJSON), fn.Name, fn.MemoryMB, fn.TimeoutSec)
+    })
+
+with open('%s', 'w') as f:
+    f.write(response)
+`, searchPathJSON, moduleName, handlerFunction, handlerFunction, string(payloadJSON), fn.Name, fn.MemoryMB, fn.TimeoutSec, maxChunkSize, maxChunkSize, runnerResultFile)
 
 	runnerFile := filepath.Join(tmpDir, "runner.py")
 	if err := os.WriteFile(runnerFile, []byte(runnerScript), 0644); err != nil {
@@ -113,40 +158,8 @@ except Exception as e:
 	cmd := exec.CommandContext(timeoutCtx, pythonCmd, "runner.py")
 	cmd.Dir = tmpDir
 
-	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range fn.Environment {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
-
-	// Run the command and capture output
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		if timeoutCtx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("function execution timed out after %d seconds", fn.TimeoutSec)
-		}
-		return nil, fmt.Errorf("function execution failed: %s (output: %s)", err, string(output))
-	}
-
-	// Parse the output - find the last JSON line
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	var result map[string]interface{}
-
-	for i := len(lines) - 1; i >= 0; i-- {
-		if err := json.Unmarshal([]byte(lines[i]), &result); err == nil {
-			break
-		}
-	}
-
-	if result == nil {
-		// Return raw output if not JSON
-		return string(output), nil
-	}
-
-	// Check for error
-	if errMsg, ok := result["error"].(string); ok {
-		return nil, fmt.Errorf("function error: %s", errMsg)
-	}
+	// Only the function's variables and secrets
+	cmd.Env = localEnv(inv.target, tmpDir)
 
-	return result["body"], nil
+	return runRunner(timeoutCtx, cmd, inv, inv.stream)
 }
@@ -0,0 +1,199 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// Executor runs invocations on one backend, such as Firecracker VMs or
+// processes on the server. The manager takes every invocation through
+// Prepare, Invoke and Teardown in turn; an executor keeps what an
+// invocation runs with between them.
+type Executor interface {
+	// Prepare acquires what the invocation runs with. An UnavailableError
+	// is passed on to the caller, so that it can retry; any other error
+	// fails the invocation.
+	Prepare(ctx context.Context, inv *Invocation) error
+
+	// Invoke runs the function's handler within its timeout and returns
+	// its result. The handler's output goes to inv.Logs() and the chunks
+	// it streams to inv.Stream(). An error fails the invocation with a
+	// status of 500.
+	Invoke(ctx context.Context, inv *Invocation) (*models.InvocationResponse, error)
+
+	// Teardown releases what Prepare acquired. It is called once for
+	// every Prepare that succeeded, whether Invoke did or not.
+	Teardown(inv *Invocation)
+}
+
+// ID returns the invocation's ID, which its history record is kept under
+func (inv *Invocation) ID() string {
+	return inv.id
+}
+
+// Function returns the function being invoked, as configured in the
+// version that runs
+func (inv *Invocation) Function() *models.Function {
+	return inv.target.fn
+}
+
+// Version returns the version that runs, models.LatestVersion for the
+// current code
+func (inv *Invocation) Version() string {
+	return inv.target.version
+}
+
+// Code returns the function's code or package
+func (inv *Invocation) Code() []byte {
+	return inv.target.code
+}
+
+// Payload returns the event the function is invoked with
+func (inv *Invocation) Payload() interface{} {
+	return inv.payload
+}
+
+// Env returns the environment the handler runs with: the function's
+// environment variables and its secrets
+func (inv *Invocation) Env() map[string]string {
+	return invocationEnv(inv.target)
+}
+
+// Logs returns the writer for the handler's output. Every line written to
+// it is published to log followers and ends up in the response's logs.
+func (inv *Invocation) Logs() io.Writer {
+	return inv.output.Stdout()
+}
+
+// Stream returns the writer for the chunks of the handler's response. They
+// are passed on to a streaming caller as they are written.
+func (inv *Invocation) Stream() io.Writer {
+	return inv.stream
+}
+
+// vmExecutor runs invocations in Firecracker VMs
+type vmExecutor struct {
+	m *Manager
+}
+
+// localExecutor runs invocations as processes on the server, in the
+// namespace sandbox if sandboxed is set
+type localExecutor struct {
+	m         *Manager
+	sandboxed bool
+}
+
+// newExecutors returns the manager's built-in executors by the type
+// functions pin them with
+func (m *Manager) newExecutors() map[models.ExecutorType]Executor {
+	return map[models.ExecutorType]Executor{
+		models.ExecutorFirecracker: &vmExecutor{m: m},
+		models.ExecutorLocal:       &localExecutor{m: m},
+		models.ExecutorSandbox:     &localExecutor{m: m, sandboxed: true},
+	}
+}
+
+// RegisterExecutor adds an executor, or replaces a built-in one, under
+// name. It can then be made the default with SetDefaultExecutor. Register
+// executors before the manager serves invocations.
+func (m *Manager) RegisterExecutor(name models.ExecutorType, executor Executor) error {
+	if name == "" {
+		return fmt.Errorf("no executor name given")
+	}
+	if executor == nil {
+		return fmt.Errorf("no executor given for %s", name)
+	}
+	m.executors[name] = executor
+	return nil
+}
+
+// SetDefaultExecutor sets the executor of functions that pin none
+func (m *Manager) SetDefaultExecutor(executor models.ExecutorType) error {
+	if executor == "" {
+		return fmt.Errorf("no default executor given")
+	}
+	if _, ok := m.executors[executor]; !ok {
+		return &models.ValidationError{Field: "executor", Message: fmt.Sprintf("unknown executor: %s", executor)}
+	}
+	m.defaultExecutor = executor
+	return nil
+}
+
+// EnableSandbox enables the sandbox executor after checking that the host
+// can create its namespaces. Sandboxed processes have no network unless
+// network is set; functions with no_network never have one.
+func (m *Manager) EnableSandbox(network bool) error {
+	sandbox, err := newSandbox(network)
+	if err != nil {
+		return err
+	}
+	m.sandbox = sandbox
+	return nil
+}
+
+// checkExecutor checks that the executor a function pins is registered.
+// Empty stands for the server's default.
+func (m *Manager) checkExecutor(executor models.ExecutorType) error {
+	if executor == "" {
+		return nil
+	}
+	if _, ok := m.executors[executor]; !ok {
+		return &models.ValidationError{Field: "executor", Message: fmt.Sprintf("unknown executor: %s", executor)}
+	}
+	return nil
+}
+
+// executorFor picks the executor of a function's invocation: the one it
+// pins, otherwise the server's default
+func (m *Manager) executorFor(fn *models.Function) models.ExecutorType {
+	if fn.Executor != "" {
+		return fn.Executor
+	}
+	return m.defaultExecutor
+}
+
+// run takes an invocation through the phases of its executor. Failures
+// become a response with a status of 500, except for an executor that
+// cannot take the invocation right now. The response's logs default to the
+// handler's output and its duration covers all phases.
+func (m *Manager) run(ctx context.Context, executor Executor, inv *Invocation) (*models.InvocationResponse, error) {
+	response, err := invokeWith(ctx, executor, inv)
+	inv.output.Flush()
+	if err != nil {
+		var unavailable *models.UnavailableError
+		if errors.As(err, &unavailable) {
+			return nil, err
+		}
+		response = &models.InvocationResponse{
+			StatusCode: 500,
+			Error:      err.Error(),
+		}
+		var limitErr *limitError
+		if errors.As(err, &limitErr) {
+			response.ErrorType = limitErr.errorType
+		}
+	}
+	if response == nil {
+		response = &models.InvocationResponse{StatusCode: 200}
+	}
+	if response.Logs == "" {
+		// Guests that stream lines without repeating them in the result
+		response.Logs = inv.output.String()
+	}
+	response.Duration = time.Since(inv.startedAt).Milliseconds()
+	return response, nil
+}
+
+// invokeWith prepares, invokes and tears down an invocation on executor
+func invokeWith(ctx context.Context, executor Executor, inv *Invocation) (*models.InvocationResponse, error) {
+	if err := executor.Prepare(ctx, inv); err != nil {
+		return nil, err
+	}
+	defer executor.Teardown(inv)
+	return executor.Invoke(ctx, inv)
+}
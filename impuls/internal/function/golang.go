@@ -0,0 +1,204 @@
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// goBinary is the name of a compiled Go function, in its build and in
+	// the package a guest gets
+	goBinary = "bootstrap"
+
+	// goBootstrapFile is the generated file that gives a Go function's
+	// package its main function
+	goBootstrapFile = "zz_impuls_bootstrap.go"
+
+	// goModule is the module a Go function is built as when its code has
+	// no go.mod of its own
+	goModule = "module function\n\ngo 1.22\n"
+)
+
+// goHandlerPattern matches the handler of a Go function: the name of a
+// function in its main package
+var goHandlerPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// goBootstrap is compiled into a Go function's main package. It implements
+// the provided runtime's contract around the handler, whose name replaces
+// IMPULS_HANDLER: the request is read from stdin and the response written
+// to stdout, which the handler's own output is redirected away from.
+const goBootstrap = `// Code generated by impuls. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+type impulsRequest struct {
+	Event   json.RawMessage ` + "`json:\"event\"`" + `
+	Context struct {
+		DeadlineMS int64 ` + "`json:\"deadline_ms\"`" + `
+	} ` + "`json:\"context\"`" + `
+}
+
+type impulsResponse struct {
+	Body  interface{} ` + "`json:\"body,omitempty\"`" + `
+	Error string      ` + "`json:\"error,omitempty\"`" + `
+	Stack string      ` + "`json:\"stack,omitempty\"`" + `
+}
+
+var (
+	impulsContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	impulsErrorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func main() {
+	// What the handler prints is logged, stdout carries the response
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	if err := json.NewEncoder(out).Encode(impulsInvoke(reflect.ValueOf(IMPULS_HANDLER))); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write response:", err)
+		os.Exit(1)
+	}
+}
+
+// impulsInvoke calls the handler with the request on stdin. Handlers take
+// an optional context.Context and an optional event of any type the event
+// decodes into, and return a result, an error, or both.
+func impulsInvoke(handler reflect.Value) (response impulsResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			response = impulsResponse{Error: fmt.Sprint("panic: ", r), Stack: string(debug.Stack())}
+		}
+	}()
+
+	var request impulsRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+		return impulsResponse{Error: "invalid request: " + err.Error()}
+	}
+	ctx := context.Background()
+	if request.Context.DeadlineMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(request.Context.DeadlineMS))
+		defer cancel()
+	}
+
+	t := handler.Type()
+	if t.Kind() != reflect.Func || t.IsVariadic() {
+		return impulsResponse{Error: "handler is not a function"}
+	}
+	if t.NumOut() > 2 || (t.NumOut() == 2 && t.Out(1) != impulsErrorType) {
+		return impulsResponse{Error: "handler must return a result, an error, or a result and an error"}
+	}
+
+	var args []reflect.Value
+	if t.NumIn() > 0 && t.In(0) == impulsContextType {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	switch t.NumIn() - len(args) {
+	case 0:
+	case 1:
+		event := reflect.New(t.In(len(args)))
+		if len(request.Event) > 0 {
+			if err := json.Unmarshal(request.Event, event.Interface()); err != nil {
+				return impulsResponse{Error: fmt.Sprintf("failed to decode event into %s: %v", event.Elem().Type(), err)}
+			}
+		}
+		args = append(args, event.Elem())
+	default:
+		return impulsResponse{Error: "handler must take a context.Context, an event, or both"}
+	}
+
+	results := handler.Call(args)
+	if n := len(results); n > 0 && t.Out(n-1) == impulsErrorType {
+		if err, _ := results[n-1].Interface().(error); err != nil {
+			return impulsResponse{Error: err.Error()}
+		}
+		results = results[:n-1]
+	}
+	if len(results) > 0 {
+		response.Body = results[0].Interface()
+	}
+	return response
+}
+`
+
+// goJob returns the build that compiles a Go target into a binary. Builds
+// are keyed by the runtime, the handler, the code and its layers, and the
+// bootstrap they are compiled with.
+func goJob(target *invocationTarget) *buildJob {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", target.fn.Runtime, target.fn.CodeFormat, target.fn.Handler)
+	h.Write([]byte(goModule))
+	h.Write([]byte(goBootstrap))
+	fmt.Fprintf(h, "code %d\n", len(target.code))
+	h.Write(target.code)
+	for _, layer := range target.layers {
+		fmt.Fprintf(h, "layer %s\n", layer.SHA256)
+	}
+
+	return &buildJob{
+		hash:     "go-" + hex.EncodeToString(h.Sum(nil)),
+		language: "go",
+		target:   target,
+	}
+}
+
+// writeGoBootstrap adds the bootstrap for the given handler to a Go
+// function's code, and a go.mod if the code has none
+func writeGoBootstrap(codeDir, handler string) error {
+	bootstrap := strings.ReplaceAll(goBootstrap, "IMPULS_HANDLER", handler)
+	if err := os.WriteFile(filepath.Join(codeDir, goBootstrapFile), []byte(bootstrap), 0644); err != nil {
+		return fmt.Errorf("failed to write bootstrap: %w", err)
+	}
+
+	goMod := filepath.Join(codeDir, "go.mod")
+	if _, err := os.Stat(goMod); os.IsNotExist(err) {
+		if err := os.WriteFile(goMod, []byte(goModule), 0644); err != nil {
+			return fmt.Errorf("failed to write go.mod: %w", err)
+		}
+	}
+	return nil
+}
+
+// goEnv is the environment the Go toolchain builds functions with: static
+// binaries with the server's toolchain, and the module and build caches
+// kept in the build cache directory across builds
+func goEnv(cacheDir string) []string {
+	return []string{
+		"CGO_ENABLED=0",
+		"GOTOOLCHAIN=local",
+		"GOFLAGS=-mod=mod -modcacherw",
+		"GOCACHE=" + filepath.Join(cacheDir, ".gocache"),
+		"GOMODCACHE=" + filepath.Join(cacheDir, ".gomodcache"),
+	}
+}
+
+// goPackage compiles a Go target for a guest, which runs it as a provided
+// binary. The binary is the package's only file.
+func (m *Manager) goPackage(target *invocationTarget) (files map[string][]byte, id string, err error) {
+	dir, cleanup, err := m.compiled(target)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cleanup()
+
+	binary, err := os.ReadFile(filepath.Join(dir, goBinary))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read binary: %w", err)
+	}
+	return map[string][]byte{goBinary: binary}, packageID(target.code, target.fn.Handler, dir), nil
+}
@@ -0,0 +1,184 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// SetHistoryConfig sets how much of each invocation is recorded and how long
+// records are kept
+func (m *Manager) SetHistoryConfig(config models.HistoryConfig) {
+	m.history = config
+}
+
+// saveInvocationRecord adds an invocation to the history and tags the
+// response with the record ID. Failing to record never fails the call.
+func (m *Manager) saveInvocationRecord(inv *Invocation, response *models.InvocationResponse) {
+	target := inv.target
+	record := &models.InvocationRecord{
+		ID:           inv.id,
+		FunctionName: target.fn.Name,
+		Version:      target.version,
+		Qualifier:    target.qualifier,
+		Local:        inv.local,
+		Status:       models.InvocationSucceeded,
+		StatusCode:   response.StatusCode,
+		Error:        response.Error,
+		StartedAt:    inv.startedAt,
+		Duration:     response.Duration,
+	}
+	if response.Error != "" || response.StatusCode >= 500 {
+		record.Status = models.InvocationFailed
+	}
+
+	if inv.payload != nil {
+		if data, err := json.Marshal(inv.payload); err == nil {
+			record.Payload, record.PayloadTruncated = truncateHead(string(data), m.history.MaxPayloadBytes)
+		}
+	}
+	record.Logs, record.LogsTruncated = truncateTail(response.Logs, m.history.MaxLogBytes)
+
+	if err := m.storage.SaveInvocationRecord(record); err != nil {
+		log.Printf("Failed to record invocation of %s: %v", target.fn.Name, err)
+		return
+	}
+	response.InvocationID = record.ID
+}
+
+// ListInvocations returns the recorded invocations of a function, newest
+// first
+func (m *Manager) ListInvocations(name string, filter models.InvocationFilter) ([]*models.InvocationRecord, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+	return m.storage.ListInvocationRecords(name, filter)
+}
+
+// GetInvocation returns a recorded invocation of a function
+func (m *Manager) GetInvocation(name, id string) (*models.InvocationRecord, error) {
+	record, err := m.storage.GetInvocationRecord(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvocationRecordNotFound) {
+			return nil, &models.NotFoundError{Resource: "invocation", Name: id}
+		}
+		return nil, err
+	}
+	if record.FunctionName != name {
+		return nil, &models.NotFoundError{Resource: "invocation", Name: id}
+	}
+	return record, nil
+}
+
+// PruneInvocations deletes the records that fall outside the retention
+// limits and returns how many were deleted
+func (m *Manager) PruneInvocations(now time.Time) (int, error) {
+	var olderThan time.Time
+	if m.history.Retention > 0 {
+		olderThan = now.Add(-m.history.Retention)
+	}
+	if olderThan.IsZero() && m.history.MaxPerFunction <= 0 {
+		return 0, nil
+	}
+	return m.storage.PruneInvocationRecords(olderThan, m.history.MaxPerFunction)
+}
+
+// truncateHead cuts s to at most max bytes, keeping the beginning. A max of
+// zero or less keeps everything.
+func truncateHead(s string, max int) (string, bool) {
+	if max <= 0 || len(s) <= max {
+		return s, false
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut], true
+}
+
+// truncateTail cuts s to at most max bytes, keeping the end. A max of zero
+// or less keeps everything.
+func truncateTail(s string, max int) (string, bool) {
+	if max <= 0 || len(s) <= max {
+		return s, false
+	}
+	cut := len(s) - max
+	for cut < len(s) && !utf8.RuneStart(s[cut]) {
+		cut++
+	}
+	return s[cut:], true
+}
+
+// HistoryPruner periodically applies the manager's retention limits to the
+// invocation history
+type HistoryPruner struct {
+	manager  *Manager
+	interval time.Duration
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewHistoryPruner creates a pruner that runs every interval
+func NewHistoryPruner(manager *Manager, interval time.Duration) *HistoryPruner {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &HistoryPruner{
+		manager:  manager,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start prunes once and then keeps pruning in the background
+func (p *HistoryPruner) Start(ctx context.Context) {
+	p.prune()
+
+	p.wg.Add(1)
+	go p.run(ctx)
+}
+
+// Stop stops the pruner
+func (p *HistoryPruner) Stop() {
+	close(p.stopChan)
+	p.wg.Wait()
+}
+
+// run prunes every interval until stopped
+func (p *HistoryPruner) run(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.prune()
+		}
+	}
+}
+
+// prune applies the retention limits once
+func (p *HistoryPruner) prune() {
+	deleted, err := p.manager.PruneInvocations(time.Now())
+	if err != nil {
+		log.Printf("Failed to prune invocation history: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Pruned %d invocation records", deleted)
+	}
+}
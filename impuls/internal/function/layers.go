@@ -0,0 +1,187 @@
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// PublishLayer publishes the next version of a layer, creating the layer on
+// its first version. The returned layer leaves out the content.
+func (m *Manager) PublishLayer(req *models.PublishLayerRequest) (*models.Layer, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	content := []byte(req.Content)
+	err := walkPackage(req.CodeFormat, content, func(name string, mode os.FileMode, r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+	if err != nil {
+		return nil, &models.ValidationError{Field: "content", Message: err.Error()}
+	}
+	// Valid base64 once the package was walked
+	data, _ := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(req.Content), ""))
+	sum := sha256.Sum256(data)
+
+	versions, err := m.storage.ListLayerVersions(req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layer versions: %w", err)
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Version + 1
+	}
+
+	layer := &models.Layer{
+		Name:        req.Name,
+		Version:     next,
+		Description: req.Description,
+		CodeFormat:  req.CodeFormat,
+		Content:     base64.StdEncoding.EncodeToString(data),
+		Size:        len(data),
+		SHA256:      hex.EncodeToString(sum[:]),
+		CreatedAt:   time.Now(),
+	}
+
+	if err := m.storage.CreateLayer(layer); err != nil {
+		if errors.Is(err, storage.ErrLayerVersionExists) {
+			return nil, &models.ConflictError{Message: fmt.Sprintf("version %d of layer %s was published concurrently, retry", next, req.Name)}
+		}
+		return nil, fmt.Errorf("failed to publish layer: %w", err)
+	}
+
+	layer.Content = ""
+	return layer, nil
+}
+
+// ListLayers returns the latest version of every layer
+func (m *Manager) ListLayers() ([]*models.Layer, error) {
+	return m.storage.ListLayers()
+}
+
+// ListLayerVersions returns all versions of a layer, oldest first
+func (m *Manager) ListLayerVersions(name string) ([]*models.Layer, error) {
+	versions, err := m.storage.ListLayerVersions(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, &models.NotFoundError{Resource: "layer", Name: name}
+	}
+	return versions, nil
+}
+
+// GetLayer returns a layer version with its content
+func (m *Manager) GetLayer(name string, version int) (*models.Layer, error) {
+	layer, err := m.storage.GetLayer(name, version)
+	if err != nil {
+		if errors.Is(err, storage.ErrLayerNotFound) {
+			return nil, &models.NotFoundError{Resource: "layer", Name: models.LayerRef{Name: name, Version: version}.String()}
+		}
+		return nil, err
+	}
+	return layer, nil
+}
+
+// DeleteLayer deletes a layer version that no function or published
+// version uses
+func (m *Manager) DeleteLayer(name string, version int) error {
+	if _, err := m.GetLayer(name, version); err != nil {
+		return err
+	}
+
+	ref := models.LayerRef{Name: name, Version: version}
+	functions, err := m.storage.List()
+	if err != nil {
+		return fmt.Errorf("failed to list functions: %w", err)
+	}
+	for _, fn := range functions {
+		if slices.Contains(fn.Layers, ref) {
+			return &models.ConflictError{Message: fmt.Sprintf("layer %s is used by function %s", ref, fn.Name)}
+		}
+		versions, err := m.storage.ListVersions(fn.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list versions: %w", err)
+		}
+		for _, v := range versions {
+			if slices.Contains(v.Layers, ref) {
+				return &models.ConflictError{Message: fmt.Sprintf("layer %s is used by version %d of function %s", ref, v.Version, fn.Name)}
+			}
+		}
+	}
+
+	return m.storage.DeleteLayer(name, version)
+}
+
+// checkLayers checks that the layers a function is given exist
+func (m *Manager) checkLayers(refs []models.LayerRef) error {
+	if err := models.ValidateLayerRefs(refs); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if _, err := m.storage.GetLayer(ref.Name, ref.Version); err != nil {
+			if errors.Is(err, storage.ErrLayerNotFound) {
+				return &models.ValidationError{Field: "layers", Message: fmt.Sprintf("layer %s does not exist", ref)}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLayers returns the layers a function runs with, in order
+func (m *Manager) loadLayers(fn *models.Function) ([]*models.Layer, error) {
+	layers := make([]*models.Layer, 0, len(fn.Layers))
+	for _, ref := range fn.Layers {
+		layer, err := m.storage.GetLayer(ref.Name, ref.Version)
+		if err != nil {
+			if errors.Is(err, storage.ErrLayerNotFound) {
+				return nil, &models.ConflictError{Message: fmt.Sprintf("layer %s of function %s no longer exists", ref, fn.Name)}
+			}
+			return nil, fmt.Errorf("failed to get layer %s: %w", ref, err)
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// layerImages returns the drive images of a target's installed dependencies
+// and layers for a VM, in the order the guest merges them. Dependencies come
+// first, so the layers' files replace theirs; their image is built once per
+// dependencies hash and shared by every function installing the same ones.
+func (m *Manager) layerImages(deps string, layers []*models.Layer) ([]string, error) {
+	var images []string
+	if deps != "" {
+		image, err := m.fcManager.LayerImage("deps-"+filepath.Base(deps), func(dir string) error {
+			return copyDependencies(deps, dir)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("dependencies: %w", err)
+		}
+		images = append(images, image)
+	}
+	for _, layer := range layers {
+		layer := layer
+		image, err := m.fcManager.LayerImage(layer.SHA256, func(dir string) error {
+			return unpackPackage(layer.CodeFormat, []byte(layer.Content), dir)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", layer.Ref(), err)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
@@ -0,0 +1,112 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// LocalLimits are the resource limits of local invocations, which run in a
+// transient cgroup of their own below CgroupDir. Memory is limited to the
+// function's MemoryMB.
+type LocalLimits struct {
+	CgroupDir string  // on a cgroup v2 hierarchy
+	CPUs      float64 // CPU time per second of wall time, 0 for no limit
+	MaxPids   int     // processes and threads, 0 for no limit
+}
+
+// DefaultLocalLimits are the local limits when none are configured
+var DefaultLocalLimits = LocalLimits{
+	CgroupDir: "/sys/fs/cgroup/impuls",
+	CPUs:      1,
+	MaxPids:   256,
+}
+
+// SetLocalLimits confines local invocations to cgroups below
+// limits.CgroupDir, which is created if needed. The cgroup v2 hierarchy must
+// delegate the memory, cpu and pids controllers to it. Without local limits,
+// local invocations use as much of the host as they like.
+func (m *Manager) SetLocalLimits(limits LocalLimits) error {
+	if limits.CPUs < 0 || limits.MaxPids < 0 {
+		return fmt.Errorf("local limits must not be negative")
+	}
+	cgroups, err := newCgroupParent(limits)
+	if err != nil {
+		return err
+	}
+	m.cgroups = cgroups
+	return nil
+}
+
+// localEnv is the environment of a local invocation's process: the
+// function's variables and secrets, a PATH to find the runtime with, and dir
+// as its home and temporary directory. Nothing else of the server's
+// environment is passed on.
+func localEnv(target *invocationTarget, dir string) []string {
+	env := []string{
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"LANG=C.UTF-8",
+		"DOTNET_CLI_TELEMETRY_OPTOUT=1",
+		"DOTNET_NOLOGO=1",
+	}
+	for _, key := range []string{"PATH", "DOTNET_ROOT"} {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	for key, value := range invocationEnv(target) {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	return env
+}
+
+// limitError is an invocation stopped because it ran into one of its
+// limits. Its type tells the caller which one.
+type limitError struct {
+	errorType string // one of the models.ErrorType constants
+	message   string
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+// runLocal runs the process of a local invocation, in the invocation's
+// sandbox and cgroup if it has them, and explains how it failed: by running
+// out of memory or time, or on its own
+func runLocal(timeoutCtx context.Context, cmd *exec.Cmd, inv *Invocation) error {
+	fn := inv.target.fn
+	if inv.sandbox != nil {
+		cleanup, err := inv.sandbox.prepare(cmd, inv)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	if inv.cgroup != nil {
+		inv.cgroup.attach(cmd)
+	}
+
+	err := cmd.Run()
+	inv.output.Flush()
+	if err == nil {
+		return nil
+	}
+	switch {
+	case inv.cgroup != nil && inv.cgroup.oomKilled():
+		return &limitError{
+			errorType: models.ErrorTypeOutOfMemory,
+			message:   fmt.Sprintf("function ran out of memory (limit %d MB)", fn.MemoryMB),
+		}
+	case timeoutCtx.Err() == context.DeadlineExceeded:
+		return &limitError{
+			errorType: models.ErrorTypeTimeout,
+			message:   fmt.Sprintf("function execution timed out after %d seconds", fn.TimeoutSec),
+		}
+	}
+	return fmt.Errorf("function execution failed: %s (output: %s)", err, inv.output.String())
+}
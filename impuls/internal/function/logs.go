@@ -0,0 +1,213 @@
+package function
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// logSubscriberBuffer is how many lines a subscriber may fall behind before
+// further lines are dropped for it
+const logSubscriberBuffer = 256
+
+// LogSubscription receives the output lines of a function's running
+// invocations
+type LogSubscription struct {
+	C <-chan models.LogLine
+
+	ch       chan models.LogLine
+	broker   *logBroker
+	function string
+	once     sync.Once
+}
+
+// Close stops the subscription. No lines are delivered after Close returns.
+func (s *LogSubscription) Close() {
+	s.once.Do(func() {
+		s.broker.unsubscribe(s)
+	})
+}
+
+// logBroker fans the output of running invocations out to subscribers. Lines
+// are only delivered live; slow subscribers miss lines rather than holding
+// up the function.
+type logBroker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*LogSubscription]struct{} // function -> subscriptions
+}
+
+func newLogBroker() *logBroker {
+	return &logBroker{
+		subscribers: make(map[string]map[*LogSubscription]struct{}),
+	}
+}
+
+// subscribe registers a subscriber for a function's lines
+func (b *logBroker) subscribe(function string) *LogSubscription {
+	ch := make(chan models.LogLine, logSubscriberBuffer)
+	sub := &LogSubscription{C: ch, ch: ch, broker: b, function: function}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[function] == nil {
+		b.subscribers[function] = make(map[*LogSubscription]struct{})
+	}
+	b.subscribers[function][sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscriber and closes its channel
+func (b *logBroker) unsubscribe(sub *LogSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers[sub.function], sub)
+	if len(b.subscribers[sub.function]) == 0 {
+		delete(b.subscribers, sub.function)
+	}
+	close(sub.ch)
+}
+
+// publish delivers a line to the function's subscribers without blocking
+func (b *logBroker) publish(line models.LogLine) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers[line.FunctionName] {
+		select {
+		case sub.ch <- line:
+		default:
+		}
+	}
+}
+
+// SubscribeLogs follows the output of a function's invocations as it is
+// written. The caller must close the subscription.
+func (m *Manager) SubscribeLogs(name string) (*LogSubscription, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+	return m.logs.subscribe(name), nil
+}
+
+// logCapture collects the stdout and stderr of one invocation and publishes
+// every complete line as it is written. Secret values the invocation runs
+// with are redacted from both.
+type logCapture struct {
+	broker       *logBroker
+	invocationID string
+	function     string
+	version      string
+	redactor     *strings.Replacer // nil without secrets
+
+	mu     sync.Mutex
+	output bytes.Buffer
+	stdout *lineWriter
+	stderr *lineWriter
+}
+
+func newLogCapture(broker *logBroker, invocationID string, target *invocationTarget) *logCapture {
+	c := &logCapture{
+		broker:       broker,
+		invocationID: invocationID,
+		function:     target.fn.Name,
+		version:      target.version,
+		redactor:     newRedactor(target.secrets),
+	}
+	c.stdout = &lineWriter{capture: c, stream: "stdout"}
+	c.stderr = &lineWriter{capture: c, stream: "stderr"}
+	return c
+}
+
+// Stdout returns the writer for the function's standard output
+func (c *logCapture) Stdout() io.Writer {
+	return c.stdout
+}
+
+// Stderr returns the writer for the function's standard error
+func (c *logCapture) Stderr() io.Writer {
+	return c.stderr
+}
+
+// Line records a complete line that did not come through the writers, e.g.
+// a line streamed by a guest runtime
+func (c *logCapture) Line(stream, line string) {
+	c.mu.Lock()
+	c.output.WriteString(line)
+	c.output.WriteByte('\n')
+	c.mu.Unlock()
+	c.publish(stream, line)
+}
+
+// Flush publishes lines that were not terminated by a newline
+func (c *logCapture) Flush() {
+	c.stdout.flush()
+	c.stderr.flush()
+}
+
+// String returns everything captured so far, both streams interleaved in
+// the order they were written
+func (c *logCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.redact(c.output.String())
+}
+
+// redact hides the invocation's secret values in s
+func (c *logCapture) redact(s string) string {
+	if c.redactor == nil {
+		return s
+	}
+	return c.redactor.Replace(s)
+}
+
+func (c *logCapture) publish(stream, line string) {
+	c.broker.publish(models.LogLine{
+		InvocationID: c.invocationID,
+		FunctionName: c.function,
+		Version:      c.version,
+		Stream:       stream,
+		Line:         c.redact(strings.TrimSuffix(line, "\r")),
+		Timestamp:    time.Now(),
+	})
+}
+
+// lineWriter is one stream of a logCapture
+type lineWriter struct {
+	capture *logCapture
+	stream  string
+	partial []byte
+}
+
+// Write captures p and publishes the lines it completes. Each stream is
+// written from a single goroutine by os/exec, so only the shared buffer
+// needs locking.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.capture.mu.Lock()
+	w.capture.output.Write(p)
+	w.capture.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.capture.publish(w.stream, string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush publishes a trailing unterminated line
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.capture.publish(w.stream, string(w.partial))
+		w.partial = nil
+	}
+}
@@ -3,12 +3,16 @@ package function
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/oblak/impuls/internal/firecracker"
 	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/secrets"
 	"github.com/oblak/impuls/internal/storage"
 )
 
@@ -17,14 +21,40 @@ type Manager struct {
 	storage   storage.Storage
 	fcManager *firecracker.Manager
 	vmPool    *firecracker.VMPool
+	metrics   *revisionMetrics
+	logs      *logBroker
+	limiter   *concurrencyLimiter
+	builds    *builder       // nil until dependency builds are enabled
+	randFloat func() float64 // picks weighted alias versions
+
+	secretsKey *secrets.MasterKey // nil until secrets are enabled
+	cgroups    *cgroupParent      // nil until local limits are enabled
+	sandbox    *sandbox           // nil until the sandbox executor is enabled
+
+	executors       map[models.ExecutorType]Executor
+	defaultExecutor models.ExecutorType // for functions that pin none
+
+	retryPolicy models.RetryPolicy   // applied to new async invocations
+	history     models.HistoryConfig // what is kept of each invocation
 }
 
 // NewManager creates a new function manager
 func NewManager(store storage.Storage, fcManager *firecracker.Manager) *Manager {
-	return &Manager{
+	m := &Manager{
 		storage:   store,
 		fcManager: fcManager,
+		metrics:   newRevisionMetrics(),
+		logs:      newLogBroker(),
+		limiter:   newConcurrencyLimiter(),
+		randFloat: rand.Float64,
+
+		defaultExecutor: models.ExecutorFirecracker,
+
+		retryPolicy: models.DefaultRetryPolicy,
+		history:     models.DefaultHistoryConfig,
 	}
+	m.executors = m.newExecutors()
+	return m
 }
 
 // Create creates a new function
@@ -49,6 +79,19 @@ func (m *Manager) Create(req *models.CreateFunctionRequest) (*models.Function, e
 		timeoutSec = 30
 	}
 
+	if err := m.checkReservation(req.Name, req.ReservedConcurrency); err != nil {
+		return nil, err
+	}
+	if err := m.checkLayers(req.Layers); err != nil {
+		return nil, err
+	}
+	if err := m.checkSecrets(req.Secrets, req.Environment); err != nil {
+		return nil, err
+	}
+	if err := m.checkExecutor(req.Executor); err != nil {
+		return nil, err
+	}
+
 	fn := &models.Function{
 		ID:          uuid.New().String(),
 		Name:        req.Name,
@@ -56,11 +99,35 @@ func (m *Manager) Create(req *models.CreateFunctionRequest) (*models.Function, e
 		Runtime:     req.Runtime,
 		Handler:     req.Handler,
 		Code:        req.Code,
+		CodeFormat:  req.CodeFormat,
 		MemoryMB:    memoryMB,
 		TimeoutSec:  timeoutSec,
 		Environment: req.Environment,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+
+		MaxConcurrency:      req.MaxConcurrency,
+		ReservedConcurrency: req.ReservedConcurrency,
+
+		NoNetwork: req.NoNetwork,
+		Executor:  req.Executor,
+		Layers:    req.Layers,
+		Secrets:   req.Secrets,
+
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if req.CORS != nil && len(req.CORS.AllowOrigins) > 0 {
+		fn.CORS = req.CORS
+	}
+
+	if err := validateCode(fn, []byte(fn.Code)); err != nil {
+		return nil, err
+	}
+	job, err := m.planBuild(fn, []byte(fn.Code))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkCompiles(fn, []byte(fn.Code)); err != nil {
+		return nil, err
 	}
 
 	// Create function in storage first (needed for PostgreSQL)
@@ -69,7 +136,7 @@ func (m *Manager) Create(req *models.CreateFunctionRequest) (*models.Function, e
 	}
 
 	// Save code to storage (for file storage, creates separate code file)
-	codePath, err := m.storage.SaveCode(fn.Name, []byte(req.Code))
+	codePath, err := m.saveCode(fn, []byte(req.Code))
 	if err != nil {
 		// Rollback: delete the created function
 		m.storage.Delete(fn.Name)
@@ -84,6 +151,10 @@ func (m *Manager) Create(req *models.CreateFunctionRequest) (*models.Function, e
 		return nil, fmt.Errorf("failed to update function with code path: %w", err)
 	}
 
+	m.limiter.setReservation(fn.Name, fn.ReservedConcurrency)
+	if job != nil {
+		go m.runBuild(fn.Name, job)
+	}
 	return fn, nil
 }
 
@@ -92,7 +163,7 @@ func (m *Manager) Get(name string) (*models.Function, error) {
 	fn, err := m.storage.Get(name)
 	if err != nil {
 		if err == storage.ErrNotFound {
-			return nil, fmt.Errorf("function %s not found", name)
+			return nil, &models.NotFoundError{Resource: "function", Name: name}
 		}
 		return nil, err
 	}
@@ -101,15 +172,19 @@ func (m *Manager) Get(name string) (*models.Function, error) {
 
 // Update updates an existing function
 func (m *Manager) Update(name string, req *models.UpdateFunctionRequest) (*models.Function, error) {
-	fn, err := m.storage.Get(name)
+	stored, err := m.storage.Get(name)
 	if err != nil {
 		if err == storage.ErrNotFound {
-			return nil, fmt.Errorf("function %s not found", name)
+			return nil, &models.NotFoundError{Resource: "function", Name: name}
 		}
 		return nil, err
 	}
 
-	// Apply updates
+	// Updates are applied to a copy, so that the stored function is left
+	// as it was if the update is rejected. Nothing is saved before every
+	// check has passed.
+	updated := *stored
+	fn := &updated
 	if req.Description != nil {
 		fn.Description = *req.Description
 	}
@@ -119,14 +194,56 @@ func (m *Manager) Update(name string, req *models.UpdateFunctionRequest) (*model
 	if req.Handler != nil {
 		fn.Handler = *req.Handler
 	}
+	if req.CodeFormat != nil {
+		fn.CodeFormat = *req.CodeFormat
+	}
+	var job *buildJob
+	if req.Code != nil || req.CodeFormat != nil || req.Handler != nil || req.Runtime != nil {
+		if job, err = m.prepareUpdatedCode(fn, req); err != nil {
+			return nil, err
+		}
+	}
+	if req.Layers != nil {
+		if err := m.checkLayers(req.Layers); err != nil {
+			return nil, err
+		}
+		fn.Layers = nil
+		if len(req.Layers) > 0 {
+			fn.Layers = req.Layers
+		}
+	}
+	if req.Code != nil || req.CodeFormat != nil || req.Runtime != nil || req.Handler != nil || req.Layers != nil {
+		if err := m.checkUpdatedCompiles(fn, req); err != nil {
+			return nil, err
+		}
+	}
+	if req.Secrets != nil || req.Environment != nil {
+		refs, env := fn.Secrets, fn.Environment
+		if req.Secrets != nil {
+			refs = req.Secrets
+		}
+		if req.Environment != nil {
+			env = req.Environment
+		}
+		if err := m.checkSecrets(refs, env); err != nil {
+			return nil, err
+		}
+		fn.Secrets = nil
+		if len(refs) > 0 {
+			fn.Secrets = refs
+		}
+	}
+	if req.CORS != nil {
+		if err := req.CORS.Validate(); err != nil {
+			return nil, err
+		}
+		fn.CORS = nil
+		if len(req.CORS.AllowOrigins) > 0 {
+			fn.CORS = req.CORS
+		}
+	}
 	if req.Code != nil {
 		fn.Code = *req.Code
-		// Update stored code
-		codePath, err := m.storage.SaveCode(fn.Name, []byte(*req.Code))
-		if err != nil {
-			return nil, fmt.Errorf("failed to save function code: %w", err)
-		}
-		fn.CodePath = codePath
 	}
 	if req.MemoryMB != nil {
 		fn.MemoryMB = *req.MemoryMB
@@ -137,158 +254,496 @@ func (m *Manager) Update(name string, req *models.UpdateFunctionRequest) (*model
 	if req.Environment != nil {
 		fn.Environment = req.Environment
 	}
+	if req.MaxConcurrency != nil {
+		fn.MaxConcurrency = *req.MaxConcurrency
+	}
+	if req.ReservedConcurrency != nil {
+		fn.ReservedConcurrency = *req.ReservedConcurrency
+	}
+	if req.NoNetwork != nil {
+		fn.NoNetwork = *req.NoNetwork
+	}
+	if req.Executor != nil {
+		if err := m.checkExecutor(*req.Executor); err != nil {
+			return nil, err
+		}
+		fn.Executor = *req.Executor
+	}
+
+	if err := models.ValidateConcurrency(fn.MaxConcurrency, fn.ReservedConcurrency); err != nil {
+		return nil, err
+	}
+	if req.ReservedConcurrency != nil {
+		if err := m.checkReservation(fn.Name, fn.ReservedConcurrency); err != nil {
+			return nil, err
+		}
+	}
 
+	// Code saved in another format is saved again, so a package is unpacked
+	if req.Code != nil || req.CodeFormat != nil {
+		var code []byte
+		if req.Code != nil {
+			code = []byte(*req.Code)
+		} else if code, err = m.storage.GetCode(fn.Name); err != nil {
+			return nil, fmt.Errorf("failed to get function code: %w", err)
+		}
+		codePath, err := m.saveCode(fn, code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save function code: %w", err)
+		}
+		fn.CodePath = codePath
+	}
 	fn.UpdatedAt = time.Now()
 
 	if err := m.storage.Update(fn); err != nil {
 		return nil, fmt.Errorf("failed to update function: %w", err)
 	}
 
+	m.limiter.setReservation(fn.Name, fn.ReservedConcurrency)
+	if job != nil {
+		go m.runBuild(fn.Name, job)
+	}
 	return fn, nil
 }
 
+// saveCode saves a function's code in its format. Packages are unpacked into
+// the function's code directory where the storage has one, for local runners
+// to load the function from without unpacking it on every invocation.
+func (m *Manager) saveCode(fn *models.Function, code []byte) (string, error) {
+	if !fn.CodeFormat.IsArchive() {
+		return m.storage.SaveCode(fn.Name, code)
+	}
+	return m.storage.SavePackage(fn.Name, fn.CodeFormat, code, func(dir string) error {
+		return unpackPackage(fn.CodeFormat, code, dir)
+	})
+}
+
+// prepareUpdatedCode checks the code a function will have after an update
+// against its new format, runtime and handler. If the code, format or
+// runtime changed, the dependencies are built anew.
+func (m *Manager) prepareUpdatedCode(fn *models.Function, req *models.UpdateFunctionRequest) (*buildJob, error) {
+	if !models.IsValidCodeFormat(fn.CodeFormat) {
+		return nil, &models.ValidationError{Field: "code_format", Message: "code_format must be empty, zip or tar.gz"}
+	}
+	if !fn.CodeFormat.IsArchive() {
+		fn.Build = nil
+		return nil, nil
+	}
+
+	var code []byte
+	if req.Code != nil {
+		code = []byte(*req.Code)
+	} else {
+		var err error
+		if code, err = m.storage.GetCode(fn.Name); err != nil {
+			return nil, fmt.Errorf("failed to get function code: %w", err)
+		}
+	}
+	if err := validateCode(fn, code); err != nil {
+		return nil, err
+	}
+
+	if req.Code == nil && req.CodeFormat == nil && req.Runtime == nil {
+		return nil, nil
+	}
+	return m.planBuild(fn, code)
+}
+
+// checkUpdatedCompiles compiles the code a .NET or Go function will have
+// after an update that changed what it is built from
+func (m *Manager) checkUpdatedCompiles(fn *models.Function, req *models.UpdateFunctionRequest) error {
+	if m.builds == nil || !isCompiled(fn.Runtime) {
+		return nil
+	}
+
+	if req.Code != nil {
+		return m.checkCompiles(fn, []byte(*req.Code))
+	}
+	code, err := m.storage.GetCode(fn.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get function code: %w", err)
+	}
+	return m.checkCompiles(fn, code)
+}
+
 // Delete deletes a function
 func (m *Manager) Delete(name string) error {
 	if err := m.storage.Delete(name); err != nil {
 		if err == storage.ErrNotFound {
-			return fmt.Errorf("function %s not found", name)
+			return &models.NotFoundError{Resource: "function", Name: name}
 		}
 		return err
 	}
+	m.metrics.reset(name)
+	m.limiter.remove(name)
 	return nil
 }
 
+// SetVMPool routes Firecracker invocations through a pool of pre-warmed VMs
+func (m *Manager) SetVMPool(pool *firecracker.VMPool) {
+	m.vmPool = pool
+}
+
 // List returns all functions
 func (m *Manager) List() ([]*models.Function, error) {
 	return m.storage.List()
 }
 
-// Invoke executes a function
-func (m *Manager) Invoke(ctx context.Context, name string, payload interface{}) (*models.InvocationResponse, error) {
-	startTime := time.Now()
+// Invoke executes a function. The qualifier selects a published version or
+// alias; an empty qualifier runs the latest code.
+func (m *Manager) Invoke(ctx context.Context, name, qualifier string, payload interface{}) (*models.InvocationResponse, error) {
+	return m.invoke(ctx, name, qualifier, payload, nil)
+}
 
-	fn, err := m.storage.Get(name)
+// InvokeStream executes a function and passes every chunk its handler
+// writes to send as soon as it is written, on any executor. The handler's
+// result, if any, is sent as the last chunk; the response that is returned
+// has no body.
+func (m *Manager) InvokeStream(ctx context.Context, name, qualifier string, payload interface{}, send func(chunk []byte) error) (*models.InvocationResponse, error) {
+	return m.invoke(ctx, name, qualifier, payload, send)
+}
+
+// invoke resolves and runs an invocation, streamed if send is set
+func (m *Manager) invoke(ctx context.Context, name, qualifier string, payload interface{}, send func(chunk []byte) error) (*models.InvocationResponse, error) {
+	target, err := m.resolve(name, qualifier)
 	if err != nil {
-		if err == storage.ErrNotFound {
-			return nil, fmt.Errorf("function %s not found", name)
-		}
+		return nil, err
+	}
+	if err := m.prepare(target); err != nil {
 		return nil, err
 	}
 
-	// Create timeout context
-	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(fn.TimeoutSec)*time.Second)
-	defer cancel()
+	release, err := m.limiter.acquire(target.fn)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	// Get function code
-	code, err := m.storage.GetCode(name)
+	executor := m.executorFor(target.fn)
+	e, ok := m.executors[executor]
+	if !ok {
+		// Pinned to an executor this server does not register
+		return nil, &models.UnavailableError{Message: fmt.Sprintf("the %s executor is not registered on this server", executor)}
+	}
+	inv := m.newInvocation(target, payload, executor != models.ExecutorFirecracker, send)
+	response, err := m.run(ctx, e, inv)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get function code: %w", err)
+		return nil, err
 	}
 
-	// Create VM configuration
-	vmConfig := firecracker.VMConfig{
-		FunctionName: fn.Name,
-		MemoryMB:     fn.MemoryMB,
-		VCPUs:        1,
-		CodePath:     fn.CodePath,
-		Handler:      fn.Handler,
-		Runtime:      string(fn.Runtime),
-		Environment:  fn.Environment,
+	m.finishInvocation(inv, response)
+	return response, nil
+}
+
+// prepare loads what a resolved target runs with besides its code: the
+// installed dependencies, the layers and the secret values
+func (m *Manager) prepare(target *invocationTarget) error {
+	var err error
+	if target.deps, err = m.dependencies(target.fn); err != nil {
+		return err
+	}
+	if target.layers, err = m.loadLayers(target.fn); err != nil {
+		return err
+	}
+	target.secrets, err = m.loadSecrets(target.fn)
+	return err
+}
+
+// Invocation is a single call of a resolved target
+type Invocation struct {
+	id        string
+	target    *invocationTarget
+	payload   interface{}
+	local     bool
+	startedAt time.Time
+	output    *logCapture // stdout and stderr, published to log followers
+	stream    *responseStream
+	vm        *vmLease // the VM of an invocation in Firecracker
+	cgroup    *cgroup  // limits a local invocation, nil without local limits
+	sandbox   *sandbox // isolates a local invocation, nil outside the sandbox
+}
+
+// newInvocation starts an invocation of target, streamed to send if it is
+// set
+func (m *Manager) newInvocation(target *invocationTarget, payload interface{}, local bool, send func(chunk []byte) error) *Invocation {
+	id := uuid.New().String()
+	return &Invocation{
+		id:        id,
+		target:    target,
+		payload:   payload,
+		local:     local,
+		startedAt: time.Now(),
+		output:    newLogCapture(m.logs, id, target),
+		stream:    newResponseStream(send),
+	}
+}
+
+// vmLease is the VM an invocation runs in and the request the guest gets
+type vmLease struct {
+	vm       *firecracker.VM
+	pooled   bool
+	request  []byte
+	deadline time.Time // the function's timeout, from before the VM was taken
+
+	// reusable is set if the guest answered before the deadline; otherwise
+	// the handler may still be running inside the VM
+	reusable bool
+}
+
+// Prepare reads the function's package, builds its layer images and takes a
+// VM for the invocation, from the warm pool when possible
+func (e *vmExecutor) Prepare(ctx context.Context, inv *Invocation) error {
+	m := e.m
+	fn, code := inv.target.fn, inv.target.code
+
+	// Guests get packages as their files, read before a VM is taken.
+	// Installed dependencies are attached like layers, below them. Go
+	// functions are compiled here and run by the guest as a provided
+	// binary, with their layers compiled in.
+	var files map[string][]byte
+	handler, id, deps, layerRefs := fn.Handler, "", "", inv.target.layers
+	var err error
+	switch {
+	case models.GetRuntimeLanguage(fn.Runtime) == "go":
+		handler, layerRefs = goBinary, nil
+		files, id, err = m.goPackage(inv.target)
+	case fn.CodeFormat.IsArchive():
+		files, err = packageFiles(fn.CodeFormat, code)
+		id, deps = packageID(code, fn.Handler, inv.target.deps), inv.target.deps
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read function package: %w", err)
 	}
 
-	// Create VM
-	vm, err := m.fcManager.CreateVM(timeoutCtx, vmConfig)
+	// Dependencies and layers are attached to the VM as drive images, built
+	// on first use
+	layers, err := m.layerImages(deps, layerRefs)
 	if err != nil {
-		return &models.InvocationResponse{
-			StatusCode: 500,
-			Error:      fmt.Sprintf("failed to create VM: %v", err),
-			Duration:   time.Since(startTime).Milliseconds(),
-		}, nil
+		return fmt.Errorf("failed to prepare function layers: %w", err)
 	}
-	defer m.fcManager.StopVM(vm.ID)
 
 	// Prepare invocation payload
 	invocationPayload := map[string]interface{}{
-		"handler": fn.Handler,
-		"code":    string(code),
-		"event":   payload,
-		"env":     fn.Environment,
+		"handler":          handler,
+		"code":             string(code),
+		"event":            inv.payload,
+		"env":              invocationEnv(inv.target),
+		"function_name":    fn.Name,
+		"function_version": inv.target.version,
+		"invocation_id":    inv.id,
+		"memory_mb":        fn.MemoryMB,
+		"timeout_sec":      fn.TimeoutSec,
+		"timeout_ms":       fn.TimeoutSec * 1000,
+	}
+	if files != nil {
+		// The handler's module is resolved against the unpacked tree
+		delete(invocationPayload, "code")
+		invocationPayload["package_id"] = id
+		invocationPayload["files"] = files
+	}
+
+	request, err := json.Marshal(invocationPayload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	payloadBytes, err := json.Marshal(invocationPayload)
+	// Waiting for a VM counts against the function's timeout
+	deadline := time.Now().Add(time.Duration(fn.TimeoutSec) * time.Second)
+	waitCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	vm, pooled, err := m.acquireVM(waitCtx, fn, layers)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		var capacityErr *firecracker.CapacityError
+		if errors.As(err, &capacityErr) {
+			return &models.UnavailableError{Message: err.Error(), RetryAfter: capacityRetryAfter}
+		}
+		return fmt.Errorf("failed to create VM: %w", err)
 	}
+	inv.vm = &vmLease{vm: vm, pooled: pooled, request: request, deadline: deadline}
+	return nil
+}
 
-	// Execute function in VM
-	result, err := m.fcManager.ExecuteFunction(timeoutCtx, vm, payloadBytes)
+// Invoke sends the invocation to the guest and waits for its result
+func (e *vmExecutor) Invoke(ctx context.Context, inv *Invocation) (*models.InvocationResponse, error) {
+	timeoutCtx, cancel := context.WithDeadline(ctx, inv.vm.deadline)
+	defer cancel()
+
+	result, err := e.m.fcManager.ExecuteFunction(timeoutCtx, inv.vm.vm, inv.vm.request, inv.output.Line, inv.stream.chunk)
 	if err != nil {
-		return &models.InvocationResponse{
-			StatusCode: 500,
-			Error:      fmt.Sprintf("failed to execute function: %v", err),
-			Duration:   time.Since(startTime).Milliseconds(),
-		}, nil
+		if timeoutCtx.Err() == context.DeadlineExceeded {
+			return nil, &limitError{
+				errorType: models.ErrorTypeTimeout,
+				message:   fmt.Sprintf("failed to execute function: %v", err),
+			}
+		}
+		return nil, fmt.Errorf("failed to execute function: %w", err)
 	}
+	inv.vm.reusable = timeoutCtx.Err() == nil
+	return parseGuestResponse(result), nil
+}
 
-	// Parse result
-	var response models.InvocationResponse
-	if err := json.Unmarshal(result, &response); err != nil {
+// Teardown hands the VM back to the pool or stops it
+func (e *vmExecutor) Teardown(inv *Invocation) {
+	e.m.releaseVM(inv.vm.vm, inv.vm.pooled, inv.vm.reusable)
+	inv.vm = nil
+}
+
+// guestResponse is the result the runtime inside the VM sends back
+type guestResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Body       interface{} `json:"body"`
+	Error      string      `json:"error"`
+	Stack      string      `json:"stack"`
+	Logs       string      `json:"logs"`
+}
+
+// parseGuestResponse converts the result of a guest runtime into an
+// invocation response. The stack of a failed call is appended to its logs.
+func parseGuestResponse(result []byte) *models.InvocationResponse {
+	var guest guestResponse
+	if err := json.Unmarshal(result, &guest); err != nil {
 		// Return raw result if not JSON
 		return &models.InvocationResponse{
 			StatusCode: 200,
 			Body:       string(result),
-			Duration:   time.Since(startTime).Milliseconds(),
-		}, nil
+		}
 	}
 
-	response.Duration = time.Since(startTime).Milliseconds()
-	return &response, nil
+	response := &models.InvocationResponse{
+		StatusCode: guest.StatusCode,
+		Body:       guest.Body,
+		Error:      guest.Error,
+		Logs:       guest.Logs,
+	}
+	if response.StatusCode == 0 {
+		response.StatusCode = 200
+		if guest.Error != "" {
+			response.StatusCode = 500
+		}
+	}
+	if guest.Stack != "" {
+		response.Logs = strings.TrimPrefix(response.Logs+"\n"+guest.Stack, "\n")
+	}
+	return response
 }
 
-// InvokeLocal invokes a function locally without Firecracker (for testing/development)
-func (m *Manager) InvokeLocal(ctx context.Context, name string, payload interface{}) (*models.InvocationResponse, error) {
-	startTime := time.Now()
-
-	fn, err := m.storage.Get(name)
-	if err != nil {
-		if err == storage.ErrNotFound {
-			return nil, fmt.Errorf("function %s not found", name)
+// acquireVM returns a VM for the function with the given layer images
+// attached. Pooled VMs only have the pool's memory size, a network and no
+// layers, so other functions always get a dedicated VM.
+func (m *Manager) acquireVM(ctx context.Context, fn *models.Function, layers []string) (*firecracker.VM, bool, error) {
+	if m.vmPool != nil && m.vmPool.Accepts(fn.MemoryMB) && !fn.NoNetwork && len(layers) == 0 {
+		vm, err := m.vmPool.GetVM(ctx, string(fn.Runtime), fn.Name)
+		if err != nil {
+			return nil, false, err
 		}
-		return nil, err
+		return vm, true, nil
+	}
+
+	vmConfig := firecracker.VMConfig{
+		FunctionName: fn.Name,
+		MemoryMB:     fn.MemoryMB,
+		VCPUs:        1,
+		CodePath:     fn.CodePath,
+		Handler:      fn.Handler,
+		Runtime:      string(fn.Runtime),
+		Environment:  fn.Environment,
+		NoNetwork:    fn.NoNetwork,
+		Layers:       layers,
 	}
 
-	// Get function code
-	code, err := m.storage.GetCode(name)
+	// The VM's process is bound to the context it is created under, so like
+	// the pool's VMs it gets one that outlives Prepare; releaseVM stops it
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	vm, err := m.fcManager.CreateVM(context.WithoutCancel(ctx), vmConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get function code: %w", err)
+		return nil, false, err
+	}
+	return vm, false, nil
+}
+
+// releaseVM hands a VM back to the pool or stops it
+func (m *Manager) releaseVM(vm *firecracker.VM, pooled, reusable bool) {
+	if pooled {
+		m.vmPool.ReturnVM(vm, reusable)
+		return
 	}
+	m.fcManager.StopVM(vm.ID)
+}
+
+// Prepare confines the invocation's processes to a cgroup of their own if
+// the server has local limits
+func (e *localExecutor) Prepare(ctx context.Context, inv *Invocation) error {
+	m := e.m
+	if e.sandboxed {
+		if m.sandbox == nil {
+			return &models.UnavailableError{Message: "the sandbox executor is not enabled on this server"}
+		}
+		inv.sandbox = m.sandbox
+	}
+	if m.cgroups != nil {
+		cg, err := m.cgroups.create(inv.id, inv.target.fn.MemoryMB)
+		if err != nil {
+			return fmt.Errorf("failed to limit function resources: %w", err)
+		}
+		inv.cgroup = cg
+	}
+	return nil
+}
+
+// Invoke runs the function's handler with the runner of its runtime
+func (e *localExecutor) Invoke(ctx context.Context, inv *Invocation) (*models.InvocationResponse, error) {
+	m := e.m
+	fn := inv.target.fn
 
 	// Execute based on runtime
 	var result interface{}
-	var execErr error
+	var err error
 
 	switch models.GetRuntimeLanguage(fn.Runtime) {
 	case "nodejs":
-		result, execErr = executeNodeJSLocal(ctx, fn, code, payload)
+		result, err = executeNodeJSLocal(ctx, inv)
 	case "python":
-		result, execErr = executePythonLocal(ctx, fn, code, payload)
+		result, err = executePythonLocal(ctx, inv)
 	case "dotnet":
-		result, execErr = executeDotNetLocal(ctx, fn, code, payload)
+		result, err = m.executeDotNetLocal(ctx, inv)
+	case "go":
+		result, err = m.executeGoLocal(ctx, inv)
+	case "provided":
+		result, err = executeProvidedLocal(ctx, inv)
 	default:
-		execErr = fmt.Errorf("unsupported runtime for local execution: %s", fn.Runtime)
+		err = fmt.Errorf("unsupported runtime for local execution: %s", fn.Runtime)
 	}
-
-	if execErr != nil {
-		return &models.InvocationResponse{
-			StatusCode: 500,
-			Error:      execErr.Error(),
-			Duration:   time.Since(startTime).Milliseconds(),
-		}, nil
+	if err != nil {
+		return nil, err
 	}
 
 	return &models.InvocationResponse{
 		StatusCode: 200,
 		Body:       result,
-		Duration:   time.Since(startTime).Milliseconds(),
 	}, nil
 }
+
+// Teardown kills what is left of the invocation's processes and removes its
+// cgroup
+func (e *localExecutor) Teardown(inv *Invocation) {
+	if inv.cgroup != nil {
+		inv.cgroup.remove()
+		inv.cgroup = nil
+	}
+}
+
+// finishInvocation completes a response from the chunks the handler wrote,
+// tags it with the revision that served it and records the outcome in the
+// metrics and the invocation history. Secret values are redacted from its
+// logs and error first.
+func (m *Manager) finishInvocation(inv *Invocation, response *models.InvocationResponse) {
+	inv.stream.finish(response)
+	response.Version = inv.target.version
+	response.Logs = inv.output.redact(response.Logs)
+	response.Error = inv.output.redact(response.Error)
+	m.recordInvocation(inv.target, response)
+	m.saveInvocationRecord(inv, response)
+}
@@ -0,0 +1,108 @@
+package function
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// revisionMetrics counts invocation outcomes per function revision. Counts
+// are kept in memory and start from zero when the server restarts.
+type revisionMetrics struct {
+	mu        sync.Mutex
+	functions map[string]map[string]*models.RevisionMetrics // function -> version -> counts
+}
+
+func newRevisionMetrics() *revisionMetrics {
+	return &revisionMetrics{
+		functions: make(map[string]map[string]*models.RevisionMetrics),
+	}
+}
+
+// record adds one invocation outcome for a revision
+func (rm *revisionMetrics) record(function, version string, success bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	revisions, ok := rm.functions[function]
+	if !ok {
+		revisions = make(map[string]*models.RevisionMetrics)
+		rm.functions[function] = revisions
+	}
+	stats, ok := revisions[version]
+	if !ok {
+		stats = &models.RevisionMetrics{Version: version}
+		revisions[version] = stats
+	}
+
+	now := time.Now()
+	stats.Invocations++
+	stats.LastInvokedAt = &now
+	if success {
+		stats.Successes++
+	} else {
+		stats.Errors++
+	}
+}
+
+// get returns a copy of the counts for a function, sorted by version
+func (rm *revisionMetrics) get(function string) []models.RevisionMetrics {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	result := make([]models.RevisionMetrics, 0, len(rm.functions[function]))
+	for _, stats := range rm.functions[function] {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		vi, iok := models.ParseVersion(result[i].Version)
+		vj, jok := models.ParseVersion(result[j].Version)
+		if iok && jok {
+			return vi < vj
+		}
+		// $LATEST sorts after published versions
+		return iok
+	})
+	return result
+}
+
+// reset drops the counts of a deleted function
+func (rm *revisionMetrics) reset(function string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.functions, function)
+}
+
+// recordInvocation records the outcome of an invocation of target
+func (m *Manager) recordInvocation(target *invocationTarget, response *models.InvocationResponse) {
+	success := response.Error == "" && response.StatusCode < 500
+	m.metrics.record(target.fn.Name, target.version, success)
+}
+
+// RevisionMetrics returns per-revision invocation counts for a function.
+// If alias is set, only the revisions that alias routes to are returned.
+func (m *Manager) RevisionMetrics(name, alias string) ([]models.RevisionMetrics, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+
+	all := m.metrics.get(name)
+	if alias == "" {
+		return all, nil
+	}
+
+	a, err := m.GetAlias(name, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]models.RevisionMetrics, 0, 2)
+	for _, stats := range all {
+		if version, ok := models.ParseVersion(stats.Version); ok && a.RoutesTo(version) {
+			filtered = append(filtered, stats)
+		}
+	}
+	return filtered, nil
+}
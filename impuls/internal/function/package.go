@@ -0,0 +1,409 @@
+package function
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// Limits on code packages
+const (
+	maxPackageBytes  = 50 << 20  // the archive, after base64 decoding
+	maxUnpackedBytes = 250 << 20 // all files together, once unpacked
+	maxPackageFiles  = 10000     // files and directories
+)
+
+// packageDir is the directory of a local runner that packages are unpacked
+// into, next to the runner's own files
+const packageDir = "code"
+
+var (
+	handlerModulePattern   = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_./-]*$`)
+	handlerFunctionPattern = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*$`)
+
+	errPackageTooLarge = fmt.Errorf("package unpacks to more than %d MiB", maxUnpackedBytes>>20)
+)
+
+// validateCode checks a function's code against its format. A package must
+// unpack within the limits and contain the handler's module.
+func validateCode(fn *models.Function, code []byte) error {
+	switch models.GetRuntimeLanguage(fn.Runtime) {
+	case "go":
+		if !goHandlerPattern.MatchString(fn.Handler) {
+			return &models.ValidationError{Field: "handler", Message: fmt.Sprintf("invalid handler: %s (expected the name of a function in package main)", fn.Handler)}
+		}
+	case "provided":
+		if !fn.CodeFormat.IsArchive() {
+			return &models.ValidationError{Field: "code_format", Message: "the provided runtime runs a binary, deploy it as a zip or tar.gz package"}
+		}
+	}
+	if !fn.CodeFormat.IsArchive() {
+		return nil
+	}
+
+	var names []string
+	err := walkPackage(fn.CodeFormat, code, func(name string, mode os.FileMode, r io.Reader) error {
+		names = append(names, name)
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+	if err != nil {
+		return &models.ValidationError{Field: "code", Message: err.Error()}
+	}
+
+	switch models.GetRuntimeLanguage(fn.Runtime) {
+	case "dotnet":
+		// The handler names a class, all .cs files are compiled together
+		for _, name := range names {
+			if strings.HasSuffix(name, ".cs") {
+				return nil
+			}
+		}
+		return &models.ValidationError{Field: "code", Message: "package contains no .cs files"}
+	case "go":
+		// The handler names a function, all .go files of the root are built
+		for _, name := range names {
+			if strings.HasSuffix(name, ".go") && !strings.Contains(name, "/") {
+				return nil
+			}
+		}
+		return &models.ValidationError{Field: "code", Message: "package contains no .go files at its root"}
+	case "provided":
+		// The handler is the path of the binary
+		for _, name := range names {
+			if name == fn.Handler {
+				return nil
+			}
+		}
+		return &models.ValidationError{Field: "handler", Message: fmt.Sprintf("binary %s not found in the package", fn.Handler)}
+	}
+
+	module, _, err := splitHandler(fn.Handler)
+	if err != nil {
+		return &models.ValidationError{Field: "handler", Message: err.Error()}
+	}
+	if !hasModule(fn.Runtime, module, names) {
+		return &models.ValidationError{Field: "handler", Message: fmt.Sprintf("module %s not found in the package", module)}
+	}
+	return nil
+}
+
+// splitHandler splits a "module.function" handler of a package. The module
+// is a path in the package tree without extension, e.g. "src/app" for
+// src/app.js; Python modules may use dots instead, e.g. "pkg.app".
+func splitHandler(handler string) (module, function string, err error) {
+	i := strings.LastIndex(handler, ".")
+	if i <= 0 || i == len(handler)-1 {
+		return "", "", fmt.Errorf("invalid handler format: %s (expected 'module.function')", handler)
+	}
+	module, function = handler[:i], handler[i+1:]
+
+	if !handlerModulePattern.MatchString(module) || !filepath.IsLocal(module) {
+		return "", "", fmt.Errorf("invalid handler module: %s", module)
+	}
+	if !handlerFunctionPattern.MatchString(function) {
+		return "", "", fmt.Errorf("invalid handler function: %s", function)
+	}
+	return module, function, nil
+}
+
+// hasModule reports whether a package with the given files has a module the
+// runtime can load
+func hasModule(runtime models.Runtime, module string, names []string) bool {
+	var candidates []string
+	switch models.GetRuntimeLanguage(runtime) {
+	case "nodejs":
+		candidates = []string{module + ".js", module + ".cjs", module + "/index.js"}
+	case "python":
+		base := strings.ReplaceAll(module, ".", "/")
+		candidates = []string{base + ".py", base + "/__init__.py"}
+	default:
+		return true
+	}
+
+	for _, name := range names {
+		for _, candidate := range candidates {
+			if name == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handlerModule returns the module to load a local runner's handler from
+// and the handler's function name. Plain source is always sourceModule.
+func handlerModule(fn *models.Function, sourceModule string) (module, function string, err error) {
+	if fn.CodeFormat.IsArchive() {
+		return splitHandler(fn.Handler)
+	}
+
+	// Parse handler (format: "filename.handlerFunction")
+	handlerParts := strings.SplitN(fn.Handler, ".", 2)
+	if len(handlerParts) != 2 {
+		return "", "", fmt.Errorf("invalid handler format: %s (expected 'module.function')", fn.Handler)
+	}
+	return sourceModule, handlerParts[1], nil
+}
+
+// localCode returns the directory a local runner loads a target's code from.
+// A package unpacked at deploy time is run where it is, unless layers have
+// to be merged with it; other code is written into dir by writeCode.
+// Runners find installed dependencies on their search path, as they are
+// not linked into an unpacked package.
+func localCode(dir string, target *invocationTarget, sourceFile string) (string, error) {
+	if target.dir != "" && len(target.layers) == 0 {
+		return target.dir, nil
+	}
+	return writeCode(dir, target, sourceFile)
+}
+
+// writeCode writes a target's code for a local runner and returns the
+// directory it is in. Plain source goes into sourceFile in dir, a package is
+// unpacked into dir's code directory and gets the installed dependencies, if
+// any. The target's layers are unpacked into the same directory first, so
+// the function's own files replace theirs.
+func writeCode(dir string, target *invocationTarget, sourceFile string) (string, error) {
+	fn := target.fn
+	codeDir := dir
+	if fn.CodeFormat.IsArchive() {
+		codeDir = filepath.Join(dir, packageDir)
+	}
+
+	for _, layer := range target.layers {
+		if err := unpackPackage(layer.CodeFormat, []byte(layer.Content), codeDir); err != nil {
+			return "", fmt.Errorf("failed to unpack layer %s: %w", layer.Ref(), err)
+		}
+	}
+
+	if !fn.CodeFormat.IsArchive() {
+		if err := os.WriteFile(filepath.Join(dir, sourceFile), target.code, 0644); err != nil {
+			return "", fmt.Errorf("failed to write function code: %w", err)
+		}
+		return dir, nil
+	}
+
+	if err := unpackPackage(fn.CodeFormat, target.code, codeDir); err != nil {
+		return "", fmt.Errorf("failed to unpack function code: %w", err)
+	}
+	if target.deps != "" {
+		if err := linkDependencies(target.deps, codeDir); err != nil {
+			return "", err
+		}
+	}
+	return codeDir, nil
+}
+
+// unpackPackage unpacks a package into dir
+func unpackPackage(format models.CodeFormat, code []byte, dir string) error {
+	return walkPackage(format, code, func(name string, mode os.FileMode, r io.Reader) error {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		perm := os.FileMode(0644)
+		if mode&0111 != 0 {
+			perm = 0755
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// packageFiles reads a package into a map of paths to file contents, the
+// form guest runtimes receive it in
+func packageFiles(format models.CodeFormat, code []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := walkPackage(format, code, func(name string, mode os.FileMode, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		files[name] = data
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// packageID identifies a package's content, the handler loaded from it and
+// the dependencies installed with it, so guests unpack it only once and load
+// another handler when only the handler changed
+func packageID(code []byte, handler, deps string) string {
+	h := sha256.New()
+	h.Write(code)
+	h.Write([]byte{0})
+	h.Write([]byte(handler))
+	h.Write([]byte{0})
+	// Artifacts are named by the hash of the dependency files
+	h.Write([]byte(filepath.Base(deps)))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// walkPackage calls visit for every regular file in a base64 encoded
+// archive. It fails on archives over the size limits, on links and special
+// files, and on paths that leave the package tree.
+func walkPackage(format models.CodeFormat, code []byte, visit func(name string, mode os.FileMode, r io.Reader) error) error {
+	encoded := strings.Join(strings.Fields(string(code)), "")
+	if len(encoded) > base64.StdEncoding.EncodedLen(maxPackageBytes) {
+		return fmt.Errorf("package is larger than %d MiB", maxPackageBytes>>20)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("package is not valid base64: %w", err)
+	}
+	if len(data) > maxPackageBytes {
+		return fmt.Errorf("package is larger than %d MiB", maxPackageBytes>>20)
+	}
+
+	w := &packageWalker{visit: visit, budget: maxUnpackedBytes}
+	switch format {
+	case models.CodeFormatZip:
+		err = w.walkZip(data)
+	case models.CodeFormatTarGz:
+		err = w.walkTarGz(data)
+	default:
+		return fmt.Errorf("unsupported code format: %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if w.files == 0 {
+		return errors.New("package contains no files")
+	}
+	return nil
+}
+
+// packageWalker enforces the package limits across all entries of an
+// archive
+type packageWalker struct {
+	visit   func(name string, mode os.FileMode, r io.Reader) error
+	budget  int64 // bytes left to unpack
+	entries int
+	files   int
+}
+
+func (w *packageWalker) walkZip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if err := w.entry(f.Name, mode); err != nil {
+			return err
+		}
+		if mode.IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		err = w.file(f.Name, mode, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *packageWalker) walkTarGz(data []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid tar.gz archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar.gz archive: %w", err)
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		mode := hdr.FileInfo().Mode()
+		if err := w.entry(hdr.Name, mode); err != nil {
+			return err
+		}
+		if mode.IsDir() {
+			continue
+		}
+		if err := w.file(hdr.Name, mode, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// entry checks an archive entry before it is read
+func (w *packageWalker) entry(name string, mode os.FileMode) error {
+	w.entries++
+	if w.entries > maxPackageFiles {
+		return fmt.Errorf("package has more than %d entries", maxPackageFiles)
+	}
+	if strings.Contains(name, `\`) || !filepath.IsLocal(name) {
+		return fmt.Errorf("%s: path leaves the package", name)
+	}
+	if !mode.IsDir() && !mode.IsRegular() {
+		return fmt.Errorf("%s: only regular files and directories are allowed", name)
+	}
+	return nil
+}
+
+// file hands the content of a regular file to visit, within the budget
+func (w *packageWalker) file(name string, mode os.FileMode, r io.Reader) error {
+	w.files++
+	if err := w.visit(path.Clean(name), mode, &budgetReader{r: r, budget: &w.budget}); err != nil {
+		if errors.Is(err, errPackageTooLarge) {
+			return err
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// budgetReader fails once more than the remaining budget was read through
+// it, so sizes claimed by archive headers need not be trusted
+type budgetReader struct {
+	r      io.Reader
+	budget *int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	*b.budget -= int64(n)
+	if *b.budget < 0 {
+		return n, errPackageTooLarge
+	}
+	return n, err
+}
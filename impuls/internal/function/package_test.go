@@ -0,0 +1,180 @@
+package function
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPackageIDCoversHandler(t *testing.T) {
+	code := []byte("package")
+	if packageID(code, "index.first", "") == packageID(code, "index.second", "") {
+		t.Error("Expected packages loaded with different handlers to have different IDs")
+	}
+	if packageID(code, "index.first", "/deps/a") == packageID(code, "index.first", "/deps/b") {
+		t.Error("Expected packages with different dependencies to have different IDs")
+	}
+}
+
+// TestWarmRuntimeReloadsChangedHandler invokes a running guest runtime
+// twice with the same code and only the handler changed, as a warm VM sees
+// after an update of the function's handler
+func TestWarmRuntimeReloadsChangedHandler(t *testing.T) {
+	runtimes := []struct {
+		name, bin, script, module, code string
+	}{
+		{"nodejs", "node", "nodejs/runtime.js", "index.js",
+			"exports.first = () => 'first';\nexports.second = () => 'second';\n"},
+		{"python", "python3", "python/runtime.py", "handler.py",
+			"def first(event, context):\n    return 'first'\n\ndef second(event, context):\n    return 'second'\n"},
+	}
+	for _, rt := range runtimes {
+		t.Run(rt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(rt.bin); err != nil {
+				t.Skipf("%s is not installed", rt.bin)
+			}
+			url := startRuntime(t, rt.bin, filepath.Join("..", "..", "runtimes", rt.script))
+			module := rt.module[:len(rt.module)-len(filepath.Ext(rt.module))]
+			files := map[string]string{rt.module: base64.StdEncoding.EncodeToString([]byte(rt.code))}
+
+			handlers := []string{"first", "second", "first"}
+			for _, name := range handlers {
+				handler := module + "." + name
+				if got := invokeRuntime(t, url, map[string]interface{}{"handler": handler, "code": rt.code}); got != name {
+					t.Errorf("Expected source loaded with %s to return %q, got %q", handler, name, got)
+				}
+			}
+			for _, name := range handlers {
+				handler := module + "." + name
+				payload := map[string]interface{}{
+					"handler":    handler,
+					"files":      files,
+					"package_id": packageID([]byte(rt.code), handler, ""),
+				}
+				if got := invokeRuntime(t, url, payload); got != name {
+					t.Errorf("Expected package loaded with %s to return %q, got %q", handler, name, got)
+				}
+			}
+		})
+	}
+}
+
+// TestRuntimeReseed asks guest runtimes to reseed as the host does after
+// restoring a VM from a snapshot. Node.js cannot reseed V8 in place and
+// exits for its bootstrap to start it again.
+func TestRuntimeReseed(t *testing.T) {
+	runtimes := []struct {
+		name, bin, script string
+		restart           bool
+	}{
+		{"nodejs", "node", "nodejs/runtime.js", true},
+		{"python", "python3", "python/runtime.py", false},
+	}
+	for _, rt := range runtimes {
+		t.Run(rt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(rt.bin); err != nil {
+				t.Skipf("%s is not installed", rt.bin)
+			}
+			url := startRuntime(t, rt.bin, filepath.Join("..", "..", "runtimes", rt.script))
+
+			body, _ := json.Marshal(map[string][]byte{"entropy": bytes.Repeat([]byte{7}, 64)})
+			resp, err := http.Post(url+"/reseed", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var result struct {
+				Restart bool `json:"restart"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK || result.Restart != rt.restart {
+				t.Fatalf("Expected status 200 and restart %v, got %d and %v", rt.restart, resp.StatusCode, result.Restart)
+			}
+
+			if !rt.restart {
+				if got := invokeRuntime(t, url, map[string]interface{}{"handler": "index.handler", "code": "def handler(event, context):\n    return 'ok'\n"}); got != "ok" {
+					t.Errorf("Expected the runtime to keep serving, got %q", got)
+				}
+				return
+			}
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+				resp, err := http.Get(url + "/health")
+				if err != nil {
+					break
+				}
+				resp.Body.Close()
+				if time.Now().After(deadline) {
+					t.Fatal("Expected the runtime to exit for a restart")
+				}
+			}
+		})
+	}
+}
+
+// startRuntime runs a guest runtime on a free port and returns its URL
+func startRuntime(t *testing.T, bin, script string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command(bin, script)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("RUNTIME_PORT=%d", port),
+		"FUNCTION_DIR="+t.TempDir(),
+		"LAYER_DIR="+filepath.Join(t.TempDir(), "layer"),
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if resp, err := http.Get(url + "/health"); err == nil {
+			resp.Body.Close()
+			return url
+		}
+	}
+	t.Fatalf("%s did not start", script)
+	return ""
+}
+
+// invokeRuntime sends an invocation to a guest runtime and returns the body
+// of its response
+func invokeRuntime(t *testing.T, url string, payload map[string]interface{}) string {
+	payload["event"] = map[string]interface{}{}
+	body, _ := json.Marshal(payload)
+	resp, err := http.Post(url+"/invoke", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Body  interface{} `json:"body"`
+		Error string      `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != "" {
+		t.Fatalf("Invocation failed: %s", result.Error)
+	}
+	return fmt.Sprint(result.Body)
+}
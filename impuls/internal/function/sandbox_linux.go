@@ -0,0 +1,492 @@
+package function
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sandboxInitArg is the name the server runs itself under to set up a
+// sandbox: as the first process in the new namespaces, it builds the
+// sandbox's file system and then executes the runtime
+const sandboxInitArg = "impuls-sandbox-init"
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == sandboxInitArg {
+		os.Exit(runSandboxInit(os.Args[1:]))
+	}
+}
+
+// sandboxSystemDirs are bound read-only into every sandbox, for the
+// runtimes and the libraries they load
+var sandboxSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32"}
+
+// sandboxEtcFiles are the only files of the host's /etc a sandbox sees:
+// name resolution, the time zone, CA certificates and the loader's cache.
+// Credentials and service configuration stay hidden.
+var sandboxEtcFiles = []string{
+	"/etc/hosts", "/etc/resolv.conf", "/etc/nsswitch.conf", "/etc/localtime",
+	"/etc/ld.so.cache", "/etc/ssl/certs", "/etc/ssl/openssl.cnf",
+}
+
+// sandboxPasswd and sandboxGroup stand in for the host's user and group
+// databases, which only need to know the sandbox's root
+const (
+	sandboxPasswd = "sandbox:x:0:0:sandbox:/tmp:/sbin/nologin\n"
+	sandboxGroup  = "sandbox:x:0:\n"
+)
+
+// sandboxDevices are the device files a sandbox gets from the host
+var sandboxDevices = []string{"null", "zero", "full", "random", "urandom"}
+
+// sandbox runs the processes of local invocations in new user, mount, pid
+// and network namespaces. They see the host's system directories and their
+// runtime read-only, their working directory and a tmpfs scratch directory,
+// and have no network unless it is enabled.
+type sandbox struct {
+	network bool // share the server's network
+	uid     int  // host user the sandbox's root is
+	gid     int  // host group the sandbox's root is
+}
+
+// sandboxSpec is what the sandbox init sets up, passed as its first
+// argument
+type sandboxSpec struct {
+	Root      string   `json:"root"` // empty directory the file system is built in
+	ReadOnly  []string `json:"read_only"`
+	Writable  []string `json:"writable"`
+	ScratchMB int      `json:"scratch_mb"`
+}
+
+// newSandbox checks that the host can create sandboxes by setting up an
+// empty one
+func newSandbox(network bool) (*sandbox, error) {
+	uid, gid, err := sandboxIDs()
+	if err != nil {
+		return nil, err
+	}
+	s := &sandbox{network: network, uid: uid, gid: gid}
+	cmd := &exec.Cmd{}
+	cleanup, err := s.wrap(cmd, &sandboxSpec{ScratchMB: 16}, network)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to set up a sandbox: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return s, nil
+}
+
+// sandboxIDs returns the host user and group the root of a sandbox is: the
+// server's own, unless the server runs as root. Sandboxes of a root server
+// run as the first of root's subordinate ids instead, so that they have
+// none of root's privileges on the host.
+func sandboxIDs() (uid, gid int, err error) {
+	uid, gid = os.Geteuid(), os.Getegid()
+	if uid != 0 {
+		return uid, gid, nil
+	}
+	if uid, err = subordinateID("/etc/subuid"); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = subordinateID("/etc/subgid"); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// subordinateID returns the first id of root's range in /etc/subuid or
+// /etc/subgid
+func subordinateID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) != 3 || (fields[0] != "root" && fields[0] != "0") {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil || start <= 0 {
+			continue
+		}
+		if count, err := strconv.Atoi(fields[2]); err == nil && count > 0 {
+			return start, nil
+		}
+	}
+	return 0, fmt.Errorf("the server runs as root, so sandboxes need a range for root in %s to run as", path)
+}
+
+// prepare makes an invocation's command run in a sandbox. The command's
+// directory is writable, the runtime it runs, any files named by absolute
+// path in its arguments, the function's unpacked package and its
+// dependencies are read-only. Home and temporary directory are the scratch
+// directory, sized like the function's memory. Functions with no_network
+// never get a network. Call cleanup once the command has exited.
+func (s *sandbox) prepare(cmd *exec.Cmd, inv *Invocation) (func(), error) {
+	spec := &sandboxSpec{ScratchMB: inv.target.fn.MemoryMB}
+	if cmd.Dir != "" && cmd.Dir != inv.target.dir {
+		spec.Writable = append(spec.Writable, cmd.Dir)
+	}
+
+	paths := []string{cmd.Path}
+	if resolved, err := filepath.EvalSymlinks(cmd.Path); err == nil {
+		paths = append(paths, resolved)
+	}
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		if filepath.Base(dir) == "bin" && filepath.Dir(dir) != "/" {
+			// The runtime's prefix, with the libraries next to its bin
+			dir = filepath.Dir(dir)
+		}
+		spec.ReadOnly = append(spec.ReadOnly, dir)
+	}
+	for _, arg := range cmd.Args[1:] {
+		if filepath.IsAbs(arg) {
+			if _, err := os.Stat(arg); err == nil {
+				spec.ReadOnly = append(spec.ReadOnly, filepath.Dir(arg))
+			}
+		}
+	}
+	if inv.target.dir != "" {
+		spec.ReadOnly = append(spec.ReadOnly, inv.target.dir)
+	}
+	if inv.target.deps != "" {
+		spec.ReadOnly = append(spec.ReadOnly, inv.target.deps)
+	}
+
+	for i, kv := range cmd.Env {
+		if strings.HasPrefix(kv, "HOME=") || strings.HasPrefix(kv, "TMPDIR=") {
+			key, _, _ := strings.Cut(kv, "=")
+			cmd.Env[i] = key + "=/tmp"
+		}
+	}
+	for _, dir := range spec.Writable {
+		if err := s.own(dir); err != nil {
+			return nil, err
+		}
+	}
+	return s.wrap(cmd, spec, s.network && !inv.target.fn.NoNetwork)
+}
+
+// wrap makes cmd start the sandbox init, which runs the command once it
+// has set up spec. A command without a path only sets the sandbox up.
+func (s *sandbox) wrap(cmd *exec.Cmd, spec *sandboxSpec, network bool) (func(), error) {
+	root, err := os.MkdirTemp("", "impuls-sandbox-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox root: %w", err)
+	}
+	if err := s.own(root); err != nil {
+		os.Remove(root)
+		return nil, err
+	}
+	spec.Root = root
+	spec.ReadOnly = sandboxPaths(spec.ReadOnly, spec.Writable)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		os.Remove(root)
+		return nil, fmt.Errorf("failed to marshal sandbox: %w", err)
+	}
+
+	args := []string{sandboxInitArg, string(data)}
+	if cmd.Path != "" {
+		args = append(args, cmd.Path)
+		args = append(args, cmd.Args...)
+	}
+	cmd.Path, cmd.Args = "/proc/self/exe", args
+
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID)
+	if !network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= flags
+	// The sandbox's root is an unprivileged user, without groups
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: s.uid, Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: s.gid, Size: 1}}
+	if s.uid != os.Geteuid() || s.gid != os.Getegid() {
+		// The process starts as the server's user, which is not mapped, and
+		// switches to the sandbox's root, dropping the server's groups
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+	}
+	return func() { os.Remove(root) }, nil
+}
+
+// own hands dir and everything in it to the sandbox's user, unless that is
+// the server's own
+func (s *sandbox) own(dir string) error {
+	if s.uid == os.Geteuid() && s.gid == os.Getegid() {
+		return nil
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, s.uid, s.gid)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hand %s to the sandbox: %w", dir, err)
+	}
+	return nil
+}
+
+// sandboxPaths returns the read-only paths to bind besides the system
+// directories: each one once and none of those already visible
+func sandboxPaths(readOnly, writable []string) []string {
+	covered := append(append([]string(nil), sandboxSystemDirs...), writable...)
+	var paths []string
+	for _, path := range readOnly {
+		path = filepath.Clean(path)
+		if !isBelowAny(path, covered) {
+			paths = append(paths, path)
+			covered = append(covered, path)
+		}
+	}
+	return paths
+}
+
+// isBelowAny reports whether path is one of dirs or below one of them
+func isBelowAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// runSandboxInit sets up the sandbox described by args[0] and executes the
+// command in args[1:], or just exits if there is none
+func runSandboxInit(args []string) int {
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: invalid spec: %v\n", err)
+		return 126
+	}
+	if err := setupSandbox(&spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 126
+	}
+	if len(args) < 3 {
+		return 0
+	}
+	err := syscall.Exec(args[1], args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: failed to run %s: %v\n", args[1], err)
+	return 127
+}
+
+// setupSandbox builds the sandbox's file system on a tmpfs and makes it
+// the root
+func setupSandbox(spec *sandboxSpec) error {
+	// Keep the mounts below out of the server's namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	root := spec.Root
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, fmt.Sprintf("size=%dm,mode=0755", spec.ScratchMB)); err != nil {
+		return fmt.Errorf("failed to mount scratch: %w", err)
+	}
+	for _, dir := range []string{"tmp", "dev/shm"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(filepath.Join(root, dir), os.ModeSticky|0777); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range sandboxSystemDirs {
+		info, err := os.Lstat(dir)
+		switch {
+		case err != nil:
+			continue
+		case info.Mode()&os.ModeSymlink != 0:
+			// Such as /bin on systems with a merged /usr
+			target, err := os.Readlink(dir)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, filepath.Join(root, dir)); err != nil {
+				return err
+			}
+		default:
+			if err := bindMount(dir, filepath.Join(root, dir), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		return err
+	}
+	for name, content := range map[string]string{"passwd": sandboxPasswd, "group": sandboxGroup} {
+		if err := os.WriteFile(filepath.Join(root, "etc", name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	for _, path := range sandboxEtcFiles {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := bindMount(path, filepath.Join(root, path), true); err != nil {
+			return err
+		}
+	}
+
+	// Parents before the paths below them
+	type bind struct {
+		path     string
+		readOnly bool
+	}
+	var binds []bind
+	for _, path := range spec.ReadOnly {
+		binds = append(binds, bind{path, true})
+	}
+	for _, path := range spec.Writable {
+		binds = append(binds, bind{path, false})
+	}
+	sort.Slice(binds, func(i, j int) bool { return len(binds[i].path) < len(binds[j].path) })
+	for _, b := range binds {
+		if err := bindMount(b.path, filepath.Join(root, b.path), b.readOnly); err != nil {
+			return err
+		}
+	}
+
+	proc := filepath.Join(root, "proc")
+	if err := os.Mkdir(proc, 0555); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	for _, name := range sandboxDevices {
+		if err := bindMount("/dev/"+name, filepath.Join(root, "dev", name), false); err != nil {
+			return err
+		}
+	}
+	links := map[string]string{"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1", "stderr": "/proc/self/fd/2"}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, "dev", name)); err != nil {
+			return err
+		}
+	}
+
+	oldRoot := filepath.Join(root, ".oldroot")
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, oldRoot); err != nil {
+		return fmt.Errorf("failed to change root: %w", err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach the host's file system: %w", err)
+	}
+	if err := os.Remove("/.oldroot"); err != nil {
+		return err
+	}
+
+	if len(spec.Writable) > 0 {
+		return syscall.Chdir(spec.Writable[0])
+	}
+	return nil
+}
+
+// bindMount makes src visible at dst, read-only with all mounts below it
+// if readOnly is set
+func bindMount(src, dst string, readOnly bool) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(dst, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create mount point for %s: %w", src, err)
+	}
+
+	if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind %s: %w", src, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	mounts, err := mountsBelow(dst)
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if err := remountReadOnly(mount); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", src, err)
+		}
+	}
+	return nil
+}
+
+// remountReadOnly makes a bind mount read-only. The flags of the mount it
+// binds are kept, as a user namespace cannot clear them.
+func remountReadOnly(path string) error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for st, ms := range map[int64]uintptr{
+		0x2:    syscall.MS_NOSUID,
+		0x4:    syscall.MS_NODEV,
+		0x8:    syscall.MS_NOEXEC,
+		0x400:  syscall.MS_NOATIME,
+		0x800:  syscall.MS_NODIRATIME,
+		0x1000: syscall.MS_RELATIME,
+	} {
+		if int64(fs.Flags)&st != 0 {
+			flags |= ms
+		}
+	}
+	return syscall.Mount("", path, "", flags, "")
+}
+
+// mountsBelow lists the mount points at and below dir
+func mountsBelow(dir string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	unescape := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if path := unescape.Replace(fields[4]); isBelowAny(path, []string{dir}) {
+			mounts = append(mounts, path)
+		}
+	}
+	return mounts, scanner.Err()
+}
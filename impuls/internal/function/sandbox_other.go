@@ -0,0 +1,20 @@
+//go:build !linux
+
+package function
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// sandbox runs the processes of local invocations in new namespaces, which
+// only exist on Linux
+type sandbox struct{}
+
+func newSandbox(network bool) (*sandbox, error) {
+	return nil, errors.New("the sandbox is only supported on Linux")
+}
+
+func (s *sandbox) prepare(cmd *exec.Cmd, inv *Invocation) (func(), error) {
+	return nil, errors.New("the sandbox is only supported on Linux")
+}
@@ -0,0 +1,151 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/scheduler"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// CreateSchedule creates a cron or rate schedule for a function
+func (m *Manager) CreateSchedule(name string, req *models.CreateScheduleRequest) (*models.Schedule, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := m.resolve(name, req.Qualifier); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	nextRun, err := scheduler.NextRun(req.Expression, req.Timezone, now)
+	if err != nil {
+		return nil, &models.ValidationError{Field: "expression", Message: err.Error()}
+	}
+
+	schedule := &models.Schedule{
+		ID:           uuid.New().String(),
+		FunctionName: name,
+		Expression:   req.Expression,
+		Timezone:     req.Timezone,
+		Qualifier:    req.Qualifier,
+		Payload:      req.Payload,
+		JitterSec:    req.JitterSec,
+		Description:  req.Description,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		NextRunAt:    nextRun,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	if err := m.storage.CreateSchedule(schedule); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, &models.NotFoundError{Resource: "function", Name: name}
+		}
+		return nil, fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	return schedule, nil
+}
+
+// ListSchedules returns all schedules of a function
+func (m *Manager) ListSchedules(name string) ([]*models.Schedule, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+	return m.storage.ListSchedules(name)
+}
+
+// GetSchedule returns a schedule of a function
+func (m *Manager) GetSchedule(name, id string) (*models.Schedule, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+
+	schedule, err := m.storage.GetSchedule(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrScheduleNotFound) {
+			return nil, &models.NotFoundError{Resource: "schedule", Name: id}
+		}
+		return nil, err
+	}
+	if schedule.FunctionName != name {
+		return nil, &models.NotFoundError{Resource: "schedule", Name: id}
+	}
+	return schedule, nil
+}
+
+// UpdateSchedule updates a schedule. Changing the expression or timezone, or
+// enabling a disabled schedule, counts the next run from now.
+func (m *Manager) UpdateSchedule(name, id string, req *models.UpdateScheduleRequest) (*models.Schedule, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	schedule, err := m.GetSchedule(name, id)
+	if err != nil {
+		return nil, err
+	}
+	expectedNext := schedule.NextRunAt
+
+	reschedule := false
+	if req.Expression != nil && *req.Expression != schedule.Expression {
+		schedule.Expression = *req.Expression
+		reschedule = true
+	}
+	if req.Timezone != nil && *req.Timezone != schedule.Timezone {
+		schedule.Timezone = *req.Timezone
+		reschedule = true
+	}
+	if req.Enabled != nil {
+		if *req.Enabled && !schedule.Enabled {
+			reschedule = true
+		}
+		schedule.Enabled = *req.Enabled
+	}
+	if req.Qualifier != nil {
+		if _, err := m.resolve(name, *req.Qualifier); err != nil {
+			return nil, err
+		}
+		schedule.Qualifier = *req.Qualifier
+	}
+	if req.Payload != nil {
+		schedule.Payload = req.Payload
+	}
+	if req.JitterSec != nil {
+		schedule.JitterSec = *req.JitterSec
+	}
+	if req.Description != nil {
+		schedule.Description = *req.Description
+	}
+
+	now := time.Now()
+	if reschedule {
+		nextRun, err := scheduler.NextRun(schedule.Expression, schedule.Timezone, now)
+		if err != nil {
+			return nil, &models.ValidationError{Field: "expression", Message: err.Error()}
+		}
+		schedule.NextRunAt = nextRun
+	}
+	schedule.UpdatedAt = now
+
+	if err := m.storage.UpdateSchedule(schedule, expectedNext); err != nil {
+		if errors.Is(err, storage.ErrScheduleChanged) {
+			return nil, &models.ConflictError{Message: fmt.Sprintf("schedule %s fired while being updated, retry", id)}
+		}
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	return schedule, nil
+}
+
+// DeleteSchedule deletes a schedule
+func (m *Manager) DeleteSchedule(name, id string) error {
+	if _, err := m.GetSchedule(name, id); err != nil {
+		return err
+	}
+	return m.storage.DeleteSchedule(id)
+}
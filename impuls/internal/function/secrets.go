@@ -0,0 +1,246 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/secrets"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// redactedSecret replaces secret values in invocation logs and errors
+const redactedSecret = "[REDACTED]"
+
+// SetSecretsKey enables secrets, encrypted with the given master key
+func (m *Manager) SetSecretsKey(key *secrets.MasterKey) {
+	m.secretsKey = key
+}
+
+// secretsDisabled is returned when a secret value is needed on a server
+// without a master key
+func secretsDisabled() error {
+	return &models.UnavailableError{Message: "secrets are not enabled on this server, start it with a master key"}
+}
+
+// CreateSecret encrypts and stores a new secret. The returned secret leaves
+// out the value.
+func (m *Manager) CreateSecret(req *models.CreateSecretRequest) (*models.Secret, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if m.secretsKey == nil {
+		return nil, secretsDisabled()
+	}
+
+	envelope, err := m.secretsKey.Seal(req.Name, []byte(req.Value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	now := time.Now()
+	secret := &models.Secret{
+		Name:        req.Name,
+		Description: req.Description,
+		Envelope:    envelope,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := m.storage.CreateSecret(secret); err != nil {
+		if errors.Is(err, storage.ErrSecretExists) {
+			return nil, &models.ConflictError{Message: fmt.Sprintf("secret %s already exists", req.Name)}
+		}
+		return nil, fmt.Errorf("failed to create secret: %w", err)
+	}
+
+	secret.Envelope = nil
+	return secret, nil
+}
+
+// UpdateSecret replaces a secret's value or description. Functions get the
+// new value from their next invocation.
+func (m *Manager) UpdateSecret(name string, req *models.UpdateSecretRequest) (*models.Secret, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	secret, err := m.storage.GetSecret(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrSecretNotFound) {
+			return nil, &models.NotFoundError{Resource: "secret", Name: name}
+		}
+		return nil, err
+	}
+
+	if req.Description != nil {
+		secret.Description = *req.Description
+	}
+	if req.Value != nil {
+		if m.secretsKey == nil {
+			return nil, secretsDisabled()
+		}
+		if secret.Envelope, err = m.secretsKey.Seal(name, []byte(*req.Value)); err != nil {
+			return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+		}
+	}
+	secret.UpdatedAt = time.Now()
+
+	if err := m.storage.UpdateSecret(secret); err != nil {
+		if errors.Is(err, storage.ErrSecretNotFound) {
+			return nil, &models.NotFoundError{Resource: "secret", Name: name}
+		}
+		return nil, fmt.Errorf("failed to update secret: %w", err)
+	}
+
+	secret.Envelope = nil
+	return secret, nil
+}
+
+// GetSecret returns a secret without its value
+func (m *Manager) GetSecret(name string) (*models.Secret, error) {
+	secret, err := m.storage.GetSecret(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrSecretNotFound) {
+			return nil, &models.NotFoundError{Resource: "secret", Name: name}
+		}
+		return nil, err
+	}
+	secret.Envelope = nil
+	return secret, nil
+}
+
+// ListSecrets returns all secrets without their values
+func (m *Manager) ListSecrets() ([]*models.Secret, error) {
+	return m.storage.ListSecrets()
+}
+
+// DeleteSecret deletes a secret that no function or published version uses
+func (m *Manager) DeleteSecret(name string) error {
+	if _, err := m.GetSecret(name); err != nil {
+		return err
+	}
+
+	functions, err := m.storage.List()
+	if err != nil {
+		return fmt.Errorf("failed to list functions: %w", err)
+	}
+	for _, fn := range functions {
+		if usesSecret(fn.Secrets, name) {
+			return &models.ConflictError{Message: fmt.Sprintf("secret %s is used by function %s", name, fn.Name)}
+		}
+		versions, err := m.storage.ListVersions(fn.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list versions: %w", err)
+		}
+		for _, v := range versions {
+			if usesSecret(v.Secrets, name) {
+				return &models.ConflictError{Message: fmt.Sprintf("secret %s is used by version %d of function %s", name, v.Version, fn.Name)}
+			}
+		}
+	}
+
+	if err := m.storage.DeleteSecret(name); err != nil {
+		if errors.Is(err, storage.ErrSecretNotFound) {
+			return &models.NotFoundError{Resource: "secret", Name: name}
+		}
+		return err
+	}
+	return nil
+}
+
+// usesSecret reports whether a function's secrets refer to the named secret
+func usesSecret(refs map[string]string, name string) bool {
+	for _, secret := range refs {
+		if secret == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecrets checks that the secrets a function is given exist
+func (m *Manager) checkSecrets(refs, env map[string]string) error {
+	if err := models.ValidateSecretRefs(refs, env); err != nil {
+		return err
+	}
+	for _, name := range refs {
+		if _, err := m.storage.GetSecret(name); err != nil {
+			if errors.Is(err, storage.ErrSecretNotFound) {
+				return &models.ValidationError{Field: "secrets", Message: fmt.Sprintf("secret %s does not exist", name)}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSecrets decrypts the secrets a function runs with, by the environment
+// variables they are set to
+func (m *Manager) loadSecrets(fn *models.Function) (map[string]string, error) {
+	if len(fn.Secrets) == 0 {
+		return nil, nil
+	}
+	if m.secretsKey == nil {
+		return nil, secretsDisabled()
+	}
+
+	values := make(map[string]string, len(fn.Secrets))
+	for key, name := range fn.Secrets {
+		secret, err := m.storage.GetSecret(name)
+		if err != nil {
+			if errors.Is(err, storage.ErrSecretNotFound) {
+				return nil, &models.ConflictError{Message: fmt.Sprintf("secret %s of function %s no longer exists", name, fn.Name)}
+			}
+			return nil, fmt.Errorf("failed to get secret %s: %w", name, err)
+		}
+		value, err := m.secretsKey.Open(name, secret.Envelope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+		}
+		values[key] = string(value)
+	}
+	return values, nil
+}
+
+// invocationEnv returns the environment a target runs with: its environment
+// variables and its secrets
+func invocationEnv(target *invocationTarget) map[string]string {
+	if len(target.secrets) == 0 {
+		return target.fn.Environment
+	}
+	env := make(map[string]string, len(target.fn.Environment)+len(target.secrets))
+	for key, value := range target.fn.Environment {
+		env[key] = value
+	}
+	for key, value := range target.secrets {
+		env[key] = value
+	}
+	return env
+}
+
+// newRedactor returns a replacer that hides the given secret values, nil if
+// there are none. Longer values are replaced first, so a value containing
+// another is hidden whole.
+func newRedactor(values map[string]string) *strings.Replacer {
+	var hidden []string
+	for _, value := range values {
+		if value != "" {
+			hidden = append(hidden, value)
+		}
+	}
+	if len(hidden) == 0 {
+		return nil
+	}
+	sort.Slice(hidden, func(i, j int) bool {
+		return len(hidden[i]) > len(hidden[j])
+	})
+
+	pairs := make([]string, 0, 2*len(hidden))
+	for _, value := range hidden {
+		pairs = append(pairs, value, redactedSecret)
+	}
+	return strings.NewReplacer(pairs...)
+}
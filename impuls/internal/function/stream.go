@@ -0,0 +1,132 @@
+package function
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// maxChunkSize is the largest chunk a handler may write at once. Runtimes
+// reject larger ones, which keeps every chunk within one guest frame.
+const maxChunkSize = 8 << 20
+
+// responseStream receives the chunks a handler writes to its response.
+// Streamed invocations forward every chunk to the caller as it is written;
+// others collect them, and they make up the body of a handler that returns
+// nothing.
+type responseStream struct {
+	send func(chunk []byte) error // nil unless the invocation is streamed
+
+	mu   sync.Mutex
+	body bytes.Buffer
+	err  error // the first chunk the caller did not take
+}
+
+func newResponseStream(send func(chunk []byte) error) *responseStream {
+	return &responseStream{send: send}
+}
+
+// Write passes a chunk on. Once the caller has gone, further chunks are
+// dropped; the handler runs to completion regardless.
+func (s *responseStream) Write(chunk []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.send == nil {
+		return s.body.Write(chunk)
+	}
+	if s.err == nil && len(chunk) > 0 {
+		s.err = s.send(chunk)
+	}
+	return len(chunk), nil
+}
+
+// chunk passes on a chunk streamed by a guest runtime
+func (s *responseStream) chunk(data []byte) {
+	s.Write(data)
+}
+
+// finish completes the response once the handler has returned. A streamed
+// response ends with the handler's result, if any, as its last chunk;
+// otherwise the chunks written by a handler that returned nothing become
+// its body.
+func (s *responseStream) finish(response *models.InvocationResponse) {
+	if s.send == nil {
+		if response.Body == nil && response.Error == "" && s.body.Len() > 0 {
+			response.Body = s.body.String()
+		}
+		return
+	}
+	if response.Error == "" && response.Body != nil {
+		s.Write(encodeChunk(response.Body))
+	}
+	response.Body = nil
+}
+
+// encodeChunk encodes a result as a chunk: a string as its text, any other
+// value as JSON
+func encodeChunk(body interface{}) []byte {
+	if s, ok := body.(string); ok {
+		return []byte(s)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// chunkFrame is one line a local runner writes to its chunk pipe
+type chunkFrame struct {
+	Data []byte `json:"data"` // base64 in JSON
+}
+
+// chunkPipe is file descriptor 3 of a local runner, which the handler
+// writes the chunks of its response to as chunkFrame lines
+type chunkPipe struct {
+	r, w *os.File
+	done chan struct{}
+}
+
+// openChunkPipe gives a runner its chunk pipe and starts passing the
+// chunks read from it to stream. Close the pipe once the runner has exited.
+func openChunkPipe(cmd *exec.Cmd, stream *responseStream) (*chunkPipe, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk pipe: %w", err)
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	p := &chunkPipe{r: r, w: w, done: make(chan struct{})}
+
+	go func() {
+		defer close(p.done)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			var frame chunkFrame
+			if len(line) > 0 && json.Unmarshal(line, &frame) == nil {
+				stream.Write(frame.Data)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// close waits for the chunks still in the pipe. Children of the function
+// that hold on to it are not waited for.
+func (p *chunkPipe) close() {
+	p.w.Close()
+	p.r.SetReadDeadline(time.Now().Add(time.Second))
+	<-p.done
+	p.r.Close()
+}
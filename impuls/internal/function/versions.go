@@ -0,0 +1,337 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// invocationTarget is the resolved code and configuration an invocation runs
+type invocationTarget struct {
+	fn        *models.Function
+	code      []byte
+	version   string            // models.LatestVersion or a published version number
+	qualifier string            // as requested by the caller
+	dir       string            // the package unpacked at deploy time, if the storage keeps one
+	deps      string            // installed dependencies, set before the target runs
+	layers    []*models.Layer   // set with deps
+	secrets   map[string]string // environment variables set to secret values, set with deps
+}
+
+// PublishVersion publishes the function's current code and configuration as
+// a new immutable version. If nothing changed since the latest version, that
+// version is returned instead of publishing a duplicate.
+func (m *Manager) PublishVersion(name string, req *models.PublishVersionRequest) (*models.FunctionVersion, error) {
+	fn, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions run with the dependencies installed when they were published
+	var dependenciesHash string
+	if fn.Build != nil {
+		if fn.Build.Status != models.BuildReady {
+			return nil, &models.ConflictError{Message: fmt.Sprintf("dependency build of function %s is %s, not ready", name, fn.Build.Status)}
+		}
+		dependenciesHash = fn.Build.DependenciesHash
+	}
+
+	code, err := m.storage.GetCode(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get function code: %w", err)
+	}
+
+	versions, err := m.storage.ListVersions(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+
+	// Functions published before the counter existed continue after their
+	// latest version
+	next := max(fn.NextVersion, 1)
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		if sameVersionConfig(latest, fn, code) && latest.DependenciesHash == dependenciesHash {
+			return latest, nil
+		}
+		next = max(next, latest.Version+1)
+	}
+
+	version := &models.FunctionVersion{
+		FunctionName:     fn.Name,
+		Version:          next,
+		Description:      req.Description,
+		Runtime:          fn.Runtime,
+		Handler:          fn.Handler,
+		Code:             string(code),
+		CodeFormat:       fn.CodeFormat,
+		DependenciesHash: dependenciesHash,
+		MemoryMB:         fn.MemoryMB,
+		TimeoutSec:       fn.TimeoutSec,
+		Environment:      fn.Environment,
+		Layers:           fn.Layers,
+		Secrets:          fn.Secrets,
+		CreatedAt:        time.Now(),
+	}
+
+	if err := m.storage.CreateVersion(version); err != nil {
+		if errors.Is(err, storage.ErrVersionExists) {
+			return nil, &models.ConflictError{Message: fmt.Sprintf("version %d of function %s was published concurrently, retry", next, name)}
+		}
+		return nil, fmt.Errorf("failed to publish version: %w", err)
+	}
+
+	if err := m.storage.SetNextVersion(name, next+1); err != nil {
+		return nil, fmt.Errorf("failed to record next version: %w", err)
+	}
+
+	return version, nil
+}
+
+// sameVersionConfig reports whether a published version matches the
+// function's current code and configuration
+func sameVersionConfig(v *models.FunctionVersion, fn *models.Function, code []byte) bool {
+	return v.Runtime == fn.Runtime &&
+		v.Handler == fn.Handler &&
+		v.Code == string(code) &&
+		v.CodeFormat == fn.CodeFormat &&
+		v.MemoryMB == fn.MemoryMB &&
+		v.TimeoutSec == fn.TimeoutSec &&
+		(len(v.Environment) == 0 && len(fn.Environment) == 0 || reflect.DeepEqual(v.Environment, fn.Environment)) &&
+		slices.Equal(v.Layers, fn.Layers) &&
+		maps.Equal(v.Secrets, fn.Secrets)
+}
+
+// ListVersions returns all published versions of a function
+func (m *Manager) ListVersions(name string) ([]*models.FunctionVersion, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+	return m.storage.ListVersions(name)
+}
+
+// GetVersion returns a published version of a function
+func (m *Manager) GetVersion(name string, version int) (*models.FunctionVersion, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+
+	v, err := m.storage.GetVersion(name, version)
+	if err != nil {
+		if errors.Is(err, storage.ErrVersionNotFound) {
+			return nil, &models.NotFoundError{Resource: "version", Name: fmt.Sprintf("%d of function %s", version, name)}
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// DeleteVersion deletes a published version that no alias points at
+func (m *Manager) DeleteVersion(name string, version int) error {
+	if _, err := m.GetVersion(name, version); err != nil {
+		return err
+	}
+
+	aliases, err := m.storage.ListAliases(name)
+	if err != nil {
+		return fmt.Errorf("failed to list aliases: %w", err)
+	}
+	for _, a := range aliases {
+		if a.RoutesTo(version) {
+			return &models.ConflictError{Message: fmt.Sprintf("version %d is referenced by alias %s", version, a.Name)}
+		}
+	}
+
+	return m.storage.DeleteVersion(name, version)
+}
+
+// CreateAlias creates an alias pointing at a published version
+func (m *Manager) CreateAlias(name string, req *models.CreateAliasRequest) (*models.Alias, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := m.GetVersion(name, req.Version); err != nil {
+		return nil, err
+	}
+
+	alias := &models.Alias{
+		FunctionName: name,
+		Name:         req.Name,
+		Version:      req.Version,
+		Description:  req.Description,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if req.RoutingConfig != nil && req.RoutingConfig.Weight > 0 {
+		if _, err := m.GetVersion(name, req.RoutingConfig.AdditionalVersion); err != nil {
+			return nil, err
+		}
+		routing := *req.RoutingConfig
+		alias.RoutingConfig = &routing
+	}
+
+	if err := m.storage.CreateAlias(alias); err != nil {
+		if errors.Is(err, storage.ErrAliasExists) {
+			return nil, &models.ConflictError{Message: fmt.Sprintf("alias %s already exists", req.Name)}
+		}
+		return nil, fmt.Errorf("failed to create alias: %w", err)
+	}
+
+	return alias, nil
+}
+
+// GetAlias returns an alias of a function
+func (m *Manager) GetAlias(name, aliasName string) (*models.Alias, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+
+	alias, err := m.storage.GetAlias(name, aliasName)
+	if err != nil {
+		if errors.Is(err, storage.ErrAliasNotFound) {
+			return nil, &models.NotFoundError{Resource: "alias", Name: aliasName}
+		}
+		return nil, err
+	}
+	return alias, nil
+}
+
+// UpdateAlias repoints an alias or changes its description or traffic split.
+// Pointing the alias at its additional version promotes that version and
+// removes the split.
+func (m *Manager) UpdateAlias(name, aliasName string, req *models.UpdateAliasRequest) (*models.Alias, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	alias, err := m.GetAlias(name, aliasName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work on a copy so a failed update leaves the stored alias untouched
+	updated := *alias
+	if req.Version != nil {
+		if _, err := m.GetVersion(name, *req.Version); err != nil {
+			return nil, err
+		}
+		updated.Version = *req.Version
+	}
+	if req.Description != nil {
+		updated.Description = *req.Description
+	}
+	if req.RoutingConfig == nil && updated.RoutingConfig != nil && updated.RoutingConfig.AdditionalVersion == updated.Version {
+		updated.RoutingConfig = nil
+	}
+	if req.RoutingConfig != nil {
+		updated.RoutingConfig = nil
+		if req.RoutingConfig.Weight != 0 {
+			routing := *req.RoutingConfig
+			updated.RoutingConfig = &routing
+		}
+	}
+	if updated.RoutingConfig != nil {
+		if err := updated.RoutingConfig.Validate(updated.Version); err != nil {
+			return nil, err
+		}
+		if _, err := m.GetVersion(name, updated.RoutingConfig.AdditionalVersion); err != nil {
+			return nil, err
+		}
+	}
+	updated.UpdatedAt = time.Now()
+
+	if err := m.storage.UpdateAlias(&updated); err != nil {
+		return nil, fmt.Errorf("failed to update alias: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// ListAliases returns all aliases of a function
+func (m *Manager) ListAliases(name string) ([]*models.Alias, error) {
+	if _, err := m.Get(name); err != nil {
+		return nil, err
+	}
+	return m.storage.ListAliases(name)
+}
+
+// DeleteAlias deletes an alias
+func (m *Manager) DeleteAlias(name, aliasName string) error {
+	if _, err := m.GetAlias(name, aliasName); err != nil {
+		return err
+	}
+	return m.storage.DeleteAlias(name, aliasName)
+}
+
+// resolve returns the code and configuration an invocation of the given
+// qualifier runs. The qualifier is empty or $LATEST for the unpublished
+// function, a version number, or an alias name. Aliases with a routing
+// config pick one of their two versions by weight.
+func (m *Manager) resolve(name, qualifier string) (*invocationTarget, error) {
+	fn, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if qualifier == "" || qualifier == models.LatestVersion {
+		code, err := m.storage.GetCode(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get function code: %w", err)
+		}
+		target := &invocationTarget{fn: fn, code: code, version: models.LatestVersion, qualifier: qualifier}
+		if info, err := os.Stat(fn.CodePath); err == nil && info.IsDir() && fn.CodeFormat.IsArchive() {
+			target.dir = fn.CodePath
+		}
+		return target, nil
+	}
+
+	version, ok := models.ParseVersion(qualifier)
+	if !ok {
+		alias, err := m.GetAlias(name, qualifier)
+		if err != nil {
+			return nil, err
+		}
+		version = alias.PickVersion(m.randFloat())
+	}
+
+	v, err := m.GetVersion(name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invocationTarget{
+		fn:        versionedFunction(fn, v),
+		code:      []byte(v.Code),
+		version:   strconv.Itoa(v.Version),
+		qualifier: qualifier,
+	}, nil
+}
+
+// versionedFunction returns a copy of fn with a published version's code and
+// configuration
+func versionedFunction(fn *models.Function, v *models.FunctionVersion) *models.Function {
+	versioned := *fn
+	versioned.Runtime = v.Runtime
+	versioned.Handler = v.Handler
+	versioned.Code = v.Code
+	versioned.CodeFormat = v.CodeFormat
+	versioned.CodePath = ""
+	versioned.MemoryMB = v.MemoryMB
+	versioned.TimeoutSec = v.TimeoutSec
+	versioned.Environment = v.Environment
+	versioned.Layers = v.Layers
+	versioned.Secrets = v.Secrets
+	versioned.Build = nil
+	if v.DependenciesHash != "" {
+		versioned.Build = &models.FunctionBuild{Status: models.BuildReady, DependenciesHash: v.DependenciesHash}
+	}
+	return &versioned
+}
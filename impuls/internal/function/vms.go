@@ -0,0 +1,64 @@
+package function
+
+import (
+	"sort"
+
+	"github.com/oblak/impuls/internal/firecracker"
+	"github.com/oblak/impuls/internal/models"
+)
+
+// ListVMs returns the VMs on this host, oldest first. functionName limits
+// them to VMs that served that function if set.
+func (m *Manager) ListVMs(functionName string) []firecracker.VMInfo {
+	vms := []firecracker.VMInfo{}
+	if m.fcManager == nil {
+		return vms
+	}
+
+	for _, vm := range m.fcManager.ListVMs() {
+		info := vm.Info()
+		if functionName != "" && info.Function != functionName {
+			continue
+		}
+		vms = append(vms, info)
+	}
+	sort.Slice(vms, func(i, j int) bool {
+		return vms[i].CreatedAt.Before(vms[j].CreatedAt)
+	})
+	return vms
+}
+
+// GetVM returns a VM on this host
+func (m *Manager) GetVM(id string) (*firecracker.VMInfo, error) {
+	if m.fcManager == nil {
+		return nil, &models.NotFoundError{Resource: "VM", Name: id}
+	}
+
+	vm, err := m.fcManager.GetVM(id)
+	if err != nil {
+		return nil, &models.NotFoundError{Resource: "VM", Name: id}
+	}
+	info := vm.Info()
+	return &info, nil
+}
+
+// StopVM stops a VM, whether it is pooled or serving an invocation
+func (m *Manager) StopVM(id string) error {
+	if m.fcManager == nil {
+		return &models.NotFoundError{Resource: "VM", Name: id}
+	}
+
+	if err := m.fcManager.StopVM(id); err != nil {
+		return &models.NotFoundError{Resource: "VM", Name: id}
+	}
+	return nil
+}
+
+// DrainVMs retires the VMs that served a function. Idle VMs are stopped at
+// once and busy ones as soon as their invocation finishes.
+func (m *Manager) DrainVMs(functionName string) (stopped, draining int) {
+	if m.fcManager == nil {
+		return 0, 0
+	}
+	return m.fcManager.DrainFunction(functionName)
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AsyncStatus is the state of a queued asynchronous invocation
+type AsyncStatus string
+
+const (
+	AsyncStatusQueued     AsyncStatus = "queued"
+	AsyncStatusRunning    AsyncStatus = "running"
+	AsyncStatusSucceeded  AsyncStatus = "succeeded"
+	AsyncStatusDeadLetter AsyncStatus = "dead_letter"
+)
+
+// AsyncInvocation is an invocation accepted with mode=async and run in the
+// background by the queue worker
+type AsyncInvocation struct {
+	ID           string              `json:"id"`
+	FunctionName string              `json:"function_name"`
+	Qualifier    string              `json:"qualifier,omitempty"`
+	Payload      json.RawMessage     `json:"payload,omitempty"`
+	Status       AsyncStatus         `json:"status"`
+	Attempts     int                 `json:"attempts"`
+	MaxAttempts  int                 `json:"max_attempts"`
+	LastError    string              `json:"last_error,omitempty"`
+	Result       *InvocationResponse `json:"result,omitempty"`
+	// NextAttemptAt is when a queued invocation becomes due. While the
+	// invocation is running it is the lease expiry, after which another
+	// worker may pick it up again.
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+// RetryPolicy controls how failed asynchronous invocations are retried
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy tries an invocation three times, waiting 1s and then 2s
+// between attempts
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Minute,
+}
+
+// Backoff returns the delay before the attempt following the given one. The
+// delay doubles with every attempt up to MaxBackoff.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ThrottledError is returned when an invocation is rejected because a
+// concurrency limit is reached
+type ThrottledError struct {
+	Function   string
+	Limit      string // "function" or "host"
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("function %s throttled: %s concurrency limit reached", e.Function, e.Limit)
+}
+
+// UnavailableError is returned when the host has no capacity left to run an
+// invocation
+type UnavailableError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return e.Message
+}
+
+// FunctionConcurrency reports a function's concurrency settings and usage on
+// this host
+type FunctionConcurrency struct {
+	Function            string `json:"function"`
+	MaxConcurrency      int    `json:"max_concurrency"`
+	ReservedConcurrency int    `json:"reserved_concurrency"`
+	Running             int    `json:"running"`
+	Throttles           int64  `json:"throttles"` // Since the server started
+}
+
+// HostConcurrency reports the host-wide concurrency limit and usage
+type HostConcurrency struct {
+	Limit             int   `json:"limit"`              // 0 if unlimited
+	Reserved          int   `json:"reserved"`           // Sum of all reservations
+	Running           int   `json:"running"`            // All running invocations
+	UnreservedRunning int   `json:"unreserved_running"` // Running outside of reservations
+	Throttles         int64 `json:"throttles"`          // Since the server started
+}
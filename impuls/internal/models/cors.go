@@ -0,0 +1,96 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxCORSMaxAgeSec is the longest browsers may cache a preflight response
+const MaxCORSMaxAgeSec = 86400
+
+var (
+	httpMethodPattern = regexp.MustCompile(`^[A-Z]+$`)
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+)
+
+// CORSConfig is the CORS policy of a function's URL. Browsers on the listed
+// origins may call the function; "*" allows any origin.
+type CORSConfig struct {
+	AllowOrigins []string `json:"allow_origins"`
+	// AllowMethods are the methods preflight requests may ask for, any
+	// method when empty
+	AllowMethods []string `json:"allow_methods,omitempty"`
+	// AllowHeaders are the request headers preflight requests may ask for
+	AllowHeaders []string `json:"allow_headers,omitempty"`
+	// ExposeHeaders are the response headers scripts may read
+	ExposeHeaders    []string `json:"expose_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	// MaxAgeSec is how long browsers may cache a preflight response, 0 for
+	// their default
+	MaxAgeSec int `json:"max_age_sec,omitempty"`
+}
+
+// Validate checks a CORS policy
+func (c *CORSConfig) Validate() error {
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return &ValidationError{Field: "cors", Message: "allow_origins cannot be \"*\" with allow_credentials"}
+			}
+			continue
+		}
+		if !isValidOrigin(origin) {
+			return &ValidationError{Field: "cors", Message: fmt.Sprintf("invalid origin %q (expected \"*\" or scheme://host[:port])", origin)}
+		}
+	}
+	for _, method := range c.AllowMethods {
+		if method != "*" && !httpMethodPattern.MatchString(method) {
+			return &ValidationError{Field: "cors", Message: fmt.Sprintf("invalid method %q", method)}
+		}
+	}
+	for _, header := range append(append([]string(nil), c.AllowHeaders...), c.ExposeHeaders...) {
+		if !headerNamePattern.MatchString(header) {
+			return &ValidationError{Field: "cors", Message: fmt.Sprintf("invalid header %q", header)}
+		}
+	}
+	if c.MaxAgeSec < 0 || c.MaxAgeSec > MaxCORSMaxAgeSec {
+		return &ValidationError{Field: "cors", Message: fmt.Sprintf("max_age_sec must be between 0 and %d", MaxCORSMaxAgeSec)}
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether the policy lets the given origin call the
+// function
+func (c *CORSConfig) AllowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsMethod reports whether preflight requests may ask for the method
+func (c *CORSConfig) AllowsMethod(method string) bool {
+	if len(c.AllowMethods) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowMethods {
+		if allowed == "*" || allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidOrigin reports whether s is a serialized origin, such as
+// https://example.com or http://localhost:3000
+func isValidOrigin(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	return u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import "testing"
+
+func TestCORSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cors    CORSConfig
+		wantErr bool
+	}{
+		{name: "any origin", cors: CORSConfig{AllowOrigins: []string{"*"}}},
+		{name: "origins", cors: CORSConfig{
+			AllowOrigins:     []string{"https://example.com", "http://localhost:3000"},
+			AllowMethods:     []string{"GET", "POST"},
+			AllowHeaders:     []string{"Content-Type", "X-Request-Id"},
+			ExposeHeaders:    []string{"ETag"},
+			AllowCredentials: true,
+			MaxAgeSec:        600,
+		}},
+		{name: "origin with path", cors: CORSConfig{AllowOrigins: []string{"https://example.com/app"}}, wantErr: true},
+		{name: "origin without scheme", cors: CORSConfig{AllowOrigins: []string{"example.com"}}, wantErr: true},
+		{name: "unsupported scheme", cors: CORSConfig{AllowOrigins: []string{"ftp://example.com"}}, wantErr: true},
+		{name: "any origin with credentials", cors: CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true}, wantErr: true},
+		{name: "lower case method", cors: CORSConfig{AllowOrigins: []string{"*"}, AllowMethods: []string{"get"}}, wantErr: true},
+		{name: "invalid header", cors: CORSConfig{AllowOrigins: []string{"*"}, AllowHeaders: []string{"X Request"}}, wantErr: true},
+		{name: "negative max age", cors: CORSConfig{AllowOrigins: []string{"*"}, MaxAgeSec: -1}, wantErr: true},
+		{name: "max age too long", cors: CORSConfig{AllowOrigins: []string{"*"}, MaxAgeSec: MaxCORSMaxAgeSec + 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cors.Validate()
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != "cors" {
+				t.Errorf("Expected validation error on cors, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCORSConfigAllows(t *testing.T) {
+	cors := &CORSConfig{AllowOrigins: []string{"https://example.com"}, AllowMethods: []string{"GET", "POST"}}
+	if !cors.AllowsOrigin("https://EXAMPLE.com") || cors.AllowsOrigin("https://evil.example") {
+		t.Error("Expected only https://example.com to be allowed")
+	}
+	if !cors.AllowsMethod("POST") || cors.AllowsMethod("DELETE") {
+		t.Error("Expected only GET and POST to be allowed")
+	}
+	if open := (&CORSConfig{AllowOrigins: []string{"*"}}); !open.AllowsOrigin("https://evil.example") || !open.AllowsMethod("DELETE") {
+		t.Error("Expected any origin and method to be allowed")
+	}
+}
@@ -12,6 +12,38 @@ const (
 	RuntimePython311 Runtime = "python311"
 	RuntimeDotNet8   Runtime = "dotnet8"
 	RuntimeDotNet7   Runtime = "dotnet7"
+	RuntimeGo122     Runtime = "go122"
+	RuntimeProvided  Runtime = "provided"
+)
+
+// CodeFormat is how a function's code is packaged
+type CodeFormat string
+
+const (
+	// CodeFormatSource is a single source file in plain text
+	CodeFormatSource CodeFormat = ""
+	// CodeFormatZip is a base64 encoded zip archive of the source tree
+	CodeFormatZip CodeFormat = "zip"
+	// CodeFormatTarGz is a base64 encoded gzipped tarball of the source tree
+	CodeFormatTarGz CodeFormat = "tar.gz"
+)
+
+// IsArchive reports whether the code is an archive of many files
+func (f CodeFormat) IsArchive() bool {
+	return f == CodeFormatZip || f == CodeFormatTarGz
+}
+
+// ExecutorType is the backend that runs a function's invocations
+type ExecutorType string
+
+const (
+	// ExecutorFirecracker runs every invocation in a Firecracker VM
+	ExecutorFirecracker ExecutorType = "firecracker"
+	// ExecutorLocal runs the runtime as a process on the server
+	ExecutorLocal ExecutorType = "local"
+	// ExecutorSandbox runs the runtime as a process in new user, mount, pid
+	// and network namespaces
+	ExecutorSandbox ExecutorType = "sandbox"
 )
 
 // Function represents a serverless function
@@ -20,14 +52,63 @@ type Function struct {
 	Name        string            `json:"name"`
 	Description string            `json:"description,omitempty"`
 	Runtime     Runtime           `json:"runtime"`
-	Handler     string            `json:"handler"` // e.g., "index.handler"
-	Code        string            `json:"code"`    // Base64 encoded or plain text
-	CodePath    string            `json:"-"`       // Internal path to stored code
+	Handler     string            `json:"handler"`               // e.g., "index.handler"
+	Code        string            `json:"code"`                  // Base64 encoded or plain text
+	CodeFormat  CodeFormat        `json:"code_format,omitempty"` // Empty for plain text
+	CodePath    string            `json:"-"`                     // Internal path to stored code
 	MemoryMB    int               `json:"memory_mb"`
 	TimeoutSec  int               `json:"timeout_sec"`
 	Environment map[string]string `json:"environment,omitempty"`
-	CreatedAt   time.Time         `json:"created_at"`
-	UpdatedAt   time.Time         `json:"updated_at"`
+	// MaxConcurrency caps the function's simultaneous invocations on this
+	// host, 0 for no cap
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// ReservedConcurrency is set aside from the host limit for this function
+	// only, so other functions cannot starve it
+	ReservedConcurrency int `json:"reserved_concurrency,omitempty"`
+	// NoNetwork runs the function in a VM without a network device, for
+	// code that needs no network egress
+	NoNetwork bool `json:"no_network,omitempty"`
+	// Executor pins the backend the function runs on, empty for the
+	// server's default
+	Executor ExecutorType `json:"executor,omitempty"`
+	// Layers are overlaid on the function's code in order, later layers
+	// over earlier ones; the function's own files take precedence
+	Layers []LayerRef `json:"layers,omitempty"`
+	// Secrets maps environment variables to the secrets they are set to
+	// when the function is invoked
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// CORS is the CORS policy of the function's URL, nil to add no CORS
+	// headers
+	CORS *CORSConfig `json:"cors,omitempty"`
+	// Build is the dependency build of a package with a package.json or
+	// requirements.txt, nil for functions without dependencies
+	Build *FunctionBuild `json:"build,omitempty"`
+	// NextVersion is the number the next published version gets. It never
+	// goes down, so the number of a deleted version is never reused.
+	NextVersion int       `json:"next_version,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BuildStatus is the state of a dependency build
+type BuildStatus string
+
+const (
+	BuildPending BuildStatus = "pending"
+	BuildReady   BuildStatus = "ready"
+	BuildFailed  BuildStatus = "failed"
+)
+
+// FunctionBuild is the installation of a package's dependencies. Builds are
+// cached by the hash of the dependency files, so functions and versions with
+// the same dependencies share one.
+type FunctionBuild struct {
+	Status           BuildStatus `json:"status"`
+	DependenciesHash string      `json:"dependencies_hash"`
+	Log              string      `json:"log,omitempty"` // output of npm or pip, from the end
+	Error            string      `json:"error,omitempty"`
+	StartedAt        time.Time   `json:"started_at"`
+	FinishedAt       *time.Time  `json:"finished_at,omitempty"`
 }
 
 // CreateFunctionRequest is the request body for creating a function
@@ -37,9 +118,23 @@ type CreateFunctionRequest struct {
 	Runtime     Runtime           `json:"runtime"`
 	Handler     string            `json:"handler"`
 	Code        string            `json:"code"`
+	CodeFormat  CodeFormat        `json:"code_format,omitempty"`
 	MemoryMB    int               `json:"memory_mb,omitempty"`
 	TimeoutSec  int               `json:"timeout_sec,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
+
+	MaxConcurrency      int `json:"max_concurrency,omitempty"`
+	ReservedConcurrency int `json:"reserved_concurrency,omitempty"`
+
+	NoNetwork bool `json:"no_network,omitempty"`
+
+	Executor ExecutorType `json:"executor,omitempty"`
+
+	Layers []LayerRef `json:"layers,omitempty"`
+
+	Secrets map[string]string `json:"secrets,omitempty"`
+
+	CORS *CORSConfig `json:"cors,omitempty"`
 }
 
 // UpdateFunctionRequest is the request body for updating a function
@@ -48,9 +143,29 @@ type UpdateFunctionRequest struct {
 	Runtime     *Runtime          `json:"runtime,omitempty"`
 	Handler     *string           `json:"handler,omitempty"`
 	Code        *string           `json:"code,omitempty"`
+	CodeFormat  *CodeFormat       `json:"code_format,omitempty"`
 	MemoryMB    *int              `json:"memory_mb,omitempty"`
 	TimeoutSec  *int              `json:"timeout_sec,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
+
+	MaxConcurrency      *int `json:"max_concurrency,omitempty"`
+	ReservedConcurrency *int `json:"reserved_concurrency,omitempty"`
+
+	NoNetwork *bool `json:"no_network,omitempty"`
+
+	// Executor pins the function to a backend, empty for the server's
+	// default
+	Executor *ExecutorType `json:"executor,omitempty"`
+
+	// Layers replaces the function's layers, an empty list removes them
+	Layers []LayerRef `json:"layers,omitempty"`
+
+	// Secrets replaces the function's secrets, an empty object removes them
+	Secrets map[string]string `json:"secrets,omitempty"`
+
+	// CORS replaces the function's CORS policy, one without allow_origins
+	// removes it
+	CORS *CORSConfig `json:"cors,omitempty"`
 }
 
 // InvocationRequest is the request body for invoking a function
@@ -60,13 +175,24 @@ type InvocationRequest struct {
 
 // InvocationResponse is the response from a function invocation
 type InvocationResponse struct {
-	StatusCode int         `json:"status_code"`
-	Body       interface{} `json:"body"`
-	Duration   int64       `json:"duration_ms"`
-	Logs       string      `json:"logs,omitempty"`
-	Error      string      `json:"error,omitempty"`
+	InvocationID string      `json:"invocation_id,omitempty"` // ID of the history record
+	StatusCode   int         `json:"status_code"`
+	Body         interface{} `json:"body"`
+	Duration     int64       `json:"duration_ms"`
+	Version      string      `json:"version,omitempty"` // Revision that served the call
+	Logs         string      `json:"logs,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	// ErrorType is set when the invocation was stopped by one of its
+	// limits rather than failing on its own
+	ErrorType string `json:"error_type,omitempty"`
 }
 
+// Error types of invocations stopped by one of their limits
+const (
+	ErrorTypeTimeout     = "timeout"
+	ErrorTypeOutOfMemory = "out_of_memory"
+)
+
 // FunctionStatus represents the current status of a function
 type FunctionStatus string
 
@@ -93,6 +219,34 @@ func (r *CreateFunctionRequest) Validate() error {
 	if r.Code == "" {
 		return &ValidationError{Field: "code", Message: "code is required"}
 	}
+	if !IsValidCodeFormat(r.CodeFormat) {
+		return &ValidationError{Field: "code_format", Message: "code_format must be empty, zip or tar.gz"}
+	}
+	if err := ValidateLayerRefs(r.Layers); err != nil {
+		return err
+	}
+	if err := ValidateSecretRefs(r.Secrets, r.Environment); err != nil {
+		return err
+	}
+	if r.CORS != nil {
+		if err := r.CORS.Validate(); err != nil {
+			return err
+		}
+	}
+	return ValidateConcurrency(r.MaxConcurrency, r.ReservedConcurrency)
+}
+
+// ValidateConcurrency checks a function's concurrency settings
+func ValidateConcurrency(max, reserved int) error {
+	if max < 0 {
+		return &ValidationError{Field: "max_concurrency", Message: "max_concurrency cannot be negative"}
+	}
+	if reserved < 0 {
+		return &ValidationError{Field: "reserved_concurrency", Message: "reserved_concurrency cannot be negative"}
+	}
+	if max > 0 && reserved > max {
+		return &ValidationError{Field: "reserved_concurrency", Message: "reserved_concurrency cannot exceed max_concurrency"}
+	}
 	return nil
 }
 
@@ -100,13 +254,19 @@ func isValidRuntime(r Runtime) bool {
 	switch r {
 	case RuntimeNodeJS20, RuntimeNodeJS18,
 		RuntimePython312, RuntimePython311,
-		RuntimeDotNet8, RuntimeDotNet7:
+		RuntimeDotNet8, RuntimeDotNet7,
+		RuntimeGo122, RuntimeProvided:
 		return true
 	default:
 		return false
 	}
 }
 
+// IsValidCodeFormat reports whether f is a supported code format
+func IsValidCodeFormat(f CodeFormat) bool {
+	return f == CodeFormatSource || f.IsArchive()
+}
+
 // GetRuntimeLanguage returns the base language for a runtime
 func GetRuntimeLanguage(r Runtime) string {
 	switch r {
@@ -116,6 +276,10 @@ func GetRuntimeLanguage(r Runtime) string {
 		return "python"
 	case RuntimeDotNet8, RuntimeDotNet7:
 		return "dotnet"
+	case RuntimeGo122:
+		return "go"
+	case RuntimeProvided:
+		return "provided"
 	default:
 		return ""
 	}
@@ -109,6 +109,8 @@ func TestIsValidRuntime(t *testing.T) {
 		RuntimePython311,
 		RuntimeDotNet8,
 		RuntimeDotNet7,
+		RuntimeGo122,
+		RuntimeProvided,
 	}
 
 	for _, r := range validRuntimes {
@@ -146,6 +148,8 @@ func TestGetRuntimeLanguage(t *testing.T) {
 		{RuntimePython311, "python"},
 		{RuntimeDotNet8, "dotnet"},
 		{RuntimeDotNet7, "dotnet"},
+		{RuntimeGo122, "go"},
+		{RuntimeProvided, "provided"},
 		{Runtime("invalid"), ""},
 	}
 
@@ -205,3 +209,34 @@ func TestFunctionEnvironment(t *testing.T) {
 		t.Errorf("Expected API_KEY=secret, got %s", fn.Environment["API_KEY"])
 	}
 }
+
+func TestValidateConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		reserved int
+		errField string
+	}{
+		{name: "no limits", max: 0, reserved: 0},
+		{name: "reservation without cap", max: 0, reserved: 5},
+		{name: "reservation within cap", max: 10, reserved: 10},
+		{name: "negative max", max: -1, errField: "max_concurrency"},
+		{name: "negative reservation", reserved: -1, errField: "reserved_concurrency"},
+		{name: "reservation over cap", max: 2, reserved: 3, errField: "reserved_concurrency"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConcurrency(tt.max, tt.reserved)
+			if tt.errField == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != tt.errField {
+				t.Errorf("Expected validation error on %s, got %v", tt.errField, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// InvocationStatus is the outcome of a recorded invocation
+type InvocationStatus string
+
+const (
+	InvocationSucceeded InvocationStatus = "succeeded"
+	InvocationFailed    InvocationStatus = "failed"
+)
+
+// InvocationRecord is the history entry kept for every invocation, so a call
+// can still be inspected after its response has been sent
+type InvocationRecord struct {
+	ID           string           `json:"id"`
+	FunctionName string           `json:"function_name"`
+	Version      string           `json:"version,omitempty"` // Revision that served the call
+	Qualifier    string           `json:"qualifier,omitempty"`
+	Local        bool             `json:"local,omitempty"`
+	Status       InvocationStatus `json:"status"`
+	StatusCode   int              `json:"status_code"`
+	Error        string           `json:"error,omitempty"`
+	StartedAt    time.Time        `json:"started_at"`
+	Duration     int64            `json:"duration_ms"`
+	// Payload is the JSON event, cut to the configured size. It is kept as
+	// text because a truncated event is no longer valid JSON.
+	Payload          string `json:"payload,omitempty"`
+	PayloadTruncated bool   `json:"payload_truncated,omitempty"`
+	// Logs is the captured stdout and stderr. When it is too long only the
+	// end, where failures usually show, is kept.
+	Logs          string `json:"logs,omitempty"`
+	LogsTruncated bool   `json:"logs_truncated,omitempty"`
+}
+
+// InvocationFilter selects invocation records
+type InvocationFilter struct {
+	Status InvocationStatus // Only records with this status, if set
+	Before time.Time        // Only records started before this time, if set
+	Limit  int              // Maximum number of records, all if zero
+}
+
+// Matches reports whether a record passes the filter, ignoring the limit
+func (f InvocationFilter) Matches(r *InvocationRecord) bool {
+	if f.Status != "" && r.Status != f.Status {
+		return false
+	}
+	if !f.Before.IsZero() && !r.StartedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// HistoryConfig controls how much of each invocation is recorded and how
+// long records are kept
+type HistoryConfig struct {
+	MaxPayloadBytes int
+	MaxLogBytes     int
+	Retention       time.Duration // Records older than this are pruned, none if zero
+	MaxPerFunction  int           // Only the newest records are kept, all if zero
+}
+
+// DefaultHistoryConfig keeps the last 1000 invocations of each function for
+// up to a week
+var DefaultHistoryConfig = HistoryConfig{
+	MaxPayloadBytes: 4 << 10,
+	MaxLogBytes:     64 << 10,
+	Retention:       7 * 24 * time.Hour,
+	MaxPerFunction:  1000,
+}
+
+// LogLine is one line of output of a running invocation
+type LogLine struct {
+	InvocationID string    `json:"invocation_id"`
+	FunctionName string    `json:"function_name"`
+	Version      string    `json:"version,omitempty"`
+	Stream       string    `json:"stream"` // "stdout" or "stderr"
+	Line         string    `json:"line"`
+	Timestamp    time.Time `json:"timestamp"`
+}
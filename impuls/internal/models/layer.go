@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// MaxFunctionLayers is how many layers a function may use
+const MaxFunctionLayers = 5
+
+var layerNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+// Layer is a published version of a layer: an archive of shared libraries
+// or helper code that functions overlay onto their own code. Versions are
+// immutable, publishing a layer again creates the next version.
+type Layer struct {
+	Name        string     `json:"name"`
+	Version     int        `json:"version"`
+	Description string     `json:"description,omitempty"`
+	CodeFormat  CodeFormat `json:"code_format"`
+	Content     string     `json:"content,omitempty"` // Base64 encoded archive, left out of lists
+	Size        int        `json:"size"`              // Bytes of the archive
+	SHA256      string     `json:"sha256"`            // Of the archive
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Ref returns the reference functions use for the layer version
+func (l *Layer) Ref() LayerRef {
+	return LayerRef{Name: l.Name, Version: l.Version}
+}
+
+// LayerRef names a layer version a function uses
+type LayerRef struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+func (r LayerRef) String() string {
+	return fmt.Sprintf("%s:%d", r.Name, r.Version)
+}
+
+// PublishLayerRequest is the request body for publishing a layer version
+type PublishLayerRequest struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	CodeFormat  CodeFormat `json:"code_format"`
+	Content     string     `json:"content"`
+}
+
+// Validate validates the publish layer request
+func (r *PublishLayerRequest) Validate() error {
+	if !IsValidLayerName(r.Name) {
+		return &ValidationError{Field: "name", Message: "name must start with a letter and contain only letters, digits, '-' and '_' (max 64)"}
+	}
+	if !r.CodeFormat.IsArchive() {
+		return &ValidationError{Field: "code_format", Message: "code_format must be zip or tar.gz"}
+	}
+	if r.Content == "" {
+		return &ValidationError{Field: "content", Message: "content is required"}
+	}
+	return nil
+}
+
+// IsValidLayerName reports whether name can name a layer
+func IsValidLayerName(name string) bool {
+	return layerNamePattern.MatchString(name)
+}
+
+// ValidateLayerRefs checks the layers of a function. Later layers are
+// overlaid on earlier ones, so each layer may appear only once.
+func ValidateLayerRefs(refs []LayerRef) error {
+	if len(refs) > MaxFunctionLayers {
+		return &ValidationError{Field: "layers", Message: fmt.Sprintf("a function can use at most %d layers", MaxFunctionLayers)}
+	}
+
+	seen := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if !IsValidLayerName(ref.Name) || ref.Version < 1 {
+			return &ValidationError{Field: "layers", Message: fmt.Sprintf("invalid layer %s", ref)}
+		}
+		if seen[ref.Name] {
+			return &ValidationError{Field: "layers", Message: fmt.Sprintf("layer %s is used more than once", ref.Name)}
+		}
+		seen[ref.Name] = true
+	}
+	return nil
+}
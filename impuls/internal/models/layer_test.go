@@ -0,0 +1,61 @@
+package models
+
+import "testing"
+
+func TestValidateLayerRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		refs    []LayerRef
+		wantErr bool
+	}{
+		{name: "no layers"},
+		{name: "ordered layers", refs: []LayerRef{{Name: "utils", Version: 3}, {Name: "crypto_v2", Version: 1}}},
+		{name: "invalid name", refs: []LayerRef{{Name: "../utils", Version: 1}}, wantErr: true},
+		{name: "missing version", refs: []LayerRef{{Name: "utils"}}, wantErr: true},
+		{name: "repeated layer", refs: []LayerRef{{Name: "utils", Version: 1}, {Name: "utils", Version: 2}}, wantErr: true},
+		{name: "too many layers", refs: []LayerRef{{"a", 1}, {"b", 1}, {"c", 1}, {"d", 1}, {"e", 1}, {"f", 1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLayerRefs(tt.refs)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != "layers" {
+				t.Errorf("Expected validation error on layers, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPublishLayerRequestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      PublishLayerRequest
+		errField string
+	}{
+		{name: "valid request", req: PublishLayerRequest{Name: "utils", CodeFormat: CodeFormatZip, Content: "UEs="}},
+		{name: "invalid name", req: PublishLayerRequest{Name: "-utils", CodeFormat: CodeFormatZip, Content: "UEs="}, errField: "name"},
+		{name: "plain source", req: PublishLayerRequest{Name: "utils", Content: "x"}, errField: "code_format"},
+		{name: "missing content", req: PublishLayerRequest{Name: "utils", CodeFormat: CodeFormatTarGz}, errField: "content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.errField == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != tt.errField {
+				t.Errorf("Expected validation error on %s, got %v", tt.errField, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Schedule invokes a function on a cron expression or at a fixed rate
+type Schedule struct {
+	ID           string          `json:"id"`
+	FunctionName string          `json:"function_name"`
+	Expression   string          `json:"expression"`         // e.g. "rate(5 minutes)" or "cron(0 9 * * MON-FRI)"
+	Timezone     string          `json:"timezone,omitempty"` // IANA name cron expressions are evaluated in, UTC if empty
+	Qualifier    string          `json:"qualifier,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"` // Event passed to the function
+	JitterSec    int             `json:"jitter_sec,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	Enabled      bool            `json:"enabled"`
+	NextRunAt    time.Time       `json:"next_run_at"`
+	LastRunAt    *time.Time      `json:"last_run_at,omitempty"`
+	// MissedRuns counts runs skipped because no server was running when
+	// they were due. Only the latest due run is fired after a restart.
+	MissedRuns   int64      `json:"missed_runs"`
+	LastMissedAt *time.Time `json:"last_missed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// CreateScheduleRequest is the request body for creating a schedule
+type CreateScheduleRequest struct {
+	Expression  string          `json:"expression"`
+	Timezone    string          `json:"timezone,omitempty"`
+	Qualifier   string          `json:"qualifier,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	JitterSec   int             `json:"jitter_sec,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Enabled     *bool           `json:"enabled,omitempty"`
+}
+
+// UpdateScheduleRequest is the request body for updating a schedule
+type UpdateScheduleRequest struct {
+	Expression  *string         `json:"expression,omitempty"`
+	Timezone    *string         `json:"timezone,omitempty"`
+	Qualifier   *string         `json:"qualifier,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	JitterSec   *int            `json:"jitter_sec,omitempty"`
+	Description *string         `json:"description,omitempty"`
+	Enabled     *bool           `json:"enabled,omitempty"`
+}
+
+// Validate validates a CreateScheduleRequest
+func (r *CreateScheduleRequest) Validate() error {
+	if r.Expression == "" {
+		return &ValidationError{Field: "expression", Message: "expression is required"}
+	}
+	if r.JitterSec < 0 {
+		return &ValidationError{Field: "jitter_sec", Message: "jitter_sec must not be negative"}
+	}
+	return nil
+}
+
+// Validate validates an UpdateScheduleRequest
+func (r *UpdateScheduleRequest) Validate() error {
+	if r.Expression != nil && *r.Expression == "" {
+		return &ValidationError{Field: "expression", Message: "expression must not be empty"}
+	}
+	if r.JitterSec != nil && *r.JitterSec < 0 {
+		return &ValidationError{Field: "jitter_sec", Message: "jitter_sec must not be negative"}
+	}
+	return nil
+}
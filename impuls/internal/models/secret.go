@@ -0,0 +1,113 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	// MaxSecretSize is the largest secret value, in bytes
+	MaxSecretSize = 64 << 10
+
+	// MaxFunctionSecrets is how many secrets a function may use
+	MaxFunctionSecrets = 50
+)
+
+var (
+	secretNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+	envNamePattern    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Secret is a named value that functions receive in their environment. The
+// value is only stored encrypted and is never returned by the API.
+type Secret struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Envelope holds the encrypted value, left out of API responses
+	Envelope  *SecretEnvelope `json:"envelope,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// SecretEnvelope is a secret value encrypted with a data key of its own,
+// which is in turn encrypted with the server's master key
+type SecretEnvelope struct {
+	KeyID        string `json:"key_id"`        // Identifies the master key
+	EncryptedKey []byte `json:"encrypted_key"` // The data key, sealed with the master key
+	Ciphertext   []byte `json:"ciphertext"`    // The value, sealed with the data key
+}
+
+// CreateSecretRequest is the request body for creating a secret
+type CreateSecretRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Value       string `json:"value"`
+}
+
+// Validate validates the create secret request
+func (r *CreateSecretRequest) Validate() error {
+	if !IsValidSecretName(r.Name) {
+		return &ValidationError{Field: "name", Message: "name must start with a letter and contain only letters, digits, '-' and '_' (max 64)"}
+	}
+	return validateSecretValue(r.Value)
+}
+
+// UpdateSecretRequest is the request body for replacing a secret's value
+type UpdateSecretRequest struct {
+	Description *string `json:"description,omitempty"`
+	Value       *string `json:"value,omitempty"`
+}
+
+// Validate validates the update secret request
+func (r *UpdateSecretRequest) Validate() error {
+	if r.Value == nil {
+		return nil
+	}
+	return validateSecretValue(*r.Value)
+}
+
+func validateSecretValue(value string) error {
+	if value == "" {
+		return &ValidationError{Field: "value", Message: "value is required"}
+	}
+	if len(value) > MaxSecretSize {
+		return &ValidationError{Field: "value", Message: fmt.Sprintf("value must be at most %d bytes", MaxSecretSize)}
+	}
+	return nil
+}
+
+// IsValidSecretName reports whether name can name a secret
+func IsValidSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// ValidateSecretRefs checks the secrets of a function, which map
+// environment variables to the names of the secrets they are set to. A
+// variable cannot be both a plain environment variable and a secret.
+func ValidateSecretRefs(refs map[string]string, env map[string]string) error {
+	if len(refs) > MaxFunctionSecrets {
+		return &ValidationError{Field: "secrets", Message: fmt.Sprintf("a function can use at most %d secrets", MaxFunctionSecrets)}
+	}
+
+	// Sorted, so the same request always reports the same variable
+	vars := make([]string, 0, len(refs))
+	for key := range refs {
+		vars = append(vars, key)
+	}
+	sort.Strings(vars)
+
+	for _, key := range vars {
+		if !envNamePattern.MatchString(key) {
+			return &ValidationError{Field: "secrets", Message: fmt.Sprintf("invalid environment variable name %q", key)}
+		}
+		if !IsValidSecretName(refs[key]) {
+			return &ValidationError{Field: "secrets", Message: fmt.Sprintf("invalid secret name %q for %s", refs[key], key)}
+		}
+		if _, ok := env[key]; ok {
+			return &ValidationError{Field: "secrets", Message: fmt.Sprintf("%s is also set in environment", key)}
+		}
+	}
+	return nil
+}
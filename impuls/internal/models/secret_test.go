@@ -0,0 +1,69 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSecretRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		refs    map[string]string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "no secrets"},
+		{name: "secrets", refs: map[string]string{"DB_PASSWORD": "db-password", "_TOKEN": "api_token"}, env: map[string]string{"DB_HOST": "db"}},
+		{name: "invalid variable", refs: map[string]string{"DB-PASSWORD": "db-password"}, wantErr: true},
+		{name: "variable starting with a digit", refs: map[string]string{"1PASSWORD": "db-password"}, wantErr: true},
+		{name: "invalid secret name", refs: map[string]string{"DB_PASSWORD": "../db"}, wantErr: true},
+		{name: "also in environment", refs: map[string]string{"DB_PASSWORD": "db-password"}, env: map[string]string{"DB_PASSWORD": "hunter2"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSecretRefs(tt.refs, tt.env)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != "secrets" {
+				t.Errorf("Expected validation error on secrets, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretRequestValidation(t *testing.T) {
+	empty, large := "", strings.Repeat("x", MaxSecretSize+1)
+
+	tests := []struct {
+		name     string
+		validate func() error
+		errField string
+	}{
+		{name: "valid create", validate: (&CreateSecretRequest{Name: "db-password", Value: "hunter2"}).Validate},
+		{name: "invalid name", validate: (&CreateSecretRequest{Name: "db password", Value: "hunter2"}).Validate, errField: "name"},
+		{name: "missing value", validate: (&CreateSecretRequest{Name: "db-password"}).Validate, errField: "value"},
+		{name: "value too large", validate: (&CreateSecretRequest{Name: "db-password", Value: large}).Validate, errField: "value"},
+		{name: "description only update", validate: (&UpdateSecretRequest{}).Validate},
+		{name: "empty value update", validate: (&UpdateSecretRequest{Value: &empty}).Validate, errField: "value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validate()
+			if tt.errField == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if ve, ok := err.(*ValidationError); !ok || ve.Field != tt.errField {
+				t.Errorf("Expected validation error on %s, got %v", tt.errField, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// LatestVersion is the qualifier of the editable, unpublished function
+const LatestVersion = "$LATEST"
+
+var aliasNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,63}$`)
+
+// FunctionVersion is an immutable snapshot of a function's code and config
+type FunctionVersion struct {
+	FunctionName string     `json:"function_name"`
+	Version      int        `json:"version"`
+	Description  string     `json:"description,omitempty"`
+	Runtime      Runtime    `json:"runtime"`
+	Handler      string     `json:"handler"`
+	Code         string     `json:"code"`
+	CodeFormat   CodeFormat `json:"code_format,omitempty"`
+	// DependenciesHash names the ready dependency build the version runs
+	// with, empty without dependencies
+	DependenciesHash string            `json:"dependencies_hash,omitempty"`
+	MemoryMB         int               `json:"memory_mb"`
+	TimeoutSec       int               `json:"timeout_sec"`
+	Environment      map[string]string `json:"environment,omitempty"`
+	Layers           []LayerRef        `json:"layers,omitempty"`
+	Secrets          map[string]string `json:"secrets,omitempty"` // Names, resolved when invoked
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+// Alias is a named pointer to a published function version
+type Alias struct {
+	FunctionName  string              `json:"function_name"`
+	Name          string              `json:"name"`
+	Version       int                 `json:"version"`
+	Description   string              `json:"description,omitempty"`
+	RoutingConfig *AliasRoutingConfig `json:"routing_config,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+// AliasRoutingConfig sends a share of an alias's invocations to a second
+// version, e.g. Weight 0.1 routes 10% of calls to AdditionalVersion
+type AliasRoutingConfig struct {
+	AdditionalVersion int     `json:"additional_version"`
+	Weight            float64 `json:"weight"`
+}
+
+// PickVersion returns the version an invocation should run, given a random
+// number r in [0, 1)
+func (a *Alias) PickVersion(r float64) int {
+	if a.RoutingConfig != nil && r < a.RoutingConfig.Weight {
+		return a.RoutingConfig.AdditionalVersion
+	}
+	return a.Version
+}
+
+// RoutesTo reports whether the alias sends any traffic to a version
+func (a *Alias) RoutesTo(version int) bool {
+	if a.Version == version {
+		return true
+	}
+	return a.RoutingConfig != nil && a.RoutingConfig.AdditionalVersion == version
+}
+
+// PublishVersionRequest is the request body for publishing a version
+type PublishVersionRequest struct {
+	Description string `json:"description,omitempty"`
+}
+
+// CreateAliasRequest is the request body for creating an alias
+type CreateAliasRequest struct {
+	Name          string              `json:"name"`
+	Version       int                 `json:"version"`
+	Description   string              `json:"description,omitempty"`
+	RoutingConfig *AliasRoutingConfig `json:"routing_config,omitempty"`
+}
+
+// UpdateAliasRequest is the request body for updating an alias. A routing
+// config with a zero weight removes the traffic split.
+type UpdateAliasRequest struct {
+	Version       *int                `json:"version,omitempty"`
+	Description   *string             `json:"description,omitempty"`
+	RoutingConfig *AliasRoutingConfig `json:"routing_config,omitempty"`
+}
+
+// Validate validates a CreateAliasRequest
+func (r *CreateAliasRequest) Validate() error {
+	if r.Name == "" {
+		return &ValidationError{Field: "name", Message: "name is required"}
+	}
+	if !aliasNamePattern.MatchString(r.Name) {
+		return &ValidationError{Field: "name", Message: "alias must start with a letter and contain only letters, digits, '-' and '_'"}
+	}
+	if r.Version < 1 {
+		return &ValidationError{Field: "version", Message: "version must be a published version number"}
+	}
+	if r.RoutingConfig != nil {
+		return r.RoutingConfig.Validate(r.Version)
+	}
+	return nil
+}
+
+// Validate validates an UpdateAliasRequest
+func (r *UpdateAliasRequest) Validate() error {
+	if r.Version != nil && *r.Version < 1 {
+		return &ValidationError{Field: "version", Message: "version must be a published version number"}
+	}
+	return nil
+}
+
+// Validate validates a routing config for an alias pointing at version
+func (c *AliasRoutingConfig) Validate(version int) error {
+	if c.Weight < 0 || c.Weight >= 1 {
+		return &ValidationError{Field: "routing_config.weight", Message: "weight must be at least 0 and less than 1"}
+	}
+	if c.Weight == 0 {
+		return nil
+	}
+	if c.AdditionalVersion < 1 {
+		return &ValidationError{Field: "routing_config.additional_version", Message: "additional_version must be a published version number"}
+	}
+	if c.AdditionalVersion == version {
+		return &ValidationError{Field: "routing_config.additional_version", Message: "additional_version must differ from the alias version"}
+	}
+	return nil
+}
+
+// RevisionMetrics counts invocations served by one revision of a function
+type RevisionMetrics struct {
+	Version       string     `json:"version"`
+	Invocations   int64      `json:"invocations"`
+	Successes     int64      `json:"successes"`
+	Errors        int64      `json:"errors"`
+	LastInvokedAt *time.Time `json:"last_invoked_at,omitempty"`
+}
+
+// ParseVersion parses a numeric version qualifier. It returns false for
+// aliases and $LATEST.
+func ParseVersion(qualifier string) (int, bool) {
+	version, err := strconv.Atoi(qualifier)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// NotFoundError is returned when a function or one of its sub-resources
+// does not exist
+type NotFoundError struct {
+	Resource string
+	Name     string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Resource, e.Name)
+}
+
+// ConflictError is returned when a request conflicts with existing state
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
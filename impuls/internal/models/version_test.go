@@ -0,0 +1,104 @@
+package models
+
+import "testing"
+
+func TestCreateAliasRequestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      CreateAliasRequest
+		errField string
+	}{
+		{name: "valid alias", req: CreateAliasRequest{Name: "prod", Version: 1}},
+		{name: "missing name", req: CreateAliasRequest{Version: 1}, errField: "name"},
+		{name: "numeric name", req: CreateAliasRequest{Name: "1", Version: 1}, errField: "name"},
+		{name: "latest name", req: CreateAliasRequest{Name: LatestVersion, Version: 1}, errField: "name"},
+		{name: "missing version", req: CreateAliasRequest{Name: "prod"}, errField: "version"},
+		{
+			name: "valid routing",
+			req:  CreateAliasRequest{Name: "prod", Version: 1, RoutingConfig: &AliasRoutingConfig{AdditionalVersion: 2, Weight: 0.1}},
+		},
+		{
+			name:     "routing weight of one",
+			req:      CreateAliasRequest{Name: "prod", Version: 1, RoutingConfig: &AliasRoutingConfig{AdditionalVersion: 2, Weight: 1}},
+			errField: "routing_config.weight",
+		},
+		{
+			name:     "negative routing weight",
+			req:      CreateAliasRequest{Name: "prod", Version: 1, RoutingConfig: &AliasRoutingConfig{AdditionalVersion: 2, Weight: -0.1}},
+			errField: "routing_config.weight",
+		},
+		{
+			name:     "routing to the alias version",
+			req:      CreateAliasRequest{Name: "prod", Version: 1, RoutingConfig: &AliasRoutingConfig{AdditionalVersion: 1, Weight: 0.1}},
+			errField: "routing_config.additional_version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.errField == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Expected ValidationError, got %v", err)
+			}
+			if verr.Field != tt.errField {
+				t.Errorf("Expected error on field %s, got %s", tt.errField, verr.Field)
+			}
+		})
+	}
+}
+
+func TestAliasPickVersion(t *testing.T) {
+	alias := &Alias{Name: "prod", Version: 1}
+	if v := alias.PickVersion(0); v != 1 {
+		t.Errorf("Expected version 1 without routing, got %d", v)
+	}
+
+	alias.RoutingConfig = &AliasRoutingConfig{AdditionalVersion: 2, Weight: 0.1}
+	tests := []struct {
+		r    float64
+		want int
+	}{
+		{r: 0, want: 2},
+		{r: 0.099, want: 2},
+		{r: 0.1, want: 1},
+		{r: 0.999, want: 1},
+	}
+	for _, tt := range tests {
+		if v := alias.PickVersion(tt.r); v != tt.want {
+			t.Errorf("PickVersion(%v) = %d, want %d", tt.r, v, tt.want)
+		}
+	}
+
+	if !alias.RoutesTo(1) || !alias.RoutesTo(2) || alias.RoutesTo(3) {
+		t.Errorf("Expected alias to route to versions 1 and 2 only")
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		qualifier string
+		version   int
+		ok        bool
+	}{
+		{"1", 1, true},
+		{"42", 42, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"prod", 0, false},
+		{LatestVersion, 0, false},
+	}
+
+	for _, tt := range tests {
+		version, ok := ParseVersion(tt.qualifier)
+		if version != tt.version || ok != tt.ok {
+			t.Errorf("ParseVersion(%q) = %d, %v; want %d, %v", tt.qualifier, version, ok, tt.version, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultLease        = 15 * time.Minute
+)
+
+// Invoker runs a single function invocation
+type Invoker interface {
+	Invoke(ctx context.Context, name, qualifier string, payload interface{}) (*models.InvocationResponse, error)
+}
+
+// Config holds the worker configuration
+type Config struct {
+	Concurrency  int           // Invocations run in parallel
+	PollInterval time.Duration // How often an idle worker checks the queue
+	// Lease is how long a claimed invocation stays hidden from other
+	// workers. It must be longer than the longest function timeout.
+	Lease       time.Duration
+	RetryPolicy models.RetryPolicy
+}
+
+// Worker runs queued asynchronous invocations, retrying failures with
+// backoff and moving invocations that exhaust their attempts to the
+// dead-letter list
+type Worker struct {
+	store    storage.Storage
+	invoker  Invoker
+	config   Config
+	now      func() time.Time
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewWorker creates a new queue worker
+func NewWorker(store storage.Storage, invoker Invoker, config Config) *Worker {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.Lease <= 0 {
+		config.Lease = defaultLease
+	}
+
+	return &Worker{
+		store:    store,
+		invoker:  invoker,
+		config:   config,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts the worker goroutines
+func (w *Worker) Start(ctx context.Context) {
+	for i := 0; i < w.config.Concurrency; i++ {
+		w.wg.Add(1)
+		go w.run(ctx)
+	}
+}
+
+// Stop stops the worker and waits for running invocations to finish.
+// Invocations still queued are picked up on the next start.
+func (w *Worker) Stop() {
+	close(w.stopChan)
+	w.wg.Wait()
+}
+
+// run processes invocations until the queue is empty, then polls
+func (w *Worker) run(ctx context.Context) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for w.processNext(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.stopChan:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNext claims and runs one due invocation. It returns false if the
+// queue had nothing due.
+func (w *Worker) processNext(ctx context.Context) bool {
+	inv, err := w.store.ClaimAsyncInvocation(w.now(), w.config.Lease)
+	if err != nil {
+		log.Printf("Failed to claim async invocation: %v", err)
+		return false
+	}
+	if inv == nil {
+		return false
+	}
+
+	w.process(ctx, inv)
+	return true
+}
+
+// process runs a claimed invocation and records the outcome
+func (w *Worker) process(ctx context.Context, inv *models.AsyncInvocation) {
+	// A worker died while running the final attempt and the lease expired
+	if inv.Attempts > inv.MaxAttempts {
+		inv.Attempts = inv.MaxAttempts
+		w.finish(inv, models.AsyncStatusDeadLetter, "lease expired during the final attempt")
+		return
+	}
+
+	response, failure, permanent := w.invoke(ctx, inv)
+	inv.Result = response
+
+	switch {
+	case failure == "":
+		inv.LastError = ""
+		w.finish(inv, models.AsyncStatusSucceeded, "")
+	case permanent || inv.Attempts >= inv.MaxAttempts:
+		w.finish(inv, models.AsyncStatusDeadLetter, failure)
+	default:
+		now := w.now()
+		inv.Status = models.AsyncStatusQueued
+		inv.LastError = failure
+		inv.NextAttemptAt = now.Add(w.config.RetryPolicy.Backoff(inv.Attempts))
+		inv.UpdatedAt = now
+		w.save(inv)
+	}
+}
+
+// invoke runs the function and describes the failure, if any. Permanent
+// failures, such as a deleted function, are not retried.
+func (w *Worker) invoke(ctx context.Context, inv *models.AsyncInvocation) (*models.InvocationResponse, string, bool) {
+	var payload interface{}
+	if len(inv.Payload) > 0 {
+		if err := json.Unmarshal(inv.Payload, &payload); err != nil {
+			return nil, fmt.Sprintf("invalid payload: %v", err), true
+		}
+	}
+
+	response, err := w.invoker.Invoke(ctx, inv.FunctionName, inv.Qualifier, payload)
+	if err != nil {
+		var notFound *models.NotFoundError
+		var invalid *models.ValidationError
+		return nil, err.Error(), errors.As(err, &notFound) || errors.As(err, &invalid)
+	}
+	if response.Error != "" {
+		return response, response.Error, false
+	}
+	if response.StatusCode >= 500 {
+		return response, fmt.Sprintf("function returned status %d", response.StatusCode), false
+	}
+	return response, "", false
+}
+
+// finish moves an invocation to a final status
+func (w *Worker) finish(inv *models.AsyncInvocation, status models.AsyncStatus, failure string) {
+	now := w.now()
+	inv.Status = status
+	if failure != "" {
+		inv.LastError = failure
+	}
+	inv.UpdatedAt = now
+	inv.CompletedAt = &now
+	w.save(inv)
+
+	if status == models.AsyncStatusDeadLetter {
+		log.Printf("Async invocation %s of %s dead-lettered after %d attempts: %s",
+			inv.ID, inv.FunctionName, inv.Attempts, inv.LastError)
+	}
+}
+
+// save stores the invocation state
+func (w *Worker) save(inv *models.AsyncInvocation) {
+	if err := w.store.UpdateAsyncInvocation(inv); err != nil {
+		log.Printf("Failed to update async invocation %s: %v", inv.ID, err)
+	}
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// fakeInvoker fails the first failures calls, then succeeds
+type fakeInvoker struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *fakeInvoker) Invoke(ctx context.Context, name, qualifier string, payload interface{}) (*models.InvocationResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.calls <= f.failures {
+		return &models.InvocationResponse{StatusCode: 500, Error: "boom"}, nil
+	}
+	return &models.InvocationResponse{StatusCode: 200, Body: payload}, nil
+}
+
+func setupWorker(t *testing.T, invoker Invoker) (*Worker, storage.Storage) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "impuls-queue-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	store, err := storage.NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(&models.Function{ID: "id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	inv := &models.AsyncInvocation{
+		ID:            "inv",
+		FunctionName:  "test-function",
+		Payload:       []byte(`{"key":"value"}`),
+		Status:        models.AsyncStatusQueued,
+		MaxAttempts:   3,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := store.EnqueueAsyncInvocation(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWorker(store, invoker, Config{
+		RetryPolicy: models.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute},
+	})
+	w.now = func() time.Time { return now }
+	return w, store
+}
+
+// drain runs due invocations, advancing the clock past every backoff
+func drain(w *Worker) int {
+	runs := 0
+	for i := 0; i < 10; i++ {
+		if w.processNext(context.Background()) {
+			runs++
+		}
+		now := w.now().Add(time.Hour)
+		w.now = func() time.Time { return now }
+	}
+	return runs
+}
+
+func TestWorkerRetriesUntilSuccess(t *testing.T) {
+	invoker := &fakeInvoker{failures: 2}
+	w, store := setupWorker(t, invoker)
+
+	if runs := drain(w); runs != 3 {
+		t.Errorf("Expected 3 runs, got %d", runs)
+	}
+
+	inv, err := store.GetAsyncInvocation("inv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != models.AsyncStatusSucceeded || inv.Attempts != 3 {
+		t.Errorf("Expected success on attempt 3, got %s after %d attempts", inv.Status, inv.Attempts)
+	}
+	if inv.Result == nil || inv.Result.StatusCode != 200 || inv.CompletedAt == nil {
+		t.Errorf("Expected the result to be stored, got %+v", inv)
+	}
+}
+
+func TestWorkerBacksOffBetweenAttempts(t *testing.T) {
+	w, store := setupWorker(t, &fakeInvoker{failures: 1})
+	start := w.now()
+
+	if !w.processNext(context.Background()) {
+		t.Fatal("Expected the invocation to be due")
+	}
+	if w.processNext(context.Background()) {
+		t.Error("Expected the retry to wait for its backoff")
+	}
+
+	inv, _ := store.GetAsyncInvocation("inv")
+	if inv.Status != models.AsyncStatusQueued || inv.LastError != "boom" {
+		t.Errorf("Expected queued retry with last error, got %+v", inv)
+	}
+	if !inv.NextAttemptAt.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected retry after 1s, got %v", inv.NextAttemptAt.Sub(start))
+	}
+}
+
+func TestWorkerDeadLettersAfterMaxAttempts(t *testing.T) {
+	w, store := setupWorker(t, &fakeInvoker{failures: 10})
+
+	if runs := drain(w); runs != 3 {
+		t.Errorf("Expected 3 runs, got %d", runs)
+	}
+
+	deadLetters, err := store.ListAsyncInvocations("test-function", models.AsyncStatusDeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].LastError != "boom" {
+		t.Errorf("Expected one dead letter, got %+v", deadLetters)
+	}
+}
+
+func TestWorkerDoesNotRetryPermanentErrors(t *testing.T) {
+	invoker := &fakeInvoker{err: &models.NotFoundError{Resource: "alias", Name: "prod"}}
+	w, store := setupWorker(t, invoker)
+
+	drain(w)
+
+	if invoker.calls != 1 {
+		t.Errorf("Expected a single attempt, got %d", invoker.calls)
+	}
+	inv, _ := store.GetAsyncInvocation("inv")
+	if inv.Status != models.AsyncStatusDeadLetter {
+		t.Errorf("Expected dead letter, got %s", inv.Status)
+	}
+}
+
+func TestWorkerRetriesTransientErrors(t *testing.T) {
+	invoker := &fakeInvoker{err: errors.New("no VM available")}
+	w, _ := setupWorker(t, invoker)
+
+	drain(w)
+
+	if invoker.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", invoker.calls)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := models.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, expected := range want {
+		if got := policy.Backoff(i + 1); got != expected {
+			t.Errorf("Backoff(%d) = %v, want %v", i+1, got, expected)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec computes the run times of a schedule expression
+type Spec interface {
+	// Next returns the first run time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// Parse parses a schedule expression. Supported forms are
+//
+//	rate(5 minutes)       fixed rate in seconds, minutes, hours or days
+//	cron(0 9 * * MON-FRI) five-field cron: minute hour day-of-month month day-of-week
+//	0 9 * * MON-FRI       the same without the cron() wrapper
+//	@hourly, @daily, @weekly, @monthly, @yearly
+func Parse(expr string) (Spec, error) {
+	expr = strings.TrimSpace(expr)
+
+	switch {
+	case strings.HasPrefix(expr, "rate(") && strings.HasSuffix(expr, ")"):
+		return parseRate(expr[len("rate(") : len(expr)-1])
+	case strings.HasPrefix(expr, "cron(") && strings.HasSuffix(expr, ")"):
+		return parseCron(expr[len("cron(") : len(expr)-1])
+	case strings.HasPrefix(expr, "@"):
+		macro, ok := cronMacros[expr]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule macro %s", expr)
+		}
+		return parseCron(macro)
+	default:
+		return parseCron(expr)
+	}
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// RateSpec runs at a fixed interval
+type RateSpec struct {
+	Interval time.Duration
+}
+
+// Next returns t plus the interval
+func (r RateSpec) Next(t time.Time) time.Time {
+	return t.Add(r.Interval)
+}
+
+var rateUnits = map[string]time.Duration{
+	"second":  time.Second,
+	"seconds": time.Second,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+}
+
+// parseRate parses the body of a rate() expression, e.g. "5 minutes"
+func parseRate(body string) (Spec, error) {
+	fields := strings.Fields(body)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("rate must be a value and a unit, e.g. rate(5 minutes)")
+	}
+
+	value, err := strconv.Atoi(fields[0])
+	if err != nil || value < 1 {
+		return nil, fmt.Errorf("rate value must be a positive integer, got %q", fields[0])
+	}
+	unit, ok := rateUnits[strings.ToLower(fields[1])]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate unit %q", fields[1])
+	}
+
+	return RateSpec{Interval: time.Duration(value) * unit}, nil
+}
+
+// CronSpec is a parsed five-field cron expression. Each field is a bitmask
+// of the values it matches.
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record unrestricted day fields. When both day
+	// fields are restricted a day matching either one runs, as in cron(8).
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day-of-month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	// Day 7 is accepted as an alias for Sunday
+	dowField = cronField{name: "day-of-week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// parseCron parses a five-field cron expression
+func parseCron(expr string) (Spec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+
+	spec := &CronSpec{}
+	var err error
+	if spec.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if spec.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if spec.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if spec.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	spec.domStar = fields[2] == "*" || fields[2] == "?"
+	spec.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return spec, nil
+}
+
+// parse parses a comma separated list of values, ranges and steps
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		b, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// parsePart parses one of "*", "n", "a-b", each optionally followed by "/step"
+func (f cronField) parsePart(part string) (uint64, error) {
+	rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+	step := 1
+	if hasStep {
+		var err error
+		step, err = strconv.Atoi(stepPart)
+		if err != nil || step < 1 {
+			return 0, fmt.Errorf("invalid step %q in %s field", stepPart, f.name)
+		}
+	}
+
+	var lo, hi int
+	switch {
+	case rangePart == "*" || rangePart == "?":
+		lo, hi = f.min, f.max
+	case strings.Contains(rangePart, "-"):
+		a, b, _ := strings.Cut(rangePart, "-")
+		var err error
+		if lo, err = f.value(a); err != nil {
+			return 0, err
+		}
+		if hi, err = f.value(b); err != nil {
+			return 0, err
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %q in %s field", rangePart, f.name)
+		}
+	default:
+		v, err := f.value(rangePart)
+		if err != nil {
+			return 0, err
+		}
+		lo, hi = v, v
+		// "5/15" means every 15 starting at 5
+		if hasStep {
+			hi = f.max
+		}
+	}
+
+	var bits uint64
+	for v := lo; v <= hi; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+// value parses a single number or name
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", s, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d in %s field", v, f.min, f.max, f.name)
+	}
+	return v, nil
+}
+
+// maxCronSearch bounds the search for the next run, so that expressions
+// that never match (e.g. 30 February) terminate
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first minute strictly after t that matches the
+// expression, in t's location. It returns the zero time if there is none
+// within five years.
+func (c *CronSpec) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronSearch)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches applies the day-of-month and day-of-week fields
+func (c *CronSpec) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
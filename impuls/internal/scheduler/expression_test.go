@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * FOO *",
+		"@sometimes",
+		"rate(5)",
+		"rate(0 minutes)",
+		"rate(5 weeks)",
+		"cron(* * *)",
+	}
+
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected Parse(%q) to fail", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	base := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", base, time.Date(2025, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", base, time.Date(2025, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", base, time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", base, time.Date(2025, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9 * * MON-FRI", base, time.Date(2025, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2025, 1, 17, 9, 0, 0, 0, time.UTC), time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", base, time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"30 10 1,15 * *", base, time.Date(2025, 2, 1, 10, 30, 0, 0, time.UTC)},
+		{"0 0 1 JAN *", base, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 1st of the month or any Friday
+		{"0 0 1 * FRI", base, time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", base, time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", base, time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"cron(0 12 * * *)", base, time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)},
+		// Seconds are dropped before searching
+		{"* * * * *", base.Add(30 * time.Second), time.Date(2025, 1, 15, 10, 31, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		spec, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := spec.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next(%v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextNeverMatches(t *testing.T) {
+	spec, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := spec.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Expected no run on 30 February, got %v", next)
+	}
+}
+
+func TestRateNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		want time.Duration
+	}{
+		{"rate(30 seconds)", 30 * time.Second},
+		{"rate(1 minute)", time.Minute},
+		{"rate(5 minutes)", 5 * time.Minute},
+		{"rate(2 hours)", 2 * time.Hour},
+		{"rate(1 day)", 24 * time.Hour},
+	}
+
+	base := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	for _, tt := range tests {
+		spec, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := spec.Next(base); got.Sub(base) != tt.want {
+			t.Errorf("%q: expected interval %v, got %v", tt.expr, tt.want, got.Sub(base))
+		}
+	}
+}
+
+func TestNextRunTimezone(t *testing.T) {
+	if _, err := NextRun("@daily", "Not/AZone", time.Now()); err == nil {
+		t.Error("Expected an error for an unknown timezone")
+	}
+
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	from := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	next, err := NextRun("0 9 * * *", "", from.In(loc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 1, 16, 9, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Expected cron to run in UTC by default, got %v", next)
+	}
+}
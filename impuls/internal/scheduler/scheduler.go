@@ -0,0 +1,210 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+const (
+	defaultPollInterval = time.Second
+
+	// maxCatchUp bounds how many missed runs are counted for one schedule
+	// after a long outage
+	maxCatchUp = 100000
+)
+
+// Enqueuer queues an asynchronous function invocation that becomes due at
+// a given time
+type Enqueuer interface {
+	EnqueueInvocation(name, qualifier string, payload json.RawMessage, at time.Time) (*models.AsyncInvocation, error)
+}
+
+// Scheduler fires due schedules by queueing an asynchronous invocation, so
+// scheduled runs get the same retries and dead-letter handling
+type Scheduler struct {
+	store        storage.Storage
+	enqueuer     Enqueuer
+	pollInterval time.Duration
+	now          func() time.Time
+	randIntn     func(n int) int
+	stopChan     chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewScheduler creates a new scheduler that checks for due schedules every
+// pollInterval
+func NewScheduler(store storage.Storage, enqueuer Enqueuer, pollInterval time.Duration) *Scheduler {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	return &Scheduler{
+		store:        store,
+		enqueuer:     enqueuer,
+		pollInterval: pollInterval,
+		now:          time.Now,
+		randIntn:     rand.Intn,
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Start fires anything that became due while no server was running, then
+// keeps checking in the background
+func (s *Scheduler) Start(ctx context.Context) {
+	s.tick()
+
+	s.wg.Add(1)
+	go s.run(ctx)
+}
+
+// Stop stops the scheduler
+func (s *Scheduler) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+}
+
+// run checks for due schedules until stopped
+func (s *Scheduler) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+// tick fires every due schedule
+func (s *Scheduler) tick() {
+	now := s.now()
+
+	due, err := s.store.ListDueSchedules(now)
+	if err != nil {
+		log.Printf("Failed to list due schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range due {
+		if err := s.fire(schedule, now); err != nil {
+			log.Printf("Failed to fire schedule %s of %s: %v", schedule.ID, schedule.FunctionName, err)
+		}
+	}
+}
+
+// fire advances a due schedule past now and queues one invocation. Runs
+// that fell due while no server was running are counted as missed rather
+// than fired.
+func (s *Scheduler) fire(schedule *models.Schedule, now time.Time) error {
+	spec, loc, err := parseSchedule(schedule.Expression, schedule.Timezone)
+	if err != nil {
+		return err
+	}
+
+	var missed int64
+	var lastMissed time.Time
+	next := spec.Next(schedule.NextRunAt.In(loc))
+	for !next.IsZero() && !next.After(now) {
+		missed++
+		lastMissed = next
+		if missed >= maxCatchUp {
+			next = spec.Next(now.In(loc))
+			break
+		}
+		next = spec.Next(next)
+	}
+	if next.IsZero() {
+		return fmt.Errorf("expression %q has no future runs", schedule.Expression)
+	}
+
+	advanced := *schedule
+	advanced.NextRunAt = next
+	advanced.LastRunAt = &now
+	advanced.UpdatedAt = now
+	if missed > 0 {
+		advanced.MissedRuns += missed
+		advanced.LastMissedAt = &lastMissed
+	}
+
+	won, err := s.store.AdvanceSchedule(&advanced, schedule.NextRunAt)
+	if err != nil {
+		return err
+	}
+	if !won {
+		// Another server fired this run, or the schedule was just changed
+		return nil
+	}
+
+	if missed > 0 {
+		log.Printf("Schedule %s of %s missed %d runs", schedule.ID, schedule.FunctionName, missed)
+	}
+
+	runAt := now
+	if schedule.JitterSec > 0 {
+		runAt = runAt.Add(time.Duration(s.randIntn(schedule.JitterSec*1000)) * time.Millisecond)
+	}
+
+	payload := schedule.Payload
+	if len(payload) == 0 {
+		payload, err = json.Marshal(map[string]interface{}{
+			"source":         "impuls.scheduler",
+			"schedule_id":    schedule.ID,
+			"scheduled_time": schedule.NextRunAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.enqueuer.EnqueueInvocation(schedule.FunctionName, schedule.Qualifier, payload, runAt)
+	return err
+}
+
+// NextRun returns the first run of a schedule expression after t. It also
+// validates the expression and timezone.
+func NextRun(expression, timezone string, t time.Time) (time.Time, error) {
+	spec, loc, err := parseSchedule(expression, timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Rates count from whole seconds
+	next := spec.Next(t.In(loc).Truncate(time.Second))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("expression %q never matches", expression)
+	}
+	return next, nil
+}
+
+// parseSchedule parses an expression and loads its timezone
+func parseSchedule(expression, timezone string) (Spec, *time.Location, error) {
+	spec, err := Parse(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc := time.UTC
+	if timezone != "" {
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
+
+	return spec, loc, nil
+}
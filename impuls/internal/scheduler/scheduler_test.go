@@ -0,0 +1,169 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+	"github.com/oblak/impuls/internal/storage"
+)
+
+// queued records invocations passed to the fake enqueuer
+type queued struct {
+	name    string
+	payload json.RawMessage
+	at      time.Time
+}
+
+type fakeEnqueuer struct {
+	calls []queued
+}
+
+func (f *fakeEnqueuer) EnqueueInvocation(name, qualifier string, payload json.RawMessage, at time.Time) (*models.AsyncInvocation, error) {
+	f.calls = append(f.calls, queued{name: name, payload: payload, at: at})
+	return &models.AsyncInvocation{ID: "inv"}, nil
+}
+
+func setupStore(t *testing.T, schedule *models.Schedule) storage.Storage {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "impuls-scheduler-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	store, err := storage.NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(&models.Function{ID: "id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateSchedule(schedule); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func newTestScheduler(store storage.Storage, enqueuer Enqueuer, now time.Time) *Scheduler {
+	s := NewScheduler(store, enqueuer, time.Second)
+	s.now = func() time.Time { return now }
+	s.randIntn = func(n int) int { return n - 1 }
+	return s
+}
+
+func TestSchedulerFiresDueSchedule(t *testing.T) {
+	due := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	store := setupStore(t, &models.Schedule{
+		ID: "every-5", FunctionName: "test-function", Expression: "rate(5 minutes)", Enabled: true, NextRunAt: due,
+	})
+
+	enqueuer := &fakeEnqueuer{}
+	newTestScheduler(store, enqueuer, due.Add(time.Second)).tick()
+
+	if len(enqueuer.calls) != 1 {
+		t.Fatalf("Expected 1 queued invocation, got %d", len(enqueuer.calls))
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal(enqueuer.calls[0].payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event["source"] != "impuls.scheduler" || event["schedule_id"] != "every-5" {
+		t.Errorf("Unexpected default event: %v", event)
+	}
+
+	schedule, _ := store.GetSchedule("every-5")
+	if want := due.Add(5 * time.Minute); !schedule.NextRunAt.Equal(want) {
+		t.Errorf("Expected next run at %v, got %v", want, schedule.NextRunAt)
+	}
+	if schedule.MissedRuns != 0 || schedule.LastRunAt == nil {
+		t.Errorf("Expected a recorded run and no missed runs, got %+v", schedule)
+	}
+
+	// Nothing is due until the next run
+	newTestScheduler(store, enqueuer, due.Add(time.Minute)).tick()
+	if len(enqueuer.calls) != 1 {
+		t.Errorf("Expected no further invocations, got %d", len(enqueuer.calls))
+	}
+}
+
+func TestSchedulerRecordsMissedRuns(t *testing.T) {
+	due := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	store := setupStore(t, &models.Schedule{
+		ID: "hourly", FunctionName: "test-function", Expression: "@hourly", Enabled: true, NextRunAt: due,
+		Payload: json.RawMessage(`{"job":"report"}`),
+	})
+
+	// The server was down from 10:00 until 13:30
+	enqueuer := &fakeEnqueuer{}
+	newTestScheduler(store, enqueuer, due.Add(3*time.Hour+30*time.Minute)).tick()
+
+	if len(enqueuer.calls) != 1 {
+		t.Fatalf("Expected a single catch-up invocation, got %d", len(enqueuer.calls))
+	}
+	if string(enqueuer.calls[0].payload) != `{"job":"report"}` {
+		t.Errorf("Expected the configured payload, got %s", enqueuer.calls[0].payload)
+	}
+
+	schedule, _ := store.GetSchedule("hourly")
+	if schedule.MissedRuns != 3 {
+		t.Errorf("Expected 3 missed runs (11:00, 12:00, 13:00), got %d", schedule.MissedRuns)
+	}
+	if schedule.LastMissedAt == nil || !schedule.LastMissedAt.Equal(due.Add(3*time.Hour)) {
+		t.Errorf("Expected last missed run at 13:00, got %v", schedule.LastMissedAt)
+	}
+	if want := due.Add(4 * time.Hour); !schedule.NextRunAt.Equal(want) {
+		t.Errorf("Expected next run at 14:00, got %v", schedule.NextRunAt)
+	}
+}
+
+func TestSchedulerFiresOncePerRun(t *testing.T) {
+	due := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	store := setupStore(t, &models.Schedule{
+		ID: "shared", FunctionName: "test-function", Expression: "* * * * *", Enabled: true, NextRunAt: due,
+	})
+
+	// Two servers see the same due schedule
+	due1, _ := store.ListDueSchedules(due)
+	due2, _ := store.ListDueSchedules(due)
+
+	enqueuer := &fakeEnqueuer{}
+	first := newTestScheduler(store, enqueuer, due)
+	second := newTestScheduler(store, enqueuer, due)
+	if err := first.fire(due1[0], due); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.fire(due2[0], due); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(enqueuer.calls) != 1 {
+		t.Errorf("Expected the run to fire once, got %d", len(enqueuer.calls))
+	}
+}
+
+func TestSchedulerJitterAndDisabled(t *testing.T) {
+	due := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	store := setupStore(t, &models.Schedule{
+		ID: "jittered", FunctionName: "test-function", Expression: "@daily", Enabled: true, NextRunAt: due, JitterSec: 30,
+	})
+	if err := store.CreateSchedule(&models.Schedule{
+		ID: "disabled", FunctionName: "test-function", Expression: "@daily", Enabled: false, NextRunAt: due,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	enqueuer := &fakeEnqueuer{}
+	newTestScheduler(store, enqueuer, due).tick()
+
+	if len(enqueuer.calls) != 1 {
+		t.Fatalf("Expected only the enabled schedule to fire, got %d", len(enqueuer.calls))
+	}
+	delay := enqueuer.calls[0].at.Sub(due)
+	if delay <= 0 || delay >= 30*time.Second {
+		t.Errorf("Expected a jitter delay below 30s, got %v", delay)
+	}
+}
@@ -0,0 +1,136 @@
+// Package secrets encrypts secret values with envelope encryption: every
+// value is sealed with a fresh data key, and the data key is sealed with the
+// server's master key. Only the sealed forms are ever stored.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// KeySize is the size of the master key and of data keys, for AES-256
+const KeySize = 32
+
+// ErrWrongKey is returned for values sealed with another master key
+var ErrWrongKey = errors.New("secret was encrypted with a different master key")
+
+// MasterKey seals and opens secret values
+type MasterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// ParseKey parses a base64 encoded 32-byte master key
+func ParseKey(encoded string) (*MasterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &MasterKey{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// LoadKey reads a master key from a file holding it base64 encoded
+func LoadKey(path string) (*MasterKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key: %w", err)
+	}
+	return ParseKey(string(data))
+}
+
+// ID identifies the master key without revealing it
+func (k *MasterKey) ID() string {
+	return k.id
+}
+
+// Seal encrypts the value of the named secret. The name is authenticated
+// with the value, so an envelope cannot be moved to another secret.
+func (k *MasterKey) Seal(name string, value []byte) (*models.SecretEnvelope, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := seal(aead, value, name)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := seal(k.aead, dataKey, name)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SecretEnvelope{KeyID: k.id, EncryptedKey: encryptedKey, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts the value of the named secret
+func (k *MasterKey) Open(name string, envelope *models.SecretEnvelope) ([]byte, error) {
+	if envelope == nil {
+		return nil, fmt.Errorf("secret %s has no value", name)
+	}
+	if envelope.KeyID != k.id {
+		return nil, ErrWrongKey
+	}
+
+	dataKey, err := open(k.aead, envelope.EncryptedKey, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := open(aead, envelope.Ciphertext, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return value, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce, which prefixes the result
+func seal(aead cipher.AEAD, plaintext []byte, name string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(name)), nil
+}
+
+// open decrypts the result of seal
+func open(aead cipher.AEAD, sealed []byte, name string) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(name))
+}
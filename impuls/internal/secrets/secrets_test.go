@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestKey(t *testing.T) (*MasterKey, string) {
+	t.Helper()
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, encoded
+}
+
+func TestSealOpen(t *testing.T) {
+	key, _ := newTestKey(t)
+	value := []byte("hunter2")
+
+	envelope, err := key.Seal("db-password", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KeyID != key.ID() || bytes.Contains(envelope.Ciphertext, value) {
+		t.Fatalf("Unexpected envelope %+v", envelope)
+	}
+
+	got, err := key.Open("db-password", envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("Expected %q, got %q", value, got)
+	}
+
+	// Every seal uses a fresh data key and nonces
+	again, err := key.Seal("db-password", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again.Ciphertext, envelope.Ciphertext) || bytes.Equal(again.EncryptedKey, envelope.EncryptedKey) {
+		t.Error("Expected sealing the same value twice to differ")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	key, _ := newTestKey(t)
+	other, _ := newTestKey(t)
+
+	envelope, err := key.Seal("db-password", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.Open("db-password", envelope); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+	if _, err := key.Open("api-token", envelope); err == nil {
+		t.Error("Expected an envelope moved to another secret to be rejected")
+	}
+
+	tampered := *envelope
+	tampered.Ciphertext = append([]byte(nil), envelope.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 1
+	if _, err := key.Open("db-password", &tampered); err == nil {
+		t.Error("Expected a tampered value to be rejected")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+		if _, err := ParseKey(encoded); err == nil {
+			t.Errorf("Expected %q to be rejected", encoded)
+		}
+	}
+
+	key, encoded := newTestKey(t)
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID() != key.ID() {
+		t.Errorf("Expected the same key from the file, got ID %s instead of %s", loaded.ID(), key.ID())
+	}
+}
@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// loadAsyncInvocations loads the asynchronous invocation queue from disk
+func (fs *FileStorage) loadAsyncInvocations() error {
+	asyncDir := filepath.Join(fs.basePath, "async")
+	entries, err := os.ReadDir(asyncDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		var inv models.AsyncInvocation
+		if !readJSONFile(filepath.Join(asyncDir, entry.Name()), &inv) {
+			continue
+		}
+		fs.asyncDB[inv.ID] = &inv
+	}
+
+	return nil
+}
+
+// saveAsyncInvocation saves an asynchronous invocation to disk
+func (fs *FileStorage) saveAsyncInvocation(inv *models.AsyncInvocation) error {
+	return writeJSONFile(filepath.Join(fs.basePath, "async", inv.ID+".json"), inv)
+}
+
+// EnqueueAsyncInvocation adds an invocation to the queue
+func (fs *FileStorage) EnqueueAsyncInvocation(inv *models.AsyncInvocation) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.functionsDB[inv.FunctionName]; !exists {
+		return ErrNotFound
+	}
+
+	stored := *inv
+	if err := fs.saveAsyncInvocation(&stored); err != nil {
+		return err
+	}
+	fs.asyncDB[inv.ID] = &stored
+	return nil
+}
+
+// ClaimAsyncInvocation marks the invocation that has been due the longest
+// as running and leases it until now+lease. Running invocations whose lease
+// expired are claimed again, so work survives a crashed worker. It returns
+// nil if nothing is due.
+func (fs *FileStorage) ClaimAsyncInvocation(now time.Time, lease time.Duration) (*models.AsyncInvocation, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var next *models.AsyncInvocation
+	for _, inv := range fs.asyncDB {
+		if inv.Status != models.AsyncStatusQueued && inv.Status != models.AsyncStatusRunning {
+			continue
+		}
+		if inv.NextAttemptAt.After(now) {
+			continue
+		}
+		if next == nil || inv.NextAttemptAt.Before(next.NextAttemptAt) {
+			next = inv
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	claimed := *next
+	claimed.Status = models.AsyncStatusRunning
+	claimed.Attempts++
+	claimed.NextAttemptAt = now.Add(lease)
+	claimed.UpdatedAt = now
+	if err := fs.saveAsyncInvocation(&claimed); err != nil {
+		return nil, err
+	}
+	fs.asyncDB[claimed.ID] = &claimed
+
+	result := claimed
+	return &result, nil
+}
+
+// UpdateAsyncInvocation saves the state of an invocation
+func (fs *FileStorage) UpdateAsyncInvocation(inv *models.AsyncInvocation) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.asyncDB[inv.ID]; !exists {
+		return ErrAsyncInvocationNotFound
+	}
+
+	stored := *inv
+	if err := fs.saveAsyncInvocation(&stored); err != nil {
+		return err
+	}
+	fs.asyncDB[inv.ID] = &stored
+	return nil
+}
+
+// GetAsyncInvocation retrieves an invocation by ID
+func (fs *FileStorage) GetAsyncInvocation(id string) (*models.AsyncInvocation, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	inv, exists := fs.asyncDB[id]
+	if !exists {
+		return nil, ErrAsyncInvocationNotFound
+	}
+	result := *inv
+	return &result, nil
+}
+
+// ListAsyncInvocations returns a function's invocations in a given status,
+// oldest first
+func (fs *FileStorage) ListAsyncInvocations(name string, status models.AsyncStatus) ([]*models.AsyncInvocation, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	invocations := []*models.AsyncInvocation{}
+	for _, inv := range fs.asyncDB {
+		if inv.FunctionName == name && inv.Status == status {
+			result := *inv
+			invocations = append(invocations, &result)
+		}
+	}
+	sort.Slice(invocations, func(i, j int) bool {
+		return invocations[i].CreatedAt.Before(invocations[j].CreatedAt)
+	})
+	return invocations, nil
+}
+
+// DeleteAsyncInvocation removes an invocation from the queue
+func (fs *FileStorage) DeleteAsyncInvocation(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.asyncDB[id]; !exists {
+		return ErrAsyncInvocationNotFound
+	}
+
+	os.Remove(filepath.Join(fs.basePath, "async", id+".json"))
+	delete(fs.asyncDB, id)
+	return nil
+}
+
+// deleteAsyncInvocations removes all queued and finished invocations of a
+// function. The caller must hold fs.mu.
+func (fs *FileStorage) deleteAsyncInvocations(name string) {
+	for id, inv := range fs.asyncDB {
+		if inv.FunctionName == name {
+			os.Remove(filepath.Join(fs.basePath, "async", id+".json"))
+			delete(fs.asyncDB, id)
+		}
+	}
+}
+
+const asyncInvocationColumns = `id, function_name, qualifier, payload, status, attempts, max_attempts,
+	last_error, result, next_attempt_at, created_at, updated_at, completed_at`
+
+// EnqueueAsyncInvocation adds an invocation to the queue
+func (ps *PostgresStorage) EnqueueAsyncInvocation(inv *models.AsyncInvocation) error {
+	resultJSON, err := marshalInvocationResult(inv.Result)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO async_invocations (` + asyncInvocationColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+
+	_, err = ps.db.Exec(query,
+		inv.ID, inv.FunctionName, inv.Qualifier, nullableJSON(inv.Payload), inv.Status,
+		inv.Attempts, inv.MaxAttempts, inv.LastError, resultJSON, inv.NextAttemptAt,
+		inv.CreatedAt, inv.UpdatedAt, inv.CompletedAt,
+	)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to enqueue invocation: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimAsyncInvocation marks the invocation that has been due the longest
+// as running and leases it until now+lease. SKIP LOCKED lets several
+// servers share the queue without handing out the same invocation twice.
+func (ps *PostgresStorage) ClaimAsyncInvocation(now time.Time, lease time.Duration) (*models.AsyncInvocation, error) {
+	query := `
+		UPDATE async_invocations
+		SET status = 'running', attempts = attempts + 1, next_attempt_at = $2, updated_at = $1
+		WHERE id = (
+			SELECT id FROM async_invocations
+			WHERE status IN ('queued', 'running') AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + asyncInvocationColumns
+
+	inv, err := scanAsyncInvocation(ps.db.QueryRow(query, now, now.Add(lease)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim invocation: %w", err)
+	}
+
+	return inv, nil
+}
+
+// UpdateAsyncInvocation saves the state of an invocation
+func (ps *PostgresStorage) UpdateAsyncInvocation(inv *models.AsyncInvocation) error {
+	resultJSON, err := marshalInvocationResult(inv.Result)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE async_invocations
+		SET status = $1, attempts = $2, max_attempts = $3, last_error = $4, result = $5,
+			next_attempt_at = $6, updated_at = $7, completed_at = $8
+		WHERE id = $9
+	`
+
+	result, err := ps.db.Exec(query,
+		inv.Status, inv.Attempts, inv.MaxAttempts, inv.LastError, resultJSON,
+		inv.NextAttemptAt, inv.UpdatedAt, inv.CompletedAt, inv.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update invocation: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrAsyncInvocationNotFound
+	}
+
+	return nil
+}
+
+// GetAsyncInvocation retrieves an invocation by ID
+func (ps *PostgresStorage) GetAsyncInvocation(id string) (*models.AsyncInvocation, error) {
+	query := `SELECT ` + asyncInvocationColumns + ` FROM async_invocations WHERE id = $1`
+
+	inv, err := scanAsyncInvocation(ps.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAsyncInvocationNotFound
+		}
+		return nil, fmt.Errorf("failed to get invocation: %w", err)
+	}
+
+	return inv, nil
+}
+
+// ListAsyncInvocations returns a function's invocations in a given status,
+// oldest first
+func (ps *PostgresStorage) ListAsyncInvocations(name string, status models.AsyncStatus) ([]*models.AsyncInvocation, error) {
+	query := `
+		SELECT ` + asyncInvocationColumns + `
+		FROM async_invocations
+		WHERE function_name = $1 AND status = $2
+		ORDER BY created_at
+	`
+
+	rows, err := ps.db.Query(query, name, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invocations: %w", err)
+	}
+	defer rows.Close()
+
+	invocations := []*models.AsyncInvocation{}
+	for rows.Next() {
+		inv, err := scanAsyncInvocation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invocation: %w", err)
+		}
+		invocations = append(invocations, inv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invocations: %w", err)
+	}
+
+	return invocations, nil
+}
+
+// DeleteAsyncInvocation removes an invocation from the queue
+func (ps *PostgresStorage) DeleteAsyncInvocation(id string) error {
+	result, err := ps.db.Exec(`DELETE FROM async_invocations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete invocation: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrAsyncInvocationNotFound
+	}
+
+	return nil
+}
+
+// scanAsyncInvocation scans an async_invocations row
+func scanAsyncInvocation(row rowScanner) (*models.AsyncInvocation, error) {
+	inv := &models.AsyncInvocation{}
+	var qualifier, lastError sql.NullString
+	var payload, resultJSON []byte
+	var completedAt sql.NullTime
+
+	err := row.Scan(
+		&inv.ID, &inv.FunctionName, &qualifier, &payload, &inv.Status,
+		&inv.Attempts, &inv.MaxAttempts, &lastError, &resultJSON, &inv.NextAttemptAt,
+		&inv.CreatedAt, &inv.UpdatedAt, &completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	inv.Qualifier = qualifier.String
+	inv.LastError = lastError.String
+	if len(payload) > 0 {
+		inv.Payload = json.RawMessage(payload)
+	}
+	if completedAt.Valid {
+		inv.CompletedAt = &completedAt.Time
+	}
+
+	if len(resultJSON) > 0 && string(resultJSON) != "null" {
+		inv.Result = &models.InvocationResponse{}
+		if err := json.Unmarshal(resultJSON, inv.Result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+		}
+	}
+
+	return inv, nil
+}
+
+// marshalInvocationResult encodes an invocation result for the JSONB
+// column, storing NULL until the invocation has run
+func marshalInvocationResult(r *models.InvocationResponse) ([]byte, error) {
+	if r == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return data, nil
+}
+
+// nullableJSON stores an empty JSON document as NULL
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
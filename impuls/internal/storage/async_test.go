@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// testAsyncQueue exercises the queue operations shared by both backends
+func testAsyncQueue(t *testing.T, s Storage) {
+	t.Helper()
+
+	if err := s.Create(&models.Function{
+		ID: "test-id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler",
+		Code: "code", MemoryMB: 128, TimeoutSec: 30, CreatedAt: time.Now(), UpdatedAt: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Millisecond)
+	for i, id := range []string{"first", "second"} {
+		inv := &models.AsyncInvocation{
+			ID:            id,
+			FunctionName:  "test-function",
+			Payload:       json.RawMessage(`{"n":1}`),
+			Status:        models.AsyncStatusQueued,
+			MaxAttempts:   3,
+			NextAttemptAt: now.Add(time.Duration(i) * time.Hour),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := s.EnqueueAsyncInvocation(inv); err != nil {
+			t.Fatalf("Failed to enqueue invocation: %v", err)
+		}
+	}
+
+	if err := s.EnqueueAsyncInvocation(&models.AsyncInvocation{ID: "orphan", FunctionName: "missing"}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for unknown function, got %v", err)
+	}
+
+	// Only the first invocation is due
+	claimed, err := s.ClaimAsyncInvocation(now, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to claim invocation: %v", err)
+	}
+	if claimed == nil || claimed.ID != "first" {
+		t.Fatalf("Expected to claim first, got %+v", claimed)
+	}
+	if claimed.Status != models.AsyncStatusRunning || claimed.Attempts != 1 {
+		t.Errorf("Expected running invocation on attempt 1, got %s attempt %d", claimed.Status, claimed.Attempts)
+	}
+
+	if next, err := s.ClaimAsyncInvocation(now, time.Minute); err != nil || next != nil {
+		t.Errorf("Expected nothing due, got %+v, %v", next, err)
+	}
+
+	// The lease of a lost worker expires and the invocation is handed out again
+	reclaimed, err := s.ClaimAsyncInvocation(now.Add(2*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed == nil || reclaimed.ID != "first" || reclaimed.Attempts != 2 {
+		t.Fatalf("Expected to reclaim first on attempt 2, got %+v", reclaimed)
+	}
+
+	reclaimed.Status = models.AsyncStatusDeadLetter
+	reclaimed.LastError = "boom"
+	reclaimed.Result = &models.InvocationResponse{StatusCode: 500, Error: "boom"}
+	if err := s.UpdateAsyncInvocation(reclaimed); err != nil {
+		t.Fatalf("Failed to update invocation: %v", err)
+	}
+
+	deadLetters, err := s.ListAsyncInvocations("test-function", models.AsyncStatusDeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].LastError != "boom" || deadLetters[0].Result == nil {
+		t.Errorf("Expected one dead letter with its result, got %+v", deadLetters)
+	}
+
+	got, err := s.GetAsyncInvocation("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Payload) != `{"n":1}` {
+		t.Errorf("Expected payload to round-trip, got %s", got.Payload)
+	}
+
+	if err := s.DeleteAsyncInvocation("first"); err != nil {
+		t.Fatalf("Failed to delete invocation: %v", err)
+	}
+	if _, err := s.GetAsyncInvocation("first"); err != ErrAsyncInvocationNotFound {
+		t.Errorf("Expected ErrAsyncInvocationNotFound, got %v", err)
+	}
+
+	// Deleting the function drops its queue
+	if err := s.Delete("test-function"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAsyncInvocation("second"); err != ErrAsyncInvocationNotFound {
+		t.Errorf("Expected ErrAsyncInvocationNotFound after function delete, got %v", err)
+	}
+}
+
+func TestFileStorageAsyncQueue(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testAsyncQueue(t, fs)
+}
+
+func TestFileStorageAsyncQueuePersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Create(&models.Function{ID: "id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}); err != nil {
+		t.Fatal(err)
+	}
+	inv := &models.AsyncInvocation{ID: "queued", FunctionName: "test-function", Status: models.AsyncStatusQueued, MaxAttempts: 3, NextAttemptAt: time.Now()}
+	if err := fs.EnqueueAsyncInvocation(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := fs.ClaimAsyncInvocation(time.Now(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.ID != "queued" {
+		t.Errorf("Expected queued invocation to survive a restart, got %+v", claimed)
+	}
+}
+
+func TestPostgresStorageAsyncQueue(t *testing.T) {
+	ps, cleanup := setupTestDB(t)
+	if ps == nil {
+		return
+	}
+	defer cleanup()
+
+	testAsyncQueue(t, ps)
+}
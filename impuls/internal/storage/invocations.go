@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// loadInvocationRecords loads the invocation history from disk. Records are
+// kept in one directory per function.
+func (fs *FileStorage) loadInvocationRecords() error {
+	invocationsDir := filepath.Join(fs.basePath, "invocations")
+	functionDirs, err := os.ReadDir(invocationsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, functionDir := range functionDirs {
+		if !functionDir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(invocationsDir, functionDir.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			var r models.InvocationRecord
+			if !readJSONFile(filepath.Join(dir, entry.Name()), &r) {
+				continue
+			}
+			fs.invocationsDB[r.ID] = &r
+		}
+	}
+
+	return nil
+}
+
+// invocationRecordPath returns the file an invocation record is stored in
+func (fs *FileStorage) invocationRecordPath(r *models.InvocationRecord) string {
+	return filepath.Join(fs.basePath, "invocations", r.FunctionName, r.ID+".json")
+}
+
+// SaveInvocationRecord stores the history record of an invocation
+func (fs *FileStorage) SaveInvocationRecord(r *models.InvocationRecord) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.functionsDB[r.FunctionName]; !exists {
+		return ErrNotFound
+	}
+
+	stored := *r
+	if err := writeJSONFile(fs.invocationRecordPath(&stored), &stored); err != nil {
+		return err
+	}
+	fs.invocationsDB[r.ID] = &stored
+	return nil
+}
+
+// GetInvocationRecord retrieves an invocation record by ID
+func (fs *FileStorage) GetInvocationRecord(id string) (*models.InvocationRecord, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	r, exists := fs.invocationsDB[id]
+	if !exists {
+		return nil, ErrInvocationRecordNotFound
+	}
+	result := *r
+	return &result, nil
+}
+
+// ListInvocationRecords returns the matching records of a function, newest
+// first
+func (fs *FileStorage) ListInvocationRecords(name string, filter models.InvocationFilter) ([]*models.InvocationRecord, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	records := []*models.InvocationRecord{}
+	for _, r := range fs.invocationsDB {
+		if r.FunctionName == name && filter.Matches(r) {
+			result := *r
+			records = append(records, &result)
+		}
+	}
+	sortNewestFirst(records)
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// PruneInvocationRecords deletes records started before olderThan and all
+// but the newest maxPerFunction records of each function. A zero olderThan
+// or maxPerFunction disables that limit. It returns the number of records
+// deleted.
+func (fs *FileStorage) PruneInvocationRecords(olderThan time.Time, maxPerFunction int) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	byFunction := make(map[string][]*models.InvocationRecord)
+	for _, r := range fs.invocationsDB {
+		byFunction[r.FunctionName] = append(byFunction[r.FunctionName], r)
+	}
+
+	deleted := 0
+	for _, records := range byFunction {
+		sortNewestFirst(records)
+		for i, r := range records {
+			if (maxPerFunction > 0 && i >= maxPerFunction) || (!olderThan.IsZero() && r.StartedAt.Before(olderThan)) {
+				os.Remove(fs.invocationRecordPath(r))
+				delete(fs.invocationsDB, r.ID)
+				deleted++
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// deleteInvocationRecords removes the invocation history of a function. The
+// caller must hold fs.mu.
+func (fs *FileStorage) deleteInvocationRecords(name string) {
+	os.RemoveAll(filepath.Join(fs.basePath, "invocations", name))
+	for id, r := range fs.invocationsDB {
+		if r.FunctionName == name {
+			delete(fs.invocationsDB, id)
+		}
+	}
+}
+
+// sortNewestFirst sorts records by start time, newest first
+func sortNewestFirst(records []*models.InvocationRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+}
+
+const invocationRecordColumns = `id, function_name, version, qualifier, local, status, status_code, error,
+	started_at, duration_ms, payload, payload_truncated, logs, logs_truncated`
+
+// SaveInvocationRecord stores the history record of an invocation
+func (ps *PostgresStorage) SaveInvocationRecord(r *models.InvocationRecord) error {
+	query := `
+		INSERT INTO invocation_records (` + invocationRecordColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+
+	_, err := ps.db.Exec(query,
+		r.ID, r.FunctionName, r.Version, r.Qualifier, r.Local, r.Status, r.StatusCode, r.Error,
+		r.StartedAt, r.Duration, r.Payload, r.PayloadTruncated, r.Logs, r.LogsTruncated,
+	)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to save invocation record: %w", err)
+	}
+
+	return nil
+}
+
+// GetInvocationRecord retrieves an invocation record by ID
+func (ps *PostgresStorage) GetInvocationRecord(id string) (*models.InvocationRecord, error) {
+	query := `SELECT ` + invocationRecordColumns + ` FROM invocation_records WHERE id = $1`
+
+	r, err := scanInvocationRecord(ps.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvocationRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get invocation record: %w", err)
+	}
+
+	return r, nil
+}
+
+// ListInvocationRecords returns the matching records of a function, newest
+// first
+func (ps *PostgresStorage) ListInvocationRecords(name string, filter models.InvocationFilter) ([]*models.InvocationRecord, error) {
+	query := `SELECT ` + invocationRecordColumns + ` FROM invocation_records WHERE function_name = $1`
+	args := []interface{}{name}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if !filter.Before.IsZero() {
+		args = append(args, filter.Before)
+		query += fmt.Sprintf(" AND started_at < $%d", len(args))
+	}
+	query += " ORDER BY started_at DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := ps.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invocation records: %w", err)
+	}
+	defer rows.Close()
+
+	records := []*models.InvocationRecord{}
+	for rows.Next() {
+		r, err := scanInvocationRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invocation record: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invocation records: %w", err)
+	}
+
+	return records, nil
+}
+
+// PruneInvocationRecords deletes records started before olderThan and all
+// but the newest maxPerFunction records of each function. A zero olderThan
+// or maxPerFunction disables that limit. It returns the number of records
+// deleted.
+func (ps *PostgresStorage) PruneInvocationRecords(olderThan time.Time, maxPerFunction int) (int, error) {
+	deleted := 0
+
+	if !olderThan.IsZero() {
+		result, err := ps.db.Exec(`DELETE FROM invocation_records WHERE started_at < $1`, olderThan)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune invocation records: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		deleted += int(rows)
+	}
+
+	if maxPerFunction > 0 {
+		query := `
+			DELETE FROM invocation_records WHERE id IN (
+				SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY function_name ORDER BY started_at DESC) AS row_num
+					FROM invocation_records
+				) ranked
+				WHERE row_num > $1
+			)
+		`
+		result, err := ps.db.Exec(query, maxPerFunction)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to prune invocation records: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		deleted += int(rows)
+	}
+
+	return deleted, nil
+}
+
+// scanInvocationRecord scans an invocation_records row
+func scanInvocationRecord(row rowScanner) (*models.InvocationRecord, error) {
+	r := &models.InvocationRecord{}
+	var version, qualifier, errMsg, payload, logs sql.NullString
+
+	err := row.Scan(
+		&r.ID, &r.FunctionName, &version, &qualifier, &r.Local, &r.Status, &r.StatusCode, &errMsg,
+		&r.StartedAt, &r.Duration, &payload, &r.PayloadTruncated, &logs, &r.LogsTruncated,
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.Version = version.String
+	r.Qualifier = qualifier.String
+	r.Error = errMsg.String
+	r.Payload = payload.String
+	r.Logs = logs.String
+
+	return r, nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// testInvocationHistory exercises the invocation history operations shared
+// by both backends
+func testInvocationHistory(t *testing.T, s Storage) {
+	t.Helper()
+
+	for _, name := range []string{"test-function", "other-function"} {
+		if err := s.Create(&models.Function{
+			ID: name + "-id", Name: name, Runtime: models.RuntimeNodeJS20, Handler: "index.handler",
+			Code: "code", MemoryMB: 128, TimeoutSec: 30, CreatedAt: time.Now(), UpdatedAt: time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		status := models.InvocationSucceeded
+		if i%2 == 1 {
+			status = models.InvocationFailed
+		}
+		record := &models.InvocationRecord{
+			ID:           fmt.Sprintf("inv-%d", i),
+			FunctionName: "test-function",
+			Version:      models.LatestVersion,
+			Status:       status,
+			StatusCode:   200,
+			StartedAt:    start.Add(time.Duration(i) * time.Minute),
+			Duration:     12,
+			Payload:      `{"n":` + fmt.Sprint(i) + `}`,
+			Logs:         "line one\nline two",
+		}
+		if err := s.SaveInvocationRecord(record); err != nil {
+			t.Fatalf("Failed to save invocation record: %v", err)
+		}
+	}
+	if err := s.SaveInvocationRecord(&models.InvocationRecord{
+		ID: "other", FunctionName: "other-function", Status: models.InvocationSucceeded, StartedAt: start,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveInvocationRecord(&models.InvocationRecord{ID: "orphan", FunctionName: "missing", StartedAt: start}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for unknown function, got %v", err)
+	}
+
+	record, err := s.GetInvocationRecord("inv-1")
+	if err != nil {
+		t.Fatalf("Failed to get invocation record: %v", err)
+	}
+	if record.Status != models.InvocationFailed || record.Logs != "line one\nline two" || record.Payload != `{"n":1}` {
+		t.Errorf("Unexpected invocation record: %+v", record)
+	}
+	if !record.StartedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected start time to round-trip, got %v", record.StartedAt)
+	}
+	if _, err := s.GetInvocationRecord("missing"); err != ErrInvocationRecordNotFound {
+		t.Errorf("Expected ErrInvocationRecordNotFound, got %v", err)
+	}
+
+	records, err := s.ListInvocationRecords("test-function", models.InvocationFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || records[0].ID != "inv-4" || records[4].ID != "inv-0" {
+		t.Errorf("Expected 5 records newest first, got %d", len(records))
+	}
+
+	records, err = s.ListInvocationRecords("test-function", models.InvocationFilter{
+		Status: models.InvocationSucceeded,
+		Before: start.Add(4 * time.Minute),
+		Limit:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "inv-2" {
+		t.Errorf("Expected only inv-2 to match the filter, got %+v", records)
+	}
+
+	// Keep the newest three records per function, and nothing older than
+	// 10:02
+	deleted, err := s.PruneInvocationRecords(start.Add(2*time.Minute), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("Expected 3 records pruned, got %d", deleted)
+	}
+	records, _ = s.ListInvocationRecords("test-function", models.InvocationFilter{})
+	if len(records) != 3 || records[2].ID != "inv-2" {
+		t.Errorf("Expected inv-2 to inv-4 to remain, got %d records", len(records))
+	}
+	if _, err := s.GetInvocationRecord("other"); err != ErrInvocationRecordNotFound {
+		t.Errorf("Expected the old record of the other function to be pruned, got %v", err)
+	}
+
+	// Deleting the function drops its history
+	if err := s.Delete("test-function"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetInvocationRecord("inv-4"); err != ErrInvocationRecordNotFound {
+		t.Errorf("Expected ErrInvocationRecordNotFound after function delete, got %v", err)
+	}
+}
+
+func TestFileStorageInvocationHistory(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testInvocationHistory(t, fs)
+}
+
+func TestFileStorageInvocationHistoryReload(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Create(&models.Function{ID: "test-id", Name: "test-function", Runtime: models.RuntimeNodeJS20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SaveInvocationRecord(&models.InvocationRecord{
+		ID: "inv", FunctionName: "test-function", Status: models.InvocationFailed, Error: "boom", StartedAt: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := reloaded.GetInvocationRecord("inv")
+	if err != nil {
+		t.Fatalf("Expected the record to survive a restart: %v", err)
+	}
+	if record.Error != "boom" {
+		t.Errorf("Unexpected reloaded record: %+v", record)
+	}
+}
+
+func TestPostgresStorageInvocationHistory(t *testing.T) {
+	ps, cleanup := setupTestDB(t)
+	if ps == nil {
+		return
+	}
+	defer cleanup()
+
+	testInvocationHistory(t, ps)
+}
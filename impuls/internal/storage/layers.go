@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// loadLayers loads all layer versions from disk
+func (fs *FileStorage) loadLayers() error {
+	layersDir := filepath.Join(fs.basePath, "layers")
+	layerDirs, err := os.ReadDir(layersDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, layerDir := range layerDirs {
+		if !layerDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(layersDir, layerDir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			var l models.Layer
+			if !readJSONFile(filepath.Join(layersDir, layerDir.Name(), entry.Name()), &l) {
+				continue
+			}
+			if fs.layersDB[l.Name] == nil {
+				fs.layersDB[l.Name] = make(map[int]*models.Layer)
+			}
+			fs.layersDB[l.Name][l.Version] = &l
+		}
+	}
+	return nil
+}
+
+// withoutContent returns a copy of a layer version for lists
+func withoutContent(l *models.Layer) *models.Layer {
+	result := *l
+	result.Content = ""
+	return &result
+}
+
+// CreateLayer stores a new layer version
+func (fs *FileStorage) CreateLayer(l *models.Layer) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.layersDB[l.Name][l.Version]; exists {
+		return ErrLayerVersionExists
+	}
+
+	stored := *l
+	path := filepath.Join(fs.basePath, "layers", l.Name, strconv.Itoa(l.Version)+".json")
+	if err := writeJSONFile(path, &stored); err != nil {
+		return err
+	}
+
+	if fs.layersDB[l.Name] == nil {
+		fs.layersDB[l.Name] = make(map[int]*models.Layer)
+	}
+	fs.layersDB[l.Name][l.Version] = &stored
+	return nil
+}
+
+// GetLayer retrieves a layer version with its content
+func (fs *FileStorage) GetLayer(name string, version int) (*models.Layer, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	l, exists := fs.layersDB[name][version]
+	if !exists {
+		return nil, ErrLayerNotFound
+	}
+	result := *l
+	return &result, nil
+}
+
+// ListLayerVersions returns all versions of a layer without their content,
+// oldest first
+func (fs *FileStorage) ListLayerVersions(name string) ([]*models.Layer, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	layers := make([]*models.Layer, 0, len(fs.layersDB[name]))
+	for _, l := range fs.layersDB[name] {
+		layers = append(layers, withoutContent(l))
+	}
+	sort.Slice(layers, func(i, j int) bool {
+		return layers[i].Version < layers[j].Version
+	})
+	return layers, nil
+}
+
+// ListLayers returns the latest version of every layer without its content,
+// sorted by name
+func (fs *FileStorage) ListLayers() ([]*models.Layer, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	layers := []*models.Layer{}
+	for _, versions := range fs.layersDB {
+		var latest *models.Layer
+		for _, l := range versions {
+			if latest == nil || l.Version > latest.Version {
+				latest = l
+			}
+		}
+		if latest != nil {
+			layers = append(layers, withoutContent(latest))
+		}
+	}
+	sort.Slice(layers, func(i, j int) bool {
+		return layers[i].Name < layers[j].Name
+	})
+	return layers, nil
+}
+
+// DeleteLayer deletes a layer version
+func (fs *FileStorage) DeleteLayer(name string, version int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.layersDB[name][version]; !exists {
+		return ErrLayerNotFound
+	}
+
+	os.Remove(filepath.Join(fs.basePath, "layers", name, strconv.Itoa(version)+".json"))
+	delete(fs.layersDB[name], version)
+	if len(fs.layersDB[name]) == 0 {
+		os.Remove(filepath.Join(fs.basePath, "layers", name))
+		delete(fs.layersDB, name)
+	}
+	return nil
+}
+
+// CreateLayer stores a new layer version
+func (ps *PostgresStorage) CreateLayer(l *models.Layer) error {
+	query := `
+		INSERT INTO layers (name, version, description, code_format, content, size, sha256, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := ps.db.Exec(query,
+		l.Name, l.Version, l.Description, l.CodeFormat, l.Content, l.Size, l.SHA256, l.CreatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrLayerVersionExists
+		}
+		return fmt.Errorf("failed to create layer: %w", err)
+	}
+
+	return nil
+}
+
+// GetLayer retrieves a layer version with its content
+func (ps *PostgresStorage) GetLayer(name string, version int) (*models.Layer, error) {
+	query := `
+		SELECT name, version, description, code_format, content, size, sha256, created_at
+		FROM layers
+		WHERE name = $1 AND version = $2
+	`
+
+	l := &models.Layer{}
+	var description sql.NullString
+	err := ps.db.QueryRow(query, name, version).Scan(
+		&l.Name, &l.Version, &description, &l.CodeFormat, &l.Content, &l.Size, &l.SHA256, &l.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrLayerNotFound
+		}
+		return nil, fmt.Errorf("failed to get layer: %w", err)
+	}
+	l.Description = description.String
+
+	return l, nil
+}
+
+// ListLayerVersions returns all versions of a layer without their content,
+// oldest first
+func (ps *PostgresStorage) ListLayerVersions(name string) ([]*models.Layer, error) {
+	query := `
+		SELECT name, version, description, code_format, size, sha256, created_at
+		FROM layers
+		WHERE name = $1
+		ORDER BY version
+	`
+	return ps.queryLayers(query, name)
+}
+
+// ListLayers returns the latest version of every layer without its content,
+// sorted by name
+func (ps *PostgresStorage) ListLayers() ([]*models.Layer, error) {
+	query := `
+		SELECT DISTINCT ON (name) name, version, description, code_format, size, sha256, created_at
+		FROM layers
+		ORDER BY name, version DESC
+	`
+	return ps.queryLayers(query)
+}
+
+// queryLayers runs a query for layer versions without their content
+func (ps *PostgresStorage) queryLayers(query string, args ...interface{}) ([]*models.Layer, error) {
+	rows, err := ps.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layers: %w", err)
+	}
+	defer rows.Close()
+
+	layers := []*models.Layer{}
+	for rows.Next() {
+		l := &models.Layer{}
+		var description sql.NullString
+		if err := rows.Scan(&l.Name, &l.Version, &description, &l.CodeFormat, &l.Size, &l.SHA256, &l.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan layer: %w", err)
+		}
+		l.Description = description.String
+		layers = append(layers, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating layers: %w", err)
+	}
+
+	return layers, nil
+}
+
+// DeleteLayer deletes a layer version
+func (ps *PostgresStorage) DeleteLayer(name string, version int) error {
+	query := `DELETE FROM layers WHERE name = $1 AND version = $2`
+
+	result, err := ps.db.Exec(query, name, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete layer: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrLayerNotFound
+	}
+
+	return nil
+}
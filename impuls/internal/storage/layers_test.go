@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+func testLayers(t *testing.T, s Storage) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, l := range []*models.Layer{
+		{Name: "utils", Version: 2, CodeFormat: models.CodeFormatZip, Content: "djI=", Size: 2, SHA256: "b2", CreatedAt: now},
+		{Name: "utils", Version: 1, Description: "first", CodeFormat: models.CodeFormatTarGz, Content: "djE=", Size: 2, SHA256: "b1", CreatedAt: now},
+		{Name: "crypto", Version: 1, CodeFormat: models.CodeFormatZip, Content: "Yw==", Size: 1, SHA256: "c1", CreatedAt: now},
+	} {
+		if err := s.CreateLayer(l); err != nil {
+			t.Fatalf("Failed to create layer %s: %v", l.Ref(), err)
+		}
+	}
+	if err := s.CreateLayer(&models.Layer{Name: "utils", Version: 1, CodeFormat: models.CodeFormatZip, CreatedAt: now}); err != ErrLayerVersionExists {
+		t.Errorf("Expected ErrLayerVersionExists, got %v", err)
+	}
+
+	l, err := s.GetLayer("utils", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Description != "first" || l.CodeFormat != models.CodeFormatTarGz || l.Content != "djE=" || l.SHA256 != "b1" || !l.CreatedAt.Equal(now) {
+		t.Errorf("Unexpected layer %+v", l)
+	}
+	if _, err := s.GetLayer("utils", 3); err != ErrLayerNotFound {
+		t.Errorf("Expected ErrLayerNotFound, got %v", err)
+	}
+
+	versions, err := s.ListLayerVersions("utils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 2 || versions[0].Content != "" {
+		t.Errorf("Expected versions [1 2] without content, got %+v", versions)
+	}
+
+	layers, err := s.ListLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 2 || layers[0].Name != "crypto" || layers[1].Name != "utils" || layers[1].Version != 2 || layers[1].Content != "" {
+		t.Errorf("Expected the latest version of each layer, got %+v", layers)
+	}
+
+	// Functions and versions keep their layer order
+	refs := []models.LayerRef{{Name: "utils", Version: 2}, {Name: "crypto", Version: 1}}
+	fn := &models.Function{ID: "layered-id", Name: "layered", Runtime: models.RuntimeNodeJS20, Handler: "index.handler", Code: "x", MemoryMB: 128, TimeoutSec: 30, Layers: refs, CreatedAt: now, UpdatedAt: now}
+	if err := s.Create(fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateVersion(&models.FunctionVersion{FunctionName: "layered", Version: 1, Runtime: models.RuntimeNodeJS20, Handler: "index.handler", Code: "x", MemoryMB: 128, TimeoutSec: 30, Layers: refs, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("layered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.GetVersion("layered", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Layers, refs) || !reflect.DeepEqual(v.Layers, refs) {
+		t.Errorf("Expected layers %v, got %v and %v", refs, got.Layers, v.Layers)
+	}
+
+	for _, version := range []int{1, 2} {
+		if err := s.DeleteLayer("utils", version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteLayer("utils", 1); err != ErrLayerNotFound {
+		t.Errorf("Expected ErrLayerNotFound, got %v", err)
+	}
+	if versions, _ := s.ListLayerVersions("utils"); len(versions) != 0 {
+		t.Errorf("Expected no versions left, got %d", len(versions))
+	}
+}
+
+func TestFileStorageLayers(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testLayers(t, fs)
+
+	// Layers survive a reload from disk
+	fs, err = NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := fs.ListLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 1 || layers[0].Name != "crypto" {
+		t.Errorf("Expected only crypto after reload, got %+v", layers)
+	}
+	l, err := fs.GetLayer("crypto", 1)
+	if err != nil || l.Content != "Yw==" {
+		t.Errorf("Expected crypto's content after reload, got %+v, %v", l, err)
+	}
+}
+
+func TestPostgresStorageLayers(t *testing.T) {
+	ps, cleanup := setupTestDB(t)
+	if ps == nil {
+		return
+	}
+	defer cleanup()
+
+	testLayers(t, ps)
+}
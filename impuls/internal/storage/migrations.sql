@@ -8,19 +8,166 @@ CREATE TABLE IF NOT EXISTS functions (
     runtime TEXT NOT NULL,
     handler TEXT NOT NULL,
     code TEXT NOT NULL,
+    code_format TEXT NOT NULL DEFAULT '',
     code_path TEXT,
     memory_mb INTEGER NOT NULL,
     timeout_sec INTEGER NOT NULL,
     environment JSONB,
+    max_concurrency INTEGER NOT NULL DEFAULT 0,
+    reserved_concurrency INTEGER NOT NULL DEFAULT 0,
+    no_network BOOLEAN NOT NULL DEFAULT FALSE,
+    build JSONB,
+    layers JSONB,
+    secrets JSONB,
+    cors JSONB,
+    executor TEXT NOT NULL DEFAULT '',
+    next_version INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMP NOT NULL,
     updated_at TIMESTAMP NOT NULL
 );
 
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS max_concurrency INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS reserved_concurrency INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS no_network BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS code_format TEXT NOT NULL DEFAULT '';
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS build JSONB;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS layers JSONB;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS secrets JSONB;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS cors JSONB;
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS executor TEXT NOT NULL DEFAULT '';
+ALTER TABLE functions ADD COLUMN IF NOT EXISTS next_version INTEGER NOT NULL DEFAULT 0;
+
 -- Create indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_functions_name ON functions(name);
 CREATE INDEX IF NOT EXISTS idx_functions_created_at ON functions(created_at DESC);
 CREATE INDEX IF NOT EXISTS idx_functions_runtime ON functions(runtime);
 
+-- Published function versions (immutable snapshots of code and config)
+CREATE TABLE IF NOT EXISTS function_versions (
+    function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    description TEXT,
+    runtime TEXT NOT NULL,
+    handler TEXT NOT NULL,
+    code TEXT NOT NULL,
+    code_format TEXT NOT NULL DEFAULT '',
+    dependencies_hash TEXT NOT NULL DEFAULT '',
+    memory_mb INTEGER NOT NULL,
+    timeout_sec INTEGER NOT NULL,
+    environment JSONB,
+    layers JSONB,
+    secrets JSONB,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (function_name, version)
+);
+
+ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS code_format TEXT NOT NULL DEFAULT '';
+ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS dependencies_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS layers JSONB;
+ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS secrets JSONB;
+
+-- Named aliases pointing at a published version
+CREATE TABLE IF NOT EXISTS function_aliases (
+    function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    description TEXT,
+    routing_config JSONB,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (function_name, name)
+);
+
+ALTER TABLE function_aliases ADD COLUMN IF NOT EXISTS routing_config JSONB;
+
+-- Asynchronous invocation queue and dead-letter store
+CREATE TABLE IF NOT EXISTS async_invocations (
+    id TEXT PRIMARY KEY,
+    function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+    qualifier TEXT,
+    payload JSONB,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    last_error TEXT,
+    result JSONB,
+    next_attempt_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_async_invocations_due ON async_invocations(next_attempt_at)
+    WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS idx_async_invocations_function ON async_invocations(function_name, status);
+
+-- Cron and rate schedules that invoke a function
+CREATE TABLE IF NOT EXISTS function_schedules (
+    id TEXT PRIMARY KEY,
+    function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+    expression TEXT NOT NULL,
+    timezone TEXT,
+    qualifier TEXT,
+    payload JSONB,
+    jitter_sec INTEGER NOT NULL DEFAULT 0,
+    description TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMPTZ NOT NULL,
+    last_run_at TIMESTAMPTZ,
+    missed_runs BIGINT NOT NULL DEFAULT 0,
+    last_missed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_function_schedules_due ON function_schedules(next_run_at) WHERE enabled;
+CREATE INDEX IF NOT EXISTS idx_function_schedules_function ON function_schedules(function_name);
+
+-- History of every invocation, pruned by the server's retention limits
+CREATE TABLE IF NOT EXISTS invocation_records (
+    id TEXT PRIMARY KEY,
+    function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+    version TEXT,
+    qualifier TEXT,
+    local BOOLEAN NOT NULL DEFAULT FALSE,
+    status TEXT NOT NULL,
+    status_code INTEGER NOT NULL,
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    payload TEXT,
+    payload_truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    logs TEXT,
+    logs_truncated BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_invocation_records_function ON invocation_records(function_name, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_invocation_records_started_at ON invocation_records(started_at);
+
+-- Published layer versions (immutable archives of shared code)
+CREATE TABLE IF NOT EXISTS layers (
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    description TEXT,
+    code_format TEXT NOT NULL,
+    content TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    sha256 TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (name, version)
+);
+
+-- Secrets, encrypted with a data key that is sealed with the master key
+CREATE TABLE IF NOT EXISTS secrets (
+    name TEXT PRIMARY KEY,
+    description TEXT,
+    key_id TEXT NOT NULL,
+    encrypted_key BYTEA NOT NULL,
+    ciphertext BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
 -- Optional: Add a trigger to automatically update updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
@@ -6,7 +6,7 @@ import (
 	"errors"
 	"fmt"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/oblak/impuls/internal/models"
 )
 
@@ -47,16 +47,156 @@ func (ps *PostgresStorage) initSchema() error {
 		runtime TEXT NOT NULL,
 		handler TEXT NOT NULL,
 		code TEXT NOT NULL,
+		code_format TEXT NOT NULL DEFAULT '',
 		code_path TEXT,
 		memory_mb INTEGER NOT NULL,
 		timeout_sec INTEGER NOT NULL,
 		environment JSONB,
+		max_concurrency INTEGER NOT NULL DEFAULT 0,
+		reserved_concurrency INTEGER NOT NULL DEFAULT 0,
+		no_network BOOLEAN NOT NULL DEFAULT FALSE,
+		build JSONB,
+		layers JSONB,
+		secrets JSONB,
+		cors JSONB,
+		executor TEXT NOT NULL DEFAULT '',
+		next_version INTEGER NOT NULL DEFAULT 0,
 		created_at TIMESTAMP NOT NULL,
 		updated_at TIMESTAMP NOT NULL
 	);
 
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS max_concurrency INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS reserved_concurrency INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS no_network BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS code_format TEXT NOT NULL DEFAULT '';
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS build JSONB;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS layers JSONB;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS secrets JSONB;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS cors JSONB;
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS executor TEXT NOT NULL DEFAULT '';
+	ALTER TABLE functions ADD COLUMN IF NOT EXISTS next_version INTEGER NOT NULL DEFAULT 0;
+
 	CREATE INDEX IF NOT EXISTS idx_functions_name ON functions(name);
 	CREATE INDEX IF NOT EXISTS idx_functions_created_at ON functions(created_at DESC);
+
+	CREATE TABLE IF NOT EXISTS function_versions (
+		function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		description TEXT,
+		runtime TEXT NOT NULL,
+		handler TEXT NOT NULL,
+		code TEXT NOT NULL,
+		code_format TEXT NOT NULL DEFAULT '',
+		dependencies_hash TEXT NOT NULL DEFAULT '',
+		memory_mb INTEGER NOT NULL,
+		timeout_sec INTEGER NOT NULL,
+		environment JSONB,
+		layers JSONB,
+		secrets JSONB,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (function_name, version)
+	);
+
+	ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS code_format TEXT NOT NULL DEFAULT '';
+	ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS dependencies_hash TEXT NOT NULL DEFAULT '';
+	ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS layers JSONB;
+	ALTER TABLE function_versions ADD COLUMN IF NOT EXISTS secrets JSONB;
+
+	CREATE TABLE IF NOT EXISTS function_aliases (
+		function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		description TEXT,
+		routing_config JSONB,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (function_name, name)
+	);
+
+	ALTER TABLE function_aliases ADD COLUMN IF NOT EXISTS routing_config JSONB;
+
+	CREATE TABLE IF NOT EXISTS async_invocations (
+		id TEXT PRIMARY KEY,
+		function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+		qualifier TEXT,
+		payload JSONB,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		last_error TEXT,
+		result JSONB,
+		next_attempt_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_async_invocations_due ON async_invocations(next_attempt_at)
+		WHERE status IN ('queued', 'running');
+	CREATE INDEX IF NOT EXISTS idx_async_invocations_function ON async_invocations(function_name, status);
+
+	CREATE TABLE IF NOT EXISTS function_schedules (
+		id TEXT PRIMARY KEY,
+		function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+		expression TEXT NOT NULL,
+		timezone TEXT,
+		qualifier TEXT,
+		payload JSONB,
+		jitter_sec INTEGER NOT NULL DEFAULT 0,
+		description TEXT,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		next_run_at TIMESTAMPTZ NOT NULL,
+		last_run_at TIMESTAMPTZ,
+		missed_runs BIGINT NOT NULL DEFAULT 0,
+		last_missed_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_function_schedules_due ON function_schedules(next_run_at) WHERE enabled;
+	CREATE INDEX IF NOT EXISTS idx_function_schedules_function ON function_schedules(function_name);
+
+	CREATE TABLE IF NOT EXISTS invocation_records (
+		id TEXT PRIMARY KEY,
+		function_name TEXT NOT NULL REFERENCES functions(name) ON DELETE CASCADE,
+		version TEXT,
+		qualifier TEXT,
+		local BOOLEAN NOT NULL DEFAULT FALSE,
+		status TEXT NOT NULL,
+		status_code INTEGER NOT NULL,
+		error TEXT,
+		started_at TIMESTAMPTZ NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		payload TEXT,
+		payload_truncated BOOLEAN NOT NULL DEFAULT FALSE,
+		logs TEXT,
+		logs_truncated BOOLEAN NOT NULL DEFAULT FALSE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_invocation_records_function ON invocation_records(function_name, started_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_invocation_records_started_at ON invocation_records(started_at);
+
+	CREATE TABLE IF NOT EXISTS layers (
+		name TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		description TEXT,
+		code_format TEXT NOT NULL,
+		content TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (name, version)
+	);
+
+	CREATE TABLE IF NOT EXISTS secrets (
+		name TEXT PRIMARY KEY,
+		description TEXT,
+		key_id TEXT NOT NULL,
+		encrypted_key BYTEA NOT NULL,
+		ciphertext BYTEA NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
 	`
 
 	_, err := ps.db.Exec(schema)
@@ -70,15 +210,37 @@ func (ps *PostgresStorage) Create(fn *models.Function) error {
 		return fmt.Errorf("failed to marshal environment: %w", err)
 	}
 
+	buildJSON, err := json.Marshal(fn.Build)
+	if err != nil {
+		return fmt.Errorf("failed to marshal build: %w", err)
+	}
+
+	layersJSON, err := json.Marshal(fn.Layers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal layers: %w", err)
+	}
+
+	secretsJSON, err := json.Marshal(fn.Secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	corsJSON, err := json.Marshal(fn.CORS)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cors: %w", err)
+	}
+
 	query := `
 		INSERT INTO functions (id, name, description, runtime, handler, code, code_path, 
-			memory_mb, timeout_sec, environment, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			memory_mb, timeout_sec, environment, max_concurrency, reserved_concurrency,
+			no_network, code_format, build, layers, secrets, cors, executor, next_version, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
 	`
 
 	_, err = ps.db.Exec(query,
 		fn.ID, fn.Name, fn.Description, fn.Runtime, fn.Handler, fn.Code, fn.CodePath,
-		fn.MemoryMB, fn.TimeoutSec, envJSON, fn.CreatedAt, fn.UpdatedAt,
+		fn.MemoryMB, fn.TimeoutSec, envJSON, fn.MaxConcurrency, fn.ReservedConcurrency,
+		fn.NoNetwork, fn.CodeFormat, buildJSON, layersJSON, secretsJSON, corsJSON, fn.Executor, fn.NextVersion, fn.CreatedAt, fn.UpdatedAt,
 	)
 
 	if err != nil {
@@ -95,17 +257,19 @@ func (ps *PostgresStorage) Create(fn *models.Function) error {
 func (ps *PostgresStorage) Get(name string) (*models.Function, error) {
 	query := `
 		SELECT id, name, description, runtime, handler, code, code_path,
-			memory_mb, timeout_sec, environment, created_at, updated_at
+			memory_mb, timeout_sec, environment, max_concurrency, reserved_concurrency,
+			no_network, code_format, build, layers, secrets, cors, executor, next_version, created_at, updated_at
 		FROM functions
 		WHERE name = $1
 	`
 
 	fn := &models.Function{}
-	var envJSON []byte
+	var envJSON, buildJSON, layersJSON, secretsJSON, corsJSON []byte
 
 	err := ps.db.QueryRow(query, name).Scan(
 		&fn.ID, &fn.Name, &fn.Description, &fn.Runtime, &fn.Handler, &fn.Code, &fn.CodePath,
-		&fn.MemoryMB, &fn.TimeoutSec, &envJSON, &fn.CreatedAt, &fn.UpdatedAt,
+		&fn.MemoryMB, &fn.TimeoutSec, &envJSON, &fn.MaxConcurrency, &fn.ReservedConcurrency,
+		&fn.NoNetwork, &fn.CodeFormat, &buildJSON, &layersJSON, &secretsJSON, &corsJSON, &fn.Executor, &fn.NextVersion, &fn.CreatedAt, &fn.UpdatedAt,
 	)
 
 	if err != nil {
@@ -121,6 +285,30 @@ func (ps *PostgresStorage) Get(name string) (*models.Function, error) {
 		}
 	}
 
+	if len(buildJSON) > 0 && string(buildJSON) != "null" {
+		if err := json.Unmarshal(buildJSON, &fn.Build); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal build: %w", err)
+		}
+	}
+
+	if len(layersJSON) > 0 && string(layersJSON) != "null" {
+		if err := json.Unmarshal(layersJSON, &fn.Layers); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal layers: %w", err)
+		}
+	}
+
+	if len(secretsJSON) > 0 && string(secretsJSON) != "null" {
+		if err := json.Unmarshal(secretsJSON, &fn.Secrets); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal secrets: %w", err)
+		}
+	}
+
+	if len(corsJSON) > 0 && string(corsJSON) != "null" {
+		if err := json.Unmarshal(corsJSON, &fn.CORS); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cors: %w", err)
+		}
+	}
+
 	return fn, nil
 }
 
@@ -128,17 +316,19 @@ func (ps *PostgresStorage) Get(name string) (*models.Function, error) {
 func (ps *PostgresStorage) GetByID(id string) (*models.Function, error) {
 	query := `
 		SELECT id, name, description, runtime, handler, code, code_path,
-			memory_mb, timeout_sec, environment, created_at, updated_at
+			memory_mb, timeout_sec, environment, max_concurrency, reserved_concurrency,
+			no_network, code_format, build, layers, secrets, cors, executor, next_version, created_at, updated_at
 		FROM functions
 		WHERE id = $1
 	`
 
 	fn := &models.Function{}
-	var envJSON []byte
+	var envJSON, buildJSON, layersJSON, secretsJSON, corsJSON []byte
 
 	err := ps.db.QueryRow(query, id).Scan(
 		&fn.ID, &fn.Name, &fn.Description, &fn.Runtime, &fn.Handler, &fn.Code, &fn.CodePath,
-		&fn.MemoryMB, &fn.TimeoutSec, &envJSON, &fn.CreatedAt, &fn.UpdatedAt,
+		&fn.MemoryMB, &fn.TimeoutSec, &envJSON, &fn.MaxConcurrency, &fn.ReservedConcurrency,
+		&fn.NoNetwork, &fn.CodeFormat, &buildJSON, &layersJSON, &secretsJSON, &corsJSON, &fn.Executor, &fn.NextVersion, &fn.CreatedAt, &fn.UpdatedAt,
 	)
 
 	if err != nil {
@@ -154,6 +344,30 @@ func (ps *PostgresStorage) GetByID(id string) (*models.Function, error) {
 		}
 	}
 
+	if len(buildJSON) > 0 && string(buildJSON) != "null" {
+		if err := json.Unmarshal(buildJSON, &fn.Build); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal build: %w", err)
+		}
+	}
+
+	if len(layersJSON) > 0 && string(layersJSON) != "null" {
+		if err := json.Unmarshal(layersJSON, &fn.Layers); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal layers: %w", err)
+		}
+	}
+
+	if len(secretsJSON) > 0 && string(secretsJSON) != "null" {
+		if err := json.Unmarshal(secretsJSON, &fn.Secrets); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal secrets: %w", err)
+		}
+	}
+
+	if len(corsJSON) > 0 && string(corsJSON) != "null" {
+		if err := json.Unmarshal(corsJSON, &fn.CORS); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cors: %w", err)
+		}
+	}
+
 	return fn, nil
 }
 
@@ -164,16 +378,40 @@ func (ps *PostgresStorage) Update(fn *models.Function) error {
 		return fmt.Errorf("failed to marshal environment: %w", err)
 	}
 
+	buildJSON, err := json.Marshal(fn.Build)
+	if err != nil {
+		return fmt.Errorf("failed to marshal build: %w", err)
+	}
+
+	layersJSON, err := json.Marshal(fn.Layers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal layers: %w", err)
+	}
+
+	secretsJSON, err := json.Marshal(fn.Secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	corsJSON, err := json.Marshal(fn.CORS)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cors: %w", err)
+	}
+
 	query := `
 		UPDATE functions
 		SET description = $1, runtime = $2, handler = $3, code = $4, code_path = $5,
-			memory_mb = $6, timeout_sec = $7, environment = $8, updated_at = $9
-		WHERE name = $10
+			memory_mb = $6, timeout_sec = $7, environment = $8, max_concurrency = $9,
+			reserved_concurrency = $10, no_network = $11, code_format = $12, build = $13,
+			layers = $14, secrets = $15, cors = $16, executor = $17,
+			next_version = GREATEST(next_version, $18), updated_at = $19
+		WHERE name = $20
 	`
 
 	result, err := ps.db.Exec(query,
 		fn.Description, fn.Runtime, fn.Handler, fn.Code, fn.CodePath,
-		fn.MemoryMB, fn.TimeoutSec, envJSON, fn.UpdatedAt, fn.Name,
+		fn.MemoryMB, fn.TimeoutSec, envJSON, fn.MaxConcurrency, fn.ReservedConcurrency,
+		fn.NoNetwork, fn.CodeFormat, buildJSON, layersJSON, secretsJSON, corsJSON, fn.Executor, fn.NextVersion, fn.UpdatedAt, fn.Name,
 	)
 
 	if err != nil {
@@ -217,7 +455,8 @@ func (ps *PostgresStorage) Delete(name string) error {
 func (ps *PostgresStorage) List() ([]*models.Function, error) {
 	query := `
 		SELECT id, name, description, runtime, handler, code, code_path,
-			memory_mb, timeout_sec, environment, created_at, updated_at
+			memory_mb, timeout_sec, environment, max_concurrency, reserved_concurrency,
+			no_network, code_format, build, layers, secrets, cors, executor, next_version, created_at, updated_at
 		FROM functions
 		ORDER BY created_at DESC
 	`
@@ -232,11 +471,12 @@ func (ps *PostgresStorage) List() ([]*models.Function, error) {
 
 	for rows.Next() {
 		fn := &models.Function{}
-		var envJSON []byte
+		var envJSON, buildJSON, layersJSON, secretsJSON, corsJSON []byte
 
 		err := rows.Scan(
 			&fn.ID, &fn.Name, &fn.Description, &fn.Runtime, &fn.Handler, &fn.Code, &fn.CodePath,
-			&fn.MemoryMB, &fn.TimeoutSec, &envJSON, &fn.CreatedAt, &fn.UpdatedAt,
+			&fn.MemoryMB, &fn.TimeoutSec, &envJSON, &fn.MaxConcurrency, &fn.ReservedConcurrency,
+			&fn.NoNetwork, &fn.CodeFormat, &buildJSON, &layersJSON, &secretsJSON, &corsJSON, &fn.Executor, &fn.NextVersion, &fn.CreatedAt, &fn.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan function: %w", err)
@@ -248,6 +488,30 @@ func (ps *PostgresStorage) List() ([]*models.Function, error) {
 			}
 		}
 
+		if len(buildJSON) > 0 && string(buildJSON) != "null" {
+			if err := json.Unmarshal(buildJSON, &fn.Build); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal build: %w", err)
+			}
+		}
+
+		if len(layersJSON) > 0 && string(layersJSON) != "null" {
+			if err := json.Unmarshal(layersJSON, &fn.Layers); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal layers: %w", err)
+			}
+		}
+
+		if len(secretsJSON) > 0 && string(secretsJSON) != "null" {
+			if err := json.Unmarshal(secretsJSON, &fn.Secrets); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal secrets: %w", err)
+			}
+		}
+
+		if len(corsJSON) > 0 && string(corsJSON) != "null" {
+			if err := json.Unmarshal(corsJSON, &fn.CORS); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal cors: %w", err)
+			}
+		}
+
 		functions = append(functions, fn)
 	}
 
@@ -282,6 +546,12 @@ func (ps *PostgresStorage) SaveCode(name string, code []byte) (string, error) {
 	return fmt.Sprintf("db://functions/%s/code", name), nil
 }
 
+// SavePackage saves a function's code package in the database, like any
+// other code. Nothing is unpacked, as the database keeps no code on disk.
+func (ps *PostgresStorage) SavePackage(name string, format models.CodeFormat, code []byte, unpack func(dir string) error) (string, error) {
+	return ps.SaveCode(name, code)
+}
+
 // GetCode retrieves function code
 func (ps *PostgresStorage) GetCode(name string) ([]byte, error) {
 	query := `SELECT code FROM functions WHERE name = $1`
@@ -305,9 +575,14 @@ func (ps *PostgresStorage) Close() error {
 
 // isUniqueViolation checks if the error is a unique constraint violation
 func isUniqueViolation(err error) bool {
-	if err == nil {
-		return false
-	}
 	// PostgreSQL error code 23505 is unique_violation
-	return err.Error() == "pq: duplicate key value violates unique constraint \"functions_name_key\""
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isForeignKeyViolation checks if the error is a foreign key violation
+func isForeignKeyViolation(err error) bool {
+	// PostgreSQL error code 23503 is foreign_key_violation
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
 }
@@ -45,13 +45,13 @@ func setupTestDB(t *testing.T) (*PostgresStorage, func()) {
 
 	// Cleanup function
 	cleanup := func() {
-		// Clear all functions
-		ps.db.Exec("TRUNCATE functions")
+		// Clear all functions, layers and secrets
+		ps.db.Exec("TRUNCATE functions, layers, secrets CASCADE")
 		ps.Close()
 	}
 
 	// Clear any existing data
-	ps.db.Exec("TRUNCATE functions")
+	ps.db.Exec("TRUNCATE functions, layers, secrets CASCADE")
 
 	return ps, cleanup
 }
@@ -231,6 +231,11 @@ func TestPostgresStorageUpdate(t *testing.T) {
 	// Update function
 	fn.MemoryMB = 256
 	fn.TimeoutSec = 60
+	fn.MaxConcurrency = 10
+	fn.ReservedConcurrency = 2
+	fn.NoNetwork = true
+	fn.CodeFormat = models.CodeFormatZip
+	fn.Build = &models.FunctionBuild{Status: models.BuildFailed, DependenciesHash: "abc123", Log: "npm ERR!", StartedAt: time.Now()}
 	fn.UpdatedAt = time.Now()
 	if err := ps.Update(fn); err != nil {
 		t.Fatalf("Failed to update function: %v", err)
@@ -248,6 +253,18 @@ func TestPostgresStorageUpdate(t *testing.T) {
 	if got.TimeoutSec != 60 {
 		t.Errorf("Expected TimeoutSec 60, got %d", got.TimeoutSec)
 	}
+	if got.MaxConcurrency != 10 || got.ReservedConcurrency != 2 {
+		t.Errorf("Expected concurrency 10/2, got %d/%d", got.MaxConcurrency, got.ReservedConcurrency)
+	}
+	if !got.NoNetwork {
+		t.Error("Expected NoNetwork to be set")
+	}
+	if got.CodeFormat != models.CodeFormatZip {
+		t.Errorf("Expected code format zip, got %q", got.CodeFormat)
+	}
+	if got.Build == nil || got.Build.Status != models.BuildFailed || got.Build.Log != "npm ERR!" {
+		t.Errorf("Expected the failed build, got %+v", got.Build)
+	}
 }
 
 func TestPostgresStorageUpdateNonExistent(t *testing.T) {
@@ -522,7 +539,7 @@ func BenchmarkPostgresStorageCreate(b *testing.B) {
 		ps.Create(fn)
 	}
 	b.StopTimer()
-	ps.db.Exec("TRUNCATE functions")
+	ps.db.Exec("TRUNCATE functions CASCADE")
 }
 
 func BenchmarkPostgresStorageGet(b *testing.B) {
@@ -551,5 +568,5 @@ func BenchmarkPostgresStorageGet(b *testing.B) {
 		ps.Get("bench-function")
 	}
 	b.StopTimer()
-	ps.db.Exec("TRUNCATE functions")
+	ps.db.Exec("TRUNCATE functions CASCADE")
 }
@@ -0,0 +1,372 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// loadSchedules loads all schedules from disk
+func (fs *FileStorage) loadSchedules() error {
+	schedulesDir := filepath.Join(fs.basePath, "schedules")
+	entries, err := os.ReadDir(schedulesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		var s models.Schedule
+		if !readJSONFile(filepath.Join(schedulesDir, entry.Name()), &s) {
+			continue
+		}
+		fs.schedulesDB[s.ID] = &s
+	}
+
+	return nil
+}
+
+// saveSchedule saves a schedule to disk
+func (fs *FileStorage) saveSchedule(s *models.Schedule) error {
+	return writeJSONFile(filepath.Join(fs.basePath, "schedules", s.ID+".json"), s)
+}
+
+// CreateSchedule stores a new schedule
+func (fs *FileStorage) CreateSchedule(s *models.Schedule) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.functionsDB[s.FunctionName]; !exists {
+		return ErrNotFound
+	}
+
+	stored := *s
+	if err := fs.saveSchedule(&stored); err != nil {
+		return err
+	}
+	fs.schedulesDB[s.ID] = &stored
+	return nil
+}
+
+// GetSchedule retrieves a schedule by ID
+func (fs *FileStorage) GetSchedule(id string) (*models.Schedule, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	s, exists := fs.schedulesDB[id]
+	if !exists {
+		return nil, ErrScheduleNotFound
+	}
+	result := *s
+	return &result, nil
+}
+
+// ListSchedules returns all schedules of a function, oldest first
+func (fs *FileStorage) ListSchedules(name string) ([]*models.Schedule, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	schedules := []*models.Schedule{}
+	for _, s := range fs.schedulesDB {
+		if s.FunctionName == name {
+			result := *s
+			schedules = append(schedules, &result)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+	return schedules, nil
+}
+
+// ListDueSchedules returns the enabled schedules whose next run is at or
+// before now
+func (fs *FileStorage) ListDueSchedules(now time.Time) ([]*models.Schedule, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	schedules := []*models.Schedule{}
+	for _, s := range fs.schedulesDB {
+		if s.Enabled && !s.NextRunAt.After(now) {
+			result := *s
+			schedules = append(schedules, &result)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].NextRunAt.Before(schedules[j].NextRunAt)
+	})
+	return schedules, nil
+}
+
+// UpdateSchedule updates an existing schedule, provided it has not fired
+// since it was read with next run time expectedNext
+func (fs *FileStorage) UpdateSchedule(s *models.Schedule, expectedNext time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	current, exists := fs.schedulesDB[s.ID]
+	if !exists {
+		return ErrScheduleNotFound
+	}
+	if !current.NextRunAt.Equal(expectedNext) {
+		return ErrScheduleChanged
+	}
+
+	stored := *s
+	stored.LastRunAt = current.LastRunAt
+	stored.MissedRuns = current.MissedRuns
+	stored.LastMissedAt = current.LastMissedAt
+	if err := fs.saveSchedule(&stored); err != nil {
+		return err
+	}
+	fs.schedulesDB[s.ID] = &stored
+	return nil
+}
+
+// AdvanceSchedule records a run of a schedule, provided its next run time is
+// still expectedNext. It returns false if another scheduler got there first
+// or the schedule was changed in the meantime.
+func (fs *FileStorage) AdvanceSchedule(s *models.Schedule, expectedNext time.Time) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	current, exists := fs.schedulesDB[s.ID]
+	if !exists || !current.Enabled || !current.NextRunAt.Equal(expectedNext) {
+		return false, nil
+	}
+
+	advanced := *current
+	advanced.NextRunAt = s.NextRunAt
+	advanced.LastRunAt = s.LastRunAt
+	advanced.MissedRuns = s.MissedRuns
+	advanced.LastMissedAt = s.LastMissedAt
+	advanced.UpdatedAt = s.UpdatedAt
+	if err := fs.saveSchedule(&advanced); err != nil {
+		return false, err
+	}
+	fs.schedulesDB[s.ID] = &advanced
+	return true, nil
+}
+
+// DeleteSchedule deletes a schedule
+func (fs *FileStorage) DeleteSchedule(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.schedulesDB[id]; !exists {
+		return ErrScheduleNotFound
+	}
+
+	os.Remove(filepath.Join(fs.basePath, "schedules", id+".json"))
+	delete(fs.schedulesDB, id)
+	return nil
+}
+
+// deleteSchedules removes all schedules of a function. The caller must hold
+// fs.mu.
+func (fs *FileStorage) deleteSchedules(name string) {
+	for id, s := range fs.schedulesDB {
+		if s.FunctionName == name {
+			os.Remove(filepath.Join(fs.basePath, "schedules", id+".json"))
+			delete(fs.schedulesDB, id)
+		}
+	}
+}
+
+const scheduleColumns = `id, function_name, expression, timezone, qualifier, payload, jitter_sec, description,
+	enabled, next_run_at, last_run_at, missed_runs, last_missed_at, created_at, updated_at`
+
+// CreateSchedule stores a new schedule
+func (ps *PostgresStorage) CreateSchedule(s *models.Schedule) error {
+	query := `
+		INSERT INTO function_schedules (` + scheduleColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`
+
+	_, err := ps.db.Exec(query,
+		s.ID, s.FunctionName, s.Expression, s.Timezone, s.Qualifier, nullableJSON(s.Payload), s.JitterSec,
+		s.Description, s.Enabled, s.NextRunAt, s.LastRunAt, s.MissedRuns, s.LastMissedAt,
+		s.CreatedAt, s.UpdatedAt,
+	)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	return nil
+}
+
+// GetSchedule retrieves a schedule by ID
+func (ps *PostgresStorage) GetSchedule(id string) (*models.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM function_schedules WHERE id = $1`
+
+	s, err := scanSchedule(ps.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+
+	return s, nil
+}
+
+// ListSchedules returns all schedules of a function, oldest first
+func (ps *PostgresStorage) ListSchedules(name string) ([]*models.Schedule, error) {
+	query := `SELECT ` + scheduleColumns + ` FROM function_schedules WHERE function_name = $1 ORDER BY created_at`
+	return ps.querySchedules(query, name)
+}
+
+// ListDueSchedules returns the enabled schedules whose next run is at or
+// before now
+func (ps *PostgresStorage) ListDueSchedules(now time.Time) ([]*models.Schedule, error) {
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM function_schedules
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at
+	`
+	return ps.querySchedules(query, now)
+}
+
+// querySchedules runs a query returning schedule rows
+func (ps *PostgresStorage) querySchedules(query string, args ...interface{}) ([]*models.Schedule, error) {
+	rows, err := ps.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []*models.Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan schedule: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schedules: %w", err)
+	}
+
+	return schedules, nil
+}
+
+// UpdateSchedule updates an existing schedule, provided it has not fired
+// since it was read with next run time expectedNext
+func (ps *PostgresStorage) UpdateSchedule(s *models.Schedule, expectedNext time.Time) error {
+	query := `
+		UPDATE function_schedules
+		SET expression = $1, timezone = $2, qualifier = $3, payload = $4, jitter_sec = $5,
+			description = $6, enabled = $7, next_run_at = $8, updated_at = $9
+		WHERE id = $10 AND next_run_at = $11
+	`
+
+	result, err := ps.db.Exec(query,
+		s.Expression, s.Timezone, s.Qualifier, nullableJSON(s.Payload), s.JitterSec,
+		s.Description, s.Enabled, s.NextRunAt, s.UpdatedAt, s.ID, expectedNext,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		if _, err := ps.GetSchedule(s.ID); err != nil {
+			return err
+		}
+		return ErrScheduleChanged
+	}
+
+	return nil
+}
+
+// AdvanceSchedule records a run of a schedule, provided its next run time is
+// still expectedNext. The conditional update makes exactly one of several
+// servers sharing the database win each run.
+func (ps *PostgresStorage) AdvanceSchedule(s *models.Schedule, expectedNext time.Time) (bool, error) {
+	query := `
+		UPDATE function_schedules
+		SET next_run_at = $1, last_run_at = $2, missed_runs = $3, last_missed_at = $4, updated_at = $5
+		WHERE id = $6 AND enabled AND next_run_at = $7
+	`
+
+	result, err := ps.db.Exec(query,
+		s.NextRunAt, s.LastRunAt, s.MissedRuns, s.LastMissedAt, s.UpdatedAt, s.ID, expectedNext,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance schedule: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows == 1, nil
+}
+
+// DeleteSchedule deletes a schedule
+func (ps *PostgresStorage) DeleteSchedule(id string) error {
+	result, err := ps.db.Exec(`DELETE FROM function_schedules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrScheduleNotFound
+	}
+
+	return nil
+}
+
+// scanSchedule scans a function_schedules row
+func scanSchedule(row rowScanner) (*models.Schedule, error) {
+	s := &models.Schedule{}
+	var timezone, qualifier, description sql.NullString
+	var payload []byte
+	var lastRunAt, lastMissedAt sql.NullTime
+
+	err := row.Scan(
+		&s.ID, &s.FunctionName, &s.Expression, &timezone, &qualifier, &payload, &s.JitterSec, &description,
+		&s.Enabled, &s.NextRunAt, &lastRunAt, &s.MissedRuns, &lastMissedAt, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.Timezone = timezone.String
+	s.Qualifier = qualifier.String
+	s.Description = description.String
+	if len(payload) > 0 {
+		s.Payload = json.RawMessage(payload)
+	}
+	if lastRunAt.Valid {
+		s.LastRunAt = &lastRunAt.Time
+	}
+	if lastMissedAt.Valid {
+		s.LastMissedAt = &lastMissedAt.Time
+	}
+
+	return s, nil
+}
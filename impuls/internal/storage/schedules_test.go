@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// testSchedules exercises the schedule operations shared by both backends
+func testSchedules(t *testing.T, s Storage) {
+	t.Helper()
+
+	if err := s.Create(&models.Function{
+		ID: "test-id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler",
+		Code: "code", MemoryMB: 128, TimeoutSec: 30, CreatedAt: time.Now(), UpdatedAt: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	schedule := &models.Schedule{
+		ID:           "schedule",
+		FunctionName: "test-function",
+		Expression:   "@hourly",
+		Payload:      json.RawMessage(`{"job":"report"}`),
+		Enabled:      true,
+		NextRunAt:    due,
+		CreatedAt:    due,
+		UpdatedAt:    due,
+	}
+	if err := s.CreateSchedule(schedule); err != nil {
+		t.Fatalf("Failed to create schedule: %v", err)
+	}
+	if err := s.CreateSchedule(&models.Schedule{ID: "orphan", FunctionName: "missing", NextRunAt: due}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for unknown function, got %v", err)
+	}
+
+	if dueSchedules, err := s.ListDueSchedules(due.Add(-time.Second)); err != nil || len(dueSchedules) != 0 {
+		t.Errorf("Expected nothing due before 10:00, got %v, %v", dueSchedules, err)
+	}
+	dueSchedules, err := s.ListDueSchedules(due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dueSchedules) != 1 || string(dueSchedules[0].Payload) != `{"job":"report"}` {
+		t.Fatalf("Expected the schedule to be due, got %+v", dueSchedules)
+	}
+
+	lastRun := due.Add(time.Second)
+	advanced := *dueSchedules[0]
+	advanced.NextRunAt = due.Add(time.Hour)
+	advanced.LastRunAt = &lastRun
+	advanced.MissedRuns = 2
+	won, err := s.AdvanceSchedule(&advanced, due)
+	if err != nil || !won {
+		t.Fatalf("Expected to advance the schedule, got %v, %v", won, err)
+	}
+	if won, _ := s.AdvanceSchedule(&advanced, due); won {
+		t.Error("Expected a second advance of the same run to lose")
+	}
+
+	// An update based on a stale read is rejected
+	stale := *dueSchedules[0]
+	stale.Description = "stale"
+	if err := s.UpdateSchedule(&stale, due); err != ErrScheduleChanged {
+		t.Errorf("Expected ErrScheduleChanged, got %v", err)
+	}
+
+	got, err := s.GetSchedule("schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Enabled = false
+	if err := s.UpdateSchedule(got, due.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to update schedule: %v", err)
+	}
+
+	got, err = s.GetSchedule("schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Enabled || got.MissedRuns != 2 || got.LastRunAt == nil || !got.NextRunAt.Equal(due.Add(time.Hour)) {
+		t.Errorf("Unexpected schedule after update: %+v", got)
+	}
+	if dueSchedules, _ := s.ListDueSchedules(due.Add(2 * time.Hour)); len(dueSchedules) != 0 {
+		t.Errorf("Expected disabled schedule not to be due, got %d", len(dueSchedules))
+	}
+
+	schedules, err := s.ListSchedules("test-function")
+	if err != nil || len(schedules) != 1 {
+		t.Errorf("Expected 1 schedule, got %v, %v", schedules, err)
+	}
+
+	// Deleting the function drops its schedules
+	if err := s.Delete("test-function"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSchedule("schedule"); err != ErrScheduleNotFound {
+		t.Errorf("Expected ErrScheduleNotFound after function delete, got %v", err)
+	}
+}
+
+func TestFileStorageSchedules(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSchedules(t, fs)
+}
+
+func TestPostgresStorageSchedules(t *testing.T) {
+	ps, cleanup := setupTestDB(t)
+	if ps == nil {
+		return
+	}
+	defer cleanup()
+
+	testSchedules(t, ps)
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// loadSecrets loads all secrets from disk
+func (fs *FileStorage) loadSecrets() error {
+	secretsDir := filepath.Join(fs.basePath, "secrets")
+	// Values are encrypted, but nobody else needs to see them either
+	if err := os.Chmod(secretsDir, 0700); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(secretsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		var s models.Secret
+		if readJSONFile(filepath.Join(secretsDir, entry.Name()), &s) {
+			fs.secretsDB[s.Name] = &s
+		}
+	}
+	return nil
+}
+
+// copySecret returns a copy of a secret that shares nothing with the stored
+// one
+func copySecret(s *models.Secret) *models.Secret {
+	result := *s
+	if s.Envelope != nil {
+		envelope := *s.Envelope
+		envelope.EncryptedKey = append([]byte(nil), envelope.EncryptedKey...)
+		envelope.Ciphertext = append([]byte(nil), envelope.Ciphertext...)
+		result.Envelope = &envelope
+	}
+	return &result
+}
+
+// saveSecret saves a secret to disk
+func (fs *FileStorage) saveSecret(s *models.Secret) error {
+	path := filepath.Join(fs.basePath, "secrets", s.Name+".json")
+	if err := writeJSONFile(path, s); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// CreateSecret stores a new secret
+func (fs *FileStorage) CreateSecret(s *models.Secret) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.secretsDB[s.Name]; exists {
+		return ErrSecretExists
+	}
+
+	stored := copySecret(s)
+	if err := fs.saveSecret(stored); err != nil {
+		return err
+	}
+	fs.secretsDB[s.Name] = stored
+	return nil
+}
+
+// GetSecret retrieves a secret with its encrypted value
+func (fs *FileStorage) GetSecret(name string) (*models.Secret, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	s, exists := fs.secretsDB[name]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+	return copySecret(s), nil
+}
+
+// UpdateSecret replaces a secret's description and encrypted value
+func (fs *FileStorage) UpdateSecret(s *models.Secret) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.secretsDB[s.Name]; !exists {
+		return ErrSecretNotFound
+	}
+
+	stored := copySecret(s)
+	if err := fs.saveSecret(stored); err != nil {
+		return err
+	}
+	fs.secretsDB[s.Name] = stored
+	return nil
+}
+
+// ListSecrets returns all secrets without their values, sorted by name
+func (fs *FileStorage) ListSecrets() ([]*models.Secret, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	secrets := make([]*models.Secret, 0, len(fs.secretsDB))
+	for _, s := range fs.secretsDB {
+		result := *s
+		result.Envelope = nil
+		secrets = append(secrets, &result)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// DeleteSecret deletes a secret
+func (fs *FileStorage) DeleteSecret(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.secretsDB[name]; !exists {
+		return ErrSecretNotFound
+	}
+
+	os.Remove(filepath.Join(fs.basePath, "secrets", name+".json"))
+	delete(fs.secretsDB, name)
+	return nil
+}
+
+// CreateSecret stores a new secret
+func (ps *PostgresStorage) CreateSecret(s *models.Secret) error {
+	if s.Envelope == nil {
+		return fmt.Errorf("secret %s has no value", s.Name)
+	}
+
+	query := `
+		INSERT INTO secrets (name, description, key_id, encrypted_key, ciphertext, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := ps.db.Exec(query,
+		s.Name, s.Description, s.Envelope.KeyID, s.Envelope.EncryptedKey, s.Envelope.Ciphertext, s.CreatedAt, s.UpdatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrSecretExists
+		}
+		return fmt.Errorf("failed to create secret: %w", err)
+	}
+
+	return nil
+}
+
+// GetSecret retrieves a secret with its encrypted value
+func (ps *PostgresStorage) GetSecret(name string) (*models.Secret, error) {
+	query := `
+		SELECT name, description, key_id, encrypted_key, ciphertext, created_at, updated_at
+		FROM secrets
+		WHERE name = $1
+	`
+
+	s := &models.Secret{Envelope: &models.SecretEnvelope{}}
+	var description sql.NullString
+	err := ps.db.QueryRow(query, name).Scan(
+		&s.Name, &description, &s.Envelope.KeyID, &s.Envelope.EncryptedKey, &s.Envelope.Ciphertext, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSecretNotFound
+		}
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	s.Description = description.String
+
+	return s, nil
+}
+
+// UpdateSecret replaces a secret's description and encrypted value
+func (ps *PostgresStorage) UpdateSecret(s *models.Secret) error {
+	if s.Envelope == nil {
+		return fmt.Errorf("secret %s has no value", s.Name)
+	}
+
+	query := `
+		UPDATE secrets
+		SET description = $1, key_id = $2, encrypted_key = $3, ciphertext = $4, updated_at = $5
+		WHERE name = $6
+	`
+
+	result, err := ps.db.Exec(query,
+		s.Description, s.Envelope.KeyID, s.Envelope.EncryptedKey, s.Envelope.Ciphertext, s.UpdatedAt, s.Name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update secret: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrSecretNotFound
+	}
+
+	return nil
+}
+
+// ListSecrets returns all secrets without their values, sorted by name
+func (ps *PostgresStorage) ListSecrets() ([]*models.Secret, error) {
+	query := `
+		SELECT name, description, created_at, updated_at
+		FROM secrets
+		ORDER BY name
+	`
+
+	rows, err := ps.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	defer rows.Close()
+
+	secrets := []*models.Secret{}
+	for rows.Next() {
+		s := &models.Secret{}
+		var description sql.NullString
+		if err := rows.Scan(&s.Name, &description, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		s.Description = description.String
+		secrets = append(secrets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating secrets: %w", err)
+	}
+
+	return secrets, nil
+}
+
+// DeleteSecret deletes a secret
+func (ps *PostgresStorage) DeleteSecret(name string) error {
+	query := `DELETE FROM secrets WHERE name = $1`
+
+	result, err := ps.db.Exec(query, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrSecretNotFound
+	}
+
+	return nil
+}
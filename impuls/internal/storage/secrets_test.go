@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+func testSecrets(t *testing.T, s Storage) {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	envelope := &models.SecretEnvelope{KeyID: "k1", EncryptedKey: []byte{1, 2, 3}, Ciphertext: []byte{4, 5, 6}}
+	for _, secret := range []*models.Secret{
+		{Name: "db-password", Description: "primary database", Envelope: envelope, CreatedAt: now, UpdatedAt: now},
+		{Name: "api-token", Envelope: envelope, CreatedAt: now, UpdatedAt: now},
+	} {
+		if err := s.CreateSecret(secret); err != nil {
+			t.Fatalf("Failed to create secret %s: %v", secret.Name, err)
+		}
+	}
+	if err := s.CreateSecret(&models.Secret{Name: "api-token", Envelope: envelope, CreatedAt: now, UpdatedAt: now}); err != ErrSecretExists {
+		t.Errorf("Expected ErrSecretExists, got %v", err)
+	}
+
+	secret, err := s.GetSecret("db-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Description != "primary database" || !reflect.DeepEqual(secret.Envelope, envelope) || !secret.CreatedAt.Equal(now) {
+		t.Errorf("Unexpected secret %+v", secret)
+	}
+	if _, err := s.GetSecret("missing"); err != ErrSecretNotFound {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+
+	rotated := &models.SecretEnvelope{KeyID: "k1", EncryptedKey: []byte{7}, Ciphertext: []byte{8, 9}}
+	later := now.Add(time.Minute)
+	if err := s.UpdateSecret(&models.Secret{Name: "db-password", Envelope: rotated, CreatedAt: now, UpdatedAt: later}); err != nil {
+		t.Fatal(err)
+	}
+	if secret, _ = s.GetSecret("db-password"); !reflect.DeepEqual(secret.Envelope, rotated) || !secret.UpdatedAt.Equal(later) {
+		t.Errorf("Expected the updated value, got %+v", secret)
+	}
+	if err := s.UpdateSecret(&models.Secret{Name: "missing", Envelope: rotated}); err != ErrSecretNotFound {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+
+	secrets, err := s.ListSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 2 || secrets[0].Name != "api-token" || secrets[1].Name != "db-password" || secrets[0].Envelope != nil {
+		t.Errorf("Expected both secrets without values, got %+v", secrets)
+	}
+
+	// Functions and versions keep their secret references
+	refs := map[string]string{"DB_PASSWORD": "db-password"}
+	fn := &models.Function{ID: "secretive-id", Name: "secretive", Runtime: models.RuntimeNodeJS20, Handler: "index.handler", Code: "x", MemoryMB: 128, TimeoutSec: 30, Secrets: refs, CreatedAt: now, UpdatedAt: now}
+	if err := s.Create(fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateVersion(&models.FunctionVersion{FunctionName: "secretive", Version: 1, Runtime: models.RuntimeNodeJS20, Handler: "index.handler", Code: "x", MemoryMB: 128, TimeoutSec: 30, Secrets: refs, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("secretive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.GetVersion("secretive", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Secrets, refs) || !reflect.DeepEqual(v.Secrets, refs) {
+		t.Errorf("Expected secrets %v, got %v and %v", refs, got.Secrets, v.Secrets)
+	}
+
+	if err := s.DeleteSecret("api-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteSecret("api-token"); err != ErrSecretNotFound {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+}
+
+func TestFileStorageSecrets(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSecrets(t, fs)
+
+	// Secrets survive a reload from disk
+	fs, err = NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := fs.GetSecret("db-password")
+	if err != nil || secret.Envelope == nil || string(secret.Envelope.Ciphertext) != string([]byte{8, 9}) {
+		t.Errorf("Expected db-password's value after reload, got %+v, %v", secret, err)
+	}
+	if _, err := fs.GetSecret("api-token"); err != ErrSecretNotFound {
+		t.Errorf("Expected api-token to stay deleted, got %v", err)
+	}
+}
+
+func TestPostgresStorageSecrets(t *testing.T) {
+	ps, cleanup := setupTestDB(t)
+	if ps == nil {
+		return
+	}
+	defer cleanup()
+
+	testSecrets(t, ps)
+}
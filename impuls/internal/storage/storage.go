@@ -1,18 +1,38 @@
 package storage
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/oblak/impuls/internal/models"
 )
 
 var (
-	ErrNotFound      = errors.New("function not found")
-	ErrAlreadyExists = errors.New("function already exists")
+	ErrNotFound        = errors.New("function not found")
+	ErrAlreadyExists   = errors.New("function already exists")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrVersionExists   = errors.New("version already exists")
+	ErrAliasNotFound   = errors.New("alias not found")
+	ErrAliasExists     = errors.New("alias already exists")
+
+	ErrAsyncInvocationNotFound = errors.New("async invocation not found")
+	ErrScheduleNotFound        = errors.New("schedule not found")
+	ErrScheduleChanged         = errors.New("schedule changed concurrently")
+
+	ErrInvocationRecordNotFound = errors.New("invocation record not found")
+
+	ErrLayerNotFound      = errors.New("layer not found")
+	ErrLayerVersionExists = errors.New("layer version already exists")
+
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrSecretExists   = errors.New("secret already exists")
 )
 
 // Storage defines the interface for function storage
@@ -24,7 +44,63 @@ type Storage interface {
 	Delete(name string) error
 	List() ([]*models.Function, error)
 	SaveCode(name string, code []byte) (string, error)
+	// SavePackage saves a function's code package, a base64 encoded
+	// archive that GetCode returns as it was saved. Storages that keep
+	// code on disk also call unpack to write the package's files into the
+	// function's code directory and return that directory's path.
+	SavePackage(name string, format models.CodeFormat, code []byte, unpack func(dir string) error) (string, error)
 	GetCode(name string) ([]byte, error)
+
+	// Versions and aliases
+	CreateVersion(v *models.FunctionVersion) error
+	GetVersion(name string, version int) (*models.FunctionVersion, error)
+	ListVersions(name string) ([]*models.FunctionVersion, error)
+	DeleteVersion(name string, version int) error
+	// SetNextVersion raises the number the function's next version gets
+	// to next, leaving the rest of the function as stored
+	SetNextVersion(name string, next int) error
+	CreateAlias(a *models.Alias) error
+	GetAlias(name, alias string) (*models.Alias, error)
+	UpdateAlias(a *models.Alias) error
+	ListAliases(name string) ([]*models.Alias, error)
+	DeleteAlias(name, alias string) error
+
+	// Asynchronous invocation queue
+	EnqueueAsyncInvocation(inv *models.AsyncInvocation) error
+	ClaimAsyncInvocation(now time.Time, lease time.Duration) (*models.AsyncInvocation, error)
+	UpdateAsyncInvocation(inv *models.AsyncInvocation) error
+	GetAsyncInvocation(id string) (*models.AsyncInvocation, error)
+	ListAsyncInvocations(name string, status models.AsyncStatus) ([]*models.AsyncInvocation, error)
+	DeleteAsyncInvocation(id string) error
+
+	// Scheduled triggers
+	CreateSchedule(s *models.Schedule) error
+	GetSchedule(id string) (*models.Schedule, error)
+	ListSchedules(name string) ([]*models.Schedule, error)
+	ListDueSchedules(now time.Time) ([]*models.Schedule, error)
+	UpdateSchedule(s *models.Schedule, expectedNext time.Time) error
+	AdvanceSchedule(s *models.Schedule, expectedNext time.Time) (bool, error)
+	DeleteSchedule(id string) error
+
+	// Invocation history
+	SaveInvocationRecord(r *models.InvocationRecord) error
+	GetInvocationRecord(id string) (*models.InvocationRecord, error)
+	ListInvocationRecords(name string, filter models.InvocationFilter) ([]*models.InvocationRecord, error)
+	PruneInvocationRecords(olderThan time.Time, maxPerFunction int) (int, error)
+
+	// Layers
+	CreateLayer(l *models.Layer) error
+	GetLayer(name string, version int) (*models.Layer, error)
+	ListLayerVersions(name string) ([]*models.Layer, error)
+	ListLayers() ([]*models.Layer, error)
+	DeleteLayer(name string, version int) error
+
+	// Secrets
+	CreateSecret(s *models.Secret) error
+	GetSecret(name string) (*models.Secret, error)
+	UpdateSecret(s *models.Secret) error
+	ListSecrets() ([]*models.Secret, error)
+	DeleteSecret(name string) error
 }
 
 // FileStorage implements Storage using the filesystem
@@ -32,6 +108,14 @@ type FileStorage struct {
 	basePath    string
 	mu          sync.RWMutex
 	functionsDB map[string]*models.Function
+	versionsDB  map[string]map[int]*models.FunctionVersion
+	aliasesDB   map[string]map[string]*models.Alias
+	asyncDB     map[string]*models.AsyncInvocation
+	schedulesDB map[string]*models.Schedule
+
+	invocationsDB map[string]*models.InvocationRecord
+	layersDB      map[string]map[int]*models.Layer
+	secretsDB     map[string]*models.Secret
 }
 
 // NewFileStorage creates a new FileStorage instance
@@ -41,6 +125,13 @@ func NewFileStorage(basePath string) (*FileStorage, error) {
 		basePath,
 		filepath.Join(basePath, "metadata"),
 		filepath.Join(basePath, "code"),
+		filepath.Join(basePath, "versions"),
+		filepath.Join(basePath, "aliases"),
+		filepath.Join(basePath, "async"),
+		filepath.Join(basePath, "schedules"),
+		filepath.Join(basePath, "invocations"),
+		filepath.Join(basePath, "layers"),
+		filepath.Join(basePath, "secrets"),
 	}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -51,6 +142,14 @@ func NewFileStorage(basePath string) (*FileStorage, error) {
 	fs := &FileStorage{
 		basePath:    basePath,
 		functionsDB: make(map[string]*models.Function),
+		versionsDB:  make(map[string]map[int]*models.FunctionVersion),
+		aliasesDB:   make(map[string]map[string]*models.Alias),
+		asyncDB:     make(map[string]*models.AsyncInvocation),
+		schedulesDB: make(map[string]*models.Schedule),
+
+		invocationsDB: make(map[string]*models.InvocationRecord),
+		layersDB:      make(map[string]map[int]*models.Layer),
+		secretsDB:     make(map[string]*models.Secret),
 	}
 
 	// Load existing functions
@@ -58,6 +157,36 @@ func NewFileStorage(basePath string) (*FileStorage, error) {
 		return nil, err
 	}
 
+	// Load published versions and aliases
+	if err := fs.loadVersions(); err != nil {
+		return nil, err
+	}
+
+	// Load the asynchronous invocation queue
+	if err := fs.loadAsyncInvocations(); err != nil {
+		return nil, err
+	}
+
+	// Load scheduled triggers
+	if err := fs.loadSchedules(); err != nil {
+		return nil, err
+	}
+
+	// Load the invocation history
+	if err := fs.loadInvocationRecords(); err != nil {
+		return nil, err
+	}
+
+	// Load layers
+	if err := fs.loadLayers(); err != nil {
+		return nil, err
+	}
+
+	// Load secrets
+	if err := fs.loadSecrets(); err != nil {
+		return nil, err
+	}
+
 	return fs, nil
 }
 
@@ -87,6 +216,11 @@ func (fs *FileStorage) loadAll() error {
 			continue
 		}
 
+		// Unpacked packages are run from the function's code directory
+		if info, err := os.Stat(fs.packageDir(fn.Name)); err == nil && info.IsDir() {
+			fn.CodePath = fs.packageDir(fn.Name)
+		}
+
 		fs.functionsDB[fn.Name] = &fn
 	}
 
@@ -143,10 +277,19 @@ func (fs *FileStorage) Update(fn *models.Function) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	if _, exists := fs.functionsDB[fn.Name]; !exists {
+	existing, exists := fs.functionsDB[fn.Name]
+	if !exists {
 		return ErrNotFound
 	}
 
+	// An update from a copy read before a version was published must not
+	// hand out that version's number again
+	if existing.NextVersion > fn.NextVersion {
+		updated := *fn
+		updated.NextVersion = existing.NextVersion
+		fn = &updated
+	}
+
 	// Save metadata
 	if err := fs.saveMetadata(fn); err != nil {
 		return err
@@ -173,7 +316,16 @@ func (fs *FileStorage) Delete(name string) error {
 	codePath := filepath.Join(fs.basePath, "code", name)
 	os.RemoveAll(codePath)
 
+	// Remove versions and aliases
+	os.RemoveAll(filepath.Join(fs.basePath, "versions", name))
+	os.RemoveAll(filepath.Join(fs.basePath, "aliases", name))
+
 	delete(fs.functionsDB, name)
+	delete(fs.versionsDB, name)
+	delete(fs.aliasesDB, name)
+	fs.deleteAsyncInvocations(name)
+	fs.deleteSchedules(name)
+	fs.deleteInvocationRecords(name)
 	return nil
 }
 
@@ -202,13 +354,96 @@ func (fs *FileStorage) SaveCode(name string, code []byte) (string, error) {
 		return "", err
 	}
 
+	// A package the function had before is replaced
+	os.RemoveAll(fs.packageDir(name))
+	for _, format := range packageFormats {
+		os.Remove(fs.packageArchive(name, format))
+	}
+
 	return codePath, nil
 }
 
-// GetCode retrieves function code from disk
+// packageFormats are the archive formats packages are kept in
+var packageFormats = []models.CodeFormat{models.CodeFormatZip, models.CodeFormatTarGz}
+
+// SavePackage saves a function's code package to disk: the archive itself,
+// and its files unpacked into the function's code directory, which is
+// returned. The files are unpacked next to the tree they replace and
+// swapped in once they are complete.
+func (fs *FileStorage) SavePackage(name string, format models.CodeFormat, code []byte, unpack func(dir string) error) (string, error) {
+	archive, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(code)), ""))
+	if err != nil {
+		return "", fmt.Errorf("package is not valid base64: %w", err)
+	}
+
+	codeDir := filepath.Join(fs.basePath, "code", name)
+	if err := os.MkdirAll(codeDir, 0755); err != nil {
+		return "", err
+	}
+	staging, err := os.MkdirTemp(codeDir, ".package-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+	if err := os.Chmod(staging, 0755); err != nil {
+		return "", err
+	}
+	if err := unpack(staging); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(fs.packageArchive(name, format), archive, 0644); err != nil {
+		return "", err
+	}
+	packageDir := fs.packageDir(name)
+	if err := os.RemoveAll(packageDir); err != nil {
+		return "", err
+	}
+	if err := os.Rename(staging, packageDir); err != nil {
+		return "", err
+	}
+
+	// Whatever code the function had before is replaced
+	os.Remove(filepath.Join(codeDir, "function.js"))
+	for _, other := range packageFormats {
+		if other != format {
+			os.Remove(fs.packageArchive(name, other))
+		}
+	}
+
+	return packageDir, nil
+}
+
+// packageDir is the directory a function's package is unpacked into
+func (fs *FileStorage) packageDir(name string) string {
+	return filepath.Join(fs.basePath, "code", name, "package")
+}
+
+// packageArchive is the file a function's package is kept in
+func (fs *FileStorage) packageArchive(name string, format models.CodeFormat) string {
+	return filepath.Join(fs.basePath, "code", name, "package."+string(format))
+}
+
+// GetCode retrieves function code from disk. Packages are returned base64
+// encoded, the way they were saved.
 func (fs *FileStorage) GetCode(name string) ([]byte, error) {
 	codePath := filepath.Join(fs.basePath, "code", name, "function.js")
-	return os.ReadFile(codePath)
+	code, err := os.ReadFile(codePath)
+	if !os.IsNotExist(err) {
+		return code, err
+	}
+
+	for _, format := range packageFormats {
+		archive, err := os.ReadFile(fs.packageArchive(name, format))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []byte(base64.StdEncoding.EncodeToString(archive)), nil
+	}
+	return nil, err
 }
 
 // saveMetadata saves function metadata to disk
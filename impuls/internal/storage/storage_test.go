@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"encoding/base64"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -185,6 +187,37 @@ func TestFileStorageUpdate(t *testing.T) {
 	}
 }
 
+func TestFileStorageUpdateKeepsNextVersion(t *testing.T) {
+	fs, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := &models.Function{ID: "test-id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}
+	if err := fs.Create(fn); err != nil {
+		t.Fatal(err)
+	}
+	published := *fn
+	published.NextVersion = 3
+	if err := fs.Update(&published); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update from a copy read before the publish keeps the counter
+	stale := *fn
+	stale.MemoryMB = 256
+	if err := fs.Update(&stale); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Get("test-function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NextVersion != 3 || got.MemoryMB != 256 {
+		t.Errorf("Expected the update with next version 3, got %+v", got)
+	}
+}
+
 func TestFileStorageDelete(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
 	if err != nil {
@@ -291,6 +324,69 @@ func TestFileStorageSaveAndGetCode(t *testing.T) {
 	}
 }
 
+func TestFileStorageSavePackage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Create(&models.Function{Name: "packaged", CodeFormat: models.CodeFormatZip}); err != nil {
+		t.Fatal(err)
+	}
+
+	code := base64.StdEncoding.EncodeToString([]byte("archive"))
+	unpack := func(dir string) error {
+		return os.WriteFile(filepath.Join(dir, "index.js"), []byte("exports.handler = () => 1;"), 0644)
+	}
+	path, err := fs.SavePackage("packaged", models.CodeFormatZip, []byte(code), unpack)
+	if err != nil {
+		t.Fatalf("Failed to save package: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "index.js")); err != nil {
+		t.Errorf("Expected the package to be unpacked into %s: %v", path, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "code", "packaged", "function.js")); !os.IsNotExist(err) {
+		t.Errorf("Expected no function.js for a package, got %v", err)
+	}
+	if got, err := fs.GetCode("packaged"); err != nil || string(got) != code {
+		t.Errorf("Expected the package as saved, got %q, %v", got, err)
+	}
+
+	// A failed unpack keeps the package the function had
+	failed := func(dir string) error { return errors.New("invalid package") }
+	if _, err := fs.SavePackage("packaged", models.CodeFormatZip, []byte(code), failed); err == nil {
+		t.Error("Expected the failed unpack to fail the save")
+	}
+	if _, err := os.Stat(filepath.Join(path, "index.js")); err != nil {
+		t.Errorf("Expected the unpacked package to be kept: %v", err)
+	}
+
+	// Reloaded functions run from their unpacked package
+	reloaded, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn, err := reloaded.Get("packaged"); err != nil || fn.CodePath != path {
+		t.Errorf("Expected the reloaded function's code path to be %s, got %+v, %v", path, fn, err)
+	}
+
+	// Plain source replaces the package
+	if _, err := fs.SaveCode("packaged", []byte("exports.handler = () => 2;")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the unpacked package to be removed, got %v", err)
+	}
+	if got, _ := fs.GetCode("packaged"); string(got) != "exports.handler = () => 2;" {
+		t.Errorf("Expected the plain source, got %q", got)
+	}
+}
+
 func TestFileStorageGetByID(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
 	if err != nil {
@@ -0,0 +1,599 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+// loadVersions loads all published versions and aliases from disk
+func (fs *FileStorage) loadVersions() error {
+	versionsDir := filepath.Join(fs.basePath, "versions")
+	fnDirs, err := os.ReadDir(versionsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fnDir := range fnDirs {
+		if !fnDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(versionsDir, fnDir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			var v models.FunctionVersion
+			if !readJSONFile(filepath.Join(versionsDir, fnDir.Name(), entry.Name()), &v) {
+				continue
+			}
+			if fs.versionsDB[v.FunctionName] == nil {
+				fs.versionsDB[v.FunctionName] = make(map[int]*models.FunctionVersion)
+			}
+			fs.versionsDB[v.FunctionName][v.Version] = &v
+		}
+	}
+
+	aliasesDir := filepath.Join(fs.basePath, "aliases")
+	fnDirs, err = os.ReadDir(aliasesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fnDir := range fnDirs {
+		if !fnDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(aliasesDir, fnDir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			var a models.Alias
+			if !readJSONFile(filepath.Join(aliasesDir, fnDir.Name(), entry.Name()), &a) {
+				continue
+			}
+			if fs.aliasesDB[a.FunctionName] == nil {
+				fs.aliasesDB[a.FunctionName] = make(map[string]*models.Alias)
+			}
+			fs.aliasesDB[a.FunctionName][a.Name] = &a
+		}
+	}
+
+	return nil
+}
+
+// readJSONFile decodes a .json file, skipping anything unreadable
+func readJSONFile(path string, v interface{}) bool {
+	if filepath.Ext(path) != ".json" {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// writeJSONFile encodes v into path, creating the parent directory
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// CreateVersion stores a published version
+func (fs *FileStorage) CreateVersion(v *models.FunctionVersion) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.functionsDB[v.FunctionName]; !exists {
+		return ErrNotFound
+	}
+	if _, exists := fs.versionsDB[v.FunctionName][v.Version]; exists {
+		return ErrVersionExists
+	}
+
+	path := filepath.Join(fs.basePath, "versions", v.FunctionName, strconv.Itoa(v.Version)+".json")
+	if err := writeJSONFile(path, v); err != nil {
+		return err
+	}
+
+	if fs.versionsDB[v.FunctionName] == nil {
+		fs.versionsDB[v.FunctionName] = make(map[int]*models.FunctionVersion)
+	}
+	fs.versionsDB[v.FunctionName][v.Version] = v
+	return nil
+}
+
+// SetNextVersion raises the number the function's next version gets
+func (fs *FileStorage) SetNextVersion(name string, next int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	existing, exists := fs.functionsDB[name]
+	if !exists {
+		return ErrNotFound
+	}
+	if existing.NextVersion >= next {
+		return nil
+	}
+
+	updated := *existing
+	updated.NextVersion = next
+	if err := fs.saveMetadata(&updated); err != nil {
+		return err
+	}
+	fs.functionsDB[name] = &updated
+	return nil
+}
+
+// GetVersion retrieves a published version
+func (fs *FileStorage) GetVersion(name string, version int) (*models.FunctionVersion, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	v, exists := fs.versionsDB[name][version]
+	if !exists {
+		return nil, ErrVersionNotFound
+	}
+	return v, nil
+}
+
+// ListVersions returns all published versions of a function, oldest first
+func (fs *FileStorage) ListVersions(name string) ([]*models.FunctionVersion, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	versions := make([]*models.FunctionVersion, 0, len(fs.versionsDB[name]))
+	for _, v := range fs.versionsDB[name] {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// DeleteVersion deletes a published version
+func (fs *FileStorage) DeleteVersion(name string, version int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.versionsDB[name][version]; !exists {
+		return ErrVersionNotFound
+	}
+
+	os.Remove(filepath.Join(fs.basePath, "versions", name, strconv.Itoa(version)+".json"))
+	delete(fs.versionsDB[name], version)
+	return nil
+}
+
+// CreateAlias stores a new alias
+func (fs *FileStorage) CreateAlias(a *models.Alias) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.functionsDB[a.FunctionName]; !exists {
+		return ErrNotFound
+	}
+	if _, exists := fs.aliasesDB[a.FunctionName][a.Name]; exists {
+		return ErrAliasExists
+	}
+
+	if err := fs.saveAlias(a); err != nil {
+		return err
+	}
+
+	if fs.aliasesDB[a.FunctionName] == nil {
+		fs.aliasesDB[a.FunctionName] = make(map[string]*models.Alias)
+	}
+	fs.aliasesDB[a.FunctionName][a.Name] = a
+	return nil
+}
+
+// GetAlias retrieves an alias
+func (fs *FileStorage) GetAlias(name, alias string) (*models.Alias, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	a, exists := fs.aliasesDB[name][alias]
+	if !exists {
+		return nil, ErrAliasNotFound
+	}
+	return a, nil
+}
+
+// UpdateAlias updates an existing alias
+func (fs *FileStorage) UpdateAlias(a *models.Alias) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.aliasesDB[a.FunctionName][a.Name]; !exists {
+		return ErrAliasNotFound
+	}
+
+	if err := fs.saveAlias(a); err != nil {
+		return err
+	}
+
+	fs.aliasesDB[a.FunctionName][a.Name] = a
+	return nil
+}
+
+// ListAliases returns all aliases of a function sorted by name
+func (fs *FileStorage) ListAliases(name string) ([]*models.Alias, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	aliases := make([]*models.Alias, 0, len(fs.aliasesDB[name]))
+	for _, a := range fs.aliasesDB[name] {
+		aliases = append(aliases, a)
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Name < aliases[j].Name
+	})
+	return aliases, nil
+}
+
+// DeleteAlias deletes an alias
+func (fs *FileStorage) DeleteAlias(name, alias string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.aliasesDB[name][alias]; !exists {
+		return ErrAliasNotFound
+	}
+
+	os.Remove(filepath.Join(fs.basePath, "aliases", name, alias+".json"))
+	delete(fs.aliasesDB[name], alias)
+	return nil
+}
+
+// saveAlias saves alias metadata to disk
+func (fs *FileStorage) saveAlias(a *models.Alias) error {
+	return writeJSONFile(filepath.Join(fs.basePath, "aliases", a.FunctionName, a.Name+".json"), a)
+}
+
+// CreateVersion stores a published version
+func (ps *PostgresStorage) CreateVersion(v *models.FunctionVersion) error {
+	envJSON, err := json.Marshal(v.Environment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal environment: %w", err)
+	}
+
+	layersJSON, err := json.Marshal(v.Layers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal layers: %w", err)
+	}
+
+	secretsJSON, err := json.Marshal(v.Secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	query := `
+		INSERT INTO function_versions (function_name, version, description, runtime, handler, code,
+			code_format, dependencies_hash, memory_mb, timeout_sec, environment, layers, secrets, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+
+	_, err = ps.db.Exec(query,
+		v.FunctionName, v.Version, v.Description, v.Runtime, v.Handler, v.Code,
+		v.CodeFormat, v.DependenciesHash, v.MemoryMB, v.TimeoutSec, envJSON, layersJSON, secretsJSON, v.CreatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrVersionExists
+		}
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to create version: %w", err)
+	}
+
+	return nil
+}
+
+// SetNextVersion raises the number the function's next version gets
+func (ps *PostgresStorage) SetNextVersion(name string, next int) error {
+	result, err := ps.db.Exec(`UPDATE functions SET next_version = GREATEST(next_version, $1) WHERE name = $2`, next, name)
+	if err != nil {
+		return fmt.Errorf("failed to set next version: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetVersion retrieves a published version
+func (ps *PostgresStorage) GetVersion(name string, version int) (*models.FunctionVersion, error) {
+	query := `
+		SELECT function_name, version, description, runtime, handler, code,
+			code_format, dependencies_hash, memory_mb, timeout_sec, environment, layers, secrets, created_at
+		FROM function_versions
+		WHERE function_name = $1 AND version = $2
+	`
+
+	v, err := scanVersion(ps.db.QueryRow(query, name, version))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+
+	return v, nil
+}
+
+// ListVersions returns all published versions of a function, oldest first
+func (ps *PostgresStorage) ListVersions(name string) ([]*models.FunctionVersion, error) {
+	query := `
+		SELECT function_name, version, description, runtime, handler, code,
+			code_format, dependencies_hash, memory_mb, timeout_sec, environment, layers, secrets, created_at
+		FROM function_versions
+		WHERE function_name = $1
+		ORDER BY version
+	`
+
+	rows, err := ps.db.Query(query, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []*models.FunctionVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// DeleteVersion deletes a published version
+func (ps *PostgresStorage) DeleteVersion(name string, version int) error {
+	query := `DELETE FROM function_versions WHERE function_name = $1 AND version = $2`
+
+	result, err := ps.db.Exec(query, name, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete version: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrVersionNotFound
+	}
+
+	return nil
+}
+
+// CreateAlias stores a new alias
+func (ps *PostgresStorage) CreateAlias(a *models.Alias) error {
+	routingJSON, err := marshalRoutingConfig(a.RoutingConfig)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO function_aliases (function_name, name, version, description, routing_config, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err = ps.db.Exec(query, a.FunctionName, a.Name, a.Version, a.Description, routingJSON, a.CreatedAt, a.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrAliasExists
+		}
+		if isForeignKeyViolation(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to create alias: %w", err)
+	}
+
+	return nil
+}
+
+// GetAlias retrieves an alias
+func (ps *PostgresStorage) GetAlias(name, alias string) (*models.Alias, error) {
+	query := `
+		SELECT function_name, name, version, description, routing_config, created_at, updated_at
+		FROM function_aliases
+		WHERE function_name = $1 AND name = $2
+	`
+
+	a, err := scanAlias(ps.db.QueryRow(query, name, alias))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAliasNotFound
+		}
+		return nil, fmt.Errorf("failed to get alias: %w", err)
+	}
+
+	return a, nil
+}
+
+// UpdateAlias updates an existing alias
+func (ps *PostgresStorage) UpdateAlias(a *models.Alias) error {
+	routingJSON, err := marshalRoutingConfig(a.RoutingConfig)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE function_aliases
+		SET version = $1, description = $2, routing_config = $3, updated_at = $4
+		WHERE function_name = $5 AND name = $6
+	`
+
+	result, err := ps.db.Exec(query, a.Version, a.Description, routingJSON, a.UpdatedAt, a.FunctionName, a.Name)
+	if err != nil {
+		return fmt.Errorf("failed to update alias: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrAliasNotFound
+	}
+
+	return nil
+}
+
+// ListAliases returns all aliases of a function sorted by name
+func (ps *PostgresStorage) ListAliases(name string) ([]*models.Alias, error) {
+	query := `
+		SELECT function_name, name, version, description, routing_config, created_at, updated_at
+		FROM function_aliases
+		WHERE function_name = $1
+		ORDER BY name
+	`
+
+	rows, err := ps.db.Query(query, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list aliases: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := []*models.Alias{}
+	for rows.Next() {
+		a, err := scanAlias(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alias: %w", err)
+		}
+		aliases = append(aliases, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating aliases: %w", err)
+	}
+
+	return aliases, nil
+}
+
+// DeleteAlias deletes an alias
+func (ps *PostgresStorage) DeleteAlias(name, alias string) error {
+	query := `DELETE FROM function_aliases WHERE function_name = $1 AND name = $2`
+
+	result, err := ps.db.Exec(query, name, alias)
+	if err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return ErrAliasNotFound
+	}
+
+	return nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVersion scans a function_versions row
+func scanVersion(row rowScanner) (*models.FunctionVersion, error) {
+	v := &models.FunctionVersion{}
+	var description sql.NullString
+	var envJSON, layersJSON, secretsJSON []byte
+
+	err := row.Scan(
+		&v.FunctionName, &v.Version, &description, &v.Runtime, &v.Handler, &v.Code,
+		&v.CodeFormat, &v.DependenciesHash, &v.MemoryMB, &v.TimeoutSec, &envJSON, &layersJSON, &secretsJSON, &v.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	v.Description = description.String
+
+	if len(envJSON) > 0 && string(envJSON) != "null" {
+		if err := json.Unmarshal(envJSON, &v.Environment); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal environment: %w", err)
+		}
+	}
+
+	if len(layersJSON) > 0 && string(layersJSON) != "null" {
+		if err := json.Unmarshal(layersJSON, &v.Layers); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal layers: %w", err)
+		}
+	}
+
+	if len(secretsJSON) > 0 && string(secretsJSON) != "null" {
+		if err := json.Unmarshal(secretsJSON, &v.Secrets); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal secrets: %w", err)
+		}
+	}
+
+	return v, nil
+}
+
+// scanAlias scans a function_aliases row
+func scanAlias(row rowScanner) (*models.Alias, error) {
+	a := &models.Alias{}
+	var description sql.NullString
+	var routingJSON []byte
+
+	err := row.Scan(&a.FunctionName, &a.Name, &a.Version, &description, &routingJSON, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.Description = description.String
+
+	if len(routingJSON) > 0 && string(routingJSON) != "null" {
+		a.RoutingConfig = &models.AliasRoutingConfig{}
+		if err := json.Unmarshal(routingJSON, a.RoutingConfig); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal routing config: %w", err)
+		}
+	}
+
+	return a, nil
+}
+
+// marshalRoutingConfig encodes an alias routing config for the JSONB column,
+// storing NULL when the alias has no traffic split
+func marshalRoutingConfig(c *models.AliasRoutingConfig) ([]byte, error) {
+	if c == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal routing config: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oblak/impuls/internal/models"
+)
+
+func TestFileStorageVersions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Versions require an existing function
+	v1 := &models.FunctionVersion{FunctionName: "test-function", Version: 1, Runtime: models.RuntimeNodeJS20, Handler: "index.handler", Code: "v1"}
+	if err := fs.CreateVersion(v1); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for missing function, got %v", err)
+	}
+
+	if err := fs.Create(&models.Function{ID: "id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := &models.FunctionVersion{FunctionName: "test-function", Version: 2, Runtime: models.RuntimeNodeJS20, Handler: "index.handler", Code: "v2"}
+	for _, v := range []*models.FunctionVersion{v2, v1} {
+		if err := fs.CreateVersion(v); err != nil {
+			t.Fatalf("Failed to create version %d: %v", v.Version, err)
+		}
+	}
+
+	if err := fs.CreateVersion(v1); err != ErrVersionExists {
+		t.Errorf("Expected ErrVersionExists, got %v", err)
+	}
+
+	versions, err := fs.ListVersions("test-function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 2 {
+		t.Fatalf("Expected versions [1 2], got %v", versions)
+	}
+
+	// Versions survive a reload from disk
+	fs, err = NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.GetVersion("test-function", 2)
+	if err != nil {
+		t.Fatalf("Failed to get version after reload: %v", err)
+	}
+	if got.Code != "v2" {
+		t.Errorf("Expected code v2, got %s", got.Code)
+	}
+
+	if err := fs.DeleteVersion("test-function", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.GetVersion("test-function", 2); err != ErrVersionNotFound {
+		t.Errorf("Expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestFileStorageSetNextVersion(t *testing.T) {
+	fs, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetNextVersion("test-function", 2); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for missing function, got %v", err)
+	}
+
+	fn := &models.Function{ID: "id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}
+	if err := fs.Create(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update between reading the function and publishing is kept
+	updated := *fn
+	updated.MemoryMB = 256
+	if err := fs.Update(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetNextVersion("test-function", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetNextVersion("test-function", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStorage(fs.basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Get("test-function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NextVersion != 3 || got.MemoryMB != 256 {
+		t.Errorf("Expected the update with next version 3, got %+v", got)
+	}
+}
+
+func TestFileStorageAliases(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "impuls-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fs, err := NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Create(&models.Function{ID: "id", Name: "test-function", Runtime: models.RuntimeNodeJS20, Handler: "index.handler"}); err != nil {
+		t.Fatal(err)
+	}
+
+	alias := &models.Alias{FunctionName: "test-function", Name: "prod", Version: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := fs.CreateAlias(alias); err != nil {
+		t.Fatalf("Failed to create alias: %v", err)
+	}
+	if err := fs.CreateAlias(alias); err != ErrAliasExists {
+		t.Errorf("Expected ErrAliasExists, got %v", err)
+	}
+
+	updated := *alias
+	updated.Version = 2
+	updated.RoutingConfig = &models.AliasRoutingConfig{AdditionalVersion: 3, Weight: 0.1}
+	if err := fs.UpdateAlias(&updated); err != nil {
+		t.Fatalf("Failed to update alias: %v", err)
+	}
+
+	fs, err = NewFileStorage(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.GetAlias("test-function", "prod")
+	if err != nil {
+		t.Fatalf("Failed to get alias after reload: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Expected alias version 2, got %d", got.Version)
+	}
+	if got.RoutingConfig == nil || got.RoutingConfig.AdditionalVersion != 3 || got.RoutingConfig.Weight != 0.1 {
+		t.Errorf("Expected routing config to version 3 at 0.1, got %+v", got.RoutingConfig)
+	}
+
+	// Deleting the function removes its aliases
+	if err := fs.Delete("test-function"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.GetAlias("test-function", "prod"); err != ErrAliasNotFound {
+		t.Errorf("Expected ErrAliasNotFound after function delete, got %v", err)
+	}
+}
+
+func TestPostgresStorageVersionsAndAliases(t *testing.T) {
+	ps, cleanup := setupTestDB(t)
+	if ps == nil {
+		return
+	}
+	defer cleanup()
+
+	fn := &models.Function{
+		ID:         "test-id",
+		Name:       "test-function",
+		Runtime:    models.RuntimeNodeJS20,
+		Handler:    "index.handler",
+		Code:       "v1",
+		MemoryMB:   128,
+		TimeoutSec: 30,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if err := ps.Create(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &models.FunctionVersion{
+		FunctionName:     "test-function",
+		Version:          1,
+		Runtime:          models.RuntimeNodeJS20,
+		Handler:          "index.handler",
+		Code:             "v1",
+		CodeFormat:       models.CodeFormatTarGz,
+		DependenciesHash: "abc123",
+		MemoryMB:         128,
+		TimeoutSec:       30,
+		Environment:      map[string]string{"KEY": "value"},
+		CreatedAt:        time.Now(),
+	}
+	if err := ps.CreateVersion(v); err != nil {
+		t.Fatalf("Failed to create version: %v", err)
+	}
+	if err := ps.CreateVersion(v); err != ErrVersionExists {
+		t.Errorf("Expected ErrVersionExists, got %v", err)
+	}
+
+	got, err := ps.GetVersion("test-function", 1)
+	if err != nil {
+		t.Fatalf("Failed to get version: %v", err)
+	}
+	if got.Environment["KEY"] != "value" {
+		t.Errorf("Expected environment KEY=value, got %v", got.Environment)
+	}
+	if got.CodeFormat != models.CodeFormatTarGz {
+		t.Errorf("Expected code format tar.gz, got %q", got.CodeFormat)
+	}
+	if got.DependenciesHash != "abc123" {
+		t.Errorf("Expected dependencies hash abc123, got %q", got.DependenciesHash)
+	}
+
+	// The counter only ever goes up
+	for _, next := range []int{3, 2} {
+		if err := ps.SetNextVersion("test-function", next); err != nil {
+			t.Fatalf("Failed to set next version: %v", err)
+		}
+	}
+	if stored, err := ps.Get("test-function"); err != nil || stored.NextVersion != 3 {
+		t.Errorf("Expected next version 3, got %+v, %v", stored, err)
+	}
+	if err := ps.SetNextVersion("missing", 2); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for missing function, got %v", err)
+	}
+
+	alias := &models.Alias{FunctionName: "test-function", Name: "prod", Version: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := ps.CreateAlias(alias); err != nil {
+		t.Fatalf("Failed to create alias: %v", err)
+	}
+
+	aliases, err := ps.ListAliases("test-function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0].Name != "prod" {
+		t.Errorf("Expected alias prod, got %v", aliases)
+	}
+	if aliases[0].RoutingConfig != nil {
+		t.Errorf("Expected no routing config, got %+v", aliases[0].RoutingConfig)
+	}
+
+	alias.RoutingConfig = &models.AliasRoutingConfig{AdditionalVersion: 2, Weight: 0.25}
+	if err := ps.UpdateAlias(alias); err != nil {
+		t.Fatalf("Failed to update alias: %v", err)
+	}
+	gotAlias, err := ps.GetAlias("test-function", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotAlias.RoutingConfig == nil || gotAlias.RoutingConfig.AdditionalVersion != 2 || gotAlias.RoutingConfig.Weight != 0.25 {
+		t.Errorf("Expected routing config to version 2 at 0.25, got %+v", gotAlias.RoutingConfig)
+	}
+
+	// Deleting the function cascades to versions and aliases
+	if err := ps.Delete("test-function"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.GetVersion("test-function", 1); err != ErrVersionNotFound {
+		t.Errorf("Expected ErrVersionNotFound after cascade, got %v", err)
+	}
+	if _, err := ps.GetAlias("test-function", "prod"); err != ErrAliasNotFound {
+		t.Errorf("Expected ErrAliasNotFound after cascade, got %v", err)
+	}
+}
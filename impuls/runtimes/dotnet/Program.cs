@@ -6,6 +6,7 @@
  */
 
 using System.Reflection;
+using System.Runtime.InteropServices;
 using System.Text;
 using System.Text.Json;
 using System.Text.Json.Serialization;
@@ -14,6 +15,10 @@ using Microsoft.CodeAnalysis.CSharp;
 
 var port = int.Parse(Environment.GetEnvironmentVariable("RUNTIME_PORT") ?? "8080");
 var functionDir = Environment.GetEnvironmentVariable("FUNCTION_DIR") ?? "/var/task";
+// The function's layers, merged at boot; absent for functions without any
+var layerDir = Environment.GetEnvironmentVariable("LAYER_DIR") ?? "/opt/layer";
+// The exit code asking the bootstrap to start the runtime again
+const int RestartExitCode = 75;
 
 var builder = WebApplication.CreateBuilder(args);
 builder.WebHost.UseUrls($"http://0.0.0.0:{port}");
@@ -24,10 +29,35 @@ var app = builder.Build();
 Assembly? cachedAssembly = null;
 string? cachedCode = null;
 object? cachedHandler = null;
+string? cachedHandlerName = null;
 MethodInfo? cachedMethod = null;
 
 app.MapGet("/health", () => Results.Json(new { status = "healthy", runtime = "dotnet" }));
 
+// A VM restored from a snapshot resumes with the RNG state of every other
+// clone. The host's entropy goes into the kernel pool, but the runtime's
+// RNGs keep their seeds for the life of the process, so it exits for its
+// bootstrap to start it again with fresh ones.
+app.MapPost("/reseed", async (HttpContext context) =>
+{
+    var request = await JsonSerializer.DeserializeAsync<ReseedRequest>(context.Request.Body);
+    AddEntropy(request?.Entropy ?? Array.Empty<byte>());
+
+    // Started again after a reseed: the seeds are fresh already
+    if (Environment.GetEnvironmentVariable("RUNTIME_RESEEDED") != null)
+    {
+        return Results.Json(new { restart = false });
+    }
+
+    context.Response.Headers["Connection"] = "close";
+    context.Response.OnCompleted(() =>
+    {
+        Environment.Exit(RestartExitCode);
+        return Task.CompletedTask;
+    });
+    return Results.Json(new { restart = true });
+});
+
 app.MapPost("/invoke", async (HttpContext context) =>
 {
     try
@@ -47,24 +77,32 @@ app.MapPost("/invoke", async (HttpContext context) =>
             }
         }
 
-        // Load and compile the function if needed
-        if (cachedCode != request.Code || cachedAssembly == null)
+        // Load and compile the function if needed. All .cs files of a
+        // package are compiled together, with those of its layers.
+        var codeKey = request.Files != null ? request.PackageId : request.Code;
+        if (cachedCode != codeKey || cachedAssembly == null)
         {
-            var (assembly, error) = CompileCode(request.Code ?? "");
+            var sources = request.Files != null
+                ? PackageSources(request.Files)
+                : new[] { ("Function.cs", request.Code ?? "") };
+            var (assembly, error) = CompileCode(WithLayerSources(layerDir, sources));
             if (assembly == null)
             {
                 return Results.Json(new { statusCode = 500, error = $"Compilation failed: {error}" });
             }
             cachedAssembly = assembly;
-            cachedCode = request.Code;
+            cachedCode = codeKey;
             cachedHandler = null;
             cachedMethod = null;
         }
 
-        // Find the handler
-        if (cachedHandler == null || cachedMethod == null)
+        // Find the handler, again when the function's handler changed
+        var handlerName = request.Handler ?? "Function.Handler";
+        if (cachedHandler == null || cachedMethod == null || cachedHandlerName != handlerName)
         {
-            var handlerParts = (request.Handler ?? "Function.Handler").Split('.');
+            cachedHandler = null;
+            cachedMethod = null;
+            var handlerParts = handlerName.Split('.');
             if (handlerParts.Length < 2)
             {
                 return Results.Json(new { statusCode = 500, error = "Invalid handler format" });
@@ -85,6 +123,7 @@ app.MapPost("/invoke", async (HttpContext context) =>
             {
                 return Results.Json(new { statusCode = 500, error = $"Method '{methodName}' not found" });
             }
+            cachedHandlerName = handlerName;
         }
 
         // Create context
@@ -135,9 +174,65 @@ app.MapPost("/invoke", async (HttpContext context) =>
 
 app.Run();
 
-static (Assembly?, string?) CompileCode(string code)
+// Write entropy to the kernel pool and force the kernel's RNG to reseed
+// from it where permitted
+static void AddEntropy(byte[] entropy)
 {
-    var syntaxTree = CSharpSyntaxTree.ParseText(code);
+    // RNDRESEEDCRNG
+    const ulong RndReseedCrng = 0x5207;
+
+    try
+    {
+        using var urandom = new FileStream("/dev/urandom", FileMode.Open, FileAccess.Write);
+        urandom.Write(entropy);
+        urandom.Flush();
+        try
+        {
+            ioctl((int)urandom.SafeFileHandle.DangerousGetHandle(), RndReseedCrng, IntPtr.Zero);
+        }
+        catch (Exception ex) when (ex is DllNotFoundException or EntryPointNotFoundException)
+        {
+        }
+    }
+    catch (Exception ex)
+    {
+        Console.Error.WriteLine($"Failed to add entropy: {ex.Message}");
+    }
+
+    [DllImport("libc", SetLastError = true)]
+    static extern int ioctl(int fd, ulong request, IntPtr arg);
+}
+
+static (string Path, string Code)[] PackageSources(Dictionary<string, string> files)
+{
+    return files
+        .Where(f => f.Key.EndsWith(".cs"))
+        .OrderBy(f => f.Key, StringComparer.Ordinal)
+        .Select(f => (f.Key, Encoding.UTF8.GetString(Convert.FromBase64String(f.Value))))
+        .ToArray();
+}
+
+// The function's files replace layer files at the same path
+static (string Path, string Code)[] WithLayerSources(string layerDir, (string Path, string Code)[] sources)
+{
+    if (!Directory.Exists(layerDir))
+    {
+        return sources;
+    }
+
+    var paths = sources.Select(s => s.Path).ToHashSet();
+    return Directory.EnumerateFiles(layerDir, "*.cs", SearchOption.AllDirectories)
+        .Select(file => Path.GetRelativePath(layerDir, file).Replace(Path.DirectorySeparatorChar, '/'))
+        .Where(path => !paths.Contains(path))
+        .OrderBy(path => path, StringComparer.Ordinal)
+        .Select(path => (path, File.ReadAllText(Path.Combine(layerDir, path))))
+        .Concat(sources)
+        .ToArray();
+}
+
+static (Assembly?, string?) CompileCode(IEnumerable<(string Path, string Code)> sources)
+{
+    var syntaxTrees = sources.Select(s => CSharpSyntaxTree.ParseText(s.Code, path: s.Path));
 
     // Add references
     var references = new List<MetadataReference>
@@ -154,7 +249,7 @@ static (Assembly?, string?) CompileCode(string code)
 
     var compilation = CSharpCompilation.Create(
         "FunctionAssembly",
-        new[] { syntaxTree },
+        syntaxTrees,
         references,
         new CSharpCompilationOptions(OutputKind.DynamicallyLinkedLibrary));
 
@@ -173,11 +268,23 @@ static (Assembly?, string?) CompileCode(string code)
     return (Assembly.Load(ms.ToArray()), null);
 }
 
+public class ReseedRequest
+{
+    [JsonPropertyName("entropy")]
+    public byte[]? Entropy { get; set; }
+}
+
 public class InvocationRequest
 {
     [JsonPropertyName("code")]
     public string? Code { get; set; }
 
+    [JsonPropertyName("files")]
+    public Dictionary<string, string>? Files { get; set; }
+
+    [JsonPropertyName("package_id")]
+    public string? PackageId { get; set; }
+
     [JsonPropertyName("handler")]
     public string? Handler { get; set; }
 
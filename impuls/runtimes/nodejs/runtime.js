@@ -11,27 +11,40 @@ const http = require('http');
 const vm = require('vm');
 const fs = require('fs');
 const path = require('path');
+const Module = require('module');
 
 const PORT = process.env.RUNTIME_PORT || 8080;
 const FUNCTION_DIR = process.env.FUNCTION_DIR || '/var/task';
+// The function's layers, merged at boot; absent for functions without any
+const LAYER_DIR = process.env.LAYER_DIR || '/opt/layer';
+
+// The largest chunk a handler may write to its response at once
+const MAX_CHUNK_SIZE = 8 * 1024 * 1024;
+
+// The exit code asking the bootstrap to start the runtime again
+const RESTART_EXIT_CODE = 75;
 
 // Function cache
 let cachedHandler = null;
 let cachedCode = null;
+let cachedHandlerName = null;
 
 /**
  * Load and compile the function code
  */
 function loadFunction(code, handler) {
-    if (cachedCode === code && cachedHandler) {
+    if (cachedCode === code && cachedHandlerName === handler && cachedHandler) {
         return cachedHandler;
     }
 
-    // Create a sandbox environment
+    // Create a sandbox environment. Relative requires resolve against the
+    // layers, which plain source is overlaid on.
     const sandbox = {
         module: { exports: {} },
         exports: {},
-        require: require,
+        require: fs.existsSync(LAYER_DIR)
+            ? Module.createRequire(path.join(LAYER_DIR, 'function.js'))
+            : require,
         console: console,
         process: process,
         Buffer: Buffer,
@@ -67,6 +80,58 @@ function loadFunction(code, handler) {
         }
 
         cachedCode = code;
+        cachedHandlerName = handler;
+        cachedHandler = handlerFn;
+
+        return handlerFn;
+    } catch (err) {
+        throw new Error(`Failed to load function: ${err.message}`);
+    }
+}
+
+/**
+ * Unpack a code package and load the handler from its module. Packages are
+ * unpacked once per ID; the handler's module is a path in the tree.
+ */
+function loadPackage(packageId, files, handler) {
+    if (cachedCode === packageId && cachedHandlerName === handler && cachedHandler) {
+        return cachedHandler;
+    }
+
+    const dot = handler.lastIndexOf('.');
+    if (dot <= 0) {
+        throw new Error(`Invalid handler format: ${handler} (expected 'module.function')`);
+    }
+    const moduleName = handler.slice(0, dot);
+    const handlerName = handler.slice(dot + 1);
+
+    try {
+        const packageDir = path.join(FUNCTION_DIR, packageId);
+        if (!fs.existsSync(packageDir)) {
+            const tmpDir = `${packageDir}.tmp`;
+            fs.rmSync(tmpDir, { recursive: true, force: true });
+            // The package's files are written over its layers
+            if (fs.existsSync(LAYER_DIR)) {
+                fs.cpSync(LAYER_DIR, tmpDir, { recursive: true });
+            }
+            for (const [name, content] of Object.entries(files)) {
+                const file = path.join(tmpDir, name);
+                if (!file.startsWith(tmpDir + path.sep)) {
+                    throw new Error(`Invalid path in package: ${name}`);
+                }
+                fs.mkdirSync(path.dirname(file), { recursive: true });
+                fs.writeFileSync(file, Buffer.from(content, 'base64'));
+            }
+            fs.renameSync(tmpDir, packageDir);
+        }
+
+        const handlerFn = require(path.join(packageDir, moduleName))[handlerName];
+        if (typeof handlerFn !== 'function') {
+            throw new Error(`Handler '${handlerName}' is not a function`);
+        }
+
+        cachedCode = packageId;
+        cachedHandlerName = handler;
         cachedHandler = handlerFn;
 
         return handlerFn;
@@ -116,9 +181,23 @@ async function executeHandler(handler, event, context) {
 }
 
 /**
- * Create Lambda-like context object
+ * Encode a chunk of the response: buffers as they are, strings as UTF-8 and
+ * any other value as JSON
  */
-function createContext(functionName, timeoutMs, memoryMB) {
+function encodeChunk(chunk) {
+    const data = Buffer.isBuffer(chunk) || chunk instanceof Uint8Array
+        ? Buffer.from(chunk)
+        : Buffer.from(typeof chunk === 'string' ? chunk : JSON.stringify(chunk));
+    if (data.length > MAX_CHUNK_SIZE) {
+        throw new Error(`chunk exceeds ${MAX_CHUNK_SIZE} bytes`);
+    }
+    return data;
+}
+
+/**
+ * Create Lambda-like context object. write sends a chunk of the response.
+ */
+function createContext(functionName, timeoutMs, memoryMB, write) {
     const startTime = Date.now();
     const requestId = generateRequestId();
 
@@ -134,6 +213,7 @@ function createContext(functionName, timeoutMs, memoryMB) {
         getRemainingTimeInMillis: () => {
             return Math.max(0, timeoutMs - (Date.now() - startTime));
         },
+        write: (chunk) => write(encodeChunk(chunk)),
         done: (err, result) => {
             // Legacy callback
         },
@@ -171,27 +251,64 @@ async function handleInvoke(req, res) {
         const startTime = Date.now();
         let logs = [];
 
+        // The host asks for NDJSON to follow the output while the function
+        // runs: log frames as lines are written, then one result frame
+        const streaming = (req.headers['accept'] || '').includes('application/x-ndjson');
+        if (streaming) {
+            res.writeHead(200, { 'Content-Type': 'application/x-ndjson' });
+        }
+
+        const capture = (level, stream, args) => {
+            const message = args.join(' ');
+            logs.push({ level, message });
+            if (streaming) {
+                message.split('\n').forEach(line => {
+                    res.write(JSON.stringify({ type: 'log', stream, line: `[${level.toUpperCase()}] ${line}` }) + '\n');
+                });
+            }
+        };
+
+        // Chunks the handler writes go to the host as they are written;
+        // without a stream they make up the body if it returns nothing
+        const chunks = [];
+        const write = (data) => {
+            if (streaming) {
+                res.write(JSON.stringify({ type: 'chunk', data: data.toString('base64') }) + '\n');
+            } else {
+                chunks.push(data);
+            }
+        };
+
+        const respond = (status, response) => {
+            if (streaming) {
+                res.end(JSON.stringify({ type: 'result', result: response }) + '\n');
+                return;
+            }
+            res.writeHead(status, { 'Content-Type': 'application/json' });
+            res.end(JSON.stringify(response));
+        };
+
         // Capture console output
         const originalLog = console.log;
         const originalError = console.error;
         const originalWarn = console.warn;
 
         console.log = (...args) => {
-            logs.push({ level: 'info', message: args.join(' ') });
+            capture('info', 'stdout', args);
             originalLog.apply(console, args);
         };
         console.error = (...args) => {
-            logs.push({ level: 'error', message: args.join(' ') });
+            capture('error', 'stderr', args);
             originalError.apply(console, args);
         };
         console.warn = (...args) => {
-            logs.push({ level: 'warn', message: args.join(' ') });
+            capture('warn', 'stderr', args);
             originalWarn.apply(console, args);
         };
 
         try {
             const payload = JSON.parse(body);
-            const { code, handler, event, env, timeout_ms, memory_mb, function_name } = payload;
+            const { code, files, package_id, handler, event, env, timeout_ms, memory_mb, function_name } = payload;
 
             // Set environment variables
             if (env) {
@@ -201,17 +318,32 @@ async function handleInvoke(req, res) {
             }
 
             // Load the function
-            const handlerFn = loadFunction(code, handler);
+            const handlerFn = files
+                ? loadPackage(package_id, files, handler)
+                : loadFunction(code, handler);
 
             // Create context
             const context = createContext(
                 function_name || 'anonymous',
                 timeout_ms || 30000,
-                memory_mb || 128
+                memory_mb || 128,
+                write
             );
 
             // Execute the handler
-            const result = await executeHandler(handlerFn, event, context);
+            let result = await executeHandler(handlerFn, event, context);
+
+            // Generators write what they yield
+            const kind = Object.prototype.toString.call(result);
+            if (kind === '[object AsyncGenerator]' || kind === '[object Generator]') {
+                for await (const chunk of result) {
+                    context.write(chunk);
+                }
+                result = undefined;
+            }
+            if (result === undefined && chunks.length > 0) {
+                result = Buffer.concat(chunks).toString();
+            }
 
             // Restore console
             console.log = originalLog;
@@ -226,8 +358,7 @@ async function handleInvoke(req, res) {
                 logs: logs.map(l => `[${l.level.toUpperCase()}] ${l.message}`).join('\n'),
             };
 
-            res.writeHead(200, { 'Content-Type': 'application/json' });
-            res.end(JSON.stringify(response));
+            respond(200, response);
 
         } catch (err) {
             // Restore console
@@ -243,8 +374,7 @@ async function handleInvoke(req, res) {
                 logs: logs.map(l => `[${l.level.toUpperCase()}] ${l.message}`).join('\n'),
             };
 
-            res.writeHead(500, { 'Content-Type': 'application/json' });
-            res.end(JSON.stringify(response));
+            respond(500, response);
         }
     });
 }
@@ -261,6 +391,42 @@ function handleHealth(req, res) {
     }));
 }
 
+/**
+ * Reseed handler. A VM restored from a snapshot resumes with the RNG state
+ * of every other clone. The host's entropy goes into the kernel pool, but
+ * V8 and OpenSSL keep their seeds for the life of the process, so the
+ * runtime exits for its bootstrap to start it again with fresh ones.
+ */
+function handleReseed(req, res) {
+    let body = '';
+
+    req.on('data', chunk => {
+        body += chunk.toString();
+    });
+
+    req.on('end', () => {
+        try {
+            const { entropy } = JSON.parse(body);
+            fs.writeFileSync('/dev/urandom', Buffer.from(entropy || '', 'base64'));
+        } catch (err) {
+            console.error('Failed to add entropy:', err.message);
+        }
+
+        // Started again after a reseed: the seeds are fresh already
+        if (process.env.RUNTIME_RESEEDED) {
+            res.writeHead(200, { 'Content-Type': 'application/json' });
+            res.end(JSON.stringify({ restart: false }));
+            return;
+        }
+
+        server.close();
+        res.writeHead(200, { 'Content-Type': 'application/json', 'Connection': 'close' });
+        res.end(JSON.stringify({ restart: true }), () => {
+            process.exit(RESTART_EXIT_CODE);
+        });
+    });
+}
+
 /**
  * Main HTTP server
  */
@@ -269,6 +435,8 @@ const server = http.createServer((req, res) => {
         handleInvoke(req, res);
     } else if (req.method === 'GET' && req.url === '/health') {
         handleHealth(req, res);
+    } else if (req.method === 'POST' && req.url === '/reseed') {
+        handleReseed(req, res);
     } else {
         res.writeHead(404, { 'Content-Type': 'application/json' });
         res.end(JSON.stringify({ error: 'Not found' }));
@@ -0,0 +1,350 @@
+// Command provided is the Impuls runtime for functions that are binaries:
+// functions of the provided runtime, and Go functions, which the host
+// compiles. It runs inside the Firecracker VM, receives invocations over
+// HTTP and runs the function's binary once per invocation.
+//
+// The binary reads one JSON request from stdin and writes one JSON response
+// to stdout; whatever it writes to stderr is logged. See docs/api.md.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	port        = envOr("RUNTIME_PORT", "8080")
+	functionDir = envOr("FUNCTION_DIR", "/var/task")
+	// The function's layers, merged at boot; absent for functions without any
+	layerDir = envOr("LAYER_DIR", "/opt/layer")
+)
+
+// invokeRequest is an invocation sent by the host
+type invokeRequest struct {
+	Handler         string            `json:"handler"`
+	Code            string            `json:"code"`
+	PackageID       string            `json:"package_id"`
+	Files           map[string][]byte `json:"files"`
+	Event           json.RawMessage   `json:"event"`
+	Env             map[string]string `json:"env"`
+	FunctionName    string            `json:"function_name"`
+	FunctionVersion string            `json:"function_version"`
+	InvocationID    string            `json:"invocation_id"`
+	MemoryMB        int               `json:"memory_mb"`
+	TimeoutMS       int               `json:"timeout_ms"`
+}
+
+// functionRequest is what the binary reads from stdin
+type functionRequest struct {
+	Event   json.RawMessage `json:"event"`
+	Context functionContext `json:"context"`
+}
+
+type functionContext struct {
+	FunctionName    string `json:"function_name"`
+	FunctionVersion string `json:"function_version"`
+	InvocationID    string `json:"invocation_id"`
+	MemoryMB        int    `json:"memory_mb"`
+	TimeoutMS       int    `json:"timeout_ms"`
+	DeadlineMS      int64  `json:"deadline_ms"`
+}
+
+// functionResponse is what the binary writes to stdout
+type functionResponse struct {
+	Body  json.RawMessage `json:"body"`
+	Error string          `json:"error"`
+	Stack string          `json:"stack"`
+}
+
+// invokeResponse is the result sent back to the host
+type invokeResponse struct {
+	StatusCode int             `json:"statusCode"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Stack      string          `json:"stack,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+	Logs       string          `json:"logs"`
+}
+
+// frameWriter writes NDJSON frames to a streaming response
+type frameWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+}
+
+func (f *frameWriter) write(frame interface{}) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.w.Write(append(data, '\n'))
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// packages serializes unpacking, so concurrent invocations of a new package
+// unpack it once
+var packages sync.Mutex
+
+func main() {
+	http.HandleFunc("/invoke", handleInvoke)
+	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/reseed", handleReseed)
+
+	log.Printf("Impuls provided runtime listening on port %s", port)
+	log.Fatal(http.ListenAndServe("0.0.0.0:"+port, nil))
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy", "runtime": "provided"})
+}
+
+// handleReseed mixes the host's entropy into the kernel's RNG. A VM
+// restored from a snapshot resumes with the RNG state of every other clone.
+// The runtime itself keeps no RNG: binaries seed theirs when they start.
+func handleReseed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Entropy []byte `json:"entropy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid reseed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := addEntropy(req.Entropy); err != nil {
+		log.Printf("Failed to add entropy: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"restart": false})
+}
+
+// addEntropy writes entropy to the kernel's pool and reseeds from it where
+// permitted; otherwise the next reseed picks it up
+func addEntropy(entropy []byte) error {
+	urandom, err := os.OpenFile("/dev/urandom", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer urandom.Close()
+	if _, err := urandom.Write(entropy); err != nil {
+		return err
+	}
+	if err := reseedKernel(urandom); err != nil {
+		log.Printf("Failed to reseed the kernel's RNG: %v", err)
+	}
+	return nil
+}
+
+func handleInvoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	start := time.Now()
+
+	// The host asks for NDJSON to follow the output while the function
+	// runs: log frames as lines are written, then one result frame
+	var frames *frameWriter
+	if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		frames = &frameWriter{w: w}
+	}
+
+	var req invokeRequest
+	response := &invokeResponse{StatusCode: http.StatusInternalServerError}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error = "invalid invocation: " + err.Error()
+	} else {
+		invoke(r.Context(), &req, response, frames)
+	}
+	response.DurationMS = time.Since(start).Milliseconds()
+
+	if frames != nil {
+		frames.write(map[string]interface{}{"type": "result", "result": response})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// invoke runs the binary of an invocation and fills in its response
+func invoke(ctx context.Context, req *invokeRequest, response *invokeResponse, frames *frameWriter) {
+	if req.Files == nil {
+		response.Error = "the provided runtime needs a code package"
+		return
+	}
+	dir, err := unpack(req.PackageID, req.Files)
+	if err != nil {
+		response.Error = "failed to load function: " + err.Error()
+		return
+	}
+	binary := filepath.Join(dir, filepath.FromSlash(req.Handler))
+	if !strings.HasPrefix(binary, dir+string(filepath.Separator)) {
+		response.Error = "invalid handler: " + req.Handler
+		return
+	}
+	if err := os.Chmod(binary, 0755); err != nil {
+		response.Error = "failed to load function: " + err.Error()
+		return
+	}
+
+	timeout := time.Duration(req.TimeoutMS) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	event := req.Event
+	if len(event) == 0 {
+		event = json.RawMessage("null")
+	}
+	stdin, err := json.Marshal(functionRequest{
+		Event: event,
+		Context: functionContext{
+			FunctionName:    req.FunctionName,
+			FunctionVersion: req.FunctionVersion,
+			InvocationID:    req.InvocationID,
+			MemoryMB:        req.MemoryMB,
+			TimeoutMS:       int(timeout.Milliseconds()),
+			DeadlineMS:      deadline.UnixMilli(),
+		},
+	})
+	if err != nil {
+		response.Error = "failed to marshal request: " + err.Error()
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = append(os.Environ(), "LAYER_DIR="+layerDir)
+	for key, value := range req.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		response.Error = "failed to start function: " + err.Error()
+		return
+	}
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Start(); err != nil {
+		response.Error = "failed to start function: " + err.Error()
+		return
+	}
+	var logs strings.Builder
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		logs.WriteString(line)
+		logs.WriteByte('\n')
+		if frames != nil {
+			frames.write(map[string]string{"type": "log", "stream": "stderr", "line": line})
+		}
+	}
+	io.Copy(io.Discard, stderr)
+	err = cmd.Wait()
+	response.Logs = logs.String()
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			response.Error = fmt.Sprintf("function execution timed out after %s", timeout)
+		} else {
+			response.Error = "function execution failed: " + err.Error()
+		}
+		return
+	}
+
+	var result functionResponse
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		response.Error = "function exited without returning a result"
+		return
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		response.Error = "invalid function result: " + err.Error()
+		return
+	}
+	if result.Error != "" {
+		response.Error, response.Stack = result.Error, result.Stack
+		return
+	}
+	response.StatusCode = http.StatusOK
+	response.Body = result.Body
+}
+
+// unpack writes a package below the function directory once per ID, over
+// the function's layers, and returns its directory
+func unpack(id string, files map[string][]byte) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid package ID: %q", id)
+	}
+	dir := filepath.Join(functionDir, id)
+
+	packages.Lock()
+	defer packages.Unlock()
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	tmpDir := dir + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(layerDir); err == nil {
+		if err := exec.Command("cp", "-a", layerDir+"/.", tmpDir+"/").Run(); err != nil {
+			return "", fmt.Errorf("failed to copy layers: %w", err)
+		}
+	}
+	for name, content := range files {
+		file := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if !strings.HasPrefix(file, tmpDir+string(filepath.Separator)) {
+			return "", fmt.Errorf("invalid path in package: %s", name)
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(file, content, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
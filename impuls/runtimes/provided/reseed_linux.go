@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// rndReseedCRNG is RNDRESEEDCRNG, which forces the kernel to reseed its RNG
+// from the input pool
+const rndReseedCRNG = 0x5207
+
+// reseedKernel forces the kernel's RNG to reseed from what was written to
+// urandom
+func reseedKernel(urandom *os.File) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, urandom.Fd(), rndReseedCRNG, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// reseedKernel fails: the guest is always Linux
+func reseedKernel(urandom *os.File) error {
+	return errors.New("reseeding the kernel's RNG is only supported on Linux")
+}
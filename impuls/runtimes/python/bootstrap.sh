@@ -11,6 +11,25 @@ if [ -n "$GUEST_IP" ]; then
     ip route add default via "${GATEWAY_IP}"
 fi
 
+# Accept invocations over vsock too. The host connects to vsock port 8080,
+# which is bridged to the runtime; VMs without a network device rely on it.
+ip link set lo up 2>/dev/null || true
+if command -v socat > /dev/null; then
+    socat VSOCK-LISTEN:8080,fork,reuseaddr TCP:127.0.0.1:8080 &
+fi
+
+# Merge the function's layers into LAYER_DIR. They are attached in order as
+# read-only drives after the root drive, later layers over earlier ones.
+export LAYER_DIR=/opt/layer
+i=0
+for dev in /dev/vd[b-z]; do
+    [ -b "$dev" ] || continue
+    mkdir -p "/mnt/layer$i" "$LAYER_DIR"
+    mount -o ro "$dev" "/mnt/layer$i"
+    cp -a "/mnt/layer$i/." "$LAYER_DIR/"
+    i=$((i + 1))
+done
+
 # Start the runtime
 cd /var/runtime
 exec python3 runtime.py
@@ -6,44 +6,75 @@ This is the runtime that executes inside the Firecracker VM.
 It receives function invocations via HTTP and executes the handler.
 """
 
+import base64
+import contextlib
 import http.server
+import importlib
+import io
 import json
 import os
+import random
+import shutil
 import sys
+import threading
 import traceback
 import importlib.util
 import time
 from typing import Any, Callable, Dict, Optional
 
 PORT = int(os.environ.get('RUNTIME_PORT', 8080))
+# The largest chunk a handler may write to its response at once
+MAX_CHUNK_SIZE = 8 * 1024 * 1024
 FUNCTION_DIR = os.environ.get('FUNCTION_DIR', '/var/task')
+# The function's layers, merged at boot; absent for functions without any
+LAYER_DIR = os.environ.get('LAYER_DIR', '/opt/layer')
+
+# RNDRESEEDCRNG, forces the kernel to reseed its RNG from the input pool
+RNDRESEEDCRNG = 0x5207
 
 # Function cache
 cached_handler: Optional[Callable] = None
 cached_code: Optional[str] = None
+cached_handler_name: Optional[str] = None
+loaded_package_dir: Optional[str] = None
 
 
 class LambdaContext:
     """Simplified AWS Lambda-like context object"""
     
-    def __init__(self, function_name: str, memory_mb: int, timeout_sec: int):
+    def __init__(self, function_name: str, memory_mb: int, timeout_sec: int,
+                 write: Callable[[bytes], None]):
         self.function_name = function_name
         self.function_version = '1'
         self.memory_limit_in_mb = memory_mb
         self._timeout_sec = timeout_sec
         self._start_time = time.time()
+        self._write = write
     
     def get_remaining_time_in_millis(self) -> int:
         elapsed = time.time() - self._start_time
         remaining = max(0, (self._timeout_sec - elapsed) * 1000)
         return int(remaining)
+    
+    def write(self, chunk: Any) -> None:
+        """Write a chunk of the response: bytes as they are, str as UTF-8
+        and any other value as JSON"""
+        if isinstance(chunk, (bytes, bytearray)):
+            data = bytes(chunk)
+        elif isinstance(chunk, str):
+            data = chunk.encode('utf-8')
+        else:
+            data = json.dumps(chunk).encode('utf-8')
+        if len(data) > MAX_CHUNK_SIZE:
+            raise ValueError(f"chunk exceeds {MAX_CHUNK_SIZE} bytes")
+        self._write(data)
 
 
 def load_function(code: str, handler: str) -> Callable:
     """Load and compile the function code"""
-    global cached_handler, cached_code
+    global cached_handler, cached_code, cached_handler_name
     
-    if cached_code == code and cached_handler is not None:
+    if cached_code == code and cached_handler_name == handler and cached_handler is not None:
         return cached_handler
     
     # Parse handler (format: "module.function_name")
@@ -53,6 +84,10 @@ def load_function(code: str, handler: str) -> Callable:
     
     handler_function = handler_parts[1]
     
+    # Plain source imports the modules of its layers
+    if os.path.isdir(LAYER_DIR) and LAYER_DIR not in sys.path:
+        sys.path.insert(0, LAYER_DIR)
+    
     # Create a temporary module from the code
     function_path = os.path.join(FUNCTION_DIR, 'function.py')
     
@@ -80,6 +115,65 @@ def load_function(code: str, handler: str) -> Callable:
         raise ValueError(f"Handler '{handler_function}' is not callable")
     
     cached_code = code
+    cached_handler_name = handler
+    cached_handler = handler_fn
+    
+    return handler_fn
+
+
+def load_package(package_id: str, files: Dict[str, str], handler: str) -> Callable:
+    """Unpack a code package and load the handler from its module"""
+    global cached_handler, cached_code, cached_handler_name, loaded_package_dir
+    
+    if cached_code == package_id and cached_handler_name == handler and cached_handler is not None:
+        return cached_handler
+    
+    # Parse handler (format: "module.function_name", the module is a path
+    # or dotted name in the package)
+    module_name, _, handler_function = handler.rpartition('.')
+    if not module_name or not handler_function:
+        raise ValueError(f"Invalid handler format: {handler} (expected 'module.function')")
+    
+    # Unpack once per package
+    package_dir = os.path.join(FUNCTION_DIR, package_id)
+    if not os.path.isdir(package_dir):
+        tmp_dir = package_dir + '.tmp'
+        shutil.rmtree(tmp_dir, ignore_errors=True)
+        # The package's files are written over its layers
+        if os.path.isdir(LAYER_DIR):
+            shutil.copytree(LAYER_DIR, tmp_dir)
+        for name, content in files.items():
+            path = os.path.normpath(os.path.join(tmp_dir, name))
+            if not path.startswith(tmp_dir + os.sep):
+                raise ValueError(f"Invalid path in package: {name}")
+            os.makedirs(os.path.dirname(path), exist_ok=True)
+            with open(path, 'wb') as f:
+                f.write(base64.b64decode(content))
+        os.rename(tmp_dir, package_dir)
+    
+    # Forget the modules of a previous package
+    if loaded_package_dir is not None and loaded_package_dir != package_dir:
+        if loaded_package_dir in sys.path:
+            sys.path.remove(loaded_package_dir)
+        for name, module in list(sys.modules.items()):
+            if (getattr(module, '__file__', None) or '').startswith(loaded_package_dir + os.sep):
+                del sys.modules[name]
+    if package_dir not in sys.path:
+        sys.path.insert(0, package_dir)
+    loaded_package_dir = package_dir
+    importlib.invalidate_caches()
+    
+    module = importlib.import_module(module_name.replace('/', '.'))
+    
+    # Get the handler function
+    handler_fn = getattr(module, handler_function, None)
+    if handler_fn is None:
+        raise ValueError(f"Handler '{handler_function}' not found in module")
+    if not callable(handler_fn):
+        raise ValueError(f"Handler '{handler_function}' is not callable")
+    
+    cached_code = package_id
+    cached_handler_name = handler
     cached_handler = handler_fn
     
     return handler_fn
@@ -95,9 +189,16 @@ async def execute_handler_async(handler: Callable, event: Any, context: LambdaCo
         return handler(event, context)
 
 
+async def drain_async(chunks: Any, context: LambdaContext) -> None:
+    """Write what an async generator yields"""
+    async for chunk in chunks:
+        context.write(chunk)
+
+
 def execute_handler(handler: Callable, event: Any, context: LambdaContext) -> Any:
-    """Execute the function handler"""
+    """Execute the function handler. Generators write what they yield."""
     import asyncio
+    import inspect
     
     if asyncio.iscoroutinefunction(handler):
         loop = asyncio.new_event_loop()
@@ -106,8 +207,39 @@ def execute_handler(handler: Callable, event: Any, context: LambdaContext) -> An
             return loop.run_until_complete(execute_handler_async(handler, event, context))
         finally:
             loop.close()
-    else:
-        return handler(event, context)
+    
+    result = handler(event, context)
+    if inspect.isgenerator(result):
+        for chunk in result:
+            context.write(chunk)
+        return None
+    if inspect.isasyncgen(result):
+        loop = asyncio.new_event_loop()
+        try:
+            loop.run_until_complete(drain_async(result, context))
+        finally:
+            loop.close()
+        return None
+    return result
+
+
+def reseed(entropy: bytes) -> None:
+    """Reseed the kernel's and the runtime's RNGs. A VM restored from a
+    snapshot resumes with the RNG state of every other clone."""
+    try:
+        with open('/dev/urandom', 'wb') as urandom:
+            urandom.write(entropy)
+            urandom.flush()
+            try:
+                import fcntl
+                fcntl.ioctl(urandom, RNDRESEEDCRNG)
+            except (ImportError, OSError):
+                # Not permitted or an older kernel: the entropy still goes
+                # into the pool for the next reseed
+                pass
+    except OSError as e:
+        print(f"Failed to add entropy: {e}", file=sys.stderr)
+    random.seed(entropy + os.urandom(32))
 
 
 class RuntimeHandler(http.server.BaseHTTPRequestHandler):
@@ -130,11 +262,38 @@ class RuntimeHandler(http.server.BaseHTTPRequestHandler):
     
     def do_POST(self):
         """Handle function invocation"""
+        if self.path == '/reseed':
+            self.handle_reseed()
+            return
         if self.path != '/invoke':
             self.send_response(404)
             self.end_headers()
             return
         
+        # The host asks for NDJSON to follow the output while the function
+        # runs: log frames as lines are written, then one result frame
+        streaming = 'application/x-ndjson' in self.headers.get('Accept', '')
+        emit = None
+        if streaming:
+            self.send_response(200)
+            self.send_header('Content-Type', 'application/x-ndjson')
+            self.end_headers()
+            emit = self.write_frame
+        
+        # Chunks the handler writes go to the host as they are written;
+        # without a stream they make up the body if it returns nothing
+        chunks = []
+        def write_chunk(data: bytes) -> None:
+            if streaming:
+                self.write_frame_json({'type': 'chunk', 'data': base64.b64encode(data).decode('ascii')})
+            else:
+                chunks.append(data)
+        
+        # Everything the function prints is returned as its logs
+        logs = io.StringIO()
+        stdout = LogStream('stdout', logs, emit)
+        stderr = LogStream('stderr', logs, emit)
+        
         try:
             # Read request body
             content_length = int(self.headers.get('Content-Length', 0))
@@ -143,6 +302,8 @@ class RuntimeHandler(http.server.BaseHTTPRequestHandler):
             
             # Extract invocation data
             code = request.get('code', '')
+            files = request.get('files')
+            package_id = request.get('package_id', '')
             handler_name = request.get('handler', 'handler.handler')
             event = request.get('event', {})
             env = request.get('env', {})
@@ -154,42 +315,105 @@ class RuntimeHandler(http.server.BaseHTTPRequestHandler):
             for key, value in env.items():
                 os.environ[key] = value
             
-            # Load the function
-            handler = load_function(code, handler_name)
+            with contextlib.redirect_stdout(stdout), contextlib.redirect_stderr(stderr):
+                # Load the function
+                if files is not None:
+                    handler = load_package(package_id, files, handler_name)
+                else:
+                    handler = load_function(code, handler_name)
+                
+                # Create context
+                context = LambdaContext(function_name, memory_mb, timeout_sec, write_chunk)
+                
+                # Execute the handler
+                result = execute_handler(handler, event, context)
+                if result is None and chunks:
+                    result = b''.join(chunks).decode('utf-8', errors='replace')
             
-            # Create context
-            context = LambdaContext(function_name, memory_mb, timeout_sec)
-            
-            # Execute the handler
-            result = execute_handler(handler, event, context)
+            stdout.flush_line()
+            stderr.flush_line()
             
             # Send response
             response = {
                 'statusCode': 200,
-                'body': result
+                'body': result,
+                'logs': logs.getvalue()
             }
             
-            self.send_response(200)
-            self.send_header('Content-Type', 'application/json')
-            self.end_headers()
-            self.wfile.write(json.dumps(response).encode())
-            
         except Exception as e:
-            error_response = {
+            stdout.flush_line()
+            stderr.flush_line()
+            
+            response = {
                 'statusCode': 500,
                 'error': str(e),
-                'stack': traceback.format_exc()
+                'stack': traceback.format_exc(),
+                'logs': logs.getvalue()
             }
-            
-            self.send_response(200)  # Still 200, error in body
-            self.send_header('Content-Type', 'application/json')
-            self.end_headers()
-            self.wfile.write(json.dumps(error_response).encode())
+        
+        if streaming:
+            self.write_frame_json({'type': 'result', 'result': response})
+            return
+        
+        self.send_response(200)  # Still 200, error in body
+        self.send_header('Content-Type', 'application/json')
+        self.end_headers()
+        self.wfile.write(json.dumps(response).encode())
+    
+    def handle_reseed(self) -> None:
+        """Mix the host's entropy into the RNGs, in place"""
+        content_length = int(self.headers.get('Content-Length', 0))
+        body = json.loads(self.rfile.read(content_length)) if content_length else {}
+        reseed(base64.b64decode(body.get('entropy', '')))
+        
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.end_headers()
+        self.wfile.write(json.dumps({'restart': False}).encode())
+    
+    def write_frame(self, stream: str, line: str) -> None:
+        """Send one line of output to the host"""
+        self.write_frame_json({'type': 'log', 'stream': stream, 'line': line})
+    
+    def write_frame_json(self, frame: Dict[str, Any]) -> None:
+        with self.server.frame_lock:
+            self.wfile.write((json.dumps(frame) + '\n').encode())
+            self.wfile.flush()
+
+
+class LogStream(io.TextIOBase):
+    """Collects what the function prints and forwards every complete line"""
+    
+    def __init__(self, stream: str, buffer: io.StringIO, emit: Optional[Callable[[str, str], None]]):
+        self.stream = stream
+        self.buffer = buffer
+        self.emit = emit
+        self.partial = ''
+    
+    def writable(self) -> bool:
+        return True
+    
+    def write(self, s: str) -> int:
+        self.buffer.write(s)
+        if self.emit is not None:
+            self.partial += s
+            *lines, self.partial = self.partial.split('\n')
+            for line in lines:
+                self.emit(self.stream, line)
+        return len(s)
+    
+    def flush_line(self) -> None:
+        """Forward a trailing line that was not terminated"""
+        if self.emit is not None and self.partial:
+            self.emit(self.stream, self.partial)
+            self.partial = ''
 
 
 def main():
     """Start the runtime server"""
     server = http.server.HTTPServer(('0.0.0.0', PORT), RuntimeHandler)
+    # Functions may print from other threads while a frame is written
+    server.frame_lock = threading.Lock()
     print(f"Python runtime listening on port {PORT}", file=sys.stderr)
     
     try:
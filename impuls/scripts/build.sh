@@ -17,8 +17,15 @@ echo "Building impuls-server..."
 cd "${PROJECT_DIR}/cmd/impuls-server"
 go build -o "${BUILD_DIR}/impuls-server" .
 
+# Build the guest runtime of provided and Go functions. It is static, so it
+# runs in any rootfs.
+echo "Building provided-runtime..."
+cd "${PROJECT_DIR}/runtimes/provided"
+CGO_ENABLED=0 go build -o "${BUILD_DIR}/provided-runtime" .
+
 echo ""
 echo "=== Build Complete ==="
 echo "Binary: ${BUILD_DIR}/impuls-server"
+echo "Guest runtime: ${BUILD_DIR}/provided-runtime (copy to /var/runtime in the rootfs)"
 echo ""
 echo "Run with: sudo ${BUILD_DIR}/impuls-server"
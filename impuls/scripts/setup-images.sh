@@ -156,7 +156,7 @@ create_alpine_rootfs() {
     
     # Use Docker to create Alpine filesystem
     docker run --rm -v "${MOUNT_DIR}:/rootfs" alpine:latest sh -c '
-        apk add --no-cache nodejs npm openrc
+        apk add --no-cache nodejs npm openrc socat
         cp -a /bin /etc /home /lib /root /run /sbin /srv /tmp /usr /var /rootfs/
         mkdir -p /rootfs/dev /rootfs/proc /rootfs/sys
         mkdir -p /rootfs/var/runtime /rootfs/var/task